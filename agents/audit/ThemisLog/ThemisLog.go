@@ -0,0 +1,145 @@
+package themislog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Audit categories
+const (
+	CategoryAuthentication = "AUTHENTICATION"
+	CategoryAuthorization  = "AUTHORIZATION"
+	CategoryPIIAccess      = "PII_ACCESS"
+	CategoryAdministration = "ADMINISTRATION"
+)
+
+// Audit severities
+const (
+	SeverityInfo     = "INFO"
+	SeverityWarning  = "WARNING"
+	SeverityCritical = "CRITICAL"
+)
+
+// Event is something an agent wants on the audit trail
+type Event struct {
+	Category    string // one of the Category* values; AUTHENTICATION if empty
+	Action      string // e.g. OTP_GENERATED, REFRESH_TOKEN_REUSE
+	ObjectType  string // Dgraph type of the object acted on
+	ObjectID    string
+	PerformedBy string // user uid, or the agent's name for system actions
+	Severity    string // one of the Severity* values; INFO if empty
+	Source      string // agent or service writing the entry
+	Details     string
+	// PreviousValue and NewValue record a change, e.g. a role assignment
+	PreviousValue string
+	NewValue      string
+}
+
+// AuditEntry is an Event as stored, linked into the hash chain
+type AuditEntry struct {
+	UID           string     `json:"uid,omitempty"`
+	ID            string     `json:"id"`
+	Sequence      int64      `json:"sequence"`     // position in the chain, from 1
+	PreviousHash  string     `json:"previousHash"` // hash of entry Sequence-1; empty for the first
+	Hash          string     `json:"hash"`
+	Category      string     `json:"category"`
+	Action        string     `json:"action"`
+	ObjectType    string     `json:"objectType"`
+	ObjectID      string     `json:"objectId"`
+	PerformedBy   string     `json:"performedBy"`
+	Timestamp     time.Time  `json:"timestamp"`
+	Details       string     `json:"details"`
+	PreviousValue string     `json:"previousValue"`
+	NewValue      string     `json:"newValue"`
+	Severity      string     `json:"severity"`
+	Source        string     `json:"source"`
+	RetentionDate time.Time  `json:"retentionDate"`        // not hashed; retention policy may change it
+	ArchivedAt    *time.Time `json:"archivedAt,omitempty"` // set when retention removed the content
+}
+
+// LogEvent appends an event to the audit trail and returns its entry ID.
+// Callers normally log the error and carry on rather than fail the action
+// being audited.
+func LogEvent(event Event) (string, error) {
+	if event.Action == "" || event.Source == "" {
+		return "", errors.New("audit events need an action and a source")
+	}
+	if event.Category == "" {
+		event.Category = CategoryAuthentication
+	}
+	if event.Severity == "" {
+		event.Severity = SeverityInfo
+	}
+
+	id, err := newEntryID()
+	if err != nil {
+		return "", fmt.Errorf("failed to create audit entry ID: %w", err)
+	}
+
+	// Dgraph keeps datetimes to the microsecond at best; hash what is stored
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	entry := &AuditEntry{
+		ID:            id,
+		Category:      event.Category,
+		Action:        event.Action,
+		ObjectType:    event.ObjectType,
+		ObjectID:      event.ObjectID,
+		PerformedBy:   event.PerformedBy,
+		Timestamp:     now,
+		Details:       event.Details,
+		PreviousValue: event.PreviousValue,
+		NewValue:      event.NewValue,
+		Severity:      event.Severity,
+		Source:        event.Source,
+		RetentionDate: auditRetentionDate(event.Category, now),
+	}
+
+	if err := appendEntry(entry); err != nil {
+		return "", err
+	}
+	return entry.ID, nil
+}
+
+// Auditor writes the events of one agent or service, so each doesn't need
+// its own wrapper around LogEvent
+type Auditor struct {
+	Source   string // Event.Source of every entry
+	Category string // Event.Category of every entry; AUTHENTICATION if empty
+}
+
+// Log appends an event and returns the error, for actions that must not go
+// ahead unless they are on the audit trail
+func (a Auditor) Log(action, objectType, objectID, performedBy, severity, details string) error {
+	_, err := LogEvent(Event{
+		Category:    a.Category,
+		Action:      action,
+		ObjectType:  objectType,
+		ObjectID:    objectID,
+		PerformedBy: performedBy,
+		Severity:    severity,
+		Source:      a.Source,
+		Details:     details,
+	})
+	return err
+}
+
+// Record appends an event. A failure is logged rather than returned, so the
+// action being audited carries on.
+func (a Auditor) Record(action, objectType, objectID, performedBy, severity, details string) {
+	if err := a.Log(action, objectType, objectID, performedBy, severity, details); err != nil {
+		log.Printf("⚠️ Warning: Audit logging failed for %s %s: %v", a.Source, action, err)
+	}
+}
+
+// newEntryID returns a unique audit entry ID
+func newEntryID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("audit_%d_%s", time.Now().UnixNano(), hex.EncodeToString(b)), nil
+}
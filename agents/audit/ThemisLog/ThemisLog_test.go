@@ -0,0 +1,190 @@
+package themislog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// testChain builds a valid chain of n entries
+func testChain(n int) []AuditEntry {
+	entries := make([]AuditEntry, n)
+	head := chainHead{}
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := range entries {
+		entries[i] = AuditEntry{
+			ID:          fmt.Sprintf("audit_%d", i+1),
+			Category:    CategoryAuthentication,
+			Action:      "OTP_GENERATED",
+			ObjectType:  "ChannelOTP",
+			ObjectID:    fmt.Sprintf("0x%x", i+1),
+			PerformedBy: "CharonOTP",
+			Timestamp:   start.Add(time.Duration(i) * time.Second),
+			Details:     fmt.Sprintf("OTP %d sent", i+1),
+			Severity:    SeverityInfo,
+			Source:      "CharonOTP",
+		}
+		entries[i].link(head)
+		head = chainHead{Sequence: entries[i].Sequence, Hash: entries[i].Hash}
+	}
+	return entries
+}
+
+func verify(entries []AuditEntry, head chainHead) []ChainProblem {
+	walk := &chainWalk{}
+	walk.check(entries)
+	walk.finish(head)
+	return walk.problems
+}
+
+func headOf(entries []AuditEntry) chainHead {
+	last := entries[len(entries)-1]
+	return chainHead{Sequence: last.Sequence, Hash: last.Hash}
+}
+
+func TestIntactChainVerifies(t *testing.T) {
+	entries := testChain(5)
+	if problems := verify(entries, headOf(entries)); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %+v", problems)
+	}
+	if entries[0].PreviousHash != "" || entries[1].PreviousHash != entries[0].Hash {
+		t.Error("Expected each entry to link to the one before")
+	}
+}
+
+func TestVerifyFindsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func([]AuditEntry) ([]AuditEntry, chainHead)
+		kind     string
+		sequence int64
+	}{
+		{
+			name: "edited details",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				e[2].Details = "nothing happened"
+				return e, headOf(e)
+			},
+			kind: ProblemModified, sequence: 3,
+		},
+		{
+			name: "edited and rehashed",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				e[2].Severity = SeverityInfo
+				e[2].PerformedBy = "0x1"
+				e[2].Hash = e[2].computeHash()
+				return e, headOf(e)
+			},
+			kind: ProblemBrokenLink, sequence: 4,
+		},
+		{
+			name: "deleted entry",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				return append(e[:1:1], e[2:]...), headOf(e)
+			},
+			kind: ProblemGap, sequence: 3,
+		},
+		{
+			name: "deleted first entry",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				return e[1:], headOf(e)
+			},
+			kind: ProblemGap, sequence: 2,
+		},
+		{
+			name: "inserted entry",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				forged := e[1]
+				forged.ID = "audit_forged"
+				forged.Hash = forged.computeHash()
+				return append(e[:2:2], append([]AuditEntry{forged}, e[2:]...)...), headOf(e)
+			},
+			kind: ProblemDuplicate, sequence: 2,
+		},
+		{
+			name: "truncated",
+			tamper: func(e []AuditEntry) ([]AuditEntry, chainHead) {
+				return e[:3], headOf(e)
+			},
+			kind: ProblemTruncated, sequence: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, head := tt.tamper(testChain(5))
+			problems := verify(entries, head)
+			if len(problems) == 0 {
+				t.Fatal("Expected the tampering to be found")
+			}
+			if problems[0].Kind != tt.kind || problems[0].Sequence != tt.sequence {
+				t.Errorf("Expected %s at %d, got %+v", tt.kind, tt.sequence, problems)
+			}
+		})
+	}
+}
+
+func TestRetentionDateIsNotHashed(t *testing.T) {
+	entries := testChain(2)
+	entries[0].RetentionDate = entries[0].RetentionDate.AddDate(3, 0, 0)
+	if problems := verify(entries, headOf(entries)); len(problems) != 0 {
+		t.Errorf("Expected retention changes to keep the chain intact, got %+v", problems)
+	}
+}
+
+func TestLogEventAppendsInOneUpsert(t *testing.T) {
+	headMutex.Lock()
+	lastHead = chainHead{}
+	headMutex.Unlock()
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	id, err := LogEvent(Event{Action: "SESSION_REVOKED", Source: "ChronosSession", Details: `reason "logout"`})
+	if err != nil {
+		t.Fatalf("LogEvent failed: %v", err)
+	}
+	if calls := dgraph.DgraphQueryCallStack.Size() - before; calls != 1 {
+		t.Fatalf("Expected one Dgraph request, got %d", calls)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	mutation := req.Mutations[0]
+	if mutation.Condition != "@if(eq(len(current), 0))" {
+		t.Errorf("Expected the first entry to create the head, got %q", mutation.Condition)
+	}
+	for _, want := range []string{id, `<sequence> "1"`, `<category> "AUTHENTICATION"`, `<severity> "INFO"`, `\"logout\"`} {
+		if !strings.Contains(mutation.SetNquads, want) {
+			t.Errorf("Expected the entry to contain %s", want)
+		}
+	}
+}
+
+func TestLogEventRequiresActionAndSource(t *testing.T) {
+	if _, err := LogEvent(Event{Action: "OTP_GENERATED"}); err == nil {
+		t.Error("Expected an event without a source to be refused")
+	}
+}
+
+func TestAuditorFillsSourceAndCategory(t *testing.T) {
+	headMutex.Lock()
+	lastHead = chainHead{}
+	headMutex.Unlock()
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	auditor := Auditor{Source: "PIIVault", Category: CategoryPIIAccess}
+	if err := auditor.Log("PII_DETOKENIZED", "User", "0x1", "admin", SeverityWarning, "email"); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	for _, want := range []string{`<source> "PIIVault"`, `<category> "` + CategoryPIIAccess + `"`} {
+		if !strings.Contains(req.Mutations[0].SetNquads, want) {
+			t.Errorf("Expected the entry to contain %s", want)
+		}
+	}
+	if err := (Auditor{}).Log("PII_DETOKENIZED", "User", "0x1", "admin", SeverityWarning, ""); err == nil {
+		t.Error("Expected an auditor without a source to be refused")
+	}
+}
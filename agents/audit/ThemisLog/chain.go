@@ -0,0 +1,200 @@
+package themislog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// chainID names the AuditChainHead record. There is one chain.
+const chainID = "audit"
+
+// maxAppendAttempts bounds the retries when other writers append first
+const maxAppendAttempts = 5
+
+// chainHead is the last entry of the chain: its sequence and hash
+type chainHead struct {
+	Sequence int64  `json:"headSequence"`
+	Hash     string `json:"headHash"`
+}
+
+// lastHead is this instance's view of the chain head. It is only a first
+// guess; the append upsert checks it against Dgraph.
+var (
+	headMutex sync.Mutex
+	lastHead  chainHead
+)
+
+// chainedFields are the hashed fields of an entry, in a fixed order
+type chainedFields struct {
+	ID            string `json:"id"`
+	Sequence      int64  `json:"sequence"`
+	PreviousHash  string `json:"previousHash"`
+	Category      string `json:"category"`
+	Action        string `json:"action"`
+	ObjectType    string `json:"objectType"`
+	ObjectID      string `json:"objectId"`
+	PerformedBy   string `json:"performedBy"`
+	Timestamp     string `json:"timestamp"`
+	Details       string `json:"details"`
+	PreviousValue string `json:"previousValue"`
+	NewValue      string `json:"newValue"`
+	Severity      string `json:"severity"`
+	Source        string `json:"source"`
+}
+
+// computeHash returns the SHA-256 of the entry's content and its link to
+// the previous entry, hex-encoded
+func (e *AuditEntry) computeHash() string {
+	content, _ := json.Marshal(chainedFields{
+		ID:            e.ID,
+		Sequence:      e.Sequence,
+		PreviousHash:  e.PreviousHash,
+		Category:      e.Category,
+		Action:        e.Action,
+		ObjectType:    e.ObjectType,
+		ObjectID:      e.ObjectID,
+		PerformedBy:   e.PerformedBy,
+		Timestamp:     e.Timestamp.UTC().Format(time.RFC3339Nano),
+		Details:       e.Details,
+		PreviousValue: e.PreviousValue,
+		NewValue:      e.NewValue,
+		Severity:      e.Severity,
+		Source:        e.Source,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// link places an entry after the given head and seals it with its hash
+func (e *AuditEntry) link(head chainHead) {
+	e.Sequence = head.Sequence + 1
+	e.PreviousHash = head.Hash
+	e.Hash = e.computeHash()
+}
+
+// appendEntry links an entry to the chain head and stores it. The upsert
+// only applies if the head is still the one the entry was linked to, so
+// concurrent writers can't fork the chain; the loser relinks and retries.
+func appendEntry(entry *AuditEntry) error {
+	headMutex.Lock()
+	defer headMutex.Unlock()
+
+	head := lastHead
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		entry.link(head)
+
+		current, err := upsertEntry(entry, head)
+		if err != nil {
+			return err
+		}
+		if current == head {
+			lastHead = chainHead{Sequence: entry.Sequence, Hash: entry.Hash}
+			return nil
+		}
+		// Someone else appended first; try again after their entry
+		head = current
+	}
+	return fmt.Errorf("audit chain is busy; gave up after %d attempts", maxAppendAttempts)
+}
+
+// upsertEntry stores an entry and moves the head to it if the head is
+// still expected. It returns the head as it was before the upsert, which
+// equals expected exactly when the entry was stored.
+func upsertEntry(entry *AuditEntry, expected chainHead) (chainHead, error) {
+	query := dgraph.NewQuery(`query head($chainId: string, $headHash: string) {
+		current as var(func: eq(chainId, $chainId)) @filter(type(AuditChainHead))
+		expected as var(func: uid(current)) @filter(eq(headHash, $headHash))
+		head(func: uid(current)) {
+			headSequence
+			headHash
+		}
+	}`).WithVariable("$chainId", chainID).WithVariable("$headHash", expected.Hash)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var mutation *dgraph.Mutation
+	if expected.Sequence == 0 {
+		// The first entry also creates the head
+		mutation = dgraph.NewMutation().
+			WithCondition("@if(eq(len(current), 0))").
+			WithSetNquads(entryNquads(entry) + fmt.Sprintf(`
+_:head <dgraph.type> "AuditChainHead" .
+_:head <chainId> %q .
+_:head <headSequence> "%d" .
+_:head <headHash> %q .
+_:head <updatedAt> "%s"^^<xs:dateTime> .`, chainID, entry.Sequence, entry.Hash, now))
+	} else {
+		mutation = dgraph.NewMutation().
+			WithCondition("@if(eq(len(expected), 1))").
+			WithSetNquads(entryNquads(entry) + fmt.Sprintf(`
+uid(expected) <headSequence> "%d" .
+uid(expected) <headHash> %q .
+uid(expected) <updatedAt> "%s"^^<xs:dateTime> .`, entry.Sequence, entry.Hash, now))
+	}
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return chainHead{}, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return parseHead(resp.Json)
+}
+
+// loadHead reads the chain head; a chain without entries has a zero head
+func loadHead() (chainHead, error) {
+	query := dgraph.NewQuery(`query head($chainId: string) {
+		head(func: eq(chainId, $chainId)) @filter(type(AuditChainHead)) {
+			headSequence
+			headHash
+		}
+	}`).WithVariable("$chainId", chainID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return chainHead{}, fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+	return parseHead(resp.Json)
+}
+
+func parseHead(data string) (chainHead, error) {
+	var result struct {
+		Head []chainHead `json:"head"`
+	}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &result); err != nil {
+			return chainHead{}, fmt.Errorf("failed to parse audit chain head: %w", err)
+		}
+	}
+	if len(result.Head) == 0 {
+		return chainHead{}, nil
+	}
+	return result.Head[0], nil
+}
+
+// entryNquads stores an entry as a new AuditEntry
+func entryNquads(e *AuditEntry) string {
+	return fmt.Sprintf(`_:entry <dgraph.type> "AuditEntry" .
+_:entry <id> %q .
+_:entry <sequence> "%d" .
+_:entry <previousHash> %q .
+_:entry <hash> %q .
+_:entry <category> %q .
+_:entry <action> %q .
+_:entry <objectType> %q .
+_:entry <objectId> %q .
+_:entry <performedBy> %q .
+_:entry <timestamp> "%s"^^<xs:dateTime> .
+_:entry <details> %q .
+_:entry <previousValue> %q .
+_:entry <newValue> %q .
+_:entry <severity> %q .
+_:entry <source> %q .
+_:entry <retentionDate> "%s"^^<xs:dateTime> .`,
+		e.ID, e.Sequence, e.PreviousHash, e.Hash, e.Category, e.Action, e.ObjectType, e.ObjectID,
+		e.PerformedBy, e.Timestamp.Format(time.RFC3339Nano), e.Details, e.PreviousValue, e.NewValue,
+		e.Severity, e.Source, e.RetentionDate.Format(time.RFC3339))
+}
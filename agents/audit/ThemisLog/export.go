@@ -0,0 +1,169 @@
+package themislog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV       = "csv"
+	FormatJSONLines = "jsonl"
+)
+
+// maxExportEntries bounds one export; narrow the filter for more
+const maxExportEntries = 100000
+
+// errStopExport ends the walk once it passes the head
+var errStopExport = errors.New("export reached the chain head")
+
+// csvColumns are the CSV header, in the order values are written
+var csvColumns = []string{
+	"sequence", "id", "previousHash", "hash", "timestamp", "category", "action",
+	"objectType", "objectId", "performedBy", "severity", "source", "details",
+	"previousValue", "newValue", "retentionDate", "archivedAt",
+}
+
+// ExportManifest describes an export's content. It is what gets signed.
+type ExportManifest struct {
+	FileName      string    `json:"fileName"`
+	Format        string    `json:"format"`
+	Filter        Filter    `json:"filter"`
+	EntryCount    int       `json:"entryCount"`
+	FirstSequence int64     `json:"firstSequence"`
+	LastSequence  int64     `json:"lastSequence"`
+	HeadSequence  int64     `json:"headSequence"` // chain head when the export started
+	HeadHash      string    `json:"headHash"`
+	ContentSHA256 string    `json:"contentSha256"` // hex SHA-256 of Content
+	ExportedBy    string    `json:"exportedBy"`
+	ExportedAt    time.Time `json:"exportedAt"`
+}
+
+// ExportBundle is an export for checking offline: the entries, their
+// manifest and, once signed, the manifest's signature
+type ExportBundle struct {
+	Manifest  ExportManifest `json:"manifest"`
+	Content   string         `json:"content"`
+	Signature string         `json:"signature,omitempty"` // JWS over the manifest, set by the caller
+}
+
+// ExportEntries writes the entries matching a filter, oldest first, as CSV
+// or JSON lines. Values are written exactly as hashed, so an auditor can
+// recompute every entry's hash from the file.
+func ExportEntries(filter Filter, format, exportedBy string) (*ExportBundle, error) {
+	if format != FormatCSV && format != FormatJSONLines {
+		return nil, fmt.Errorf("unsupported audit export format %q", format)
+	}
+
+	head, err := loadHead()
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	writer := newEntryWriter(&content, format)
+	manifest := ExportManifest{
+		Format:       format,
+		Filter:       filter,
+		HeadSequence: head.Sequence,
+		HeadHash:     head.Hash,
+		ExportedBy:   exportedBy,
+		ExportedAt:   time.Now().UTC(),
+	}
+
+	err = eachEntry(filter, func(entry *AuditEntry) error {
+		// Entries appended after the export started are left for the next one
+		if entry.Sequence > head.Sequence {
+			return errStopExport
+		}
+		if manifest.EntryCount == maxExportEntries {
+			return fmt.Errorf("audit export is limited to %d entries; narrow the filter", maxExportEntries)
+		}
+		if manifest.EntryCount == 0 {
+			manifest.FirstSequence = entry.Sequence
+		}
+		manifest.LastSequence = entry.Sequence
+		manifest.EntryCount++
+		return writer.write(entry)
+	})
+	if err != nil && err != errStopExport {
+		return nil, err
+	}
+	if err := writer.flush(); err != nil {
+		return nil, fmt.Errorf("failed to write audit export: %w", err)
+	}
+
+	sum := sha256.Sum256(content.Bytes())
+	manifest.ContentSHA256 = hex.EncodeToString(sum[:])
+	manifest.FileName = fmt.Sprintf("audit_%s_%d-%d.%s",
+		manifest.ExportedAt.Format("20060102T150405Z"), manifest.FirstSequence, manifest.LastSequence, format)
+
+	return &ExportBundle{Manifest: manifest, Content: content.String()}, nil
+}
+
+// entryWriter writes entries in one export format
+type entryWriter struct {
+	buf    *bytes.Buffer
+	csv    *csv.Writer
+	header bool
+}
+
+func newEntryWriter(buf *bytes.Buffer, format string) *entryWriter {
+	w := &entryWriter{buf: buf}
+	if format == FormatCSV {
+		w.csv = csv.NewWriter(buf)
+	}
+	return w
+}
+
+func (w *entryWriter) write(entry *AuditEntry) error {
+	if w.csv == nil {
+		line := *entry
+		line.UID = ""
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		w.buf.Write(data)
+		w.buf.WriteByte('\n')
+		return nil
+	}
+
+	if !w.header {
+		if err := w.csv.Write(csvColumns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	archivedAt := ""
+	if entry.ArchivedAt != nil {
+		archivedAt = entry.ArchivedAt.UTC().Format(time.RFC3339)
+	}
+	return w.csv.Write([]string{
+		strconv.FormatInt(entry.Sequence, 10), entry.ID, entry.PreviousHash, entry.Hash,
+		entry.Timestamp.UTC().Format(time.RFC3339Nano), entry.Category, entry.Action,
+		entry.ObjectType, entry.ObjectID, entry.PerformedBy, entry.Severity, entry.Source,
+		entry.Details, entry.PreviousValue, entry.NewValue, entry.RetentionDate.UTC().Format(time.RFC3339),
+		archivedAt,
+	})
+}
+
+func (w *entryWriter) flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	if !w.header {
+		if err := w.csv.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
@@ -0,0 +1,93 @@
+package themislog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVExportKeepsHashesCheckable(t *testing.T) {
+	entries := testChain(3)
+	entries[1].Details = "name \"Ada\", line\nbreak"
+	entries[1].Hash = entries[1].computeHash()
+
+	var buf bytes.Buffer
+	writer := newEntryWriter(&buf, FormatCSV)
+	for i := range entries {
+		if err := writer.write(&entries[i]); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := writer.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %v", err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("Expected a header and 3 rows, got %v", rows)
+	}
+
+	// An auditor rebuilds each entry from its row and recomputes the hash
+	for _, row := range rows[1:] {
+		sequence, _ := strconv.ParseInt(row[0], 10, 64)
+		timestamp, _ := time.Parse(time.RFC3339Nano, row[4])
+		entry := AuditEntry{
+			Sequence: sequence, ID: row[1], PreviousHash: row[2], Timestamp: timestamp,
+			Category: row[5], Action: row[6], ObjectType: row[7], ObjectID: row[8],
+			PerformedBy: row[9], Severity: row[10], Source: row[11], Details: row[12],
+			PreviousValue: row[13], NewValue: row[14],
+		}
+		if entry.computeHash() != row[3] {
+			t.Errorf("Expected entry %d to match its hash", sequence)
+		}
+	}
+}
+
+func TestJSONLinesExportKeepsHashesCheckable(t *testing.T) {
+	entries := testChain(2)
+	entries[0].UID = "0x99"
+
+	var buf bytes.Buffer
+	writer := newEntryWriter(&buf, FormatJSONLines)
+	for i := range entries {
+		if err := writer.write(&entries[i]); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "0x99") {
+		t.Fatalf("Expected one line per entry without Dgraph uids, got %q", buf.String())
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+	if entry.computeHash() != entries[1].Hash {
+		t.Error("Expected the exported entry to match its hash")
+	}
+}
+
+func TestExportManifestCoversContent(t *testing.T) {
+	bundle, err := ExportEntries(Filter{Source: "CharonOTP"}, FormatCSV, "0x1")
+	if err != nil {
+		t.Fatalf("ExportEntries failed: %v", err)
+	}
+	if bundle.Manifest.ContentSHA256 == "" || bundle.Manifest.ExportedBy != "0x1" || !strings.HasSuffix(bundle.Manifest.FileName, ".csv") {
+		t.Errorf("Unexpected manifest %+v", bundle.Manifest)
+	}
+	if !strings.HasPrefix(bundle.Content, "sequence,id,") {
+		t.Errorf("Expected an empty export to still have a header, got %q", bundle.Content)
+	}
+
+	if _, err := ExportEntries(Filter{}, "xlsx", "0x1"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
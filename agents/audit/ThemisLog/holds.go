@@ -0,0 +1,355 @@
+package themislog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// LegalHold keeps a user's or centre's records from being purged, e.g.
+// during litigation or an investigation, until it is released
+type LegalHold struct {
+	ID         string     `json:"holdId"`
+	UserID     string     `json:"userId,omitempty"`
+	CentreID   string     `json:"centreId,omitempty"`
+	Reason     string     `json:"reason"`
+	PlacedBy   string     `json:"placedBy"`
+	PlacedAt   time.Time  `json:"placedAt"`
+	Active     bool       `json:"active"`
+	ReleasedBy string     `json:"releasedBy,omitempty"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+}
+
+// PlaceLegalHold stops retention from removing the records of a user or of
+// every member of a centre. Exactly one of userID and centreID is set.
+func PlaceLegalHold(placedBy, userID, centreID, reason string) (*LegalHold, error) {
+	if (userID == "") == (centreID == "") {
+		return nil, errors.New("a legal hold names either a user or a centre")
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("a legal hold needs a reason")
+	}
+	target, edge := userID, "heldUser"
+	if centreID != "" {
+		target, edge = centreID, "heldCentre"
+	}
+	if !isUID(target) {
+		return nil, fmt.Errorf("invalid %s uid %q", strings.TrimPrefix(edge, "held"), target)
+	}
+
+	id, err := newHoldID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create legal hold ID: %w", err)
+	}
+	hold := &LegalHold{
+		ID:       id,
+		UserID:   userID,
+		CentreID: centreID,
+		Reason:   reason,
+		PlacedBy: placedBy,
+		PlacedAt: time.Now().UTC(),
+		Active:   true,
+	}
+
+	nquads := fmt.Sprintf(`_:hold <dgraph.type> "LegalHold" .
+_:hold <holdId> %q .
+_:hold <%s> <%s> .
+_:hold <reason> %q .
+_:hold <placedBy> %q .
+_:hold <placedAt> "%s"^^<xs:dateTime> .
+_:hold <active> "true"^^<xs:boolean> .`,
+		hold.ID, edge, target, hold.Reason, hold.PlacedBy, hold.PlacedAt.Format(time.RFC3339))
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return nil, fmt.Errorf("failed to store legal hold: %w", err)
+	}
+
+	if _, err := LogEvent(Event{
+		Category:    CategoryAdministration,
+		Action:      "LEGAL_HOLD_PLACED",
+		ObjectType:  "LegalHold",
+		ObjectID:    hold.ID,
+		PerformedBy: placedBy,
+		Severity:    SeverityWarning,
+		Source:      "ThemisLog",
+		Details:     fmt.Sprintf("Hold on %s %s: %s", strings.TrimPrefix(edge, "held"), target, reason),
+		NewValue:    target,
+	}); err != nil {
+		return hold, fmt.Errorf("legal hold placed but not audited: %w", err)
+	}
+	return hold, nil
+}
+
+// ReleaseLegalHold lets retention apply again to the records a hold
+// covered. It returns false if there is no active hold with that ID.
+func ReleaseLegalHold(releasedBy, holdID, reason string) (bool, error) {
+	if strings.TrimSpace(reason) == "" {
+		return false, errors.New("releasing a legal hold needs a reason")
+	}
+
+	query := dgraph.NewQuery(`query hold($holdId: string) {
+		hold as var(func: eq(holdId, $holdId)) @filter(type(LegalHold) AND eq(active, true))
+
+		released(func: uid(hold)) {
+			count(uid)
+		}
+	}`).WithVariable("$holdId", holdID)
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(hold), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(hold) <active> "false"^^<xs:boolean> .
+uid(hold) <releasedBy> %q .
+uid(hold) <releasedAt> "%s"^^<xs:dateTime> .`, releasedBy, time.Now().UTC().Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return false, fmt.Errorf("failed to release legal hold: %w", err)
+	}
+
+	var result struct {
+		Released []struct {
+			Count int `json:"count"`
+		} `json:"released"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, fmt.Errorf("failed to parse legal hold release: %w", err)
+	}
+	if len(result.Released) == 0 || result.Released[0].Count != 1 {
+		return false, nil
+	}
+
+	if _, err := LogEvent(Event{
+		Category:    CategoryAdministration,
+		Action:      "LEGAL_HOLD_RELEASED",
+		ObjectType:  "LegalHold",
+		ObjectID:    holdID,
+		PerformedBy: releasedBy,
+		Severity:    SeverityWarning,
+		Source:      "ThemisLog",
+		Details:     reason,
+	}); err != nil {
+		return true, fmt.Errorf("legal hold released but not audited: %w", err)
+	}
+	return true, nil
+}
+
+// ListLegalHolds lists legal holds, newest first
+func ListLegalHolds(activeOnly bool) ([]LegalHold, error) {
+	filter := ""
+	if activeOnly {
+		filter = "@filter(eq(active, true))"
+	}
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		holds(func: type(LegalHold), orderdesc: placedAt) %s {
+			holdId
+			heldUser { uid }
+			heldCentre { uid }
+			reason
+			placedBy
+			placedAt
+			active
+			releasedBy
+			releasedAt
+		}
+	}`, filter))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list legal holds: %w", err)
+	}
+
+	var result struct {
+		Holds []struct {
+			LegalHold
+			HeldUser   *struct{ UID string } `json:"heldUser"`
+			HeldCentre *struct{ UID string } `json:"heldCentre"`
+		} `json:"holds"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse legal holds: %w", err)
+	}
+
+	holds := make([]LegalHold, len(result.Holds))
+	for i, h := range result.Holds {
+		holds[i] = h.LegalHold
+		if h.HeldUser != nil {
+			holds[i].UserID = h.HeldUser.UID
+		}
+		if h.HeldCentre != nil {
+			holds[i].CentreID = h.HeldCentre.UID
+		}
+	}
+	return holds, nil
+}
+
+// UserHeld reports whether an active legal hold covers a user, directly or
+// through their centre
+func UserHeld(userID string) (bool, error) {
+	held, err := resolveHolds()
+	if err != nil {
+		return false, err
+	}
+	for _, u := range held.users {
+		if u == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// heldRecords are what the active legal holds cover
+type heldRecords struct {
+	holds    int
+	users    []string // held users and members of held centres
+	centres  []string
+	channels []string // channelHash of the held users' channels
+}
+
+// resolveHolds finds the users, centres and channels under an active hold
+func resolveHolds() (*heldRecords, error) {
+	query := dgraph.NewQuery(`{
+		holds as var(func: type(LegalHold)) @filter(eq(active, true)) {
+			hu as heldUser
+			hc as heldCentre
+		}
+		var(func: has(centre)) @filter(uid_in(centre, uid(hc))) {
+			mu as user
+		}
+
+		holdCount(func: uid(holds)) {
+			count(uid)
+		}
+		users(func: uid(hu, mu)) {
+			uid
+		}
+		centres(func: uid(hc)) {
+			uid
+		}
+	}`)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legal holds: %w", err)
+	}
+
+	var result struct {
+		HoldCount []struct {
+			Count int `json:"count"`
+		} `json:"holdCount"`
+		Users   []struct{ UID string } `json:"users"`
+		Centres []struct{ UID string } `json:"centres"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse legal holds: %w", err)
+	}
+
+	held := &heldRecords{}
+	if len(result.HoldCount) > 0 {
+		held.holds = result.HoldCount[0].Count
+	}
+	for _, u := range result.Users {
+		if isUID(u.UID) {
+			held.users = append(held.users, u.UID)
+		}
+	}
+	for _, c := range result.Centres {
+		if isUID(c.UID) {
+			held.centres = append(held.centres, c.UID)
+		}
+	}
+	if len(held.users) == 0 {
+		return held, nil
+	}
+
+	// OTPs are stored against the channel, not the user
+	resp, err = dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(fmt.Sprintf(`{
+		channels(func: eq(userId, %s)) @filter(type(UserChannels)) {
+			channelHash
+		}
+	}`, quotedList(held.users))))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read held channels: %w", err)
+	}
+
+	var channels struct {
+		Channels []struct {
+			ChannelHash string `json:"channelHash"`
+		} `json:"channels"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &channels); err != nil {
+		return nil, fmt.Errorf("failed to parse held channels: %w", err)
+	}
+	for _, c := range channels.Channels {
+		if c.ChannelHash != "" {
+			held.channels = append(held.channels, c.ChannelHash)
+		}
+	}
+	return held, nil
+}
+
+// filterFor returns the @filter clause, starting with AND, that leaves out
+// held records of a type. It is empty when nothing of the type is held.
+func (h *heldRecords) filterFor(recordType string) string {
+	var clauses []string
+	switch recordType {
+	case RecordAuditEntry:
+		subjects := append(append([]string{}, h.users...), h.centres...)
+		if len(subjects) > 0 {
+			list := quotedList(subjects)
+			clauses = append(clauses, "NOT eq(performedBy, "+list+")", "NOT eq(objectId, "+list+")")
+		}
+	case RecordChannelOTP:
+		if len(h.channels) > 0 {
+			clauses = append(clauses, "NOT eq(channelHash, "+quotedList(h.channels)+")")
+		}
+	case RecordWebAuthnChallenge:
+		if len(h.users) > 0 {
+			clauses = append(clauses, "NOT eq(userId, "+quotedList(h.users)+")")
+		}
+	case RecordAuthSession:
+		if len(h.users) > 0 {
+			clauses = append(clauses, "NOT uid_in(user, ["+strings.Join(h.users, ", ")+"])")
+		}
+	}
+	if len(clauses) == 0 {
+		return ""
+	}
+	return "AND " + strings.Join(clauses, " AND ")
+}
+
+// quotedList writes values as a DQL list of strings
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// isUID reports whether id looks like a Dgraph uid
+func isUID(id string) bool {
+	if !strings.HasPrefix(id, "0x") || len(id) < 3 {
+		return false
+	}
+	for _, c := range id[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// newHoldID returns a unique legal hold ID
+func newHoldID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "hold_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,43 @@
+package themislog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func TestPlaceLegalHoldValidates(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID, centreID string
+		reason           string
+	}{
+		{"neither user nor centre", "", "", "litigation"},
+		{"both user and centre", "0x1", "0x2", "litigation"},
+		{"no reason", "0x1", "", " "},
+		{"not a uid", "ada@example.com", "", "litigation"},
+	}
+	for _, tt := range tests {
+		if _, err := PlaceLegalHold("0xa", tt.userID, tt.centreID, tt.reason); err == nil {
+			t.Errorf("%s: expected the hold to be refused", tt.name)
+		}
+	}
+}
+
+func TestPlaceLegalHoldOnCentre(t *testing.T) {
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	hold, err := PlaceLegalHold("0xa", "", "0x2", "Subject access dispute")
+	if err != nil {
+		t.Fatalf("PlaceLegalHold failed: %v", err)
+	}
+	if !hold.Active || hold.CentreID != "0x2" || !strings.HasPrefix(hold.ID, "hold_") {
+		t.Errorf("Unexpected hold %+v", hold)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	if !strings.Contains(req.Mutations[0].SetNquads, "_:hold <heldCentre> <0x2> .") {
+		t.Errorf("Expected the hold to point at the centre, got %s", req.Mutations[0].SetNquads)
+	}
+}
@@ -0,0 +1,235 @@
+package themislog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// Page sizes for QueryEntries
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// maxCountWindow bounds the period CountActionsByDay reads
+const maxCountWindow = 366 * 24 * time.Hour
+
+// ErrInvalidCursor is returned for a cursor QueryEntries didn't hand out
+var ErrInvalidCursor = errors.New("invalid audit cursor")
+
+// Filter selects audit entries. Empty fields match everything; From is
+// inclusive and To exclusive.
+type Filter struct {
+	Category    string    `json:"category,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	ObjectType  string    `json:"objectType,omitempty"`
+	ObjectID    string    `json:"objectId,omitempty"`
+	PerformedBy string    `json:"performedBy,omitempty"`
+	Severity    string    `json:"severity,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+}
+
+// EntryPage is one page of QueryEntries, newest entry first
+type EntryPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"nextCursor,omitempty"` // empty on the last page
+}
+
+// ActionCount is how often an action was logged on a day (UTC)
+type ActionCount struct {
+	Day    string `json:"day"` // 2006-01-02
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+}
+
+// QueryEntries returns the entries matching a filter, newest first. Pass
+// the previous page's NextCursor to read on. Only chained entries are
+// returned.
+func QueryEntries(filter Filter, cursor string, limit int) (*EntryPage, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	before, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// One extra entry tells whether there is another page
+	entries, err := findEntries(filter, before, false, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &EntryPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = encodeCursor(page.Entries[limit-1].Sequence)
+	}
+	return page, nil
+}
+
+// CountActionsByDay counts the entries matching a filter per action and
+// day, ordered by day and action. The filter needs a From and To no more
+// than a year apart.
+func CountActionsByDay(filter Filter) ([]ActionCount, error) {
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return nil, errors.New("counting audit entries needs a from and to time")
+	}
+	if !filter.To.After(filter.From) || filter.To.Sub(filter.From) > maxCountWindow {
+		return nil, errors.New("audit counts cover at most a year, and to must be after from")
+	}
+
+	counts := map[ActionCount]int{}
+	err := eachEntry(filter, func(entry *AuditEntry) error {
+		key := ActionCount{Day: entry.Timestamp.UTC().Format("2006-01-02"), Action: entry.Action}
+		counts[key]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ActionCount, 0, len(counts))
+	for key, n := range counts {
+		key.Count = n
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Day != result[j].Day {
+			return result[i].Day < result[j].Day
+		}
+		return result[i].Action < result[j].Action
+	})
+	return result, nil
+}
+
+// eachEntry calls fn for every entry matching a filter, oldest first
+func eachEntry(filter Filter, fn func(*AuditEntry) error) error {
+	after := int64(0)
+	for {
+		entries, err := findEntries(filter, after, true, MaxPageSize)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if err := fn(&entries[i]); err != nil {
+				return err
+			}
+		}
+		if len(entries) < MaxPageSize {
+			return nil
+		}
+		after = entries[len(entries)-1].Sequence
+	}
+}
+
+// findEntries reads up to limit entries matching a filter, either after a
+// sequence in ascending order or before it in descending order
+func findEntries(filter Filter, bound int64, ascending bool, limit int) ([]AuditEntry, error) {
+	root := fmt.Sprintf("lt(sequence, $bound), orderdesc: sequence, first: %d", limit)
+	if ascending {
+		root = fmt.Sprintf("gt(sequence, $bound), orderasc: sequence, first: %d", limit)
+	}
+
+	params := []string{"$bound: int"}
+	conditions := []string{"type(AuditEntry)"}
+	vars := map[string]interface{}{"$bound": bound}
+	add := func(predicate, name, function string, value interface{}) {
+		params = append(params, fmt.Sprintf("$%s: string", name))
+		conditions = append(conditions, fmt.Sprintf("%s(%s, $%s)", function, predicate, name))
+		vars["$"+name] = value
+	}
+	for _, field := range []struct{ predicate, value string }{
+		{"category", filter.Category},
+		{"action", filter.Action},
+		{"objectType", filter.ObjectType},
+		{"objectId", filter.ObjectID},
+		{"performedBy", filter.PerformedBy},
+		{"severity", filter.Severity},
+		{"source", filter.Source},
+	} {
+		if field.value != "" {
+			add(field.predicate, field.predicate, "eq", field.value)
+		}
+	}
+	if !filter.From.IsZero() {
+		add("timestamp", "from", "ge", filter.From.UTC().Format(time.RFC3339Nano))
+	}
+	if !filter.To.IsZero() {
+		add("timestamp", "to", "lt", filter.To.UTC().Format(time.RFC3339Nano))
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query entries(%s) {
+		entries(func: %s) @filter(%s) {
+			uid
+			id
+			sequence
+			previousHash
+			hash
+			category
+			action
+			objectType
+			objectId
+			performedBy
+			timestamp
+			details
+			previousValue
+			newValue
+			severity
+			source
+			retentionDate
+			archivedAt
+		}
+	}`, strings.Join(params, ", "), root, strings.Join(conditions, " AND ")))
+	for name, value := range vars {
+		query = query.WithVariable(name, value)
+	}
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+
+	var result struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse audit entries: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// A cursor is the sequence the next page starts before; the first page
+// starts before everything
+func encodeCursor(sequence int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(sequence, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return math.MaxInt64, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	sequence, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || sequence < 1 {
+		return 0, ErrInvalidCursor
+	}
+	return sequence, nil
+}
@@ -0,0 +1,63 @@
+package themislog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	sequence, err := decodeCursor(encodeCursor(42))
+	if err != nil || sequence != 42 {
+		t.Errorf("Expected 42 back from the cursor, got %d (%v)", sequence, err)
+	}
+
+	for _, cursor := range []string{"not base64!", encodeCursor(0), "YWJj"} {
+		if _, err := decodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("Expected cursor %q to be refused, got %v", cursor, err)
+		}
+	}
+}
+
+func TestQueryEntriesFiltersAndPages(t *testing.T) {
+	before := dgraph.DgraphQueryCallStack.Size()
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := QueryEntries(Filter{PerformedBy: "0x1", Action: "SESSION_REVOKED", From: from}, encodeCursor(500), 50)
+	if err != nil {
+		t.Fatalf("QueryEntries failed: %v", err)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	for _, want := range []string{
+		"lt(sequence, $bound), orderdesc: sequence, first: 51",
+		"eq(performedBy, $performedBy)",
+		"eq(action, $action)",
+		"ge(timestamp, $from)",
+	} {
+		if !strings.Contains(req.Query.Query, want) {
+			t.Errorf("Expected the query to contain %q", want)
+		}
+	}
+	if strings.Contains(req.Query.Query, "$category") {
+		t.Error("Expected empty filter fields to be left out")
+	}
+	if req.Query.Variables["$bound"] != "500" || req.Query.Variables["$from"] != "2025-03-01T00:00:00Z" {
+		t.Errorf("Unexpected variables %v", req.Query.Variables)
+	}
+}
+
+func TestCountActionsByDayNeedsAWindow(t *testing.T) {
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, filter := range []Filter{
+		{From: from},
+		{From: from, To: from.AddDate(2, 0, 0)},
+		{From: from, To: from.AddDate(0, 0, -1)},
+	} {
+		if _, err := CountActionsByDay(filter); err == nil {
+			t.Errorf("Expected %v to %v to be refused", filter.From, filter.To)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package themislog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	"modus/config"
+)
+
+// RetentionPoliciesSecret is the Modus secret holding the retention
+// policies as a JSON array of RetentionPolicy. DefaultRetentionPolicies
+// apply when it is not set.
+const RetentionPoliciesSecret = "RETENTION_POLICIES"
+
+// Record types the retention engine removes
+const (
+	RecordAuditEntry        = "AuditEntry"
+	RecordChannelOTP        = "ChannelOTP"
+	RecordWebAuthnChallenge = "WebAuthnChallenge"
+	RecordAuthSession       = "AuthSession"
+)
+
+// retentionBatchSize is how many records one purge upsert removes
+const retentionBatchSize = 1000
+
+// retentionClock is the datetime predicate a record type's age counts from
+var retentionClock = map[string]string{
+	RecordAuditEntry:        "timestamp",
+	RecordChannelOTP:        "createdAt",
+	RecordWebAuthnChallenge: "expiresAt",
+	RecordAuthSession:       "expiresAt",
+}
+
+// RetentionPolicy says how many days records of a type are kept. For audit
+// entries Category selects the entries it applies to; empty matches every
+// category without a policy of its own.
+type RetentionPolicy struct {
+	RecordType    string `json:"recordType"`
+	Category      string `json:"category,omitempty"`
+	RetentionDays int    `json:"retentionDays"`
+}
+
+// DefaultRetentionPolicies are used unless RetentionPoliciesSecret is set:
+// 2 years for authentication and authorization logs, 7 for PII access and
+// everything else audited
+var DefaultRetentionPolicies = []RetentionPolicy{
+	{RecordType: RecordAuditEntry, Category: CategoryAuthentication, RetentionDays: 2 * 365},
+	{RecordType: RecordAuditEntry, Category: CategoryAuthorization, RetentionDays: 2 * 365},
+	{RecordType: RecordAuditEntry, Category: CategoryPIIAccess, RetentionDays: 7 * 365},
+	{RecordType: RecordAuditEntry, RetentionDays: 7 * 365},
+	{RecordType: RecordChannelOTP, RetentionDays: 30},
+	{RecordType: RecordWebAuthnChallenge, RetentionDays: 1},
+	{RecordType: RecordAuthSession, RetentionDays: 90},
+}
+
+var retentionPoliciesOverride []RetentionPolicy
+
+func init() {
+	config.RegisterSecret(RetentionPoliciesSecret, false, func(value string) error {
+		_, err := parseRetentionPolicies(value)
+		return err
+	})
+}
+
+// SetRetentionPolicies overrides the policies read from RetentionPoliciesSecret
+func SetRetentionPolicies(config string) error {
+	policies, err := parseRetentionPolicies(config)
+	if err != nil {
+		return err
+	}
+	retentionPoliciesOverride = policies
+	return nil
+}
+
+// loadRetentionPolicies returns the configured retention policies
+func loadRetentionPolicies() ([]RetentionPolicy, error) {
+	if retentionPoliciesOverride != nil {
+		return retentionPoliciesOverride, nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.RetentionPolicies == "" {
+		return DefaultRetentionPolicies, nil
+	}
+	return parseRetentionPolicies(cfg.Secrets.RetentionPolicies)
+}
+
+// parseRetentionPolicies parses and checks a RetentionPoliciesSecret value.
+// Every record type needs a policy without category, so nothing is kept
+// forever by omission.
+func parseRetentionPolicies(config string) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	if err := json.Unmarshal([]byte(config), &policies); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", RetentionPoliciesSecret, err)
+	}
+
+	seen := make(map[[2]string]bool)
+	for _, p := range policies {
+		if _, ok := retentionClock[p.RecordType]; !ok {
+			return nil, fmt.Errorf("invalid %s: unknown record type %q", RetentionPoliciesSecret, p.RecordType)
+		}
+		if p.Category != "" && (p.RecordType != RecordAuditEntry || !isCategoryName(p.Category)) {
+			return nil, fmt.Errorf("invalid %s: category %q can't be used for %s", RetentionPoliciesSecret, p.Category, p.RecordType)
+		}
+		key := [2]string{p.RecordType, p.Category}
+		if seen[key] {
+			return nil, fmt.Errorf("invalid %s: duplicate policy for %s %q", RetentionPoliciesSecret, p.RecordType, p.Category)
+		}
+		seen[key] = true
+		if p.RetentionDays <= 0 {
+			return nil, fmt.Errorf("invalid %s: policy for %s %q needs positive retentionDays", RetentionPoliciesSecret, p.RecordType, p.Category)
+		}
+	}
+	for recordType := range retentionClock {
+		if !seen[[2]string{recordType, ""}] {
+			return nil, fmt.Errorf("invalid %s: a policy for %s without category is required", RetentionPoliciesSecret, recordType)
+		}
+	}
+	return policies, nil
+}
+
+// isCategoryName allows the characters audit categories are made of, as
+// they are written into purge queries
+func isCategoryName(category string) bool {
+	for _, c := range category {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return category != ""
+}
+
+// retentionFor picks the policy for a record type and audit category
+func retentionFor(policies []RetentionPolicy, recordType, category string) RetentionPolicy {
+	var fallback RetentionPolicy
+	for _, p := range policies {
+		if p.RecordType != recordType {
+			continue
+		}
+		if p.Category == category && category != "" {
+			return p
+		}
+		if p.Category == "" {
+			fallback = p
+		}
+	}
+	return fallback
+}
+
+// auditRetentionDate is when an entry logged now expires under the
+// configured policy
+func auditRetentionDate(category string, now time.Time) time.Time {
+	policies, err := loadRetentionPolicies()
+	if err != nil {
+		policies = DefaultRetentionPolicies
+	}
+	return now.AddDate(0, 0, retentionFor(policies, RecordAuditEntry, category).RetentionDays)
+}
+
+// RetentionResult is what one policy removed in a run
+type RetentionResult struct {
+	RecordType string    `json:"recordType"`
+	Category   string    `json:"category,omitempty"`
+	Cutoff     time.Time `json:"cutoff"` // records older than this were removed
+	Removed    int       `json:"removed"`
+	Sequences  string    `json:"sequences,omitempty"` // archived audit entries, e.g. "1-40,52"
+}
+
+// RetentionReport summarises a retention run
+type RetentionReport struct {
+	StartedAt      time.Time         `json:"startedAt"`
+	FinishedAt     time.Time         `json:"finishedAt"`
+	ActiveHolds    int               `json:"activeHolds"`
+	HeldUsers      int               `json:"heldUsers"`
+	Results        []RetentionResult `json:"results"`
+	SummaryEntryID string            `json:"summaryEntryId"` // the RETENTION_RUN audit entry
+}
+
+// RunRetention removes every record past its retention policy, except
+// those under a legal hold. Audit entries are archived: their content is
+// removed but their place in the hash chain is kept, so VerifyChain still
+// holds. Other records are deleted. The run writes a RETENTION_RUN entry
+// summarising what it removed, even when it fails part way.
+func RunRetention(performedBy string) (*RetentionReport, error) {
+	policies, err := loadRetentionPolicies()
+	if err != nil {
+		return nil, err
+	}
+	held, err := resolveHolds()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{
+		StartedAt:   time.Now().UTC(),
+		ActiveHolds: held.holds,
+		HeldUsers:   len(held.users),
+	}
+
+	var runErr error
+	for _, policy := range policies {
+		result := RetentionResult{
+			RecordType: policy.RecordType,
+			Category:   policy.Category,
+			Cutoff:     report.StartedAt.AddDate(0, 0, -policy.RetentionDays),
+		}
+		if policy.RecordType == RecordAuditEntry {
+			var sequences []int64
+			sequences, runErr = archiveAuditEntries(policy, policies, held, result.Cutoff)
+			result.Removed = len(sequences)
+			result.Sequences = sequenceRanges(sequences)
+		} else {
+			result.Removed, runErr = purgeRecords(policy.RecordType, held.filterFor(policy.RecordType), result.Cutoff)
+		}
+		report.Results = append(report.Results, result)
+		if runErr != nil {
+			break
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	severity := SeverityInfo
+	if runErr != nil {
+		severity = SeverityWarning
+	}
+	details, _ := json.Marshal(report.Results)
+	report.SummaryEntryID, err = LogEvent(Event{
+		Category:    CategoryAdministration,
+		Action:      "RETENTION_RUN",
+		ObjectType:  "RetentionPolicy",
+		PerformedBy: performedBy,
+		Severity:    severity,
+		Source:      "ThemisLog",
+		Details:     string(details),
+	})
+	if runErr != nil {
+		return report, runErr
+	}
+	if err != nil {
+		return report, fmt.Errorf("retention ran but its summary wasn't logged: %w", err)
+	}
+	return report, nil
+}
+
+// archiveAuditEntries strips the content of audit entries older than the
+// cutoff in batches, keeping the fields the chain and its reports need. It
+// returns the sequences archived.
+func archiveAuditEntries(policy RetentionPolicy, policies []RetentionPolicy, held *heldRecords, cutoff time.Time) ([]int64, error) {
+	params := "$cutoff: string, $category: string"
+	categoryFilter := "AND eq(category, $category)"
+	if policy.Category == "" {
+		params = "$cutoff: string"
+		// The catch-all leaves categories with their own policy alone
+		var others []string
+		for _, p := range policies {
+			if p.RecordType == RecordAuditEntry && p.Category != "" {
+				others = append(others, strconv.Quote(p.Category))
+			}
+		}
+		categoryFilter = ""
+		if len(others) > 0 {
+			categoryFilter = fmt.Sprintf("AND NOT eq(category, [%s])", strings.Join(others, ", "))
+		}
+	}
+
+	var archived []int64
+	for {
+		query := dgraph.NewQuery(fmt.Sprintf(`query archive(%s) {
+			expired as var(func: lt(timestamp, $cutoff), first: %d) @filter(type(AuditEntry) AND NOT has(archivedAt) %s %s)
+
+			archived(func: uid(expired)) {
+				sequence
+			}
+		}`, params, retentionBatchSize, categoryFilter, held.filterFor(RecordAuditEntry))).
+			WithVariable("$cutoff", cutoff.Format(time.RFC3339))
+		if policy.Category != "" {
+			query = query.WithVariable("$category", policy.Category)
+		}
+
+		mutation := dgraph.NewMutation().
+			WithCondition("@if(gt(len(expired), 0))").
+			WithDelNquads(`uid(expired) <objectId> * .
+uid(expired) <performedBy> * .
+uid(expired) <details> * .
+uid(expired) <previousValue> * .
+uid(expired) <newValue> * .`).
+			WithSetNquads(fmt.Sprintf(`uid(expired) <archivedAt> "%s"^^<xs:dateTime> .`, time.Now().UTC().Format(time.RFC3339)))
+
+		resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+		if err != nil {
+			return archived, fmt.Errorf("failed to archive audit entries: %w", err)
+		}
+
+		var result struct {
+			Archived []struct {
+				Sequence int64 `json:"sequence"`
+			} `json:"archived"`
+		}
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return archived, fmt.Errorf("failed to parse archived audit entries: %w", err)
+		}
+		for _, entry := range result.Archived {
+			archived = append(archived, entry.Sequence)
+		}
+		if len(result.Archived) < retentionBatchSize {
+			return archived, nil
+		}
+	}
+}
+
+// purgeRecords deletes records of a type older than the cutoff in batches
+// and returns how many were deleted
+func purgeRecords(recordType, holdFilter string, cutoff time.Time) (int, error) {
+	purged := 0
+	for {
+		query := dgraph.NewQuery(fmt.Sprintf(`query purge($cutoff: string) {
+			expired as var(func: lt(%s, $cutoff), first: %d) @filter(type(%s) %s)
+
+			purged(func: uid(expired)) {
+				count(uid)
+			}
+		}`, retentionClock[recordType], retentionBatchSize, recordType, holdFilter)).
+			WithVariable("$cutoff", cutoff.Format(time.RFC3339))
+
+		mutation := dgraph.NewMutation().
+			WithCondition("@if(gt(len(expired), 0))").
+			WithDelNquads(`uid(expired) * * .`)
+
+		resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge %s records: %w", recordType, err)
+		}
+
+		var result struct {
+			Purged []struct {
+				Count int `json:"count"`
+			} `json:"purged"`
+		}
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return purged, fmt.Errorf("failed to parse purged %s records: %w", recordType, err)
+		}
+		count := 0
+		if len(result.Purged) > 0 {
+			count = result.Purged[0].Count
+		}
+		purged += count
+		if count < retentionBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// sequenceRanges writes sequences as sorted ranges, e.g. "1-40,52".
+// Entries from before the chain have no sequence and are left out.
+func sequenceRanges(sequences []int64) string {
+	sorted := make([]int64, 0, len(sequences))
+	for _, sequence := range sequences {
+		if sequence > 0 {
+			sorted = append(sorted, sequence)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	sequences = sorted
+
+	var ranges []string
+	for i := 0; i < len(sequences); {
+		j := i
+		for j+1 < len(sequences) && sequences[j+1] == sequences[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.FormatInt(sequences[i], 10))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sequences[i], sequences[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
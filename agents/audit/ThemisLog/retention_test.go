@@ -0,0 +1,126 @@
+package themislog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func TestParseRetentionPolicies(t *testing.T) {
+	valid := `[
+		{"recordType": "AuditEntry", "retentionDays": 2555},
+		{"recordType": "AuditEntry", "category": "AUTHENTICATION", "retentionDays": 730},
+		{"recordType": "ChannelOTP", "retentionDays": 30},
+		{"recordType": "WebAuthnChallenge", "retentionDays": 1},
+		{"recordType": "AuthSession", "retentionDays": 90}
+	]`
+	if _, err := parseRetentionPolicies(valid); err != nil {
+		t.Fatalf("Expected valid policies, got %v", err)
+	}
+
+	tests := map[string]string{
+		"missing record type": `[{"recordType": "AuditEntry", "retentionDays": 10}]`,
+		"unknown record type": strings.Replace(valid, `"ChannelOTP", "retentionDays": 30}`, `"ChannelOTP", "retentionDays": 30}, {"recordType": "User", "retentionDays": 1}`, 1),
+		"category off audit":  strings.Replace(valid, `"ChannelOTP", "retentionDays": 30}`, `"ChannelOTP", "retentionDays": 30}, {"recordType": "ChannelOTP", "category": "PII_ACCESS", "retentionDays": 1}`, 1),
+		"unsafe category":     strings.Replace(valid, `"AUTHENTICATION"`, `"AUTH\") OR has(uid"`, 1),
+		"zero days":           strings.Replace(valid, `"retentionDays": 30`, `"retentionDays": 0`, 1),
+		"duplicate":           strings.Replace(valid, `"AUTHENTICATION"`, `""`, 1),
+	}
+	for name, config := range tests {
+		if _, err := parseRetentionPolicies(config); err == nil {
+			t.Errorf("%s: expected the policies to be refused", name)
+		}
+	}
+}
+
+func TestDefaultRetentionFollowsCategory(t *testing.T) {
+	tests := []struct {
+		category string
+		days     int
+	}{
+		{CategoryAuthentication, 730},
+		{CategoryPIIAccess, 2555},
+		{CategoryAdministration, 2555},
+	}
+	for _, tt := range tests {
+		if got := retentionFor(DefaultRetentionPolicies, RecordAuditEntry, tt.category).RetentionDays; got != tt.days {
+			t.Errorf("Expected %s entries to be kept %d days, got %d", tt.category, tt.days, got)
+		}
+	}
+}
+
+func TestSequenceRanges(t *testing.T) {
+	if got := sequenceRanges([]int64{52, 3, 1, 2, 0, 4, 40}); got != "1-4,40,52" {
+		t.Errorf("Expected 1-4,40,52, got %q", got)
+	}
+	if got := sequenceRanges(nil); got != "" {
+		t.Errorf("Expected no ranges, got %q", got)
+	}
+}
+
+func TestHeldRecordsAreLeftOut(t *testing.T) {
+	held := &heldRecords{users: []string{"0x1", "0x2"}, centres: []string{"0x9"}, channels: []string{"abc"}}
+
+	tests := map[string][]string{
+		RecordAuditEntry:        {`NOT eq(performedBy, ["0x1", "0x2", "0x9"])`, `NOT eq(objectId, ["0x1", "0x2", "0x9"])`},
+		RecordChannelOTP:        {`NOT eq(channelHash, ["abc"])`},
+		RecordWebAuthnChallenge: {`NOT eq(userId, ["0x1", "0x2"])`},
+		RecordAuthSession:       {`NOT uid_in(user, [0x1, 0x2])`},
+	}
+	for recordType, wants := range tests {
+		filter := held.filterFor(recordType)
+		for _, want := range wants {
+			if !strings.Contains(filter, want) {
+				t.Errorf("Expected the %s filter to contain %s, got %q", recordType, want, filter)
+			}
+		}
+	}
+
+	if filter := (&heldRecords{}).filterFor(RecordAuthSession); filter != "" {
+		t.Errorf("Expected no filter without holds, got %q", filter)
+	}
+}
+
+func TestRunRetentionPurgesEachPolicyAndSummarises(t *testing.T) {
+	defaults, _ := json.Marshal(DefaultRetentionPolicies)
+	if err := SetRetentionPolicies(string(defaults)); err != nil {
+		t.Fatalf("Expected the default policies to be valid, got %v", err)
+	}
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	report, err := RunRetention("ThemisLog")
+	if err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
+	}
+	if len(report.Results) != len(DefaultRetentionPolicies) {
+		t.Fatalf("Expected a result per policy, got %+v", report.Results)
+	}
+
+	var queries []string
+	for _, item := range dgraph.DgraphQueryCallStack.Items[before:] {
+		queries = append(queries, item[1].(*dgraph.Request).Query.Query)
+	}
+	all := strings.Join(queries, "\n")
+	for _, want := range []string{
+		"NOT eq(category, [\"AUTHENTICATION\", \"AUTHORIZATION\", \"PII_ACCESS\"])",
+		"lt(createdAt, $cutoff), first: 1000) @filter(type(ChannelOTP)",
+		"lt(expiresAt, $cutoff), first: 1000) @filter(type(AuthSession)",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected a query containing %s", want)
+		}
+	}
+
+	authCutoff := report.Results[0].Cutoff
+	if age := report.StartedAt.Sub(authCutoff); age != 730*24*time.Hour {
+		t.Errorf("Expected authentication entries to be cut off after 730 days, got %v", age)
+	}
+
+	last := dgraph.DgraphQueryCallStack.Items[dgraph.DgraphQueryCallStack.Size()-1][1].(*dgraph.Request)
+	if !strings.Contains(last.Mutations[0].SetNquads, `<action> "RETENTION_RUN"`) {
+		t.Error("Expected the run to end with a RETENTION_RUN audit entry")
+	}
+}
@@ -0,0 +1,193 @@
+package themislog
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// verifyPageSize is how many entries VerifyChain reads per query
+const verifyPageSize = 1000
+
+// Problems VerifyChain can find
+const (
+	ProblemGap        = "gap"         // entries are missing before this one
+	ProblemDuplicate  = "duplicate"   // another entry has the same sequence
+	ProblemModified   = "modified"    // the entry's content doesn't match its hash
+	ProblemBrokenLink = "broken_link" // previousHash doesn't match the entry before
+	ProblemTruncated  = "truncated"   // the newest entries are missing
+)
+
+// ChainProblem is one place where the chain doesn't hold
+type ChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	EntryID  string `json:"entryId,omitempty"`
+	Kind     string `json:"kind"` // one of the Problem* values
+	Detail   string `json:"detail"`
+}
+
+// VerificationReport is the result of walking the chain
+type VerificationReport struct {
+	Valid          bool           `json:"valid"`
+	EntriesChecked int            `json:"entriesChecked"`
+	HeadSequence   int64          `json:"headSequence"`
+	Problems       []ChainProblem `json:"problems,omitempty"`
+	VerifiedAt     time.Time      `json:"verifiedAt"`
+}
+
+// chainWalk carries the walk from one page of entries to the next
+type chainWalk struct {
+	previous *AuditEntry
+	checked  int
+	problems []ChainProblem
+}
+
+// check compares each entry with its own hash and with the entry before
+// it. Entries must be ordered by sequence.
+func (w *chainWalk) check(entries []AuditEntry) {
+	for i := range entries {
+		entry := &entries[i]
+		w.checked++
+
+		// Archived entries have lost their content but keep their links
+		if entry.ArchivedAt == nil && entry.computeHash() != entry.Hash {
+			w.report(entry, ProblemModified, "content does not match the stored hash")
+		}
+
+		expected := int64(1)
+		expectedHash := ""
+		if w.previous != nil {
+			expected = w.previous.Sequence + 1
+			expectedHash = w.previous.Hash
+		}
+
+		switch {
+		case w.previous != nil && entry.Sequence == w.previous.Sequence:
+			w.report(entry, ProblemDuplicate, fmt.Sprintf("sequence also used by entry %s", w.previous.ID))
+			continue // keep linking from the first one
+		case entry.Sequence > expected:
+			w.report(entry, ProblemGap, fmt.Sprintf("entries %d to %d are missing", expected, entry.Sequence-1))
+		case entry.PreviousHash != expectedHash:
+			w.report(entry, ProblemBrokenLink, "previousHash does not match the entry before")
+		}
+		w.previous = entry
+	}
+}
+
+// finish compares the last entry walked with the chain head
+func (w *chainWalk) finish(head chainHead) {
+	last := chainHead{}
+	if w.previous != nil {
+		last = chainHead{Sequence: w.previous.Sequence, Hash: w.previous.Hash}
+	}
+	switch {
+	case head.Sequence > last.Sequence:
+		w.problems = append(w.problems, ChainProblem{
+			Sequence: last.Sequence + 1,
+			Kind:     ProblemTruncated,
+			Detail:   fmt.Sprintf("the head is at %d but the last entry is %d", head.Sequence, last.Sequence),
+		})
+	case head.Sequence == last.Sequence && head.Hash != last.Hash:
+		w.problems = append(w.problems, ChainProblem{
+			Sequence: last.Sequence,
+			Kind:     ProblemTruncated,
+			Detail:   "the last entry is not the one the head points to",
+		})
+	}
+}
+
+func (w *chainWalk) report(entry *AuditEntry, kind, detail string) {
+	w.problems = append(w.problems, ChainProblem{Sequence: entry.Sequence, EntryID: entry.ID, Kind: kind, Detail: detail})
+}
+
+// VerifyChain walks the whole audit chain and reports every entry that was
+// changed, removed or inserted since it was written. The head is read
+// first, so entries appended during the walk are checked but don't count
+// as problems.
+func VerifyChain() (*VerificationReport, error) {
+	head, err := loadHead()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages overlap on their last sequence, so an entry sharing it with
+	// another isn't skipped; entries already checked are dropped
+	walk := &chainWalk{}
+	from := int64(0)
+	seen := map[string]bool{}
+	for {
+		page, err := loadEntries(from, verifyPageSize)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]AuditEntry, 0, len(page))
+		for _, entry := range page {
+			if !seen[entry.UID] {
+				entries = append(entries, entry)
+			}
+		}
+		walk.check(entries)
+		if len(page) < verifyPageSize || len(entries) == 0 {
+			break
+		}
+
+		from = page[len(page)-1].Sequence
+		seen = map[string]bool{}
+		for _, entry := range page {
+			if entry.Sequence == from {
+				seen[entry.UID] = true
+			}
+		}
+	}
+	walk.finish(head)
+
+	return &VerificationReport{
+		Valid:          len(walk.problems) == 0,
+		EntriesChecked: walk.checked,
+		HeadSequence:   head.Sequence,
+		Problems:       walk.problems,
+		VerifiedAt:     time.Now(),
+	}, nil
+}
+
+// loadEntries reads chained entries from a sequence on, in order. Entries
+// written before the chain have no sequence and are skipped.
+func loadEntries(from int64, limit int) ([]AuditEntry, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query entries($from: int) {
+		entries(func: ge(sequence, $from), orderasc: sequence, first: %d) @filter(type(AuditEntry)) {
+			uid
+			id
+			sequence
+			previousHash
+			hash
+			category
+			action
+			objectType
+			objectId
+			performedBy
+			timestamp
+			details
+			previousValue
+			newValue
+			severity
+			source
+			retentionDate
+			archivedAt
+		}
+	}`, limit)).WithVariable("$from", from)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit entries: %w", err)
+	}
+
+	var result struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse audit entries: %w", err)
+	}
+	return result.Entries, nil
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	charonotp "modus/agents/auth/CharonOTP"
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/services/totp"
 	"modus/services/webauthn"
 )
 
@@ -64,7 +67,7 @@ func CerberusMFA(req CerberusMFARequest) (*CerberusMFAResponse, error) {
 			UserExists:       true,
 			Action:          "signin",
 			UserID:          userID,
-			AvailableMethods: []string{"webauthn", "passwordless"},
+			AvailableMethods: signinMethods(userID),
 			NextStep:        "Choose authentication method: WebAuthn (biometric/hardware) or Passwordless DID",
 			Message:         "Welcome back! Please complete authentication.",
 		}, nil
@@ -99,6 +102,20 @@ func CerberusMFA(req CerberusMFARequest) (*CerberusMFAResponse, error) {
 	}
 }
 
+// signinMethods lists the factors an existing user can sign in with
+func signinMethods(userID string) []string {
+	methods := []string{"webauthn", "passwordless"}
+
+	hasTOTP, err := totp.NewTOTPService().HasTOTP(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check TOTP enrolment: %v", err)
+	} else if hasTOTP {
+		methods = append(methods, "totp")
+	}
+
+	return methods
+}
+
 // checkUserByChannel checks if a user exists by channel hash
 func checkUserByChannel(channelDID, channelType string) (bool, string, error) {
 	// Create DQL query to check if user exists by channel hash
@@ -300,7 +317,10 @@ func InitiateWebAuthnRegistration(userID, username, displayName string) (*webaut
 	// Debug: log.Printf("🔐 CerberusMFA: Initiating WebAuthn registration for user %s", userID)
 	
 	ctx := context.Background()
-	webauthnService := webauthn.NewWebAuthnService()
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
 	
 	req := webauthn.ChallengeRequest{
 		UserID:      userID,
@@ -322,7 +342,10 @@ func VerifyWebAuthnRegistration(req webauthn.RegistrationRequest) (*webauthn.Reg
 	// Debug: log.Printf("🔐 CerberusMFA: Verifying WebAuthn registration for user %s", req.UserID)
 	
 	ctx := context.Background()
-	webauthnService := webauthn.NewWebAuthnService()
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
 	
 	response, err := webauthnService.VerifyRegistration(ctx, req)
 	if err != nil {
@@ -342,7 +365,10 @@ func VerifyWebAuthnRegistration(req webauthn.RegistrationRequest) (*webauthn.Reg
 func InitiateWebAuthnAuthentication(userID string) (*webauthn.AssertionChallengeResponse, error) {
 	// Debug: log.Printf("🔐 CerberusMFA: Initiating WebAuthn authentication for user %s", userID)
 	
-	webauthnService := webauthn.NewWebAuthnService()
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
 	
 	req := webauthn.AssertionChallengeRequest{
 		UserID: userID,
@@ -361,7 +387,10 @@ func InitiateWebAuthnAuthentication(userID string) (*webauthn.AssertionChallenge
 func VerifyWebAuthnAuthentication(req webauthn.AuthenticationRequest) (*webauthn.AuthenticationResponse, error) {
 	// Debug: log.Printf("🔐 CerberusMFA: Verifying WebAuthn authentication for user %s", req.UserID)
 	
-	webauthnService := webauthn.NewWebAuthnService()
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
 	
 	response, err := webauthnService.VerifyAuthentication(req)
 	if err != nil {
@@ -376,3 +405,183 @@ func VerifyWebAuthnAuthentication(req webauthn.AuthenticationRequest) (*webauthn
 	
 	return &response, nil
 }
+
+// challengeSweepRole may remove expired WebAuthn challenges
+const challengeSweepRole = "admin"
+
+// SweepWebAuthnChallengesAsAdmin removes expired WebAuthn challenges
+func SweepWebAuthnChallengesAsAdmin(adminUserID string) (int, error) {
+	isAdmin, err := userHasRole(adminUserID, challengeSweepRole)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return 0, fmt.Errorf("only administrators can sweep WebAuthn challenges")
+	}
+
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return 0, err
+	}
+
+	swept, err := webauthnService.SweepExpiredChallenges()
+	if err != nil {
+		return 0, fmt.Errorf("failed to sweep WebAuthn challenges: %v", err)
+	}
+
+	log.Printf("🧹 Admin %s swept %d expired WebAuthn challenges", adminUserID, swept)
+	return swept, nil
+}
+
+// WebAuthn Credential Management Functions
+
+// ListWebAuthnCredentials lists the authenticators registered by a user
+func ListWebAuthnCredentials(userID string) ([]webauthn.CredentialInfo, error) {
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := webauthnService.ListCredentials(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebAuthn credentials: %v", err)
+	}
+
+	return credentials, nil
+}
+
+// HasEnrolledFactor reports whether a user has a passkey or a confirmed
+// authenticator app. Adding another factor to such an account needs a
+// sensitive-action session, so a weaker session can't attach its own.
+func HasEnrolledFactor(userID string) (bool, error) {
+	credentials, err := ListWebAuthnCredentials(userID)
+	if err != nil {
+		return false, err
+	}
+	if len(credentials) > 0 {
+		return true, nil
+	}
+
+	hasTOTP, err := totp.NewTOTPService().HasTOTP(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check TOTP enrolment: %v", err)
+	}
+	return hasTOTP, nil
+}
+
+// RenameWebAuthnCredential sets the nickname of one of the user's authenticators
+func RenameWebAuthnCredential(userID, credentialID, nickname string) (*webauthn.CredentialResponse, error) {
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := webauthnService.RenameCredential(webauthn.RenameCredentialRequest{
+		UserID:       userID,
+		CredentialID: credentialID,
+		Nickname:     nickname,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename WebAuthn credential: %v", err)
+	}
+
+	return &response, nil
+}
+
+// RevokeWebAuthnCredential removes one of the user's authenticators and ends
+// every session that was created with it. When otp is set it is verified
+// first as the second factor needed to remove the user's last authenticator.
+func RevokeWebAuthnCredential(ctx context.Context, userID, credentialID, reason string, otp *charonotp.VerifyOTPRequest) (*webauthn.CredentialResponse, error) {
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err != nil {
+		return nil, err
+	}
+
+	otherFactorVerified := false
+	if otp != nil && otp.OTPCode != "" {
+		// Only a code sent for step-up can approve removing an authenticator
+		otpReq := *otp
+		otpReq.Purpose = charonotp.OTPPurposeStepUp
+		otpResp, err := charonotp.VerifyOTP(otpReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify OTP: %v", err)
+		}
+		// The code must have been sent to one of this user's channels
+		if !otpResp.Verified || otpResp.UserID != userID {
+			return &webauthn.CredentialResponse{
+				Success: false,
+				Message: "Second factor verification failed",
+			}, nil
+		}
+		otherFactorVerified = true
+	}
+
+	response, err := webauthnService.RevokeCredential(webauthn.RevokeCredentialRequest{
+		UserID:              userID,
+		CredentialID:        credentialID,
+		Reason:              reason,
+		OtherFactorVerified: otherFactorVerified,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke WebAuthn credential: %v", err)
+	}
+	if !response.Success {
+		return &response, nil
+	}
+
+	// Sign out everywhere the revoked authenticator was used
+	revoked, err := chronossession.RevokeCredentialSessions(ctx, response.CredentialID, "authenticator revoked")
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to revoke sessions for credential %s: %v", response.CredentialID, err)
+	} else if revoked > 0 {
+		response.Message = fmt.Sprintf("%s; %d session(s) signed out", response.Message, revoked)
+	}
+
+	return &response, nil
+}
+
+// TOTP Integration Functions
+
+// BeginTOTPEnrollment starts authenticator app enrolment for a user
+func BeginTOTPEnrollment(userID, accountName string) (*totp.EnrollmentResponse, error) {
+	totpService := totp.NewTOTPService()
+
+	response, err := totpService.BeginEnrollment(totp.EnrollmentRequest{
+		UserID:      userID,
+		AccountName: accountName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin TOTP enrolment: %v", err)
+	}
+
+	return &response, nil
+}
+
+// ConfirmTOTPEnrollment activates a pending authenticator app enrolment
+func ConfirmTOTPEnrollment(userID, code string) (*totp.VerifyResponse, error) {
+	totpService := totp.NewTOTPService()
+
+	response, err := totpService.ConfirmEnrollment(totp.VerifyRequest{UserID: userID, Code: code})
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm TOTP enrolment: %v", err)
+	}
+
+	return &response, nil
+}
+
+// verifyTOTP checks an authenticator app code as a second factor. It is
+// only reached through StepUpWithTOTP, once the user has signed in.
+func verifyTOTP(userID, code string) (*totp.VerifyResponse, error) {
+	totpService := totp.NewTOTPService()
+
+	response, err := totpService.Verify(totp.VerifyRequest{UserID: userID, Code: code})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify TOTP code: %v", err)
+	}
+
+	if !response.Success {
+		log.Printf("❌ TOTP verification failed for user %s: %s", userID, response.Message)
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,192 @@
+package cerberusmfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	themislog "modus/agents/audit/ThemisLog"
+	chronossession "modus/agents/sessions/ChronosSession"
+)
+
+// auditAdminRole is the role allowed to verify the audit trail
+const auditAdminRole = "admin"
+
+// auditReviewerRoles may query and export the audit trail
+var auditReviewerRoles = []string{"admin", "verifier"}
+
+// auditStatementIssuer is the iss of signed export manifests
+const auditStatementIssuer = "ThemisLog"
+
+// VerifyAuditChainAsAdmin walks the audit hash chain and records the
+// outcome on the chain itself
+func VerifyAuditChainAsAdmin(adminUserID string) (*themislog.VerificationReport, error) {
+	isAdmin, err := userHasRole(adminUserID, auditAdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can verify the audit trail")
+	}
+
+	report, err := themislog.VerifyChain()
+	if err != nil {
+		return nil, err
+	}
+
+	severity := themislog.SeverityInfo
+	if !report.Valid {
+		severity = themislog.SeverityCritical
+	}
+	if _, err := themislog.LogEvent(themislog.Event{
+		Category:    themislog.CategoryAdministration,
+		Action:      "AUDIT_CHAIN_VERIFIED",
+		ObjectType:  "AuditChainHead",
+		PerformedBy: adminUserID,
+		Severity:    severity,
+		Source:      "CerberusMFA",
+		Details:     fmt.Sprintf("%d entries checked up to %d; %d problem(s)", report.EntriesChecked, report.HeadSequence, len(report.Problems)),
+	}); err != nil {
+		log.Printf("⚠️ Failed to audit chain verification: %v", err)
+	}
+
+	log.Printf("🔍 Admin %s verified %d audit entries: %d problem(s)", adminUserID, report.EntriesChecked, len(report.Problems))
+	return report, nil
+}
+
+// QueryAuditAsReviewer returns a page of audit entries, newest first
+func QueryAuditAsReviewer(userID string, filter themislog.Filter, cursor string, limit int) (*themislog.EntryPage, error) {
+	if err := requireAuditReviewer(userID); err != nil {
+		return nil, err
+	}
+	return themislog.QueryEntries(filter, cursor, limit)
+}
+
+// CountAuditActionsAsReviewer counts audit entries per action and day
+func CountAuditActionsAsReviewer(userID string, filter themislog.Filter) ([]themislog.ActionCount, error) {
+	if err := requireAuditReviewer(userID); err != nil {
+		return nil, err
+	}
+	return themislog.CountActionsByDay(filter)
+}
+
+// ExportAuditAsReviewer exports audit entries and signs the manifest with
+// the session key, so an auditor can check the bundle offline against the
+// JWKS. The export itself is audited.
+func ExportAuditAsReviewer(userID string, filter themislog.Filter, format string) (*themislog.ExportBundle, error) {
+	if err := requireAuditReviewer(userID); err != nil {
+		return nil, err
+	}
+
+	bundle, err := themislog.ExportEntries(filter, format, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestJSON, err := json.Marshal(bundle.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export manifest: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(manifestJSON, &claims); err != nil {
+		return nil, fmt.Errorf("failed to encode export manifest: %v", err)
+	}
+	if bundle.Signature, err = chronossession.SignStatement(auditStatementIssuer, claims); err != nil {
+		return nil, fmt.Errorf("failed to sign export manifest: %v", err)
+	}
+
+	filterJSON, _ := json.Marshal(filter)
+	if _, err := themislog.LogEvent(themislog.Event{
+		Category:    themislog.CategoryPIIAccess,
+		Action:      "AUDIT_EXPORTED",
+		ObjectType:  "AuditEntry",
+		ObjectID:    bundle.Manifest.FileName,
+		PerformedBy: userID,
+		Severity:    themislog.SeverityWarning,
+		Source:      "CerberusMFA",
+		Details:     fmt.Sprintf("%d entries exported as %s; filter %s", bundle.Manifest.EntryCount, format, filterJSON),
+	}); err != nil {
+		log.Printf("⚠️ Failed to record audit export: %v", err)
+	}
+
+	log.Printf("📦 User %s exported %d audit entries as %s", userID, bundle.Manifest.EntryCount, format)
+	return bundle, nil
+}
+
+// requireAuditReviewer checks that a user may read the audit trail
+func requireAuditReviewer(userID string) error {
+	for _, role := range auditReviewerRoles {
+		hasRole, err := userHasRole(userID, role)
+		if err != nil {
+			return fmt.Errorf("failed to check audit reviewer role: %v", err)
+		}
+		if hasRole {
+			return nil
+		}
+	}
+	return fmt.Errorf("only administrators and verifiers can read the audit trail")
+}
+
+// PlaceLegalHoldAsAdmin keeps a user's or centre's records from retention
+// purges until the hold is released
+func PlaceLegalHoldAsAdmin(adminUserID, userID, centreID, reason string) (*themislog.LegalHold, error) {
+	isAdmin, err := userHasRole(adminUserID, auditAdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can place legal holds")
+	}
+
+	hold, err := themislog.PlaceLegalHold(adminUserID, userID, centreID, reason)
+	if err != nil {
+		return hold, err
+	}
+
+	log.Printf("⚖️ Admin %s placed legal hold %s", adminUserID, hold.ID)
+	return hold, nil
+}
+
+// ReleaseLegalHoldAsAdmin releases a legal hold; false if no active hold
+// has that ID
+func ReleaseLegalHoldAsAdmin(adminUserID, holdID, reason string) (bool, error) {
+	isAdmin, err := userHasRole(adminUserID, auditAdminRole)
+	if err != nil {
+		return false, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return false, fmt.Errorf("only administrators can release legal holds")
+	}
+
+	released, err := themislog.ReleaseLegalHold(adminUserID, holdID, reason)
+	if err != nil {
+		return released, err
+	}
+
+	if released {
+		log.Printf("⚖️ Admin %s released legal hold %s", adminUserID, holdID)
+	}
+	return released, nil
+}
+
+// ApplyRetentionAsAdmin removes records past their retention policy, except
+// those under a legal hold. The run is recorded as performed by the admin.
+func ApplyRetentionAsAdmin(adminUserID string) (*themislog.RetentionReport, error) {
+	isAdmin, err := userHasRole(adminUserID, auditAdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can apply retention policies")
+	}
+
+	return themislog.RunRetention(adminUserID)
+}
+
+// ListLegalHoldsAsReviewer lists legal holds, newest first
+func ListLegalHoldsAsReviewer(userID string, activeOnly bool) ([]themislog.LegalHold, error) {
+	if err := requireAuditReviewer(userID); err != nil {
+		return nil, err
+	}
+	return themislog.ListLegalHolds(activeOnly)
+}
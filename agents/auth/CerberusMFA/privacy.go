@@ -0,0 +1,200 @@
+package cerberusmfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	charonotp "modus/agents/auth/CharonOTP"
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/services/gdpr"
+)
+
+// privacyAdminRole is the role allowed to handle data-subject requests
+const privacyAdminRole = "admin"
+
+// ErasureResponse represents the state of an erasure request
+type ErasureResponse struct {
+	Success   bool                `json:"success"`
+	Status    string              `json:"status,omitempty"` // see gdpr.Status*
+	RequestID string              `json:"requestId,omitempty"`
+	DueAt     time.Time           `json:"dueAt,omitempty"`
+	Report    *gdpr.ErasureReport `json:"report,omitempty"` // once erased
+	Message   string              `json:"message"`
+}
+
+// ExportSubjectData returns the signed-in user's own data as an archive
+func ExportSubjectData(userID string) (*gdpr.SubjectArchive, error) {
+	return gdpr.ExportSubjectData(userID, userID)
+}
+
+// ExportSubjectDataAsAdmin exports a user's data for a subject access
+// request received outside the app
+func ExportSubjectDataAsAdmin(adminUserID, userID string) (*gdpr.SubjectArchive, error) {
+	if err := requirePrivacyAdmin(adminUserID); err != nil {
+		return nil, err
+	}
+	return gdpr.ExportSubjectData(userID, adminUserID)
+}
+
+// RequestErasure queues the signed-in user's request to erase their data.
+// The user proves it is them again with an OTP sent with purpose
+// "account-delete" to one of their own channels.
+func RequestErasure(userID, recipient, otpCode, reason string) (*ErasureResponse, error) {
+	otpResp, err := charonotp.VerifyOTP(charonotp.VerifyOTPRequest{
+		OTPCode:   otpCode,
+		Recipient: recipient,
+		Purpose:   charonotp.OTPPurposeAccountDelete,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify OTP: %v", err)
+	}
+	if !otpResp.Verified || otpResp.UserID != userID {
+		return &ErasureResponse{
+			Success: false,
+			Message: "We couldn't confirm it's you. Check the code and try again",
+		}, nil
+	}
+
+	req, err := gdpr.OpenErasureRequest(userID, otpResp.ChannelDID, reason)
+	if errors.Is(err, gdpr.ErrRequestOpen) {
+		return &ErasureResponse{Success: false, Message: "You already have an erasure request in progress"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to request erasure: %v", err)
+	}
+
+	return &ErasureResponse{
+		Success:   true,
+		Status:    req.Status,
+		RequestID: req.UID,
+		DueAt:     req.DueAt,
+		Message:   "Your request has been sent to an administrator. We'll erase your data once it is approved",
+	}, nil
+}
+
+// ListErasureRequestsAsAdmin returns erasure requests in a status, oldest
+// first; pending approval if status is empty
+func ListErasureRequestsAsAdmin(adminUserID, status string) ([]gdpr.ErasureRequest, error) {
+	if err := requirePrivacyAdmin(adminUserID); err != nil {
+		return nil, err
+	}
+	if status == "" {
+		status = gdpr.StatusPendingApproval
+	}
+
+	requests, err := gdpr.ListErasureRequests(status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list erasure requests: %v", err)
+	}
+	return requests, nil
+}
+
+// ApproveErasureAsAdmin approves a pending erasure request and erases the
+// user: their sessions are revoked, then their data is erased. An approved
+// request whose erasure didn't finish, e.g. because of a legal hold, is
+// retried by approving it again.
+func ApproveErasureAsAdmin(ctx context.Context, adminUserID, requestID string) (*ErasureResponse, error) {
+	if err := requirePrivacyAdmin(adminUserID); err != nil {
+		return nil, err
+	}
+
+	req, err := gdpr.GetErasureRequest(requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load erasure request: %v", err)
+	}
+	if req == nil || (req.Status != gdpr.StatusPendingApproval && req.Status != gdpr.StatusApproved) {
+		return &ErasureResponse{Success: false, Message: "Erasure request is not awaiting approval"}, nil
+	}
+	if req.UserID == adminUserID {
+		return &ErasureResponse{Success: false, Message: "Administrators can't approve their own erasure"}, nil
+	}
+
+	if req.Status == gdpr.StatusPendingApproval {
+		approved, err := gdpr.ApproveErasureRequest(req, adminUserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to approve erasure: %v", err)
+		}
+		if !approved {
+			return &ErasureResponse{Success: false, Message: "Erasure request is not awaiting approval"}, nil
+		}
+		req.Status = gdpr.StatusApproved
+	}
+
+	if _, err := chronossession.RevokeUserSessions(ctx, req.UserID, "account erased"); err != nil {
+		log.Printf("⚠️ Warning: Failed to revoke sessions before erasure: %v", err)
+	}
+
+	report, err := gdpr.CompleteErasureRequest(req, adminUserID)
+	if errors.Is(err, gdpr.ErrLegalHold) {
+		return &ErasureResponse{
+			Success:   false,
+			Status:    gdpr.StatusApproved,
+			RequestID: req.UID,
+			DueAt:     req.DueAt,
+			Message:   "Erasure approved, but the user is under a legal hold. Approve it again once the hold is released",
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase user: %v", err)
+	}
+
+	return &ErasureResponse{
+		Success:   true,
+		Status:    gdpr.StatusCompleted,
+		RequestID: req.UID,
+		DueAt:     req.DueAt,
+		Report:    report,
+		Message:   "User erased",
+	}, nil
+}
+
+// RejectErasureAsAdmin rejects a pending erasure request, e.g. when the
+// data must be kept to meet a legal obligation
+func RejectErasureAsAdmin(adminUserID, requestID, note string) (*ErasureResponse, error) {
+	if err := requirePrivacyAdmin(adminUserID); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(note) == "" {
+		return &ErasureResponse{Success: false, Message: "Say why the request is rejected"}, nil
+	}
+
+	req, err := gdpr.GetErasureRequest(requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load erasure request: %v", err)
+	}
+	if req == nil || req.Status != gdpr.StatusPendingApproval {
+		return &ErasureResponse{Success: false, Message: "Erasure request is not awaiting approval"}, nil
+	}
+
+	rejected, err := gdpr.RejectErasureRequest(req, adminUserID, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject erasure: %v", err)
+	}
+	if !rejected {
+		return &ErasureResponse{Success: false, Message: "Erasure request is not awaiting approval"}, nil
+	}
+
+	return &ErasureResponse{
+		Success:   true,
+		Status:    gdpr.StatusRejected,
+		RequestID: req.UID,
+		DueAt:     req.DueAt,
+		Message:   "Erasure request rejected",
+	}, nil
+}
+
+// requirePrivacyAdmin checks that a user may handle data-subject requests
+func requirePrivacyAdmin(userID string) error {
+	isAdmin, err := userHasRole(userID, privacyAdminRole)
+	if err != nil {
+		return fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return fmt.Errorf("only administrators can handle data-subject requests")
+	}
+	return nil
+}
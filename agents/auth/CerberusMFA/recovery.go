@@ -0,0 +1,509 @@
+package cerberusmfa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	charonotp "modus/agents/auth/CharonOTP"
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/services/email"
+	"modus/services/pii"
+	"modus/services/recovery"
+	"modus/services/webauthn"
+)
+
+// recoveryApproverRole is the role allowed to approve account recovery
+const recoveryApproverRole = "admin"
+
+// RecoveryStatusIncomplete is reported when a recovery was completed but
+// not every session or passkey could be revoked. No session is issued; the
+// user has to go through support.
+const RecoveryStatusIncomplete = "incomplete"
+
+// AccountRecoveryResponse represents the state of an account recovery
+type AccountRecoveryResponse struct {
+	Success            bool      `json:"success"`
+	Status             string    `json:"status,omitempty"`        // see recovery.Status* and RecoveryStatusIncomplete
+	RecoveryToken      string    `json:"recoveryToken,omitempty"` // only returned when recovery starts
+	ExpiresAt          time.Time `json:"expiresAt,omitempty"`
+	UserID             string    `json:"userId,omitempty"`
+	RevokedSessions    int       `json:"revokedSessions,omitempty"`
+	RevokedCredentials int       `json:"revokedCredentials,omitempty"`
+	Message            string    `json:"message"`
+}
+
+// Recovery Code Functions
+
+// IssueInitialRecoveryCodes creates recovery codes when a user enrols a
+// factor and has no unused codes left. It returns nil if they already do.
+func IssueInitialRecoveryCodes(userID string) ([]string, error) {
+	codeService := recovery.NewRecoveryCodeService()
+
+	remaining, err := codeService.Remaining(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check recovery codes: %v", err)
+	}
+	if remaining > 0 {
+		return nil, nil
+	}
+
+	return RegenerateRecoveryCodes(userID)
+}
+
+// RegenerateRecoveryCodes replaces all of a user's recovery codes
+func RegenerateRecoveryCodes(userID string) ([]string, error) {
+	codeService := recovery.NewRecoveryCodeService()
+
+	codes, err := codeService.Generate(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %v", err)
+	}
+
+	return codes, nil
+}
+
+// Account Recovery Functions
+
+// StartAccountRecovery opens a recovery for a user who can still receive an
+// OTP on one of their channels. The OTP must have been sent with purpose
+// "recovery". The returned token is then used with a recovery code or to
+// ask an admin for approval.
+func StartAccountRecovery(recipient, otpCode string) (*AccountRecoveryResponse, error) {
+	otpResp, err := charonotp.VerifyOTP(charonotp.VerifyOTPRequest{
+		OTPCode:   otpCode,
+		Recipient: recipient,
+		Purpose:   charonotp.OTPPurposeRecovery,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify OTP: %v", err)
+	}
+	if !otpResp.Verified || otpResp.Action != "signin" || otpResp.UserID == "" {
+		return &AccountRecoveryResponse{
+			Success: false,
+			Message: "Account recovery could not be started. Check the code and try again",
+		}, nil
+	}
+
+	recoveryService := recovery.NewRecoveryService()
+
+	token, expiresAt, err := recoveryService.Start(otpResp.UserID, otpResp.ChannelDID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start account recovery: %v", err)
+	}
+
+	return &AccountRecoveryResponse{
+		Success:       true,
+		Status:        recovery.StatusOTPVerified,
+		RecoveryToken: token,
+		ExpiresAt:     expiresAt,
+		Message:       "Enter one of your recovery codes, or request admin approval if you no longer have any",
+	}, nil
+}
+
+// CompleteRecoveryWithCode finishes a recovery with one of the user's
+// recovery codes. recipient is the address the recovery OTP went to; it is
+// emailed the security alert along with the user's other verified addresses.
+func CompleteRecoveryWithCode(ctx context.Context, token, code, recipient string) (*AccountRecoveryResponse, error) {
+	recoveryService := recovery.NewRecoveryService()
+
+	req, err := recoveryService.Get(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recovery request: %v", err)
+	}
+	if !req.Open(recovery.StatusOTPVerified, time.Now()) {
+		return &AccountRecoveryResponse{Success: false, Message: "Invalid or expired recovery request"}, nil
+	}
+
+	codeService := recovery.NewRecoveryCodeService()
+
+	redeemed, err := codeService.Consume(req.UserID, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check recovery code: %v", err)
+	}
+	if !redeemed {
+		if recoveryService.RecordCodeFailure(req) {
+			return &AccountRecoveryResponse{
+				Success: false,
+				Status:  recovery.StatusRejected,
+				Message: "Too many wrong recovery codes. Start account recovery again",
+			}, nil
+		}
+		return &AccountRecoveryResponse{
+			Success: false,
+			Status:  recovery.StatusOTPVerified,
+			Message: "Invalid or already used recovery code",
+		}, nil
+	}
+
+	return performRecovery(ctx, req, recovery.StatusOTPVerified, recovery.MethodRecoveryCode, recipient)
+}
+
+// RequestRecoveryApproval queues a recovery for admin approval, for users
+// who have lost their recovery codes as well as their authenticators
+func RequestRecoveryApproval(token, reason string) (*AccountRecoveryResponse, error) {
+	recoveryService := recovery.NewRecoveryService()
+
+	req, err := recoveryService.Get(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recovery request: %v", err)
+	}
+	if !req.Open(recovery.StatusOTPVerified, time.Now()) {
+		return &AccountRecoveryResponse{Success: false, Message: "Invalid or expired recovery request"}, nil
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return &AccountRecoveryResponse{Success: false, Message: "Tell us why you need your account reset"}, nil
+	}
+
+	queued, err := recoveryService.RequestApproval(req, reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request recovery approval: %v", err)
+	}
+	if !queued {
+		return &AccountRecoveryResponse{Success: false, Message: "Invalid or expired recovery request"}, nil
+	}
+
+	return &AccountRecoveryResponse{
+		Success:   true,
+		Status:    recovery.StatusPendingApproval,
+		ExpiresAt: time.Now().Add(recovery.ApprovalWindowHours * time.Hour),
+		Message:   "Your request has been sent to an administrator. You can finish recovery with the same recovery token once it is approved",
+	}, nil
+}
+
+// ListPendingRecoveries returns the recovery requests awaiting approval
+func ListPendingRecoveries(adminUserID string) ([]recovery.RecoveryRequest, error) {
+	isAdmin, err := userHasRole(adminUserID, recoveryApproverRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check approver role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can review account recovery")
+	}
+
+	recoveryService := recovery.NewRecoveryService()
+
+	requests, err := recoveryService.ListPending()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recovery requests: %v", err)
+	}
+
+	return requests, nil
+}
+
+// ApproveAccountRecovery lets an admin approve a pending recovery request
+func ApproveAccountRecovery(adminUserID, recoveryID string) (*AccountRecoveryResponse, error) {
+	isAdmin, err := userHasRole(adminUserID, recoveryApproverRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check approver role: %v", err)
+	}
+	if !isAdmin {
+		return &AccountRecoveryResponse{Success: false, Message: "Only administrators can approve account recovery"}, nil
+	}
+
+	recoveryService := recovery.NewRecoveryService()
+
+	req, err := recoveryService.GetByID(recoveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recovery request: %v", err)
+	}
+	if !req.Open(recovery.StatusPendingApproval, time.Now()) {
+		return &AccountRecoveryResponse{Success: false, Message: "Recovery request is not awaiting approval"}, nil
+	}
+	if req.UserID == adminUserID {
+		return &AccountRecoveryResponse{Success: false, Message: "Administrators can't approve their own recovery"}, nil
+	}
+
+	approved, err := recoveryService.Approve(req, adminUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve account recovery: %v", err)
+	}
+	if !approved {
+		return &AccountRecoveryResponse{Success: false, Message: "Recovery request is not awaiting approval"}, nil
+	}
+
+	return &AccountRecoveryResponse{
+		Success:   true,
+		Status:    recovery.StatusApproved,
+		UserID:    req.UserID,
+		ExpiresAt: time.Now().Add(recovery.ResetGrantHours * time.Hour),
+		Message:   "Account recovery approved",
+	}, nil
+}
+
+// CompleteRecoveryWithApproval finishes a recovery an admin has approved
+func CompleteRecoveryWithApproval(ctx context.Context, token, recipient string) (*AccountRecoveryResponse, error) {
+	recoveryService := recovery.NewRecoveryService()
+
+	req, err := recoveryService.Get(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recovery request: %v", err)
+	}
+	if req != nil && req.Open(recovery.StatusPendingApproval, time.Now()) {
+		return &AccountRecoveryResponse{
+			Success: false,
+			Status:  recovery.StatusPendingApproval,
+			Message: "Your recovery request is still waiting for approval",
+		}, nil
+	}
+	if !req.Open(recovery.StatusApproved, time.Now()) {
+		return &AccountRecoveryResponse{Success: false, Message: "Invalid or expired recovery request"}, nil
+	}
+
+	return performRecovery(ctx, req, recovery.StatusApproved, recovery.MethodAdminApproval, recipient)
+}
+
+// performRecovery claims the request and then locks out whoever else may
+// hold the account: every session and WebAuthn credential is revoked and all
+// verified channels are told. If either revocation fails the response is
+// RecoveryStatusIncomplete rather than a success. Otherwise the caller signs
+// the user in afresh so they can enrol a new authenticator.
+func performRecovery(ctx context.Context, req *recovery.RecoveryRequest, fromStatus, method, recipient string) (*AccountRecoveryResponse, error) {
+	recoveryService := recovery.NewRecoveryService()
+
+	claimed, err := recoveryService.Complete(req, fromStatus, method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete account recovery: %v", err)
+	}
+	if !claimed {
+		return &AccountRecoveryResponse{Success: false, Message: "Invalid or expired recovery request"}, nil
+	}
+
+	response := &AccountRecoveryResponse{
+		Success: true,
+		Status:  recovery.StatusCompleted,
+		UserID:  req.UserID,
+		Message: "Account recovered. Set up a new authenticator now",
+	}
+
+	var failures []string
+	revokedSessions, err := chronossession.RevokeUserSessions(ctx, req.UserID, "account recovery")
+	if err != nil {
+		log.Printf("❌ Failed to revoke sessions after recovery for user %s: %v", req.UserID, err)
+		failures = append(failures, fmt.Sprintf("sessions: %v", err))
+	}
+	response.RevokedSessions = revokedSessions
+
+	webauthnService, err := webauthn.NewWebAuthnService()
+	if err == nil {
+		response.RevokedCredentials, err = webauthnService.RevokeAllCredentials(req.UserID, "account recovery")
+	}
+	if err != nil {
+		log.Printf("❌ Failed to revoke WebAuthn credentials after recovery for user %s: %v", req.UserID, err)
+		failures = append(failures, fmt.Sprintf("passkeys: %v", err))
+	}
+
+	if err := notifyVerifiedChannels(req, recipient, method); err != nil {
+		log.Printf("⚠️ Warning: Failed to notify channels after recovery for user %s: %v", req.UserID, err)
+	}
+
+	if len(failures) > 0 {
+		if _, err := themislog.LogEvent(themislog.Event{
+			Category:    themislog.CategoryAuthentication,
+			Action:      "ACCOUNT_RECOVERY_INCOMPLETE",
+			ObjectType:  "User",
+			ObjectID:    req.UserID,
+			PerformedBy: req.UserID,
+			Severity:    themislog.SeverityCritical,
+			Source:      "CerberusMFA",
+			Details:     "Recovery completed but revocation failed: " + strings.Join(failures, "; "),
+		}); err != nil {
+			log.Printf("⚠️ Failed to audit incomplete recovery: %v", err)
+		}
+
+		response.Success = false
+		response.Status = RecoveryStatusIncomplete
+		response.Message = "We couldn't sign out every device or remove every passkey on your account. Contact support to finish recovering it"
+	}
+
+	return response, nil
+}
+
+// notifyVerifiedChannels emails the recovery alert to each of the user's
+// verified email channels and records a security notification for every
+// verified channel. Addresses come from the user's verified contacts in the
+// PII vault, plus the address the recovery OTP went to. Channels with no
+// transport yet (SMS, WhatsApp, Telegram) keep an undelivered notification.
+func notifyVerifiedChannels(req *recovery.RecoveryRequest, recipient, method string) error {
+	details := fmt.Sprintf("Your account was recovered on %s using %s. All devices were signed out and passkeys removed. If this wasn't you, contact support immediately.",
+		time.Now().UTC().Format("2 Jan 2006 15:04 MST"), strings.ReplaceAll(method, "_", " "))
+
+	channels, err := verifiedChannels(req.UserID)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := verifiedEmailAddresses(req.UserID)
+	if err != nil {
+		// Still reach the recovering address and record the notifications
+		log.Printf("⚠️ Warning: Failed to read contact addresses for user %s: %v", req.UserID, err)
+		addresses = map[string]string{}
+	}
+	if recipient != "" && charonotp.ChannelDID("email", recipient) == req.ChannelDID {
+		addresses[req.ChannelDID] = recipient
+	}
+
+	delivered := map[string]bool{}
+	for _, channel := range channels {
+		address, ok := addresses[channel.ChannelHash]
+		if !ok || delivered[channel.ChannelHash] {
+			continue
+		}
+		resp, err := email.SendSecurityAlertEmail(address, "account_recovered", details)
+		if err != nil {
+			log.Printf("⚠️ Warning: Failed to send recovery alert email: %v", err)
+		} else if resp.Success {
+			delivered[channel.ChannelHash] = true
+		}
+	}
+
+	return storeSecurityNotifications(req.UserID, "account_recovered", details, channels, delivered)
+}
+
+// verifiedEmailAddresses reveals the user's verified email contacts through
+// the PII vault, keyed by the channel hash they sign in with
+func verifiedEmailAddresses(userID string) (map[string]string, error) {
+	query := dgraph.NewQuery(`query contacts($userId: string) {
+		contacts(func: eq(userId, $userId)) @filter(type(UserContact) AND eq(contactType, "EMAIL") AND eq(verified, true)) {
+			token
+		}
+	}`).WithVariable("$userId", userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user contacts: %v", err)
+	}
+
+	var result struct {
+		Contacts []struct {
+			Token string `json:"token"`
+		} `json:"contacts"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse user contacts: %v", err)
+	}
+
+	tokens := make([]string, 0, len(result.Contacts))
+	for _, contact := range result.Contacts {
+		tokens = append(tokens, contact.Token)
+	}
+	values, err := pii.NewVault(pii.DefaultTenant).Detokenize(pii.PurposeNotification, userID, tokens...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reveal contact addresses: %v", err)
+	}
+
+	addresses := make(map[string]string, len(values))
+	for _, address := range values {
+		addresses[charonotp.ChannelDID("email", address)] = address
+	}
+	return addresses, nil
+}
+
+// storeSecurityNotifications records a security notification for each of the
+// given channels. Those whose hash is in delivered are marked as delivered.
+func storeSecurityNotifications(userID, event, details string, channels []verifiedChannel, delivered map[string]bool) error {
+	now := time.Now().Format(time.RFC3339)
+	var nquads strings.Builder
+	for i, channel := range channels {
+		fmt.Fprintf(&nquads, `_:notice%d <dgraph.type> "SecurityNotification" .
+_:notice%d <userId> "%s" .
+_:notice%d <channelType> "%s" .
+_:notice%d <channelHash> "%s" .
+_:notice%d <event> "%s" .
+_:notice%d <message> %q .
+_:notice%d <delivered> "%t"^^<xs:boolean> .
+_:notice%d <createdAt> "%s"^^<xs:dateTime> .
+`, i, i, userID, i, channel.ChannelType, i, channel.ChannelHash, i, event, i, details, i, delivered[channel.ChannelHash], i, now)
+	}
+	if nquads.Len() == 0 {
+		return nil
+	}
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads.String())
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		return fmt.Errorf("failed to store security notifications: %v", err)
+	}
+	return nil
+}
+
+// verifiedChannel is a UserChannels entry a notification can be addressed to
+type verifiedChannel struct {
+	ChannelType string `json:"channelType"`
+	ChannelHash string `json:"channelHash"`
+}
+
+// verifiedChannels lists a user's verified channels. Channels are keyed by
+// the user's DID in older records and by uid in newer ones, so both are matched.
+func verifiedChannels(userID string) ([]verifiedChannel, error) {
+	query := fmt.Sprintf(`{
+		user(func: uid(%s)) {
+			d as did
+		}
+		byUID(func: eq(userId, %q)) @filter(type(UserChannels) AND eq(verified, true)) {
+			channelType
+			channelHash
+		}
+		byDID(func: eq(userId, val(d))) @filter(type(UserChannels) AND eq(verified, true)) {
+			channelType
+			channelHash
+		}
+	}`, userID, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user channels: %v", err)
+	}
+
+	var result struct {
+		ByUID []verifiedChannel `json:"byUID"`
+		ByDID []verifiedChannel `json:"byDID"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse user channels: %v", err)
+	}
+
+	return append(result.ByUID, result.ByDID...), nil
+}
+
+// userHasRole checks the roles stored on the user node
+func userHasRole(userID, role string) (bool, error) {
+	query := fmt.Sprintf(`{
+		user(func: uid(%s)) {
+			roles {
+				name
+			}
+		}
+	}`, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		User []struct {
+			Roles []struct {
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, err
+	}
+
+	for _, user := range result.User {
+		for _, r := range user.Roles {
+			if r.Name == role {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
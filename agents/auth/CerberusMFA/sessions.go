@@ -0,0 +1,189 @@
+package cerberusmfa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	chronossession "modus/agents/sessions/ChronosSession"
+)
+
+// sessionAdminRole is the role allowed to sign out other users' sessions
+const sessionAdminRole = "admin"
+
+// SessionScope selects the users whose sessions an admin revokes. Exactly
+// one field is set.
+type SessionScope struct {
+	UserID   string `json:"userId,omitempty"`
+	Role     string `json:"role,omitempty"`
+	CentreID string `json:"centreId,omitempty"`
+}
+
+// Session Inventory Functions
+
+// RevokeSessionsAsAdmin signs out every session of the users in scope and
+// returns how many users and sessions were affected
+func RevokeSessionsAsAdmin(ctx context.Context, adminUserID string, scope SessionScope, reason string) (int, int, error) {
+	isAdmin, err := userHasRole(adminUserID, sessionAdminRole)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return 0, 0, fmt.Errorf("only administrators can sign out other users")
+	}
+
+	users, err := usersInScope(scope)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	revoked := 0
+	for _, user := range users {
+		n, err := chronossession.RevokeUserSessions(ctx, user.UID, reason)
+		if err != nil {
+			return len(users), revoked, fmt.Errorf("failed to revoke sessions for user %s: %v", user.UID, err)
+		}
+		revoked += n
+	}
+
+	log.Printf("🔒 Admin %s signed out %d session(s) for %d user(s): %s", adminUserID, revoked, len(users), reason)
+	return len(users), revoked, nil
+}
+
+// MigrateSessionsAsAdmin converts sessions stored before they followed the
+// AuthSession schema
+func MigrateSessionsAsAdmin(ctx context.Context, adminUserID string) (*chronossession.SessionMigrationResult, error) {
+	isAdmin, err := userHasRole(adminUserID, sessionAdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can migrate sessions")
+	}
+
+	result, err := chronossession.MigrateSessions(ctx, adminUserID)
+	if err != nil {
+		return result, err
+	}
+
+	log.Printf("🔄 Admin %s migrated %d session(s); %d without a user were invalidated", adminUserID, result.Migrated, result.Orphaned)
+	return result, nil
+}
+
+// NotifyNewSignIn records a "new sign-in" security notification for each of
+// the user's verified channels, describing the device that signed in
+func NotifyNewSignIn(userID string, session chronossession.SessionInfo) error {
+	where := ""
+	if session.Location != "" {
+		where = fmt.Sprintf(" near %s", session.Location)
+	}
+	details := fmt.Sprintf("New sign-in to your account on %s: %s on %s (%s)%s. If this wasn't you, sign out that session and contact support.",
+		session.AuthenticatedAt.UTC().Format("2 Jan 2006 15:04 MST"), session.Browser, session.OS, session.Device, where)
+
+	channels, err := verifiedChannels(userID)
+	if err != nil {
+		return err
+	}
+	return storeSecurityNotifications(userID, "new_sign_in", details, channels, nil)
+}
+
+// scopedUser is a user matched by a SessionScope
+type scopedUser struct {
+	UID string `json:"uid"`
+}
+
+// roleName is a role as returned with a user
+type roleName struct {
+	Name string `json:"name"`
+}
+
+// usersInScope resolves a SessionScope to users
+func usersInScope(scope SessionScope) ([]scopedUser, error) {
+	var query *dgraph.Query
+	switch {
+	case scope.UserID != "" && scope.Role == "" && scope.CentreID == "":
+		if strings.HasPrefix(scope.UserID, "0x") {
+			query = dgraph.NewQuery(`query users($id: string) {
+				users(func: uid($id)) @filter(type(User)) {
+					uid
+				}
+			}`).WithVariable("$id", scope.UserID)
+		} else {
+			query = dgraph.NewQuery(`query users($id: string) {
+				users(func: eq(did, $id)) @filter(type(User)) {
+					uid
+				}
+			}`).WithVariable("$id", scope.UserID)
+		}
+	case scope.Role != "" && scope.UserID == "" && scope.CentreID == "":
+		query = dgraph.NewQuery(`query users($role: string) {
+			users(func: type(User)) @cascade {
+				uid
+				roles @filter(anyofterms(name, $role)) {
+					name
+				}
+			}
+		}`).WithVariable("$role", scope.Role)
+	case scope.CentreID != "" && scope.UserID == "" && scope.Role == "":
+		query = dgraph.NewQuery(`query users($centre: string) {
+			members(func: has(centre)) @filter(uid_in(centre, $centre)) {
+				user {
+					uid
+				}
+			}
+		}`).WithVariable("$centre", scope.CentreID)
+	default:
+		return nil, fmt.Errorf("exactly one of user, role or centre is required")
+	}
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve users: %v", err)
+	}
+
+	var result struct {
+		Users []struct {
+			scopedUser
+			Roles []roleName `json:"roles"`
+		} `json:"users"`
+		Members []struct {
+			User scopedUser `json:"user"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var users []scopedUser
+	add := func(u scopedUser) {
+		if u.UID != "" && !seen[u.UID] {
+			seen[u.UID] = true
+			users = append(users, u)
+		}
+	}
+	for _, u := range result.Users {
+		// anyofterms also matches e.g. "centre admin"; only exact names count
+		if scope.Role != "" && !hasRoleName(u.Roles, scope.Role) {
+			continue
+		}
+		add(u.scopedUser)
+	}
+	for _, m := range result.Members {
+		add(m.User)
+	}
+	return users, nil
+}
+
+// hasRoleName checks for a role by exact name
+func hasRoleName(roles []roleName, name string) bool {
+	for _, r := range roles {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
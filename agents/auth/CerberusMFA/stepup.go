@@ -0,0 +1,70 @@
+package cerberusmfa
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/services/webauthn"
+)
+
+// Step-Up Authentication Functions
+
+// StepUpWithTOTP re-authenticates the holder of a session with an
+// authenticator app code and reissues the session at the raised level
+func StepUpWithTOTP(ctx context.Context, token, code string) (*chronossession.SessionResponse, error) {
+	userID, err := stepUpSessionUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := verifyTOTP(userID, code)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("step-up failed: %s", response.Message)
+	}
+
+	return chronossession.StepUpSessionToken(ctx, token, chronossession.AuthMethod{
+		AuthType: chronossession.SESSION_TYPE_TOTP,
+	})
+}
+
+// StepUpWithWebAuthn re-authenticates the holder of a session with one of
+// their WebAuthn authenticators and reissues the session at the raised level
+func StepUpWithWebAuthn(ctx context.Context, token string, req webauthn.AuthenticationRequest) (*chronossession.SessionResponse, error) {
+	userID, err := stepUpSessionUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := VerifyWebAuthnAuthentication(req)
+	if err != nil {
+		return nil, err
+	}
+	// The assertion must come from the session's own user
+	if !response.Success || response.UserID != userID {
+		return nil, fmt.Errorf("step-up failed: %s", response.Message)
+	}
+
+	return chronossession.StepUpSessionToken(ctx, token, chronossession.AuthMethod{
+		AuthType:      chronossession.SESSION_TYPE_WEBAUTHN,
+		UserVerified:  response.UserVerified,
+		HardwareBound: response.HardwareBound,
+	})
+}
+
+// stepUpSessionUser returns the user of a valid session
+func stepUpSessionUser(ctx context.Context, token string) (string, error) {
+	validation, err := chronossession.ValidateSessionToken(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate session: %v", err)
+	}
+	if !validation.Valid {
+		log.Printf("❌ Step-up rejected: %s", validation.Message)
+		return "", fmt.Errorf("invalid session: %s", validation.Message)
+	}
+	return validation.UserID, nil
+}
@@ -8,44 +8,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
-	"strings"
 	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/console"
 	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/config"
 	"modus/services/email"
 )
 
+// OTP purposes. A code is only accepted for the purpose it was issued for.
+const (
+	OTPPurposeSignin        = "signin"
+	OTPPurposeSignup        = "signup"
+	OTPPurposeRecovery      = "recovery"
+	OTPPurposeStepUp        = "step-up"
+	OTPPurposeChannelAdd    = "channel-add"
+	OTPPurposeAccountDelete = "account-delete"
+)
+
+var validOTPPurposes = map[string]bool{
+	OTPPurposeSignin:        true,
+	OTPPurposeSignup:        true,
+	OTPPurposeRecovery:      true,
+	OTPPurposeStepUp:        true,
+	OTPPurposeChannelAdd:    true,
+	OTPPurposeAccountDelete: true,
+}
+
+// normalizePurpose defaults an empty purpose to signin and rejects unknown ones
+func normalizePurpose(purpose string) (string, error) {
+	if purpose == "" {
+		return OTPPurposeSignin, nil
+	}
+	if !validOTPPurposes[purpose] {
+		return "", fmt.Errorf("unsupported OTP purpose: %s", purpose)
+	}
+	return purpose, nil
+}
+
 // OTPRequest represents the request to generate and send OTP
 type OTPRequest struct {
-	Channel     string `json:"channel"`     // "email", "sms", "whatsapp", "telegram"
-	Recipient   string `json:"recipient"`   // email, phone number, etc.
-	UserID      string `json:"userId,omitempty"`
+	Channel   string `json:"channel"`   // "email", "sms", "whatsapp", "telegram"
+	Recipient string `json:"recipient"` // email, phone number, etc.
+	UserID    string `json:"userId,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"` // client IP for per-IP send limits
+	Purpose   string `json:"purpose,omitempty"`   // see OTPPurpose*; defaults to "signin"
 }
 
 // OTPResponse represents the response after OTP generation
 type OTPResponse struct {
-	OTPID     string    `json:"otpId"`
-	Sent      bool      `json:"sent"`
-	Verified  bool      `json:"verified"`
-	Channel   string `json:"channel"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	Message   string    `json:"message,omitempty"`
+	OTPID      string    `json:"otpId"`
+	Sent       bool      `json:"sent"`
+	Verified   bool      `json:"verified"`
+	Channel    string    `json:"channel"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Message    string    `json:"message,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`  // "RATE_LIMITED" when throttled
+	RetryAfter int       `json:"retryAfter,omitempty"` // seconds until another OTP can be sent
 }
 
 // VerifyOTPRequest represents the request to verify an OTP
 type VerifyOTPRequest struct {
 	OTPCode   string `json:"otpCode"`
 	Recipient string `json:"recipient"`
+	Purpose   string `json:"purpose,omitempty"` // must match the purpose the code was sent for
 }
 
 // VerifyOTPResponse represents the response after OTP verification
 type VerifyOTPResponse struct {
-	Verified  bool   `json:"verified"`
-	Message   string `json:"message"`
-	UserID    string `json:"userId,omitempty"`
-	Action    string `json:"action,omitempty"` // "signin" or "register"
-	ChannelDID string `json:"channelDID,omitempty"` // Unique identifier for the channel
+	Verified    bool   `json:"verified"`
+	Message     string `json:"message"`
+	UserID      string `json:"userId,omitempty"`
+	Action      string `json:"action,omitempty"`      // "signin" or "register"
+	ChannelDID  string `json:"channelDID,omitempty"`  // Unique identifier for the channel
+	ChannelType string `json:"channelType,omitempty"` // Channel the code was sent on
+	Purpose     string `json:"purpose,omitempty"`     // Purpose the verified code was issued for
+	ErrorCode   string `json:"errorCode,omitempty"`   // "LOCKED_OUT" when the channel is locked
+	RetryAfter  int    `json:"retryAfter,omitempty"`  // seconds until the lockout ends
 }
 
 // ChannelOTPRecord represents the OTP stored in Dgraph (matches ChannelOTP schema)
@@ -81,43 +121,8 @@ func hashString(input string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// logAuditEvent creates an audit trail entry for OTP operations
-// Follows ThemisLog agent patterns for ISO 27001 compliance
-func logAuditEvent(category, action, objectType, objectId, performedBy, details string) {
-	// Generate unique audit ID
-	auditID := fmt.Sprintf("audit_%d", time.Now().UnixNano())
-	
-	// Create audit entry with proper timestamp and retention
-	retentionDate := time.Now().AddDate(7, 0, 0) // 7 years retention for compliance
-	
-	// Escape quotes in details to prevent DQL syntax errors
-	escapedDetails := strings.ReplaceAll(details, `"`, `\"`) 
-	
-	nquads := fmt.Sprintf(`_:audit <id> "%s" .
-_:audit <category> "%s" .
-_:audit <action> "%s" .
-_:audit <objectType> "%s" .
-_:audit <objectId> "%s" .
-_:audit <performedBy> "%s" .
-_:audit <timestamp> "%s"^^<xs:dateTime> .
-_:audit <details> "%s" .
-_:audit <severity> "INFO" .
-_:audit <source> "CharonOTP" .
-_:audit <retentionDate> "%s"^^<xs:dateTime> .
-_:audit <dgraph.type> "AuditEntry" .`,
-		auditID, category, action, objectType, objectId, performedBy,
-		time.Now().Format(time.RFC3339), escapedDetails, retentionDate.Format(time.RFC3339))
-	
-	// Store audit entry asynchronously (fire-and-forget for performance)
-	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
-	_, err := dgraph.ExecuteMutations("dgraph", mutationObj)
-	if err != nil {
-		// Log audit failure but don't block main operation
-		console.Warn(fmt.Sprintf("Audit logging failed: %s", err.Error()))
-	}
-}
-
-
+// auditor writes OTP and magic link events to the audit trail
+var auditor = themislog.Auditor{Source: "CharonOTP", Category: themislog.CategoryAuthentication}
 
 // executeMutation executes a DQL mutation using Dgraph SDK
 func executeMutation(nquads string) error {
@@ -129,8 +134,10 @@ func executeMutation(nquads string) error {
 	return nil
 }
 
-// storeOTPInDgraph stores the OTP record in Dgraph using Modus SDK best practices
-func storeOTPInDgraph(channel, recipient, otpCode string, expiresAt time.Time) (string, error) {
+// storeOTPInDgraph stores the OTP record in Dgraph using Modus SDK best practices.
+// Earlier unused codes for the same channel and purpose are invalidated in the
+// same upsert, so only the most recently sent code works.
+func storeOTPInDgraph(channel, recipient, otpCode, purpose string, expiresAt time.Time) (string, error) {
 	// Use Modus SDK console for structured logging
 	// Debug: console.Log(fmt.Sprintf("Starting OTP storage for channel: %s", channel))
 	
@@ -144,25 +151,35 @@ func storeOTPInDgraph(channel, recipient, otpCode string, expiresAt time.Time) (
 	otpID := fmt.Sprintf("otp_%d", start.UnixNano())
 	
 	// Create N-Quads format using proven working pattern from memories
-	nquads := fmt.Sprintf(`_:channelotp <channelHash> "%s" .
+	nquads := fmt.Sprintf(`uid(previous) <used> "true"^^<xs:boolean> .
+_:channelotp <channelHash> "%s" .
 _:channelotp <channelType> "%s" .
 _:channelotp <otpHash> "%s" .
+_:channelotp <purpose> "%s" .
 _:channelotp <verified> "false"^^<xs:boolean> .
 _:channelotp <expiresAt> "%s"^^<xs:dateTime> .
+_:channelotp <createdAt> "%s"^^<xs:dateTime> .
 _:channelotp <used> "false"^^<xs:boolean> .
+_:channelotp <failedAttempts> "0"^^<xs:int> .
 _:channelotp <dgraph.type> "ChannelOTP" .`,
-		channelHash, channel, otpHash, expiresAt.Format(time.RFC3339))
+		channelHash, channel, otpHash, purpose, expiresAt.Format(time.RFC3339), start.Format(time.RFC3339))
 	
-	// Execute mutation using proven Modus SDK pattern
+	// Invalidate previous codes and insert the new one in a single upsert
+	query := dgraph.NewQuery(`query previous($channelHash: string, $purpose: string) {
+		previous as var(func: eq(channelHash, $channelHash)) @filter(type(ChannelOTP) AND eq(purpose, $purpose) AND eq(used, false))
+		invalidated(func: uid(previous)) {
+			count(uid)
+		}
+	}`).WithVariable("$channelHash", channelHash).WithVariable("$purpose", purpose)
 	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
-	result, err := dgraph.ExecuteMutations("dgraph", mutationObj)
+	result, err := dgraph.ExecuteQuery("dgraph", query, mutationObj)
 	
 	if err != nil {
 		// Log error with audit trail
 		console.Error(fmt.Sprintf("OTP storage failed: %s (duration: %v)", err.Error(), time.Since(start)))
 		
 		// Create audit entry for failed OTP storage
-		logAuditEvent("AUTHENTICATION", "OTP_STORAGE_FAILED", "ChannelOTP", otpID, "CharonOTP", 
+		auditor.Record("OTP_STORAGE_FAILED", "ChannelOTP", otpID, "CharonOTP", themislog.SeverityInfo,
 			fmt.Sprintf(`{"channel":"%s","error":"%s","duration_ms":%d}`, 
 				channel, err.Error(), time.Since(start).Milliseconds()))
 		
@@ -180,13 +197,26 @@ _:channelotp <dgraph.type> "ChannelOTP" .`,
 	// Debug: console.Log(fmt.Sprintf("OTP stored successfully: %s (duration: %v)", otpUID, time.Since(start)))
 	
 	// Create audit entry for successful OTP storage
-	logAuditEvent("AUTHENTICATION", "OTP_GENERATED", "ChannelOTP", otpUID, "CharonOTP",
-		fmt.Sprintf(`{"channel":"%s","expiresAt":"%s","duration_ms":%d}`,
-			channel, expiresAt.Format(time.RFC3339), time.Since(start).Milliseconds()))
+	auditor.Record("OTP_GENERATED", "ChannelOTP", otpUID, "CharonOTP", themislog.SeverityInfo,
+		fmt.Sprintf(`{"channel":"%s","purpose":"%s","invalidated":%d,"expiresAt":"%s","duration_ms":%d}`,
+			channel, purpose, countInvalidated(result.Json), expiresAt.Format(time.RFC3339), time.Since(start).Milliseconds()))
 	
 	return otpUID, nil
 }
 
+// countInvalidated reads how many earlier codes storeOTPInDgraph invalidated
+func countInvalidated(resultJSON string) int {
+	var result struct {
+		Invalidated []struct {
+			Count int `json:"count"`
+		} `json:"invalidated"`
+	}
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil || len(result.Invalidated) == 0 {
+		return 0
+	}
+	return result.Invalidated[0].Count
+}
+
 // sendOTPViaEmail sends OTP via email using the async email queue for instant response
 func sendOTPViaEmail(recipient, otpCode string) error {
 	// Use the ASYNC email service for non-blocking OTP emails
@@ -239,10 +269,36 @@ func SendOTP(ctx context.Context, req OTPRequest) (OTPResponse, error) {
 	if req.Recipient == "" {
 		return OTPResponse{}, fmt.Errorf("recipient is required")
 	}
+	purpose, err := normalizePurpose(req.Purpose)
+	if err != nil {
+		return OTPResponse{}, err
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return OTPResponse{}, err
+	}
 	
-	// Set hardcoded default values
-	expiryMins := 5 // Fixed 5 minutes expiry
-	
+	// Enforce per-recipient and per-IP send limits before generating anything
+	sendChecks := []rateCheck{{recipientSendLimit, hashString(req.Recipient)}}
+	if req.IPAddress != "" {
+		sendChecks = append(sendChecks, rateCheck{ipSendLimit, hashString(req.IPAddress)})
+	}
+	throttle, err := consumeRateLimits(time.Now(), sendChecks...)
+	if err != nil {
+		return OTPResponse{}, fmt.Errorf("failed to check send limits: %w", err)
+	}
+	if throttle != nil {
+		auditor.Record("OTP_SEND_THROTTLED", "ChannelOTP", hashString(req.Recipient), "CharonOTP", themislog.SeverityInfo,
+			fmt.Sprintf(`{"channel":"%s","scope":"%s","retryAfter":%d}`, req.Channel, throttle.Scope, throttle.RetryAfterSeconds()))
+		return OTPResponse{
+			Sent:       false,
+			Channel:    req.Channel,
+			Message:    fmt.Sprintf("Too many OTP requests. Try again in %d seconds", throttle.RetryAfterSeconds()),
+			ErrorCode:  throttle.Code,
+			RetryAfter: throttle.RetryAfterSeconds(),
+		}, nil
+	}
+
 	// Generate OTP
 	otpCode, err := generateOTP()
 	if err != nil {
@@ -250,7 +306,7 @@ func SendOTP(ctx context.Context, req OTPRequest) (OTPResponse, error) {
 	}
 	
 	// Calculate expiry time
-	expiresAt := time.Now().Add(time.Duration(expiryMins) * time.Minute)
+	expiresAt := time.Now().Add(cfg.OTP.CodeTTL)
 	
 	// Send OTP via appropriate channel FIRST (fast path)
 	var sendErr error
@@ -271,7 +327,7 @@ func SendOTP(ctx context.Context, req OTPRequest) (OTPResponse, error) {
 	// Store OTP in Dgraph synchronously (WASM compatible)
 	// Debug: console.Log("Starting synchronous OTP storage")
 	storageStart := time.Now()
-	otpID, storageErr := storeOTPInDgraph(req.Channel, req.Recipient, otpCode, expiresAt)
+	otpID, storageErr := storeOTPInDgraph(req.Channel, req.Recipient, otpCode, purpose, expiresAt)
 	if storageErr != nil {
 		console.Error(fmt.Sprintf("OTP storage failed after %v: %v", time.Since(storageStart), storageErr))
 		// Use fallback ID for response even if storage fails
@@ -307,13 +363,37 @@ func VerifyOTP(req VerifyOTPRequest) (VerifyOTPResponse, error) {
 	// Hash the provided channel and OTP for database comparison
 	channelHash := hashString(req.Recipient)
 	otpHash := hashString(req.OTPCode)
+	purpose, err := normalizePurpose(req.Purpose)
+	if err != nil {
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  err.Error(),
+		}, nil
+	}
 	
+	// A locked channel is refused before the code is even looked at
+	lockout, err := checkLockout(time.Now(), rateCheck{channelLockout, channelHash})
+	if err != nil {
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  "Failed to verify OTP: database error",
+		}, err
+	}
+	if lockout != nil {
+		return VerifyOTPResponse{
+			Verified:   false,
+			Message:    fmt.Sprintf("Too many failed attempts. Try again in %d seconds", lockout.RetryAfterSeconds()),
+			ErrorCode:  lockout.Code,
+			RetryAfter: lockout.RetryAfterSeconds(),
+		}, nil
+	}
+
 	// Debug: console.Log(fmt.Sprintf("🔍 Verifying OTP: channel=%s, code=%s", req.Recipient, req.OTPCode))
 	// Debug: console.Log(fmt.Sprintf("🔍 Hashes: channelHash=%s, otpHash=%s", channelHash, otpHash))
 	
 	// Query Dgraph to find matching OTP record using proper Modus SDK
 	query := fmt.Sprintf(`{
-		otp_verification(func: eq(channelHash, "%s")) @filter(eq(otpHash, "%s") AND eq(verified, false) AND eq(used, false)) {
+		otp_verification(func: eq(channelHash, "%s")) @filter(eq(otpHash, "%s") AND eq(purpose, "%s") AND eq(verified, false) AND eq(used, false)) {
 			uid
 			channelHash
 			otpHash
@@ -325,7 +405,7 @@ func VerifyOTP(req VerifyOTPRequest) (VerifyOTPResponse, error) {
 			purpose
 			channelType
 		}
-	}`, channelHash, otpHash)
+	}`, channelHash, otpHash, purpose)
 	
 	// Debug: console.Log(fmt.Sprintf("🔍 DQL Query: %s", query))
 	
@@ -370,6 +450,10 @@ func VerifyOTP(req VerifyOTPRequest) (VerifyOTPResponse, error) {
 	
 	// Check if OTP was found
 	if len(response.OTPVerification) == 0 {
+		// Count the wrong guess against the channel's live OTPs and the channel
+		if err := recordFailedAttempt(time.Now(), channelHash); err != nil {
+			console.Error(fmt.Sprintf("❌ Failed to record OTP attempt: %v", err))
+		}
 		return VerifyOTPResponse{
 			Verified: false,
 			Message:  "Invalid OTP code or OTP has already been used",
@@ -408,11 +492,13 @@ func VerifyOTP(req VerifyOTPRequest) (VerifyOTPResponse, error) {
 	
 	// Return successful verification with routing information
 	return VerifyOTPResponse{
-		Verified:   true,
-		Message:    "OTP verified successfully",
-		UserID:     userID,
-		Action:     action,     // "signin" or "register"
-		ChannelDID: channelDID, // Unique identifier for the channel
+		Verified:    true,
+		Message:     "OTP verified successfully",
+		UserID:      userID,
+		Action:      action,     // "signin" or "register"
+		ChannelDID:  channelDID, // Unique identifier for the channel
+		ChannelType: channelType,
+		Purpose:     purpose,
 	}, nil
 }
 
@@ -441,6 +527,12 @@ func generateChannelDID(channel, recipient string) string {
 	return hashString(fmt.Sprintf("%s:%s", channel, recipient))
 }
 
+// ChannelDID returns the DID a recipient is stored under, so other agents
+// can match a plaintext address against a channel without storing it
+func ChannelDID(channel, recipient string) string {
+	return generateChannelDID(channel, recipient)
+}
+
 // checkUserExists checks if a user exists by channel DID
 func checkUserExists(channelDID, channelType string) (bool, string, error) {
 	// Create DQL query to check if user exists by channel DID
@@ -450,7 +542,6 @@ func checkUserExists(channelDID, channelType string) (bool, string, error) {
 		query = fmt.Sprintf(`{
 			user(func: eq(emailDID, "%s")) {
 				uid
-				email
 				emailDID
 				status
 			}
@@ -459,7 +550,6 @@ func checkUserExists(channelDID, channelType string) (bool, string, error) {
 		query = fmt.Sprintf(`{
 			user(func: eq(phoneDID, "%s")) {
 				uid
-				phone
 				phoneDID
 				status
 			}
@@ -484,8 +574,6 @@ func checkUserExists(channelDID, channelType string) (bool, string, error) {
 	var response struct {
 		User []struct {
 			UID    string `json:"uid"`
-			Email  string `json:"email,omitempty"`
-			Phone  string `json:"phone,omitempty"`
 			Status string `json:"status"`
 		} `json:"user"`
 	}
@@ -515,8 +603,11 @@ func checkUserExists(channelDID, channelType string) (bool, string, error) {
 // Checks if user exists and returns appropriate action (signin/register)
 func PostOTPVerification(channel, recipient string) (string, string, string, error) {
 	// Generate channel DID for unique identification
-	channelDID := generateChannelDID(channel, recipient)
+	return routeChannelDID(channel, generateChannelDID(channel, recipient))
+}
 
+// routeChannelDID returns the signin/register routing for a channel DID
+func routeChannelDID(channel, channelDID string) (string, string, string, error) {
 	// Check if user exists by channel DID
 	userExists, userID, err := checkUserExists(channelDID, channel)
 	if err != nil {
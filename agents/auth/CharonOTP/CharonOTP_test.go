@@ -65,3 +65,29 @@ func TestOTPChannels(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizePurpose(t *testing.T) {
+	if purpose, err := normalizePurpose(""); err != nil || purpose != OTPPurposeSignin {
+		t.Errorf("Expected empty purpose to default to signin, got %q (%v)", purpose, err)
+	}
+	if purpose, err := normalizePurpose(OTPPurposeAccountDelete); err != nil || purpose != OTPPurposeAccountDelete {
+		t.Errorf("Expected account-delete to be accepted, got %q (%v)", purpose, err)
+	}
+	if _, err := normalizePurpose("delete-everything"); err == nil {
+		t.Error("Expected unknown purpose to be rejected")
+	}
+
+	_, err := SendOTP(context.Background(), OTPRequest{Channel: "email", Recipient: "user@example.com", Purpose: "bogus"})
+	if err == nil {
+		t.Error("Expected SendOTP to reject unknown purpose")
+	}
+}
+
+func TestCountInvalidated(t *testing.T) {
+	if got := countInvalidated(`{"invalidated":[{"count":2}]}`); got != 2 {
+		t.Errorf("Expected 2 invalidated codes, got %d", got)
+	}
+	if got := countInvalidated(`{"data":{"query":"query"}}`); got != 0 {
+		t.Errorf("Expected 0 for a response without counts, got %d", got)
+	}
+}
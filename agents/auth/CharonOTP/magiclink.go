@@ -0,0 +1,274 @@
+package charonotp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/console"
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/config"
+	"modus/services/email"
+)
+
+// magicLinkTokenBytes is the token entropy (256 bits)
+const magicLinkTokenBytes = 32
+
+// SendMagicLinkRequest represents the request to email a sign-in link
+type SendMagicLinkRequest struct {
+	Recipient string `json:"recipient"`           // email address
+	Purpose   string `json:"purpose,omitempty"`   // see OTPPurpose*; defaults to "signin"
+	DeviceID  string `json:"deviceId,omitempty"`  // binds the link to the requesting device
+	IPAddress string `json:"ipAddress,omitempty"` // client IP for per-IP send limits
+}
+
+// MagicLinkResponse represents the response after a sign-in link is sent
+type MagicLinkResponse struct {
+	Sent       bool      `json:"sent"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Message    string    `json:"message,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`  // "RATE_LIMITED" when throttled
+	RetryAfter int       `json:"retryAfter,omitempty"` // seconds until another link can be sent
+}
+
+// VerifyMagicLinkRequest represents the token from a clicked sign-in link
+type VerifyMagicLinkRequest struct {
+	Token    string `json:"token"`
+	DeviceID string `json:"deviceId,omitempty"` // required if the link was requested with one
+}
+
+// generateMagicLinkToken creates a random URL-safe token
+func generateMagicLinkToken() (string, error) {
+	b := make([]byte, magicLinkTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// buildMagicLink appends the token to the frontend page (MAGIC_LINK_BASE_URL)
+// that receives it and calls VerifyMagicLink
+func buildMagicLink(baseURL, token string) string {
+	return baseURL + "?token=" + url.QueryEscape(token)
+}
+
+// magicLinkDeviceMatches checks the device a link is opened on against the
+// one it was requested from. Links requested without a device ID open anywhere.
+func magicLinkDeviceMatches(deviceHash, deviceID string) bool {
+	if deviceHash == "" {
+		return true
+	}
+	if deviceID == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(deviceHash), []byte(hashString(deviceID))) == 1
+}
+
+// SendMagicLink emails a single-use sign-in link. Only the token hash is
+// stored, together with the channel DID and purpose it was issued for.
+func SendMagicLink(ctx context.Context, req SendMagicLinkRequest) (MagicLinkResponse, error) {
+	if req.Recipient == "" {
+		return MagicLinkResponse{}, fmt.Errorf("recipient is required")
+	}
+	purpose, err := normalizePurpose(req.Purpose)
+	if err != nil {
+		return MagicLinkResponse{}, err
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return MagicLinkResponse{}, err
+	}
+
+	// Links share the OTP send budget so they can't be used to get around it
+	sendChecks := []rateCheck{{recipientSendLimit, hashString(req.Recipient)}}
+	if req.IPAddress != "" {
+		sendChecks = append(sendChecks, rateCheck{ipSendLimit, hashString(req.IPAddress)})
+	}
+	throttle, err := consumeRateLimits(time.Now(), sendChecks...)
+	if err != nil {
+		return MagicLinkResponse{}, fmt.Errorf("failed to check send limits: %w", err)
+	}
+	if throttle != nil {
+		auditor.Record("MAGIC_LINK_SEND_THROTTLED", "MagicLinkToken", hashString(req.Recipient), "CharonOTP", themislog.SeverityInfo,
+			fmt.Sprintf(`{"scope":"%s","retryAfter":%d}`, throttle.Scope, throttle.RetryAfterSeconds()))
+		return MagicLinkResponse{
+			Sent:       false,
+			Message:    fmt.Sprintf("Too many sign-in requests. Try again in %d seconds", throttle.RetryAfterSeconds()),
+			ErrorCode:  throttle.Code,
+			RetryAfter: throttle.RetryAfterSeconds(),
+		}, nil
+	}
+
+	token, err := generateMagicLinkToken()
+	if err != nil {
+		return MagicLinkResponse{}, fmt.Errorf("failed to generate magic link token: %w", err)
+	}
+	expiresAt := time.Now().Add(cfg.OTP.MagicLinkTTL)
+
+	tokenUID, err := storeMagicLinkToken(req.Recipient, token, purpose, req.DeviceID, expiresAt)
+	if err != nil {
+		return MagicLinkResponse{}, err
+	}
+
+	sendErr := sendMagicLinkEmail(req.Recipient, buildMagicLink(cfg.OTP.MagicLinkBaseURL, token), cfg.OTP.MagicLinkTTL)
+	if sendErr != nil {
+		console.Error(fmt.Sprintf("Failed to send magic link: %v", sendErr))
+	}
+
+	auditor.Record("MAGIC_LINK_GENERATED", "MagicLinkToken", tokenUID, "CharonOTP", themislog.SeverityInfo,
+		fmt.Sprintf(`{"purpose":"%s","deviceBound":%t,"sent":%t,"expiresAt":"%s"}`,
+			purpose, req.DeviceID != "", sendErr == nil, expiresAt.Format(time.RFC3339)))
+
+	response := MagicLinkResponse{
+		Sent:      sendErr == nil,
+		ExpiresAt: expiresAt,
+		Message:   "Sign-in link sent via email",
+	}
+	if sendErr != nil {
+		response.Message = fmt.Sprintf("Sign-in link generated but failed to send: %v", sendErr)
+	}
+	return response, nil
+}
+
+// storeMagicLinkToken stores the hashed token and invalidates earlier unused
+// links for the same channel and purpose in one upsert
+func storeMagicLinkToken(recipient, token, purpose, deviceID string, expiresAt time.Time) (string, error) {
+	channelDID := generateChannelDID("email", recipient)
+	now := time.Now()
+
+	nquads := fmt.Sprintf(`uid(previous) <used> "true"^^<xs:boolean> .
+_:magiclink <tokenHash> "%s" .
+_:magiclink <channelDID> "%s" .
+_:magiclink <channelType> "email" .
+_:magiclink <purpose> "%s" .
+_:magiclink <expiresAt> "%s"^^<xs:dateTime> .
+_:magiclink <createdAt> "%s"^^<xs:dateTime> .
+_:magiclink <used> "false"^^<xs:boolean> .
+_:magiclink <dgraph.type> "MagicLinkToken" .`,
+		hashString(token), channelDID, purpose, expiresAt.Format(time.RFC3339), now.Format(time.RFC3339))
+	if deviceID != "" {
+		nquads += fmt.Sprintf("\n_:magiclink <deviceHash> \"%s\" .", hashString(deviceID))
+	}
+
+	query := dgraph.NewQuery(`query previous($channelDID: string, $purpose: string) {
+		previous as var(func: eq(channelDID, $channelDID)) @filter(type(MagicLinkToken) AND eq(purpose, $purpose) AND eq(used, false))
+	}`).WithVariable("$channelDID", channelDID).WithVariable("$purpose", purpose)
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	result, err := dgraph.ExecuteQuery("dgraph", query, mutationObj)
+	if err != nil {
+		console.Error(fmt.Sprintf("Magic link storage failed: %s", err.Error()))
+		return "", fmt.Errorf("failed to store magic link: %w", err)
+	}
+
+	return result.Uids["magiclink"], nil
+}
+
+// sendMagicLinkEmail sends the link through the email service
+func sendMagicLinkEmail(recipient, link string, ttl time.Duration) error {
+	response, err := email.SendMagicLinkEmail(recipient, link, fmt.Sprintf("%d minutes", int(ttl.Minutes())))
+	if err != nil {
+		return fmt.Errorf("failed to send magic link email: %w", err)
+	}
+	if !response.Success {
+		return fmt.Errorf("email service error: %s", response.Error)
+	}
+	return nil
+}
+
+// VerifyMagicLink consumes a sign-in link token and returns the same routing
+// as VerifyOTP, so callers can treat both the same way
+func VerifyMagicLink(req VerifyMagicLinkRequest) (VerifyOTPResponse, error) {
+	if req.Token == "" {
+		return VerifyOTPResponse{Verified: false, Message: "Invalid or expired sign-in link"}, nil
+	}
+
+	// Mark the token used in the same upsert that reads it, so a link
+	// clicked twice (or raced) only signs in once
+	query := dgraph.NewQuery(`query consume($tokenHash: string) {
+		t as var(func: eq(tokenHash, $tokenHash)) @filter(type(MagicLinkToken) AND eq(used, false))
+		magic_link(func: uid(t)) {
+			uid
+			channelDID
+			channelType
+			purpose
+			deviceHash
+			expiresAt
+		}
+	}`).WithVariable("$tokenHash", hashString(req.Token))
+
+	mutationObj := dgraph.NewMutation().
+		WithCondition("@if(eq(len(t), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(t) <used> "true"^^<xs:boolean> .
+uid(t) <usedAt> "%s"^^<xs:dateTime> .`, time.Now().Format(time.RFC3339)))
+
+	result, err := dgraph.ExecuteQuery("dgraph", query, mutationObj)
+	if err != nil {
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  "Failed to verify sign-in link: database error",
+		}, fmt.Errorf("failed to consume magic link: %w", err)
+	}
+
+	var response struct {
+		MagicLink []struct {
+			UID         string    `json:"uid"`
+			ChannelDID  string    `json:"channelDID"`
+			ChannelType string    `json:"channelType"`
+			Purpose     string    `json:"purpose"`
+			DeviceHash  string    `json:"deviceHash"`
+			ExpiresAt   time.Time `json:"expiresAt"`
+		} `json:"magic_link"`
+	}
+	if err := json.Unmarshal([]byte(result.Json), &response); err != nil {
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  "Failed to parse verification response",
+		}, fmt.Errorf("failed to parse magic link response: %w", err)
+	}
+
+	if len(response.MagicLink) != 1 {
+		return VerifyOTPResponse{Verified: false, Message: "Invalid or expired sign-in link"}, nil
+	}
+	link := response.MagicLink[0]
+
+	if time.Now().After(link.ExpiresAt) {
+		return VerifyOTPResponse{Verified: false, Message: "Invalid or expired sign-in link"}, nil
+	}
+
+	if !magicLinkDeviceMatches(link.DeviceHash, req.DeviceID) {
+		auditor.Record("MAGIC_LINK_DEVICE_MISMATCH", "MagicLinkToken", link.UID, "CharonOTP", themislog.SeverityInfo,
+			fmt.Sprintf(`{"purpose":"%s"}`, link.Purpose))
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  "Open the sign-in link on the device you requested it from",
+		}, nil
+	}
+
+	action, userID, channelDID, err := routeChannelDID(link.ChannelType, link.ChannelDID)
+	if err != nil {
+		return VerifyOTPResponse{
+			Verified: false,
+			Message:  "Failed to determine next action",
+		}, fmt.Errorf("post-verification routing failed: %w", err)
+	}
+
+	auditor.Record("MAGIC_LINK_VERIFIED", "MagicLinkToken", link.UID, "CharonOTP", themislog.SeverityInfo,
+		fmt.Sprintf(`{"purpose":"%s","action":"%s"}`, link.Purpose, action))
+
+	return VerifyOTPResponse{
+		Verified:    true,
+		Message:     "Sign-in link verified successfully",
+		UserID:      userID,
+		Action:      action,
+		ChannelDID:  channelDID,
+		ChannelType: link.ChannelType,
+		Purpose:     link.Purpose,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package charonotp
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestGenerateMagicLinkToken(t *testing.T) {
+	token, err := generateMagicLinkToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatalf("Token is not base64url: %v", err)
+	}
+	if len(raw) != magicLinkTokenBytes {
+		t.Errorf("Expected %d bytes of entropy, got %d", magicLinkTokenBytes, len(raw))
+	}
+
+	other, _ := generateMagicLinkToken()
+	if other == token {
+		t.Error("Expected tokens to be unique")
+	}
+
+	link, err := url.Parse(buildMagicLink("https://example.com/auth/magic-link", token))
+	if err != nil {
+		t.Fatalf("Invalid magic link: %v", err)
+	}
+	if link.Query().Get("token") != token {
+		t.Errorf("Expected token to round-trip through the link, got %q", link.Query().Get("token"))
+	}
+}
+
+func TestMagicLinkDeviceMatches(t *testing.T) {
+	bound := hashString("device-123")
+
+	if !magicLinkDeviceMatches("", "") || !magicLinkDeviceMatches("", "anything") {
+		t.Error("Expected unbound links to open on any device")
+	}
+	if !magicLinkDeviceMatches(bound, "device-123") {
+		t.Error("Expected bound link to open on the requesting device")
+	}
+	if magicLinkDeviceMatches(bound, "device-456") {
+		t.Error("Expected bound link to be rejected on another device")
+	}
+	if magicLinkDeviceMatches(bound, "") {
+		t.Error("Expected bound link to be rejected without a device ID")
+	}
+}
+
+func TestVerifyMagicLinkRejectsEmptyToken(t *testing.T) {
+	resp, err := VerifyMagicLink(VerifyMagicLinkRequest{})
+	if err != nil || resp.Verified {
+		t.Errorf("Expected empty token to be rejected, got %+v (%v)", resp, err)
+	}
+}
@@ -0,0 +1,258 @@
+package charonotp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// MaxOTPAttempts is the number of wrong codes after which an OTP is burned
+const MaxOTPAttempts = 5
+
+// rateLimit is a sliding-window limit of Max events per Window. Events are
+// stored as OTPRateEvent nodes keyed by scope and a hashed subject.
+type rateLimit struct {
+	Scope  string
+	Max    int
+	Window time.Duration
+}
+
+var (
+	// recipientSendLimit throttles OTPs sent to one email address or phone
+	recipientSendLimit = rateLimit{Scope: "otp_send_recipient", Max: 3, Window: 10 * time.Minute}
+	// ipSendLimit throttles OTPs requested from one client IP
+	ipSendLimit = rateLimit{Scope: "otp_send_ip", Max: 20, Window: time.Hour}
+	// channelFailureLimit locks a channel after this many wrong codes
+	channelFailureLimit = rateLimit{Scope: "otp_verify_failure", Max: 10, Window: 15 * time.Minute}
+	// channelLockout is an active lockout; one event blocks the channel for Window
+	channelLockout = rateLimit{Scope: "otp_channel_lockout", Max: 1, Window: 30 * time.Minute}
+)
+
+// ThrottleError reports a request refused by a rate limit or lockout
+type ThrottleError struct {
+	Code       string        // "RATE_LIMITED" or "LOCKED_OUT"
+	Scope      string        // rate limit scope that refused the request
+	RetryAfter time.Duration // how long until the request would be accepted
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%s: retry after %d seconds", e.Code, e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds
+func (e *ThrottleError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// rateCheck applies a limit to one subject (already hashed)
+type rateCheck struct {
+	limit   rateLimit
+	subject string
+}
+
+func (c rateCheck) key() string {
+	return c.limit.Scope + ":" + c.subject
+}
+
+// consumeRateLimits records one event against every check, but only if all
+// of them are under their limit. The count and insert happen in one upsert
+// so concurrent requests can't both slip under the limit.
+func consumeRateLimits(now time.Time, checks ...rateCheck) (*ThrottleError, error) {
+	windows, err := executeRateLimitUpsert(now, checks, true)
+	if err != nil {
+		return nil, err
+	}
+	return throttleFromWindows(checks, windows, now, "RATE_LIMITED"), nil
+}
+
+// recordRateEvent records an event unconditionally and returns how many
+// events, including this one, fall inside the window
+func recordRateEvent(now time.Time, check rateCheck) (int, error) {
+	windows, err := executeRateLimitUpsert(now, []rateCheck{check}, false)
+	if err != nil {
+		return 0, err
+	}
+	return len(windows[0]) + 1, nil
+}
+
+// checkLockout reports an active lockout without recording anything
+func checkLockout(now time.Time, check rateCheck) (*ThrottleError, error) {
+	query := rateLimitQuery(now, []rateCheck{check})
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lockout: %w", err)
+	}
+	windows, err := parseRateWindows(resp.Json, 1)
+	if err != nil {
+		return nil, err
+	}
+	return throttleFromWindows([]rateCheck{check}, windows, now, "LOCKED_OUT"), nil
+}
+
+func executeRateLimitUpsert(now time.Time, checks []rateCheck, conditional bool) ([][]time.Time, error) {
+	query, mutation := rateLimitUpsert(now, checks, conditional)
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply rate limit: %w", err)
+	}
+	return parseRateWindows(resp.Json, len(checks))
+}
+
+// rateLimitQuery selects the events inside each check's window as w0, w1, ...
+func rateLimitQuery(now time.Time, checks []rateCheck) *dgraph.Query {
+	var params, blocks []string
+	for i := range checks {
+		params = append(params, fmt.Sprintf("$k%d: string, $s%d: string", i, i))
+		blocks = append(blocks, fmt.Sprintf(`r%d as var(func: eq(rateKey, $k%d)) @filter(type(OTPRateEvent) AND ge(occurredAt, $s%d))
+		w%d(func: uid(r%d), orderasc: occurredAt) {
+			occurredAt
+		}`, i, i, i, i, i))
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf("query limits(%s) {\n\t\t%s\n\t}",
+		strings.Join(params, ", "), strings.Join(blocks, "\n\t\t")))
+	for i, c := range checks {
+		query = query.
+			WithVariable(fmt.Sprintf("$k%d", i), c.key()).
+			WithVariable(fmt.Sprintf("$s%d", i), now.Add(-c.limit.Window).Format(time.RFC3339))
+	}
+	return query
+}
+
+// rateLimitUpsert builds the query plus a mutation inserting one event per
+// check. When conditional, the insert only happens if every check is under
+// its limit.
+func rateLimitUpsert(now time.Time, checks []rateCheck, conditional bool) (*dgraph.Query, *dgraph.Mutation) {
+	var nquads, conditions []string
+	for i, c := range checks {
+		nquads = append(nquads, fmt.Sprintf(`_:e%d <dgraph.type> "OTPRateEvent" .
+_:e%d <rateKey> "%s" .
+_:e%d <scope> "%s" .
+_:e%d <occurredAt> "%s"^^<xs:dateTime> .`,
+			i, i, c.key(), i, c.limit.Scope, i, now.Format(time.RFC3339)))
+		conditions = append(conditions, fmt.Sprintf("lt(len(r%d), %d)", i, c.limit.Max))
+	}
+
+	mutation := dgraph.NewMutation().WithSetNquads(strings.Join(nquads, "\n"))
+	if conditional {
+		mutation = mutation.WithCondition(fmt.Sprintf("@if(%s)", strings.Join(conditions, " AND ")))
+	}
+	return rateLimitQuery(now, checks), mutation
+}
+
+// parseRateWindows reads the w0..wN blocks of a rate limit query
+func parseRateWindows(respJSON string, n int) ([][]time.Time, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(respJSON), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit response: %w", err)
+	}
+
+	windows := make([][]time.Time, n)
+	for i := range windows {
+		raw, ok := result[fmt.Sprintf("w%d", i)]
+		if !ok {
+			continue
+		}
+		var events []struct {
+			OccurredAt time.Time `json:"occurredAt"`
+		}
+		if err := json.Unmarshal(raw, &events); err != nil {
+			return nil, fmt.Errorf("failed to parse rate limit window: %w", err)
+		}
+		for _, e := range events {
+			windows[i] = append(windows[i], e.OccurredAt)
+		}
+	}
+	return windows, nil
+}
+
+// throttleFromWindows returns the check with the longest wait, if any is at its limit
+func throttleFromWindows(checks []rateCheck, windows [][]time.Time, now time.Time, code string) *ThrottleError {
+	var throttle *ThrottleError
+	for i, c := range checks {
+		retryAfter := slidingWindowRetryAfter(windows[i], c.limit, now)
+		if retryAfter > 0 && (throttle == nil || retryAfter > throttle.RetryAfter) {
+			throttle = &ThrottleError{Code: code, Scope: c.limit.Scope, RetryAfter: retryAfter}
+		}
+	}
+	return throttle
+}
+
+// slidingWindowRetryAfter returns how long until fewer than limit.Max of the
+// given events (oldest first) are inside the window, or 0 if they already are
+func slidingWindowRetryAfter(events []time.Time, limit rateLimit, now time.Time) time.Duration {
+	if len(events) < limit.Max {
+		return 0
+	}
+	// The request is allowed once the event Max places from the end ages out
+	retryAfter := events[len(events)-limit.Max].Add(limit.Window).Sub(now)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return retryAfter
+}
+
+// recordFailedAttempt counts a wrong code against every live OTP for the
+// channel and against the channel itself. OTPs that reach MaxOTPAttempts
+// are burned, and the channel is locked out once channelFailureLimit is hit.
+func recordFailedAttempt(now time.Time, channelHash string) error {
+	query := dgraph.NewQuery(`query attempts($channelHash: string) {
+		live as var(func: eq(channelHash, $channelHash)) @filter(type(ChannelOTP) AND eq(verified, false) AND eq(used, false)) {
+			attempts as failedAttempts
+			next as math(attempts + 1)
+		}
+		otps(func: uid(live)) {
+			uid
+			failedAttempts
+		}
+	}`).WithVariable("$channelHash", channelHash)
+
+	mutation := dgraph.NewMutation().WithSetNquads(`uid(live) <failedAttempts> val(next) .`)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return fmt.Errorf("failed to count OTP attempt: %w", err)
+	}
+
+	var result struct {
+		OTPs []struct {
+			UID            string `json:"uid"`
+			FailedAttempts int    `json:"failedAttempts"`
+		} `json:"otps"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return fmt.Errorf("failed to parse OTP attempts: %w", err)
+	}
+
+	// The query half sees the counts from before this attempt
+	for _, otp := range result.OTPs {
+		if otp.FailedAttempts+1 < MaxOTPAttempts {
+			continue
+		}
+		if err := executeMutation(fmt.Sprintf(`<%s> <used> "true"^^<xs:boolean> .`, otp.UID)); err != nil {
+			return fmt.Errorf("failed to burn OTP: %w", err)
+		}
+		auditor.Record("OTP_ATTEMPTS_EXCEEDED", "ChannelOTP", otp.UID, "CharonOTP", themislog.SeverityInfo,
+			fmt.Sprintf(`{"channelHash":"%s","attempts":%d}`, channelHash, otp.FailedAttempts+1))
+	}
+
+	failures, err := recordRateEvent(now, rateCheck{channelFailureLimit, channelHash})
+	if err != nil {
+		return err
+	}
+	if failures >= channelFailureLimit.Max {
+		if _, err := recordRateEvent(now, rateCheck{channelLockout, channelHash}); err != nil {
+			return err
+		}
+		auditor.Record("OTP_CHANNEL_LOCKED", "ChannelOTP", channelHash, "CharonOTP", themislog.SeverityInfo,
+			fmt.Sprintf(`{"channelHash":"%s","failures":%d,"lockedUntil":"%s"}`,
+				channelHash, failures, now.Add(channelLockout.Window).Format(time.RFC3339)))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package charonotp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limit := rateLimit{Scope: "test", Max: 3, Window: 10 * time.Minute}
+
+	events := []time.Time{
+		now.Add(-9 * time.Minute),
+		now.Add(-5 * time.Minute),
+	}
+	if got := slidingWindowRetryAfter(events, limit, now); got != 0 {
+		t.Errorf("Expected no wait under the limit, got %v", got)
+	}
+
+	// At the limit the oldest event has to age out of the window
+	events = append(events, now.Add(-time.Minute))
+	if got := slidingWindowRetryAfter(events, limit, now); got != time.Minute {
+		t.Errorf("Expected 1m wait at the limit, got %v", got)
+	}
+
+	// Over the limit (e.g. unconditional failure events) waits on the Max-th newest
+	events = append(events, now)
+	if got := slidingWindowRetryAfter(events, limit, now); got != 5*time.Minute {
+		t.Errorf("Expected 5m wait over the limit, got %v", got)
+	}
+}
+
+func TestThrottleFromWindows(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	checks := []rateCheck{
+		{recipientSendLimit, hashString("user@example.com")},
+		{ipSendLimit, hashString("203.0.113.7")},
+	}
+
+	recipient := []time.Time{now.Add(-8 * time.Minute), now.Add(-4 * time.Minute), now.Add(-time.Minute)}
+	if throttle := throttleFromWindows(checks, [][]time.Time{recipient[:2], nil}, now, "RATE_LIMITED"); throttle != nil {
+		t.Fatalf("Expected no throttle under both limits, got %v", throttle)
+	}
+
+	throttle := throttleFromWindows(checks, [][]time.Time{recipient, nil}, now, "RATE_LIMITED")
+	if throttle == nil {
+		t.Fatal("Expected recipient limit to throttle")
+	}
+	if throttle.Scope != recipientSendLimit.Scope || throttle.RetryAfterSeconds() != 120 {
+		t.Errorf("Expected recipient throttle with 120s retry, got %s %ds", throttle.Scope, throttle.RetryAfterSeconds())
+	}
+}
+
+func TestRateLimitUpsert(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	checks := []rateCheck{
+		{recipientSendLimit, "abc"},
+		{ipSendLimit, "def"},
+	}
+
+	query, mutation := rateLimitUpsert(now, checks, true)
+	if query.Variables["$k0"] != "otp_send_recipient:abc" || query.Variables["$k1"] != "otp_send_ip:def" {
+		t.Errorf("Unexpected rate keys: %v", query.Variables)
+	}
+	if query.Variables["$s0"] != "2025-01-01T11:50:00Z" {
+		t.Errorf("Expected window start 10m ago, got %s", query.Variables["$s0"])
+	}
+	if mutation.Condition != "@if(lt(len(r0), 3) AND lt(len(r1), 20))" {
+		t.Errorf("Unexpected upsert condition: %s", mutation.Condition)
+	}
+	if strings.Count(mutation.SetNquads, `"OTPRateEvent"`) != 2 {
+		t.Error("Expected one event per check")
+	}
+
+	_, unconditional := rateLimitUpsert(now, checks[:1], false)
+	if unconditional.Condition != "" {
+		t.Error("Expected failure events to be recorded unconditionally")
+	}
+}
+
+func TestParseRateWindows(t *testing.T) {
+	windows, err := parseRateWindows(`{"w0":[{"occurredAt":"2025-01-01T11:55:00Z"}],"r0":[]}`, 2)
+	if err != nil {
+		t.Fatalf("parseRateWindows failed: %v", err)
+	}
+	if len(windows[0]) != 1 || len(windows[1]) != 0 {
+		t.Errorf("Unexpected windows: %v", windows)
+	}
+}
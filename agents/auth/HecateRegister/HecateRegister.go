@@ -2,11 +2,14 @@ package hecateregister
 
 import (
 	"context"
-	// "encoding/json" // Uncomment when debug logging is enabled
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/services/pii"
 )
 
 // UserRegistrationRequest represents the request to register a new user
@@ -55,7 +58,7 @@ type PIITokenizationRequest struct {
 	Phone       string `json:"phone,omitempty"`
 }
 
-// PIITokenizationResponse from internal/pii service
+// PIITokenizationResponse holds the vault tokens by field
 type PIITokenizationResponse struct {
 	Tokens map[string]string `json:"tokens"`
 	Status string           `json:"status"`
@@ -71,29 +74,27 @@ type AuditEvent struct {
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// generateUserID creates a unique user identifier
-func generateUserID() string {
-	// Generate a unique user ID (could use UUID or other method)
-	return fmt.Sprintf("user_%d", time.Now().UnixNano())
-}
-
-// tokenizePII handles PII tokenization via internal/pii service
-func tokenizePII(req PIITokenizationRequest) (*PIITokenizationResponse, error) {
-	// TODO: Integrate with internal/pii service for ISO-compliant tokenization
-	// For now, return placeholder tokens
-	tokens := map[string]string{
-		"firstName": fmt.Sprintf("tok_fn_%d", time.Now().UnixNano()),
-		"lastName":  fmt.Sprintf("tok_ln_%d", time.Now().UnixNano()),
-	}
-	
-	if req.Email != "" {
-		tokens["email"] = fmt.Sprintf("tok_em_%d", time.Now().UnixNano())
-	}
-	
-	if req.Phone != "" {
-		tokens["phone"] = fmt.Sprintf("tok_ph_%d", time.Now().UnixNano())
+// tokenizePII stores the user's PII in the vault and returns the tokens to
+// store in its place
+func tokenizePII(userUID string, req PIITokenizationRequest) (*PIITokenizationResponse, error) {
+	vault := pii.NewVault(pii.DefaultTenant)
+	tokens := map[string]string{}
+	for _, field := range []struct{ name, kind, value string }{
+		{"firstName", pii.KindName, req.FirstName},
+		{"lastName", pii.KindName, req.LastName},
+		{"email", pii.KindEmail, req.Email},
+		{"phone", pii.KindPhone, req.Phone},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			continue
+		}
+		token, err := vault.Tokenize(userUID, field.kind, field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to tokenise %s: %v", field.name, err)
+		}
+		tokens[field.name] = token
 	}
-	
+
 	return &PIITokenizationResponse{
 		Tokens: tokens,
 		Status: "success",
@@ -110,97 +111,152 @@ func triggerIdentityCheck(userID string) (string, error) {
 	return identityCheckID, nil
 }
 
-// emitAuditEvent creates an ISO-compliant audit trail entry
-func emitAuditEvent(_ AuditEvent) (string, error) {
-	// TODO: Integrate with ISO audit-trail system
-	// For now, log the event and return placeholder audit ID
-	auditID := fmt.Sprintf("audit_%d", time.Now().UnixNano())
-	
-	// Debug logging - uncomment for troubleshooting
-	// eventJSON, _ := json.MarshalIndent(event, "", "  ")
-	// fmt.Printf("📋 Audit Event [%s]:\n%s\n", auditID, string(eventJSON))
-	
-	return auditID, nil
+// emitAuditEvent records the registration on the ThemisLog audit trail
+func emitAuditEvent(event AuditEvent) (string, error) {
+	details := map[string]interface{}{
+		"ipAddress": event.IPAddress,
+		"userAgent": event.UserAgent,
+	}
+	for k, v := range event.Metadata {
+		details[k] = v
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit details: %v", err)
+	}
+
+	return themislog.LogEvent(themislog.Event{
+		Category:    themislog.CategoryAuthentication,
+		Action:      event.EventType,
+		ObjectType:  "User",
+		ObjectID:    event.UserID,
+		PerformedBy: event.UserID,
+		Source:      "HecateRegister",
+		Details:     string(detailsJSON),
+	})
 }
 
-// createUserInDgraph stores the new user record in Dgraph
-func createUserInDgraph(req UserRegistrationRequest, userID string) error {
+// createUserInDgraph stores the new user record in Dgraph and returns its
+// UID. The record holds the channel DID, never the address itself.
+func createUserInDgraph(req UserRegistrationRequest) (string, error) {
 	// Determine channel DID field based on channel type
-	var channelDIDField, channelField, channelVerifiedField string
-	var channelValue string
+	var channelDIDField, channelVerifiedField string
 	
 	switch req.ChannelType {
 	case "email":
 		channelDIDField = "emailDID"
-		channelField = "email"
 		channelVerifiedField = "emailVerified"
-		channelValue = req.Recipient
 	case "phone":
 		channelDIDField = "phoneDID"
-		channelField = "phone"
 		channelVerifiedField = "phoneVerified"
-		channelValue = req.Recipient
 	default:
-		return fmt.Errorf("unsupported channel type: %s", req.ChannelType)
+		return "", fmt.Errorf("unsupported channel type: %s", req.ChannelType)
 	}
 	
 	// Create DQL mutation for user creation
 	nquads := fmt.Sprintf(`
 		_:user <dgraph.type> "User" .
-		_:user <%s> "%s" .
-		_:user <%s> "%s" .
+		_:user <%s> %q .
 		_:user <%s> "true"^^<xs:boolean> .
 		_:user <createdAt> "%s"^^<xs:dateTime> .
 		_:user <status> "active" .
 	`, channelDIDField, req.ChannelDID,
-		channelField, channelValue,
 		channelVerifiedField,
 		time.Now().Format(time.RFC3339))
 	
+	// Execute mutation using Dgraph SDK
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	result, err := dgraph.ExecuteMutations("dgraph", mutationObj)
+	if err != nil {
+		return "", fmt.Errorf("failed to create user in Dgraph: %v", err)
+	}
+
+	// Extract the created user UID
+	uid, exists := result.Uids["user"]
+	if !exists {
+		return "", fmt.Errorf("user was created without a UID")
+	}
+	return uid, nil
+}
+
+// storeUserRecords stores the user's primary contact and profile, holding
+// vault tokens in place of the address and names
+func storeUserRecords(req UserRegistrationRequest, userUID string, tokens map[string]string) error {
+	now := time.Now().Format(time.RFC3339)
+	contactType := "EMAIL"
+	if req.ChannelType == "phone" {
+		contactType = "PHONE"
+	}
+
+	// The channel was verified by OTP before registration
+	nquads := fmt.Sprintf(`
+		_:contact <dgraph.type> "UserContact" .
+		_:contact <userId> %q .
+		_:contact <contactType> %q .
+		_:contact <purpose> "PRIMARY" .
+		_:contact <token> %q .
+		_:contact <verified> "true"^^<xs:boolean> .
+		_:contact <verifiedAt> "%s"^^<xs:dateTime> .
+		_:contact <addedAt> "%s"^^<xs:dateTime> .
+	`, userUID, contactType, tokens[req.ChannelType], now, now)
+
 	// Add user profile if provided
 	if req.FirstName != "" || req.LastName != "" {
 		profileNquads := fmt.Sprintf(`
 			_:profile <dgraph.type> "UserProfile" .
-			_:profile <userId> "%s" .
-			_:profile <firstName> "%s" .
-			_:profile <lastName> "%s" .
-			_:profile <displayName> "%s" .
-			_:profile <timezone> "%s" .
-			_:profile <language> "%s" .
+			_:profile <userId> %q .
+			_:profile <firstName> %q .
+			_:profile <lastName> %q .
+			_:profile <displayName> %q .
+			_:profile <timezone> %q .
+			_:profile <language> %q .
 			_:profile <updatedAt> "%s"^^<xs:dateTime> .
-		`, userID, req.FirstName, req.LastName, 
-			req.DisplayName, req.Timezone, req.Language,
-			time.Now().Format(time.RFC3339))
+		`, userUID, tokens["firstName"], tokens["lastName"],
+			req.DisplayName, req.Timezone, req.Language, now)
 		
 		nquads += profileNquads
 	}
 	
-	// Execute mutation using Dgraph SDK
-	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
-	result, err := dgraph.ExecuteMutations("dgraph", mutationObj)
-	if err != nil {
-		return fmt.Errorf("failed to create user in Dgraph: %v", err)
-	}
-	
-	// Extract the created user UID
-	if len(result.Uids) > 0 {
-		if uid, exists := result.Uids["user"]; exists {
-			// Debug: fmt.Printf("✅ User created with UID: %s\n", uid)
-			_ = uid // Suppress unused variable warning
-		}
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return fmt.Errorf("failed to store user profile: %v", err)
 	}
-	
 	return nil
 }
 
+// discardUser removes a user whose registration could not be completed, so
+// the channel can be registered again
+func discardUser(userUID string) {
+	if _, err := pii.NewVault(pii.DefaultTenant).EraseSubject(userUID, userUID); err != nil {
+		fmt.Printf("⚠️ Failed to erase PII of incomplete registration %s: %v\n", userUID, err)
+	}
+	mutation := dgraph.NewMutation().WithDelNquads(fmt.Sprintf("<%s> * * .", userUID))
+	if _, err := dgraph.ExecuteMutations("dgraph", mutation); err != nil {
+		fmt.Printf("⚠️ Failed to remove incomplete registration %s: %v\n", userUID, err)
+	}
+}
+
 // RegisterUser is the main exported function to register a new user
 func RegisterUser(ctx context.Context, req UserRegistrationRequest) (UserRegistrationResponse, error) {
 	// Debug: fmt.Printf("🌙 HecateRegister: Initiating user registration for %s\n", req.Recipient)
 	
-	// Generate unique user ID
-	userID := generateUserID()
+	if req.ChannelDID == "" || strings.TrimSpace(req.Recipient) == "" {
+		return UserRegistrationResponse{
+			Success: false,
+			Message: "A verified channel is required",
+		}, fmt.Errorf("channel DID and recipient are required")
+	}
 	
-	// Step 1: PII Tokenization for ISO compliance
+	// Step 1: Create user record in Dgraph
+	userID, err := createUserInDgraph(req)
+	if err != nil {
+		return UserRegistrationResponse{
+			Success: false,
+			Message: "Failed to create user account",
+		}, fmt.Errorf("user creation failed: %v", err)
+	}
+
+	// Step 2: PII Tokenization for ISO compliance; only tokens are stored
+	// on the user's records
 	piiReq := PIITokenizationRequest{
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
@@ -213,20 +269,16 @@ func RegisterUser(ctx context.Context, req UserRegistrationRequest) (UserRegistr
 		piiReq.Phone = req.Recipient
 	}
 	
-	piiResp, err := tokenizePII(piiReq)
-	if err != nil {
-		return UserRegistrationResponse{
-			Success: false,
-			Message: "Failed to tokenize PII data",
-		}, fmt.Errorf("PII tokenization failed: %v", err)
+	piiResp, err := tokenizePII(userID, piiReq)
+	if err == nil {
+		err = storeUserRecords(req, userID, piiResp.Tokens)
 	}
-	
-	// Step 2: Create user record in Dgraph
-	if err := createUserInDgraph(req, userID); err != nil {
+	if err != nil {
+		discardUser(userID)
 		return UserRegistrationResponse{
 			Success: false,
-			Message: "Failed to create user account",
-		}, fmt.Errorf("user creation failed: %v", err)
+			Message: "Failed to store user profile",
+		}, fmt.Errorf("user profile storage failed: %v", err)
 	}
 	
 	// Step 3: Trigger identity verification
@@ -238,7 +290,7 @@ func RegisterUser(ctx context.Context, req UserRegistrationRequest) (UserRegistr
 	
 	// Step 4: Emit audit event for ISO compliance
 	auditEvent := AuditEvent{
-		EventType: "UserRegistered",
+		EventType: "USER_REGISTERED",
 		UserID:    userID,
 		Timestamp: time.Now(),
 		IPAddress: req.IPAddress,
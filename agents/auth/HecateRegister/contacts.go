@@ -0,0 +1,109 @@
+package hecateregister
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	"modus/services/pii"
+)
+
+// Contact types a UserContact can hold. Postal addresses are kept as a
+// PostalAddress instead.
+const (
+	ContactTypeEmail = "EMAIL"
+	ContactTypePhone = "PHONE"
+	ContactTypeOther = "OTHER"
+)
+
+// contactKinds is the vault kind of each contact type's value
+var contactKinds = map[string]string{
+	ContactTypeEmail: pii.KindEmail,
+	ContactTypePhone: pii.KindPhone,
+	ContactTypeOther: pii.KindText,
+}
+
+// EmergencyContact is someone to reach on a user's behalf
+type EmergencyContact struct {
+	Name         string `json:"contactName"`
+	Relationship string `json:"relationship"`
+	Phone        string `json:"phone,omitempty"`
+	Email        string `json:"email,omitempty"`
+}
+
+// AddUserContact stores a contact detail of a user, e.g. a billing email.
+// The value goes to the PII vault and the UserContact holds its token,
+// which is returned. The contact is unverified until confirmed by OTP.
+func AddUserContact(userUID, contactType, purpose, value string) (string, error) {
+	kind, ok := contactKinds[contactType]
+	if !ok {
+		return "", fmt.Errorf("unsupported contact type: %s", contactType)
+	}
+	purpose = strings.ToUpper(strings.TrimSpace(purpose))
+	if purpose == "" {
+		return "", errors.New("contact purpose is required")
+	}
+
+	token, err := pii.NewVault(pii.DefaultTenant).Tokenize(userUID, kind, value)
+	if err != nil {
+		return "", err
+	}
+
+	nquads := fmt.Sprintf(`_:contact <dgraph.type> "UserContact" .
+_:contact <userId> %q .
+_:contact <contactType> %q .
+_:contact <purpose> %q .
+_:contact <token> %q .
+_:contact <verified> "false"^^<xs:boolean> .
+_:contact <addedAt> "%s"^^<xs:dateTime> .`,
+		userUID, contactType, purpose, token, time.Now().Format(time.RFC3339))
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return "", fmt.Errorf("failed to store contact: %v", err)
+	}
+	return token, nil
+}
+
+// AddEmergencyContact stores an emergency contact of a user. Their name,
+// phone and email go to the PII vault; the UserEmergency holds the tokens.
+func AddEmergencyContact(userUID string, contact EmergencyContact) error {
+	if strings.TrimSpace(contact.Name) == "" {
+		return errors.New("emergency contact name is required")
+	}
+	if strings.TrimSpace(contact.Phone) == "" && strings.TrimSpace(contact.Email) == "" {
+		return errors.New("emergency contact needs a phone number or email")
+	}
+
+	vault := pii.NewVault(pii.DefaultTenant)
+	tokens := map[string]string{}
+	for _, field := range []struct{ name, kind, value string }{
+		{"contactName", pii.KindName, contact.Name},
+		{"phone", pii.KindPhone, contact.Phone},
+		{"email", pii.KindEmail, contact.Email},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			continue
+		}
+		token, err := vault.Tokenize(userUID, field.kind, field.value)
+		if err != nil {
+			return fmt.Errorf("failed to tokenise emergency contact %s: %v", field.name, err)
+		}
+		tokens[field.name] = token
+	}
+
+	nquads := fmt.Sprintf(`_:emergency <dgraph.type> "UserEmergency" .
+_:emergency <userId> %q .
+_:emergency <contactName> %q .
+_:emergency <relationship> %q .`,
+		userUID, tokens["contactName"], contact.Relationship)
+	for _, field := range []string{"phone", "email"} {
+		if token, ok := tokens[field]; ok {
+			nquads += fmt.Sprintf("\n_:emergency <%s> %q .", field, token)
+		}
+	}
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return fmt.Errorf("failed to store emergency contact: %v", err)
+	}
+	return nil
+}
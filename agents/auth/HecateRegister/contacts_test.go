@@ -0,0 +1,64 @@
+package hecateregister
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	"modus/services/pii"
+)
+
+func setupVault(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`[{"kid": "test", "status": "active", "key": %q}]`, base64.StdEncoding.EncodeToString(key))
+	if err := pii.SetMasterKeys(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddEmergencyContactStoresOnlyTokens(t *testing.T) {
+	setupVault(t)
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	err := AddEmergencyContact("0x2a", EmergencyContact{
+		Name:         "Charles Babbage",
+		Relationship: "colleague",
+		Phone:        "+44 7700 900123",
+	})
+	if err != nil {
+		t.Fatalf("AddEmergencyContact failed: %v", err)
+	}
+
+	last := dgraph.DgraphQueryCallStack.Items[dgraph.DgraphQueryCallStack.Size()-1][1].(*dgraph.Request)
+	nquads := last.Mutations[0].SetNquads
+	if strings.Contains(nquads, "Babbage") || strings.Contains(nquads, "7700") {
+		t.Errorf("Expected no plaintext PII on the UserEmergency, got %s", nquads)
+	}
+	if !strings.Contains(nquads, `<phone> "`+pii.TokenPhonePrefix) || strings.Contains(nquads, "<email>") {
+		t.Errorf("Expected a phone token and no email, got %s", nquads)
+	}
+	// One vault record each for the name and phone, then the contact
+	if n := dgraph.DgraphQueryCallStack.Size() - before; n != 3 {
+		t.Errorf("Expected 3 Dgraph calls, got %d", n)
+	}
+}
+
+func TestAddUserContactValidates(t *testing.T) {
+	setupVault(t)
+	if _, err := AddUserContact("0x2a", "ADDRESS", "BILLING", "10 Downing Street"); err == nil {
+		t.Error("Expected postal addresses to be refused")
+	}
+	if _, err := AddUserContact("0x2a", ContactTypeEmail, "", "ada@example.com"); err == nil {
+		t.Error("Expected a purpose to be required")
+	}
+	if _, err := AddUserContact("0x2a", ContactTypeEmail, "BILLING", "not an email"); err == nil {
+		t.Error("Expected an invalid email to be refused")
+	}
+}
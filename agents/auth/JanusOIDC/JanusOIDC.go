@@ -0,0 +1,648 @@
+package janusoidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	themislog "modus/agents/audit/ThemisLog"
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/config"
+)
+
+// Scopes a client can be registered for and request
+const (
+	ScopeOpenID        = "openid"         // required for an ID token
+	ScopeProfile       = "profile"        // name, given_name, family_name, zoneinfo, locale
+	ScopeEmail         = "email"          // email, email_verified
+	ScopeRoles         = "roles"          // the user's role names
+	ScopeOfflineAccess = "offline_access" // a refresh token
+)
+
+// SupportedScopes lists every scope this provider understands
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeRoles, ScopeOfflineAccess}
+
+// Authorization request values
+const (
+	ResponseTypeCode     = "code"
+	CodeChallengeS256    = "S256"
+	PromptNone           = "none"
+	PromptLogin          = "login"
+	PromptConsent        = "consent"
+	ConsentApprove       = "approve"
+	ConsentDeny          = "deny"
+	GrantTypeCode        = "authorization_code"
+	GrantTypeRefresh     = "refresh_token"
+	TokenTypeBearer      = "Bearer"
+	authorizationCodeTTL = time.Minute
+	idTokenTTL           = 10 * time.Minute
+	// freshLoginWindow is how recent a sign-in must be for prompt=login
+	freshLoginWindow = 5 * time.Minute
+)
+
+// OAuth error codes (RFC 6749 §4.1.2.1 and §5.2, OIDC Core §3.1.2.6)
+const (
+	ErrInvalidRequest          = "invalid_request"
+	ErrInvalidClient           = "invalid_client"
+	ErrInvalidGrant            = "invalid_grant"
+	ErrInvalidScope            = "invalid_scope"
+	ErrInvalidToken            = "invalid_token"
+	ErrInsufficientScope       = "insufficient_scope"
+	ErrAccessDenied            = "access_denied"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	ErrUnsupportedGrantType    = "unsupported_grant_type"
+	ErrLoginRequired           = "login_required"
+	ErrConsentRequired         = "consent_required"
+	ErrServerError             = "server_error"
+)
+
+// OAuthError is an error reported to a relying party
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, format string, args ...interface{}) *OAuthError {
+	return &OAuthError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// AuthorizationRequest is an authorization request forwarded by the
+// frontend's authorize page, together with the user's first-party session
+type AuthorizationRequest struct {
+	// SessionToken is the user's session from signing in with CharonOTP or
+	// WebAuthn; empty if they haven't signed in yet
+	SessionToken        string `json:"sessionToken,omitempty"`
+	ClientID            string `json:"clientId"`
+	RedirectURI         string `json:"redirectUri"`
+	ResponseType        string `json:"responseType"`
+	Scope               string `json:"scope"`
+	State               string `json:"state,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"codeChallenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod"`
+	Prompt              string `json:"prompt,omitempty"` // "none", "login" or "consent"
+	MaxAge              int64  `json:"maxAge,omitempty"` // seconds since sign-in; 0 for any
+	// Consent is set by the consent screen: "approve" or "deny"
+	Consent string `json:"consent,omitempty"`
+}
+
+// AuthorizationResponse tells the frontend what to do next. Either
+// RedirectURL is set (send the browser there; it carries the code or an
+// error) or the user must sign in or consent first, then call again.
+type AuthorizationResponse struct {
+	RedirectURL     string   `json:"redirectUrl,omitempty"`
+	LoginRequired   bool     `json:"loginRequired"`
+	ConsentRequired bool     `json:"consentRequired"`
+	ClientName      string   `json:"clientName"`
+	Scopes          []string `json:"scopes"` // requested scopes, for the consent screen
+}
+
+// Authorize handles the authorization endpoint of the authorization-code
+// flow. Errors before the client and redirect URI are verified are
+// returned, never redirected, so they can't be used as an open redirect.
+func Authorize(ctx context.Context, req AuthorizationRequest) (*AuthorizationResponse, error) {
+	client, err := getClient(req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.Disabled {
+		return nil, oauthError(ErrInvalidClient, "unknown client")
+	}
+	if !client.redirectAllowed(req.RedirectURI) {
+		return nil, oauthError(ErrInvalidRequest, "redirect_uri is not registered for this client")
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, oauthErr := checkAuthorizationRequest(client, req)
+	response := &AuthorizationResponse{ClientName: client.Name, Scopes: scopes}
+	if oauthErr != nil {
+		response.RedirectURL = errorRedirect(req.RedirectURI, req.State, cfg.OIDC.Issuer, oauthErr)
+		return response, nil
+	}
+
+	// The user signs in through the existing CharonOTP or WebAuthn flows
+	chronos, err := chronossession.Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+	session, err := signedInSession(ctx, chronos, req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		if req.Prompt == PromptNone {
+			response.RedirectURL = errorRedirect(req.RedirectURI, req.State, cfg.OIDC.Issuer, oauthError(ErrLoginRequired, "the user is not signed in"))
+			return response, nil
+		}
+		response.LoginRequired = true
+		return response, nil
+	}
+
+	user, err := lookupUser(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, oauthError(ErrAccessDenied, "user not found")
+	}
+
+	switch {
+	case req.Consent == ConsentDeny:
+		auditor.Record("OAUTH_CONSENT_DENIED", consentRecordType, client.ClientID, user.UID, themislog.SeverityInfo,
+			fmt.Sprintf("User declined %s for scopes %s", client.Name, strings.Join(scopes, " ")))
+		response.RedirectURL = errorRedirect(req.RedirectURI, req.State, cfg.OIDC.Issuer, oauthError(ErrAccessDenied, "the user declined"))
+		return response, nil
+	case req.Consent == ConsentApprove:
+		if err := grantConsent(user.UID, client.ClientID, scopes); err != nil {
+			return nil, err
+		}
+		auditor.Record("OAUTH_CONSENT_GRANTED", consentRecordType, client.ClientID, user.UID, themislog.SeverityInfo,
+			fmt.Sprintf("User allowed %s scopes %s", client.Name, strings.Join(scopes, " ")))
+	case !client.Trusted:
+		granted, err := consentedScopes(user.UID, client.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		if req.Prompt == PromptConsent || !containsAll(granted, scopes) {
+			if req.Prompt == PromptNone {
+				response.RedirectURL = errorRedirect(req.RedirectURI, req.State, cfg.OIDC.Issuer, oauthError(ErrConsentRequired, "the user has not consented"))
+				return response, nil
+			}
+			response.ConsentRequired = true
+			return response, nil
+		}
+	}
+
+	code, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization code: %w", err)
+	}
+	grant := &authorizationGrant{
+		ClientID:      client.ClientID,
+		UserUID:       user.UID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		Assurance:     session.Assurance,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	}
+	if err := storeAuthorizationCode(code, grant); err != nil {
+		return nil, err
+	}
+
+	auditor.Record("OAUTH_CODE_ISSUED", codeRecordType, client.ClientID, user.UID, themislog.SeverityInfo,
+		fmt.Sprintf("Authorization code issued to %s for scopes %s", client.Name, grant.Scope))
+	response.RedirectURL = codeRedirect(req.RedirectURI, code, req.State, cfg.OIDC.Issuer)
+	return response, nil
+}
+
+// checkAuthorizationRequest validates the parts of a request that are
+// reported back to the client, and returns the requested scopes
+func checkAuthorizationRequest(client *Client, req AuthorizationRequest) ([]string, *OAuthError) {
+	scopes := strings.Fields(req.Scope)
+
+	if req.ResponseType != ResponseTypeCode {
+		return scopes, oauthError(ErrUnsupportedResponseType, "only response_type=code is supported")
+	}
+	if len(scopes) == 0 {
+		return scopes, oauthError(ErrInvalidScope, "scope is required")
+	}
+	for _, scope := range scopes {
+		if !contains(SupportedScopes, scope) {
+			return scopes, oauthError(ErrInvalidScope, "unknown scope %q", scope)
+		}
+		if !contains(client.Scopes, scope) {
+			return scopes, oauthError(ErrInvalidScope, "client may not request scope %q", scope)
+		}
+	}
+
+	// PKCE is required of every client, confidential or not (RFC 9700)
+	if req.CodeChallenge == "" {
+		return scopes, oauthError(ErrInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != CodeChallengeS256 {
+		return scopes, oauthError(ErrInvalidRequest, "code_challenge_method must be S256")
+	}
+	if len(req.CodeChallenge) != 43 {
+		return scopes, oauthError(ErrInvalidRequest, "code_challenge must be a base64url SHA-256 hash")
+	}
+
+	switch req.Prompt {
+	case "", PromptNone, PromptLogin, PromptConsent:
+	default:
+		return scopes, oauthError(ErrInvalidRequest, "unsupported prompt %q", req.Prompt)
+	}
+	if req.Prompt == PromptNone && req.Consent != "" {
+		return scopes, oauthError(ErrInvalidRequest, "prompt=none can't be combined with a consent decision")
+	}
+	return scopes, nil
+}
+
+// signedInSession returns the user's first-party session if it is valid
+// and recent enough for prompt=login and max_age, or nil if they must sign
+// in again
+func signedInSession(ctx context.Context, chronos *chronossession.ChronosSession, req AuthorizationRequest, now time.Time) (*chronossession.ValidationResponse, error) {
+	if req.SessionToken == "" {
+		return nil, nil
+	}
+	validation, err := chronos.ValidateSession(ctx, &chronossession.ValidationRequest{Token: req.SessionToken})
+	if err != nil {
+		return nil, err
+	}
+	if !validation.Valid {
+		return nil, nil
+	}
+
+	if !recentEnough(validation.Assurance.AuthTime, req, now) {
+		return nil, nil
+	}
+	return validation, nil
+}
+
+// recentEnough checks a sign-in time against max_age, and against the
+// fresh-login window for prompt=login
+func recentEnough(authTime time.Time, req AuthorizationRequest, now time.Time) bool {
+	maxAge := time.Duration(req.MaxAge) * time.Second
+	if req.Prompt == PromptLogin && (maxAge == 0 || maxAge > freshLoginWindow) {
+		maxAge = freshLoginWindow
+	}
+	return maxAge == 0 || now.Sub(authTime) <= maxAge
+}
+
+// codeRedirect sends the code back to the client. iss lets the client
+// check which provider answered (RFC 9207).
+func codeRedirect(redirectURI, code, state, issuer string) string {
+	params := url.Values{"code": {code}, "iss": {issuer}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return appendQuery(redirectURI, params)
+}
+
+// errorRedirect reports an authorization error to the client
+func errorRedirect(redirectURI, state, issuer string, oauthErr *OAuthError) string {
+	params := url.Values{"error": {oauthErr.Code}, "iss": {issuer}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return appendQuery(redirectURI, params)
+}
+
+// appendQuery adds parameters to a redirect URI that may already have a query
+func appendQuery(redirectURI string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	return redirectURI + separator + params.Encode()
+}
+
+// TokenRequest is a token endpoint request
+type TokenRequest struct {
+	GrantType    string `json:"grantType"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"` // confidential clients only
+	Code         string `json:"code,omitempty"`
+	RedirectURI  string `json:"redirectUri,omitempty"`
+	CodeVerifier string `json:"codeVerifier,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// TokenResponse is a token endpoint response (RFC 6749 §5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"` // offline_access only
+	IDToken      string `json:"id_token,omitempty"`      // openid only
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Token handles the token endpoint: it redeems an authorization code, or
+// rotates a refresh token, for a client that authenticated itself
+func Token(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	client, err := getClient(req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.Disabled || !client.authenticate(req.ClientSecret) {
+		return nil, oauthError(ErrInvalidClient, "client authentication failed")
+	}
+
+	chronos, err := chronossession.Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	switch req.GrantType {
+	case GrantTypeCode:
+		return redeemCode(ctx, chronos, client, req)
+	case GrantTypeRefresh:
+		return refreshTokens(ctx, chronos, client, req)
+	default:
+		return nil, oauthError(ErrUnsupportedGrantType, "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// redeemCode exchanges an authorization code for tokens. A code presented
+// twice revokes the tokens issued for it (RFC 6749 §4.1.2).
+func redeemCode(ctx context.Context, chronos *chronossession.ChronosSession, client *Client, req TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" {
+		return nil, oauthError(ErrInvalidRequest, "code is required")
+	}
+
+	grant, consumed, err := consumeAuthorizationCode(req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return nil, oauthError(ErrInvalidGrant, "unknown authorization code")
+	}
+	if !consumed {
+		if grant.Used && grant.FamilyID != "" {
+			if _, err := chronossession.RevokeUserSession(ctx, grant.UserUID, grant.FamilyID, "authorization code replayed"); err != nil {
+				log.Printf("⚠️ Warning: Failed to revoke tokens of replayed code for client %s: %v", client.ClientID, err)
+			}
+			auditor.Record("OAUTH_CODE_REPLAY", codeRecordType, grant.UID, grant.UserUID, themislog.SeverityCritical,
+				fmt.Sprintf("Authorization code for %s presented again; revoked session %s", grant.ClientID, grant.FamilyID))
+		}
+		return nil, oauthError(ErrInvalidGrant, "authorization code is expired or already used")
+	}
+
+	if oauthErr := checkCodeGrant(grant, client, req); oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	user, err := lookupUser(grant.UserUID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, oauthError(ErrInvalidGrant, "user not found")
+	}
+	// Only the ID token carries user claims
+	if scopes := strings.Fields(grant.Scope); contains(scopes, ScopeOpenID) {
+		if err := user.revealPII(scopes, client.ClientID); err != nil {
+			return nil, err
+		}
+	}
+
+	tokens, familyID, err := issueTokens(ctx, chronos, cfg.OIDC.Issuer, client, grant, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordCodeSession(grant.UID, familyID); err != nil {
+		log.Printf("⚠️ Warning: Failed to link authorization code to its session: %v", err)
+	}
+
+	auditor.Record("OAUTH_TOKEN_ISSUED", codeRecordType, grant.UID, user.UID, themislog.SeverityInfo,
+		fmt.Sprintf("Tokens issued to %s for scopes %s", client.Name, grant.Scope))
+	return tokens, nil
+}
+
+// checkCodeGrant checks a redeemed code against the client redeeming it
+func checkCodeGrant(grant *authorizationGrant, client *Client, req TokenRequest) *OAuthError {
+	if grant.ClientID != client.ClientID {
+		return oauthError(ErrInvalidGrant, "authorization code was issued to another client")
+	}
+	if grant.RedirectURI != req.RedirectURI {
+		return oauthError(ErrInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if !verifyCodeChallenge(req.CodeVerifier, grant.CodeChallenge) {
+		return oauthError(ErrInvalidGrant, "code_verifier does not match code_challenge")
+	}
+	return nil
+}
+
+// issueTokens issues the access token, and the ID token and refresh token
+// when their scopes were granted. It returns the session ID (sid) as well.
+func issueTokens(ctx context.Context, chronos *chronossession.ChronosSession, issuer string, client *Client, grant *authorizationGrant, user *userRecord) (*TokenResponse, string, error) {
+	scopes := strings.Fields(grant.Scope)
+	assurance := grant.Assurance
+
+	// The access token is a ChronosSession session bound to the client, so
+	// signing out or revoking the user's sessions ends it too
+	session, err := chronos.IssueSession(ctx, &chronossession.SessionRequest{
+		UserID: user.UID,
+		AdditionalClaims: map[string]interface{}{
+			"iss":       issuer,
+			"aud":       client.ClientID,
+			"client_id": client.ClientID,
+			"scope":     grant.Scope,
+		},
+		AuthMethod: chronossession.AuthMethod{AuthType: chronossession.SESSION_TYPE_OAUTH},
+		Assurance:  &assurance,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to issue access token: %w", err)
+	}
+
+	response := &TokenResponse{
+		AccessToken: session.Token,
+		TokenType:   TokenTypeBearer,
+		ExpiresIn:   int64(time.Until(session.ExpiresAt).Seconds()),
+		Scope:       grant.Scope,
+	}
+	if contains(scopes, ScopeOfflineAccess) {
+		response.RefreshToken = session.RefreshToken
+	}
+
+	if contains(scopes, ScopeOpenID) {
+		response.IDToken, err = chronos.IssueIDToken(&chronossession.IDTokenRequest{
+			Issuer:      issuer,
+			Subject:     user.UID,
+			Audience:    client.ClientID,
+			Nonce:       grant.Nonce,
+			AccessToken: session.Token,
+			Assurance:   assurance,
+			TTL:         idTokenTTL,
+			Claims:      user.claims(scopes),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to issue ID token: %w", err)
+		}
+	}
+	return response, session.SessionID, nil
+}
+
+// refreshTokens rotates a refresh token issued to the client
+func refreshTokens(ctx context.Context, chronos *chronossession.ChronosSession, client *Client, req TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError(ErrInvalidRequest, "refresh_token is required")
+	}
+
+	session, err := chronos.RefreshSession(ctx, &chronossession.RefreshRequest{
+		RefreshToken: req.RefreshToken,
+		ClientID:     client.ClientID,
+	})
+	if err != nil {
+		return nil, oauthError(ErrInvalidGrant, "%v", err)
+	}
+
+	return &TokenResponse{
+		AccessToken:  session.Token,
+		TokenType:    TokenTypeBearer,
+		ExpiresIn:    int64(time.Until(session.ExpiresAt).Seconds()),
+		RefreshToken: session.RefreshToken,
+	}, nil
+}
+
+// UserInfo returns the claims the access token's scopes allow (OIDC Core §5.3)
+func UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	clientID := unverifiedClientID(accessToken)
+	if clientID == "" {
+		return nil, oauthError(ErrInvalidToken, "not an access token issued to a client")
+	}
+
+	chronos, err := chronossession.Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+	// The signature and client binding are checked here
+	validation, err := chronos.ValidateSession(ctx, &chronossession.ValidationRequest{Token: accessToken, ClientID: clientID})
+	if err != nil {
+		return nil, err
+	}
+	if !validation.Valid {
+		return nil, oauthError(ErrInvalidToken, "%s", validation.Message)
+	}
+
+	scopes := strings.Fields(validation.Scope)
+	if !contains(scopes, ScopeOpenID) {
+		return nil, oauthError(ErrInsufficientScope, "the openid scope is required")
+	}
+
+	user, err := lookupUser(validation.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, oauthError(ErrInvalidToken, "user not found")
+	}
+	if err := user.revealPII(scopes, clientID); err != nil {
+		return nil, err
+	}
+
+	claims := user.claims(scopes)
+	claims["sub"] = user.UID
+	return claims, nil
+}
+
+// ProviderMetadata is the OpenID Provider discovery document
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Discovery returns the provider metadata served at
+// /.well-known/openid-configuration under the issuer
+func Discovery() (*ProviderMetadata, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	issuer := cfg.OIDC.Issuer
+
+	return &ProviderMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   SupportedScopes,
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               []string{GrantTypeCode, GrantTypeRefresh},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{chronossession.ALG_ES256, chronossession.ALG_EDDSA},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeS256},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "at_hash",
+			"name", "given_name", "family_name", "zoneinfo", "locale", "email", "email_verified", "roles"},
+		AuthorizationResponseIssParameter: true,
+	}, nil
+}
+
+// verifyCodeChallenge checks a PKCE code_verifier against the S256
+// code_challenge from the authorization request (RFC 7636 §4.6)
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// 43 to 128 characters from the unreserved set
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// newOpaqueToken returns a random URL-safe token for codes and secrets
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash codes and client secrets are stored under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAll reports whether every value in want is in have
+func containsAll(have, want []string) bool {
+	for _, v := range want {
+		if !contains(have, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,432 @@
+package janusoidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/config"
+)
+
+const testIssuer = "https://do-study.hypermode.host"
+
+// setupProvider configures ChronosSession with a fresh signing key
+func setupProvider(t *testing.T) *chronossession.ChronosSession {
+	t.Helper()
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	keys, _ := json.Marshal([]chronossession.SigningKeyConfig{{
+		KID:        "oidc-test",
+		Alg:        chronossession.ALG_ES256,
+		Status:     chronossession.KEY_STATUS_ACTIVE,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}})
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	cfg, err := config.Defaults(config.Production)
+	if err != nil {
+		t.Fatalf("Defaults failed: %v", err)
+	}
+	cfg.Secrets.SessionSigningKeys = string(keys)
+	cfg.Secrets.PIIMasterKeys = fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}]`, base64.StdEncoding.EncodeToString(masterKey))
+	if err := config.Set(cfg); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := chronossession.SetSigningKeys(string(keys)); err != nil {
+		t.Fatalf("SetSigningKeys failed: %v", err)
+	}
+	if err := chronossession.SetSessionPolicies(`[{"idleTimeoutSeconds":3600,"absoluteLifetimeSeconds":86400}]`); err != nil {
+		t.Fatalf("SetSessionPolicies failed: %v", err)
+	}
+
+	chronos, err := chronossession.Initialize()
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	return chronos
+}
+
+// relyingParty stands in for an app such as the reporting dashboard: it
+// starts the flow, handles the callback and verifies the ID token the way
+// an OIDC client library would
+type relyingParty struct {
+	client   *Client
+	verifier string
+	state    string
+	nonce    string
+}
+
+func newRelyingParty(t *testing.T, client *Client) *relyingParty {
+	t.Helper()
+	rp := &relyingParty{client: client}
+	for _, v := range []*string{&rp.verifier, &rp.state, &rp.nonce} {
+		token, err := newOpaqueToken()
+		if err != nil {
+			t.Fatalf("newOpaqueToken failed: %v", err)
+		}
+		*v = token
+	}
+	return rp
+}
+
+func (rp *relyingParty) authorizationRequest(scope string) AuthorizationRequest {
+	challenge := sha256.Sum256([]byte(rp.verifier))
+	return AuthorizationRequest{
+		ClientID:            rp.client.ClientID,
+		RedirectURI:         rp.client.RedirectURIs[0],
+		ResponseType:        ResponseTypeCode,
+		Scope:               scope,
+		State:               rp.state,
+		Nonce:               rp.nonce,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: CodeChallengeS256,
+	}
+}
+
+// callback checks the redirect back to the app and returns the code
+func (rp *relyingParty) callback(t *testing.T, redirectURL string) string {
+	t.Helper()
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatalf("Invalid redirect %q: %v", redirectURL, err)
+	}
+	if !strings.HasPrefix(redirectURL, rp.client.RedirectURIs[0]+"?") {
+		t.Fatalf("Expected a redirect to %s, got %s", rp.client.RedirectURIs[0], redirectURL)
+	}
+	params := parsed.Query()
+	if params.Get("state") != rp.state || params.Get("iss") != testIssuer {
+		t.Fatalf("Expected our state and issuer, got %v", params)
+	}
+	if params.Get("error") != "" {
+		t.Fatalf("Authorization failed: %s", params.Get("error_description"))
+	}
+	return params.Get("code")
+}
+
+func (rp *relyingParty) tokenRequest(code string) TokenRequest {
+	return TokenRequest{
+		GrantType:    GrantTypeCode,
+		ClientID:     rp.client.ClientID,
+		Code:         code,
+		RedirectURI:  rp.client.RedirectURIs[0],
+		CodeVerifier: rp.verifier,
+	}
+}
+
+// verifyIDToken checks an ID token against the provider's JWKS
+func (rp *relyingParty) verifyIDToken(t *testing.T, idToken, accessToken string) jwt.MapClaims {
+	t.Helper()
+	jwks, err := chronossession.GetJWKS()
+	if err != nil {
+		t.Fatalf("GetJWKS failed: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		for _, k := range jwks.Keys {
+			if k.KeyID == token.Header["kid"] && k.KeyType == "EC" {
+				x, _ := base64.RawURLEncoding.DecodeString(k.X)
+				y, _ := base64.RawURLEncoding.DecodeString(k.Y)
+				return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key %v", token.Header["kid"])
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithIssuer(testIssuer), jwt.WithAudience(rp.client.ClientID))
+	if err != nil {
+		t.Fatalf("ID token did not verify: %v", err)
+	}
+
+	if claims["nonce"] != rp.nonce {
+		t.Errorf("Expected nonce %q, got %v", rp.nonce, claims["nonce"])
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	if claims["at_hash"] != base64.RawURLEncoding.EncodeToString(sum[:16]) {
+		t.Errorf("Expected at_hash to bind the access token, got %v", claims["at_hash"])
+	}
+	return claims
+}
+
+func testClient() *Client {
+	return &Client{
+		ClientID:     "reporting-dashboard",
+		Name:         "Reporting Dashboard",
+		RedirectURIs: []string{"https://reports.example.com/callback"},
+		Scopes:       []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeRoles, ScopeOfflineAccess},
+	}
+}
+
+func testUser() *userRecord {
+	user := &userRecord{
+		UID:           "0x2a",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Profile:       &userProfile{FirstName: "Ada", LastName: "Lovelace", Timezone: "Europe/London", Language: "en"},
+	}
+	user.Roles = append(user.Roles, struct {
+		Name string `json:"name"`
+	}{Name: "assessor"})
+	return user
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	chronos := setupProvider(t)
+	client := testClient()
+	user := testUser()
+	rp := newRelyingParty(t, client)
+
+	// The app sends the user to the authorize page
+	req := rp.authorizationRequest("openid profile email offline_access")
+	scopes, oauthErr := checkAuthorizationRequest(client, req)
+	if oauthErr != nil {
+		t.Fatalf("Expected the request to be accepted, got %v", oauthErr)
+	}
+
+	// The user signed in with WebAuthn a moment ago
+	assurance := chronossession.Assurance{AMR: []string{"hwk", "mfa"}, AAL: 3, AuthTime: time.Now().Add(-time.Minute)}
+	code, _ := newOpaqueToken()
+	grant := &authorizationGrant{
+		ClientID:      client.ClientID,
+		UserUID:       user.UID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		Assurance:     assurance,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	}
+
+	// The browser comes back to the app, which redeems the code
+	got := rp.callback(t, codeRedirect(req.RedirectURI, code, req.State, testIssuer))
+	if got != code {
+		t.Fatalf("Expected the code in the callback, got %q", got)
+	}
+	if oauthErr := checkCodeGrant(grant, client, rp.tokenRequest(got)); oauthErr != nil {
+		t.Fatalf("Expected the code to be redeemable, got %v", oauthErr)
+	}
+
+	tokens, familyID, err := issueTokens(context.Background(), chronos, testIssuer, client, grant, user)
+	if err != nil {
+		t.Fatalf("issueTokens failed: %v", err)
+	}
+	if tokens.TokenType != TokenTypeBearer || tokens.RefreshToken == "" || familyID == "" {
+		t.Errorf("Expected a bearer token with a refresh token, got %+v", tokens)
+	}
+
+	claims := rp.verifyIDToken(t, tokens.IDToken, tokens.AccessToken)
+	if claims["sub"] != user.UID || claims["email"] != user.Email || claims["given_name"] != "Ada" {
+		t.Errorf("Expected user claims for the granted scopes, got %v", claims)
+	}
+	if _, ok := claims["roles"]; ok {
+		t.Error("Expected no roles claim without the roles scope")
+	}
+	if int64(claims["auth_time"].(float64)) != assurance.AuthTime.Unix() {
+		t.Errorf("Expected auth_time of the sign-in, got %v", claims["auth_time"])
+	}
+
+	// The access token is bound to the app
+	if clientID := unverifiedClientID(tokens.AccessToken); clientID != client.ClientID {
+		t.Errorf("Expected the access token to carry client_id, got %q", clientID)
+	}
+	validation, err := chronos.ValidateSession(context.Background(), &chronossession.ValidationRequest{Token: tokens.AccessToken})
+	if err != nil || validation.Valid {
+		t.Errorf("Expected the access token to be refused as a first-party session, got %+v", validation)
+	}
+}
+
+func TestRefreshTokenOnlyWithOfflineAccess(t *testing.T) {
+	chronos := setupProvider(t)
+	client := testClient()
+	grant := &authorizationGrant{ClientID: client.ClientID, UserUID: "0x2a", Scope: "openid"}
+
+	tokens, _, err := issueTokens(context.Background(), chronos, testIssuer, client, grant, testUser())
+	if err != nil {
+		t.Fatalf("issueTokens failed: %v", err)
+	}
+	if tokens.RefreshToken != "" {
+		t.Error("Expected no refresh token without offline_access")
+	}
+	if tokens.IDToken == "" {
+		t.Error("Expected an ID token for the openid scope")
+	}
+}
+
+func TestCheckCodeGrantRejectsMismatches(t *testing.T) {
+	client := testClient()
+	rp := newRelyingParty(t, client)
+	req := rp.authorizationRequest("openid")
+	grant := &authorizationGrant{ClientID: client.ClientID, RedirectURI: req.RedirectURI, CodeChallenge: req.CodeChallenge}
+
+	other := testClient()
+	other.ClientID = "content-authoring"
+
+	tests := []struct {
+		name   string
+		client *Client
+		modify func(*TokenRequest)
+		want   string
+	}{
+		{"another client", other, func(r *TokenRequest) {}, "issued to another client"},
+		{"other redirect", client, func(r *TokenRequest) { r.RedirectURI = "https://reports.example.com/other" }, "redirect_uri"},
+		{"wrong verifier", client, func(r *TokenRequest) { r.CodeVerifier = strings.Repeat("a", 43) }, "code_verifier"},
+		{"missing verifier", client, func(r *TokenRequest) { r.CodeVerifier = "" }, "code_verifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenReq := rp.tokenRequest("code")
+			tt.modify(&tokenReq)
+			oauthErr := checkCodeGrant(grant, tt.client, tokenReq)
+			if oauthErr == nil || oauthErr.Code != ErrInvalidGrant || !strings.Contains(oauthErr.Description, tt.want) {
+				t.Errorf("Expected invalid_grant mentioning %q, got %v", tt.want, oauthErr)
+			}
+		})
+	}
+}
+
+func TestCheckAuthorizationRequest(t *testing.T) {
+	client := testClient()
+	client.Scopes = []string{ScopeOpenID, ScopeEmail}
+	rp := newRelyingParty(t, client)
+
+	tests := []struct {
+		name   string
+		modify func(*AuthorizationRequest)
+		want   string
+	}{
+		{"implicit flow", func(r *AuthorizationRequest) { r.ResponseType = "token" }, ErrUnsupportedResponseType},
+		{"no scope", func(r *AuthorizationRequest) { r.Scope = "" }, ErrInvalidScope},
+		{"unknown scope", func(r *AuthorizationRequest) { r.Scope = "openid admin" }, ErrInvalidScope},
+		{"scope not registered", func(r *AuthorizationRequest) { r.Scope = "openid roles" }, ErrInvalidScope},
+		{"no PKCE", func(r *AuthorizationRequest) { r.CodeChallenge = "" }, ErrInvalidRequest},
+		{"plain PKCE", func(r *AuthorizationRequest) { r.CodeChallengeMethod = "plain" }, ErrInvalidRequest},
+		{"unknown prompt", func(r *AuthorizationRequest) { r.Prompt = "select_account" }, ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := rp.authorizationRequest("openid email")
+			tt.modify(&req)
+			if _, oauthErr := checkAuthorizationRequest(client, req); oauthErr == nil || oauthErr.Code != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, oauthErr)
+			}
+		})
+	}
+}
+
+func TestErrorRedirectCarriesStateAndIssuer(t *testing.T) {
+	redirect := errorRedirect("https://reports.example.com/callback?tenant=7", "xyz", testIssuer, oauthError(ErrAccessDenied, "the user declined"))
+	parsed, _ := url.Parse(redirect)
+	params := parsed.Query()
+	if params.Get("tenant") != "7" || params.Get("error") != ErrAccessDenied || params.Get("state") != "xyz" || params.Get("iss") != testIssuer {
+		t.Errorf("Expected the error, state and issuer on the registered URI, got %s", redirect)
+	}
+}
+
+func TestSignedInSessionNeedsAKnownSession(t *testing.T) {
+	chronos := setupProvider(t)
+	ctx := context.Background()
+
+	if session, err := signedInSession(ctx, chronos, AuthorizationRequest{}, time.Now()); err != nil || session != nil {
+		t.Fatalf("Expected no session without a token, got %v, %v", session, err)
+	}
+
+	// The test double has no record of the token
+	issued, err := chronos.IssueSession(ctx, &chronossession.SessionRequest{UserID: "0x2a"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if session, err := signedInSession(ctx, chronos, AuthorizationRequest{SessionToken: issued.Token}, time.Now()); err != nil || session != nil {
+		t.Errorf("Expected an unknown session to require sign-in, got %v, %v", session, err)
+	}
+}
+
+func TestRecentEnough(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		signedIn time.Duration
+		req      AuthorizationRequest
+		want     bool
+	}{
+		{"any age", 30 * 24 * time.Hour, AuthorizationRequest{}, true},
+		{"within max_age", 50 * time.Second, AuthorizationRequest{MaxAge: 60}, true},
+		{"past max_age", 2 * time.Minute, AuthorizationRequest{MaxAge: 60}, false},
+		{"prompt=login after a fresh sign-in", time.Minute, AuthorizationRequest{Prompt: PromptLogin}, true},
+		{"prompt=login after an old sign-in", time.Hour, AuthorizationRequest{Prompt: PromptLogin}, false},
+		{"prompt=login caps a long max_age", time.Hour, AuthorizationRequest{Prompt: PromptLogin, MaxAge: 86400}, false},
+	}
+	for _, tt := range tests {
+		if got := recentEnough(now.Add(-tt.signedIn), tt.req, now); got != tt.want {
+			t.Errorf("%s: recentEnough = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserClaimsFollowScopes(t *testing.T) {
+	user := testUser()
+
+	claims := user.claims([]string{ScopeOpenID})
+	if len(claims) != 0 {
+		t.Errorf("Expected no user claims for openid alone, got %v", claims)
+	}
+
+	claims = user.claims([]string{ScopeOpenID, ScopeProfile, ScopeRoles})
+	if claims["name"] != "Ada Lovelace" || claims["zoneinfo"] != "Europe/London" || claims["locale"] != "en" {
+		t.Errorf("Expected profile claims, got %v", claims)
+	}
+	if roles, _ := claims["roles"].([]string); len(roles) != 1 || roles[0] != "assessor" {
+		t.Errorf("Expected the assessor role, got %v", claims["roles"])
+	}
+	if _, ok := claims["email"]; ok {
+		t.Error("Expected no email without the email scope")
+	}
+}
+
+func TestRevealPIIOnlyReleasesVaultValues(t *testing.T) {
+	user := testUser()
+
+	if err := user.revealPII([]string{ScopeOpenID, ScopeProfile}, "reports"); err != nil {
+		t.Fatalf("revealPII failed: %v", err)
+	}
+	if user.Profile.FirstName != "" || user.Profile.LastName != "" {
+		t.Errorf("Expected names the vault doesn't hold to be dropped, got %+v", user.Profile)
+	}
+	if user.Email != "ada@example.com" {
+		t.Errorf("Expected the email to be left alone without the email scope, got %s", user.Email)
+	}
+}
+
+func TestDiscoveryUsesConfiguredIssuer(t *testing.T) {
+	setupProvider(t)
+
+	metadata, err := Discovery()
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	if metadata.Issuer != testIssuer || metadata.TokenEndpoint != testIssuer+"/oauth/token" {
+		t.Errorf("Expected endpoints under %s, got %+v", testIssuer, metadata)
+	}
+	if len(metadata.CodeChallengeMethodsSupported) != 1 || metadata.CodeChallengeMethodsSupported[0] != CodeChallengeS256 {
+		t.Errorf("Expected only S256, got %v", metadata.CodeChallengeMethodsSupported)
+	}
+}
@@ -0,0 +1,6 @@
+package janusoidc
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes OAuth and OpenID Connect events to the audit trail
+var auditor = themislog.Auditor{Source: "JanusOIDC", Category: themislog.CategoryAuthentication}
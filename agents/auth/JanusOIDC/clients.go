@@ -0,0 +1,272 @@
+package janusoidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// clientAdminRole is the role allowed to register relying parties
+const clientAdminRole = "admin"
+
+const clientRecordType = "OAuthClient"
+
+// Client is a registered relying party
+type Client struct {
+	UID          string   `json:"uid,omitempty"`
+	ClientID     string   `json:"clientId"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"` // scopes the client may request
+	// Confidential clients authenticate at the token endpoint with their
+	// secret; public clients (SPAs, mobile apps) rely on PKCE alone
+	Confidential bool `json:"confidential"`
+	// Trusted clients are our own apps; their users aren't asked to consent
+	Trusted    bool      `json:"trusted"`
+	SecretHash string    `json:"secretHash,omitempty"`
+	Disabled   bool      `json:"disabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ClientRegistration describes a relying party to register
+type ClientRegistration struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+	Trusted      bool     `json:"trusted"`
+}
+
+// RegisteredClient is returned once, when a client is registered. The
+// secret is not stored and can't be shown again.
+type RegisteredClient struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"` // confidential clients only
+}
+
+// RegisterClient registers a relying party. Only administrators can.
+func RegisterClient(adminUserID string, reg ClientRegistration) (*RegisteredClient, error) {
+	isAdmin, err := userHasRole(adminUserID, clientAdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %v", err)
+	}
+	if !isAdmin {
+		return nil, fmt.Errorf("only administrators can register OAuth clients")
+	}
+
+	if err := validateRegistration(reg); err != nil {
+		return nil, err
+	}
+
+	clientID, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client ID: %v", err)
+	}
+	registered := &RegisteredClient{ClientID: clientID}
+
+	secretHash := ""
+	if reg.Confidential {
+		if registered.ClientSecret, err = newOpaqueToken(); err != nil {
+			return nil, fmt.Errorf("failed to create client secret: %v", err)
+		}
+		secretHash = hashToken(registered.ClientSecret)
+	}
+
+	nquads := fmt.Sprintf(`_:client <dgraph.type> %q .
+_:client <clientId> %q .
+_:client <clientName> %q .
+_:client <confidential> "%t"^^<xs:boolean> .
+_:client <trusted> "%t"^^<xs:boolean> .
+_:client <disabled> "false"^^<xs:boolean> .
+_:client <createdBy> <%s> .
+_:client <createdAt> "%s"^^<xs:dateTime> .
+`, clientRecordType, clientID, reg.Name, reg.Confidential, reg.Trusted, adminUserID, time.Now().Format(time.RFC3339))
+	if secretHash != "" {
+		nquads += fmt.Sprintf("_:client <secretHash> %q .\n", secretHash)
+	}
+	for _, uri := range reg.RedirectURIs {
+		nquads += fmt.Sprintf("_:client <redirectUris> %q .\n", uri)
+	}
+	for _, scope := range reg.Scopes {
+		nquads += fmt.Sprintf("_:client <scopes> %q .\n", scope)
+	}
+
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return nil, fmt.Errorf("failed to store client: %v", err)
+	}
+
+	auditor.Record("OAUTH_CLIENT_REGISTERED", clientRecordType, clientID, adminUserID, themislog.SeverityInfo,
+		fmt.Sprintf("Registered %s (confidential: %t, trusted: %t) for scopes %s",
+			reg.Name, reg.Confidential, reg.Trusted, strings.Join(reg.Scopes, " ")))
+	log.Printf("🚪 Admin %s registered OAuth client %s (%s)", adminUserID, reg.Name, clientID)
+	return registered, nil
+}
+
+// validateRegistration checks a client registration before it is stored
+func validateRegistration(reg ClientRegistration) error {
+	if strings.TrimSpace(reg.Name) == "" {
+		return fmt.Errorf("client name is required")
+	}
+	if len(reg.RedirectURIs) == 0 {
+		return fmt.Errorf("at least one redirect URI is required")
+	}
+	for _, uri := range reg.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+	if !contains(reg.Scopes, ScopeOpenID) {
+		return fmt.Errorf("clients must be allowed the openid scope")
+	}
+	for _, scope := range reg.Scopes {
+		if !contains(SupportedScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI accepts absolute https URIs without a fragment, and
+// plain http only on the loopback interface for native apps (RFC 8252 §7.3)
+func validateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", uri)
+	}
+	if parsed.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("redirect URI %q must not have a fragment", uri)
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if isLoopback(parsed.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI %q must use https", uri)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// redirectAllowed reports whether a redirect URI is registered. It must
+// match exactly; prefixes and wildcards would let codes leak (RFC 9700 §4.1).
+func (c *Client) redirectAllowed(uri string) bool {
+	return uri != "" && contains(c.RedirectURIs, uri)
+}
+
+// authenticate checks the client secret of a confidential client. Public
+// clients must not send one.
+func (c *Client) authenticate(secret string) bool {
+	if !c.Confidential {
+		return secret == ""
+	}
+	if secret == "" || c.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(c.SecretHash)) == 1
+}
+
+// getClient loads a registered client, or returns nil if there is none
+func getClient(clientID string) (*Client, error) {
+	if clientID == "" {
+		return nil, nil
+	}
+
+	query := `query client($clientId: string) {
+		client(func: eq(clientId, $clientId)) @filter(type(OAuthClient)) {
+			uid
+			clientId
+			name: clientName
+			redirectUris
+			scopes
+			confidential
+			trusted
+			secretHash
+			disabled
+			createdAt
+		}
+	}`
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query).WithVariable("$clientId", clientID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client: %v", err)
+	}
+
+	var result struct {
+		Client []Client `json:"client"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse client: %v", err)
+	}
+	if len(result.Client) == 0 || result.Client[0].ClientID != clientID {
+		return nil, nil
+	}
+	return &result.Client[0], nil
+}
+
+// userHasRole checks whether a user holds a role
+func userHasRole(userID, role string) (bool, error) {
+	if !isUID(userID) {
+		return false, nil
+	}
+	query := fmt.Sprintf(`{
+		user(func: uid(%s)) {
+			roles {
+				name
+			}
+		}
+	}`, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		User []struct {
+			Roles []struct {
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, err
+	}
+
+	for _, user := range result.User {
+		for _, r := range user.Roles {
+			if r.Name == role {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isUID checks that an ID is a Dgraph uid, since it is written into queries
+func isUID(id string) bool {
+	if !strings.HasPrefix(id, "0x") || len(id) < 3 {
+		return false
+	}
+	for _, c := range id[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
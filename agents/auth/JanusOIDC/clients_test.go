@@ -0,0 +1,81 @@
+package janusoidc
+
+import "testing"
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://reports.example.com/callback", true},
+		{"http://127.0.0.1:8400/callback", true},
+		{"http://localhost:3000/callback", true},
+		{"http://reports.example.com/callback", false},
+		{"https://reports.example.com/callback#frag", false},
+		{"/callback", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		if err := validateRedirectURI(tt.uri); (err == nil) != tt.valid {
+			t.Errorf("validateRedirectURI(%q) = %v, want valid=%t", tt.uri, err, tt.valid)
+		}
+	}
+}
+
+func TestRedirectMustMatchExactly(t *testing.T) {
+	client := testClient()
+	for _, uri := range []string{
+		"https://reports.example.com/callback/",
+		"https://reports.example.com/callback?next=/admin",
+		"https://reports.example.com/callback.evil.example",
+		"",
+	} {
+		if client.redirectAllowed(uri) {
+			t.Errorf("Expected %q to be refused", uri)
+		}
+	}
+	if !client.redirectAllowed("https://reports.example.com/callback") {
+		t.Error("Expected the registered URI to be allowed")
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	public := testClient()
+	if !public.authenticate("") || public.authenticate("secret") {
+		t.Error("Expected a public client to authenticate without a secret only")
+	}
+
+	secret, _ := newOpaqueToken()
+	confidential := testClient()
+	confidential.Confidential = true
+	confidential.SecretHash = hashToken(secret)
+	if !confidential.authenticate(secret) {
+		t.Error("Expected the right secret to authenticate")
+	}
+	if confidential.authenticate("") || confidential.authenticate(secret+"x") {
+		t.Error("Expected a missing or wrong secret to fail")
+	}
+}
+
+func TestValidateRegistration(t *testing.T) {
+	valid := ClientRegistration{
+		Name:         "Content Authoring",
+		RedirectURIs: []string{"https://author.example.com/callback"},
+		Scopes:       []string{ScopeOpenID, ScopeProfile},
+	}
+	if err := validateRegistration(valid); err != nil {
+		t.Fatalf("Expected a valid registration, got %v", err)
+	}
+
+	noOpenID := valid
+	noOpenID.Scopes = []string{ScopeProfile}
+	unknown := valid
+	unknown.Scopes = []string{ScopeOpenID, "admin"}
+	noRedirect := valid
+	noRedirect.RedirectURIs = nil
+	for name, reg := range map[string]ClientRegistration{"no openid": noOpenID, "unknown scope": unknown, "no redirect": noRedirect} {
+		if err := validateRegistration(reg); err == nil {
+			t.Errorf("%s: expected the registration to be refused", name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package janusoidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	chronossession "modus/agents/sessions/ChronosSession"
+)
+
+const codeRecordType = "OAuthAuthorizationCode"
+
+// authorizationGrant is what an authorization code stands for, as stored in
+// Dgraph under the code's hash
+type authorizationGrant struct {
+	UID           string                   `json:"uid,omitempty"`
+	ClientID      string                   `json:"clientId"`
+	UserUID       string                   `json:"-"`
+	RedirectURI   string                   `json:"redirectUri"`
+	Scope         string                   `json:"scope"`
+	Nonce         string                   `json:"nonce"`
+	CodeChallenge string                   `json:"codeChallenge"`
+	Assurance     chronossession.Assurance `json:"-"` // of the sign-in the code was issued from
+	// FamilyID is the session the code was redeemed for, revoked if the code
+	// is presented again
+	FamilyID  string    `json:"familyId"`
+	Used      bool      `json:"used"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// storedGrant is an OAuthAuthorizationCode record
+type storedGrant struct {
+	authorizationGrant
+	User *struct {
+		UID string `json:"uid"`
+	} `json:"user"`
+	AssuranceJSON string `json:"assurance"`
+}
+
+// storeAuthorizationCode stores a grant under the hash of its code
+func storeAuthorizationCode(code string, grant *authorizationGrant) error {
+	assurance, err := json.Marshal(grant.Assurance)
+	if err != nil {
+		return fmt.Errorf("failed to encode assurance: %v", err)
+	}
+
+	nquads := fmt.Sprintf(`_:code <dgraph.type> %q .
+_:code <codeHash> %q .
+_:code <clientId> %q .
+_:code <user> <%s> .
+_:code <redirectUri> %q .
+_:code <scope> %q .
+_:code <nonce> %q .
+_:code <codeChallenge> %q .
+_:code <assurance> %q .
+_:code <used> "false"^^<xs:boolean> .
+_:code <expiresAt> "%s"^^<xs:dateTime> .
+_:code <createdAt> "%s"^^<xs:dateTime> .`,
+		codeRecordType, hashToken(code), grant.ClientID, grant.UserUID, grant.RedirectURI, grant.Scope,
+		grant.Nonce, grant.CodeChallenge, string(assurance),
+		grant.ExpiresAt.Format(time.RFC3339), time.Now().Format(time.RFC3339))
+
+	if _, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads)); err != nil {
+		return fmt.Errorf("failed to store authorization code: %v", err)
+	}
+	return nil
+}
+
+// consumeAuthorizationCode marks a code used in one upsert. It returns the
+// grant (nil if the code is unknown) and whether this call consumed it; a
+// grant that wasn't consumed was expired or already redeemed.
+func consumeAuthorizationCode(code string) (*authorizationGrant, bool, error) {
+	now := time.Now()
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query code($codeHash: string) {
+		c as var(func: eq(codeHash, $codeHash)) @filter(type(%s) AND eq(used, false) AND gt(expiresAt, %q))
+		code(func: eq(codeHash, $codeHash)) @filter(type(%s)) {
+			uid
+			clientId
+			user {
+				uid
+			}
+			redirectUri
+			scope
+			nonce
+			codeChallenge
+			assurance
+			familyId
+			used
+			expiresAt
+		}
+	}`, codeRecordType, now.Format(time.RFC3339), codeRecordType)).
+		WithVariable("$codeHash", hashToken(code))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(c), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(c) <used> "true"^^<xs:boolean> .
+uid(c) <usedAt> "%s"^^<xs:dateTime> .`, now.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to redeem authorization code: %v", err)
+	}
+
+	var result struct {
+		Code []storedGrant `json:"code"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(result.Code) == 0 {
+		return nil, false, nil
+	}
+
+	stored := result.Code[0]
+	grant := stored.authorizationGrant
+	if stored.User != nil {
+		grant.UserUID = stored.User.UID
+	}
+	if stored.AssuranceJSON != "" {
+		if err := json.Unmarshal([]byte(stored.AssuranceJSON), &grant.Assurance); err != nil {
+			return nil, false, fmt.Errorf("failed to read assurance: %v", err)
+		}
+	}
+
+	// The query reads the state before the mutation, so the code was
+	// consumed exactly when it matched the mutation's condition
+	consumed := !grant.Used && grant.ExpiresAt.After(now)
+	return &grant, consumed, nil
+}
+
+// recordCodeSession links a redeemed code to the session issued for it
+func recordCodeSession(codeUID, familyID string) error {
+	if codeUID == "" || familyID == "" {
+		return nil
+	}
+	nquads := fmt.Sprintf(`<%s> <familyId> %q .`, codeUID, familyID)
+	_, err := dgraph.ExecuteMutations("dgraph", dgraph.NewMutation().WithSetNquads(nquads))
+	return err
+}
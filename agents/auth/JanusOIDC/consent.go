@@ -0,0 +1,87 @@
+package janusoidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+const consentRecordType = "OAuthConsent"
+
+// consentedScopes returns the scopes a user has allowed a client
+func consentedScopes(userUID, clientID string) ([]string, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query consent($clientId: string) {
+		consent(func: eq(clientId, $clientId)) @filter(type(%s) AND uid_in(user, %s)) {
+			scopes
+		}
+	}`, consentRecordType, userUID)).WithVariable("$clientId", clientID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load consent: %v", err)
+	}
+
+	var result struct {
+		Consent []struct {
+			Scopes []string `json:"scopes"`
+		} `json:"consent"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse consent: %v", err)
+	}
+
+	var scopes []string
+	for _, c := range result.Consent {
+		scopes = append(scopes, c.Scopes...)
+	}
+	return scopes, nil
+}
+
+// grantConsent records that a user allowed a client some scopes, adding
+// them to any the user allowed before
+func grantConsent(userUID, clientID string, scopes []string) error {
+	query := dgraph.NewQuery(fmt.Sprintf(`query consent($clientId: string) {
+		c as var(func: eq(clientId, $clientId)) @filter(type(%s) AND uid_in(user, %s))
+	}`, consentRecordType, userUID)).WithVariable("$clientId", clientID)
+
+	now := time.Now().Format(time.RFC3339)
+	update := fmt.Sprintf(`uid(c) <grantedAt> "%s"^^<xs:dateTime> .`, now)
+	create := fmt.Sprintf(`_:consent <dgraph.type> %q .
+_:consent <clientId> %q .
+_:consent <user> <%s> .
+_:consent <grantedAt> "%s"^^<xs:dateTime> .`, consentRecordType, clientID, userUID, now)
+	for _, scope := range scopes {
+		update += fmt.Sprintf("\nuid(c) <scopes> %q .", scope)
+		create += fmt.Sprintf("\n_:consent <scopes> %q .", scope)
+	}
+
+	_, err := dgraph.ExecuteQuery("dgraph", query,
+		dgraph.NewMutation().WithCondition("@if(eq(len(c), 1))").WithSetNquads(update),
+		dgraph.NewMutation().WithCondition("@if(eq(len(c), 0))").WithSetNquads(create))
+	if err != nil {
+		return fmt.Errorf("failed to record consent: %v", err)
+	}
+	return nil
+}
+
+// RevokeConsent withdraws everything a user allowed a client. Tokens the
+// client already holds stay valid until they expire or are revoked.
+func RevokeConsent(userUID, clientID string) error {
+	query := dgraph.NewQuery(fmt.Sprintf(`query consent($clientId: string) {
+		c as var(func: eq(clientId, $clientId)) @filter(type(%s) AND uid_in(user, %s))
+	}`, consentRecordType, userUID)).WithVariable("$clientId", clientID)
+
+	_, err := dgraph.ExecuteQuery("dgraph", query,
+		dgraph.NewMutation().WithCondition("@if(gt(len(c), 0))").WithDelNquads(`uid(c) * * .`))
+	if err != nil {
+		return fmt.Errorf("failed to revoke consent: %v", err)
+	}
+
+	auditor.Record("OAUTH_CONSENT_REVOKED", consentRecordType, clientID, userUID, themislog.SeverityInfo,
+		"User withdrew consent")
+	return nil
+}
@@ -0,0 +1,170 @@
+package janusoidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	"modus/services/pii"
+)
+
+// userRecord is what the provider knows about a user. As loaded, Email and
+// the profile's names are PII vault tokens; revealPII replaces them with
+// the values a client may see.
+type userRecord struct {
+	UID           string `json:"uid"`
+	Email         string `json:"-"`
+	EmailVerified bool   `json:"-"`
+	Roles         []struct {
+		Name string `json:"name"`
+	} `json:"roles"`
+	Profile *userProfile `json:"-"`
+}
+
+// userContact is a user's primary email UserContact
+type userContact struct {
+	Token    string `json:"token"`
+	Verified bool   `json:"verified"`
+}
+
+// userProfile is the UserProfile stored for a user at registration
+type userProfile struct {
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	DisplayName string `json:"displayName"`
+	Timezone    string `json:"timezone"`
+	Language    string `json:"language"`
+}
+
+// lookupUser loads a user and their profile, or returns nil if there is no
+// such user
+func lookupUser(userUID string) (*userRecord, error) {
+	if !isUID(userUID) {
+		return nil, nil
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query user($userId: string) {
+		user(func: uid(%s)) @filter(type(User)) {
+			uid
+			roles {
+				name
+			}
+		}
+		contact(func: eq(userId, $userId), first: 1) @filter(type(UserContact) AND eq(contactType, "EMAIL") AND eq(purpose, "PRIMARY")) {
+			token
+			verified
+		}
+		profile(func: eq(userId, $userId)) @filter(type(UserProfile)) {
+			firstName
+			lastName
+			displayName
+			timezone
+			language
+		}
+	}`, userUID)).WithVariable("$userId", userUID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+
+	var result struct {
+		User    []userRecord  `json:"user"`
+		Contact []userContact `json:"contact"`
+		Profile []userProfile `json:"profile"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse user: %v", err)
+	}
+	if len(result.User) == 0 || result.User[0].UID != userUID {
+		return nil, nil
+	}
+
+	user := &result.User[0]
+	if len(result.Contact) > 0 {
+		user.Email = result.Contact[0].Token
+		user.EmailVerified = result.Contact[0].Verified
+	}
+	if len(result.Profile) > 0 {
+		user.Profile = &result.Profile[0]
+	}
+	return user, nil
+}
+
+// revealPII detokenises the email and names the granted scopes release to
+// a client. Access is recorded by the vault against the client; values it
+// doesn't hold are left out rather than handing a token to the client.
+func (u *userRecord) revealPII(scopes []string, clientID string) error {
+	var fields []*string
+	if contains(scopes, ScopeEmail) {
+		fields = append(fields, &u.Email)
+	}
+	if contains(scopes, ScopeProfile) && u.Profile != nil {
+		fields = append(fields, &u.Profile.FirstName, &u.Profile.LastName)
+	}
+
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		tokens = append(tokens, *field)
+	}
+	values, err := pii.NewVault(pii.DefaultTenant).Detokenize(pii.PurposeOIDCClaims, clientID, tokens...)
+	if err != nil {
+		return fmt.Errorf("failed to reveal user claims: %w", err)
+	}
+	for _, field := range fields {
+		*field = values[*field]
+	}
+	return nil
+}
+
+// claims returns the user claims the granted scopes allow (OIDC Core §5.4)
+func (u *userRecord) claims(scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{}
+
+	if contains(scopes, ScopeProfile) && u.Profile != nil {
+		p := u.Profile
+		name := p.DisplayName
+		if name == "" {
+			name = strings.TrimSpace(p.FirstName + " " + p.LastName)
+		}
+		setClaim(claims, "name", name)
+		setClaim(claims, "given_name", p.FirstName)
+		setClaim(claims, "family_name", p.LastName)
+		setClaim(claims, "zoneinfo", p.Timezone)
+		setClaim(claims, "locale", p.Language)
+	}
+
+	if contains(scopes, ScopeEmail) && u.Email != "" {
+		claims["email"] = u.Email
+		claims["email_verified"] = u.EmailVerified
+	}
+
+	if contains(scopes, ScopeRoles) {
+		roles := make([]string, 0, len(u.Roles))
+		for _, r := range u.Roles {
+			roles = append(roles, r.Name)
+		}
+		claims["roles"] = roles
+	}
+	return claims
+}
+
+// setClaim sets a claim unless its value is empty
+func setClaim(claims map[string]interface{}, name, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
+
+// unverifiedClientID reads the client_id claim of an access token before it
+// is validated, to know which client to validate it for
+func unverifiedClientID(accessToken string) string {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		return ""
+	}
+	clientID, _ := claims["client_id"].(string)
+	return clientID
+}
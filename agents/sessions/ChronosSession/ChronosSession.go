@@ -7,40 +7,86 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5" // JWT package for token handling
 	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph" // Correct Modus SDK path with pkg
+
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/config"
 )
 
+// ErrSessionIdle is returned when a session has been idle for longer than
+// its timeout policy allows
+var ErrSessionIdle = errors.New("session expired after inactivity; please sign in again")
+
 // ChronosSession manages user session lifecycles
 type ChronosSession struct {
-	secretKey       string
-	ttl             int64
-	refreshWindow   int64
+	keys              *keyRing
+	ttl               int64           // access token lifetime in seconds
+	refreshTTL        int64           // refresh token lifetime in seconds, renewed on rotation
+	policies          []SessionPolicy // idle and absolute timeouts per role and client type
 	sessionRecordType string
 }
 
-// Initialize creates a new ChronosSession instance with configuration from env
+// shared is the ChronosSession every call uses once Initialize has built
+// it. A failed build isn't kept, so a fixed secret is read on the next call.
+var (
+	shared   *ChronosSession
+	sharedMu sync.Mutex
+)
+
+// Initialize returns the ChronosSession built from the shared configuration
+// and secrets. It is built once per instance: signing keys and timeout
+// policies are read again only when the instance restarts or they are
+// overridden.
 func Initialize() (*ChronosSession, error) {
-	// TEMPORARY FIX: Hardcode configuration values for testing
-	// TODO: Fix Modus runtime environment variable loading
-	secretKey := "your-secure-secret-key-for-testing-jwt-tokens"
-	ttl := int64(86400)      // 24 hours in seconds
-	refreshWindow := int64(3600) // 1 hour refresh window
-	
-	// Debug logging - uncomment for troubleshooting session configuration
-	// fmt.Println("✅ Using hardcoded session configuration for testing")
-	// fmt.Printf("   SECRET_KEY: %s\n", secretKey[:10]+"...")
-	// fmt.Printf("   TTL: %d seconds\n", ttl)
-	// fmt.Printf("   REFRESH_WINDOW: %d seconds\n", refreshWindow)
-
-	return &ChronosSession{
-		secretKey:       secretKey,
-		ttl:             ttl,
-		refreshWindow:   refreshWindow,
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	if shared != nil {
+		return shared, nil
+	}
+
+	// Signing keys come from the SESSION_SIGNING_KEYS secret
+	keys, err := loadKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	// Timeout policies come from SESSION_TIMEOUT_POLICIES, or the defaults
+	policies, err := loadSessionPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	// Token lifetimes come from the shared configuration
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	ttl := int64(cfg.Session.AccessTokenTTL.Seconds())
+	refreshTTL := int64(cfg.Session.RefreshTokenTTL.Seconds())
+
+	shared = &ChronosSession{
+		keys:              keys,
+		ttl:               ttl,
+		refreshTTL:        refreshTTL,
+		policies:          policies,
 		sessionRecordType: "AuthSession",
-	}, nil
+	}
+	return shared, nil
+}
+
+// forgetShared drops the shared ChronosSession, so the next Initialize
+// builds it again
+func forgetShared() {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	shared = nil
 }
 
 // IssueSession creates a new session token for a user
@@ -48,18 +94,57 @@ func (cs *ChronosSession) IssueSession(ctx context.Context, req *SessionRequest)
 	if req.UserID == "" {
 		return nil, errors.New("user ID is required")
 	}
+	if req.ClientType != "" && !validClientType(req.ClientType) {
+		return nil, fmt.Errorf("unknown client type %q", req.ClientType)
+	}
 
-	// Set token issuance and expiration times
+	// Set token issuance and expiration times. Neither token outlives the
+	// absolute lifetime fixed at sign-in.
 	now := time.Now()
-	expiresAt := now.Add(time.Duration(cs.ttl) * time.Second)
+	limits := req.limits
+	if limits == nil {
+		resolved, err := cs.sessionLimitsFor(req.UserID, req.ClientType, req.CredentialID != "", now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve session policy: %w", err)
+		}
+		limits = &resolved
+	}
+	expiresAt := limits.cap(now.Add(time.Duration(cs.ttl) * time.Second))
 
 	// Prepare standard claims
+	tokenID, err := newTokenID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token ID: %w", err)
+	}
 	claims := jwt.MapClaims{
-		"sub": req.UserID,        // Subject: UserID
-		"iat": now.Unix(),        // Issued At: Current time
-		"exp": expiresAt.Unix(),  // Expires At: Current time + TTL
-		"jti": fmt.Sprintf("%d-%s", now.Unix(), req.UserID), // JWT ID: Unique identifier for this token
+		"sub": req.UserID,       // Subject: UserID
+		"iat": now.Unix(),       // Issued At: Current time
+		"exp": expiresAt.Unix(), // Expires At: Current time + TTL
+		"jti": tokenID,          // JWT ID: Unique identifier for this token
+	}
+
+	// sid names the refresh token family; rotation keeps it, a new sign-in
+	// starts a new one
+	familyID := req.familyID
+	if familyID == "" {
+		var err error
+		if familyID, err = newFamilyID(); err != nil {
+			return nil, fmt.Errorf("failed to create session ID: %w", err)
+		}
+	}
+	claims["sid"] = familyID
+
+	// Bind the session to the WebAuthn credential so it survives refresh
+	if req.CredentialID != "" {
+		claims["cred"] = req.CredentialID
+	}
+
+	// Record how the user authenticated (amr, acr, auth_time)
+	assurance := newAssurance(methodReferences(req.AuthMethod), now)
+	if req.Assurance != nil {
+		assurance = *req.Assurance
 	}
+	assurance.setClaims(claims)
 
 	// Add any additional claims
 	for k, v := range req.AdditionalClaims {
@@ -68,30 +153,50 @@ func (cs *ChronosSession) IssueSession(ctx context.Context, req *SessionRequest)
 		}
 	}
 
-	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(cs.secretKey))
+	// Sign the token with the active key; the kid header names it
+	tokenString, err := cs.keys.sign(claims)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign token: %w", err)
 	}
 
-	// Store the session in the database
-	err = cs.storeSession(ctx, req.UserID, tokenString, now, expiresAt, req)
+	// Issue the refresh token, keeping only its hash and the claims to
+	// reissue the access token from
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	refreshExpiresAt := limits.cap(now.Add(time.Duration(cs.refreshTTL) * time.Second))
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode claims: %w", err)
+	}
+	refreshNquads := refreshTokenNquads(familyID, hashRefreshToken(refreshToken), string(claimsJSON), now, refreshExpiresAt)
+
+	// Store the session and its refresh token in the database
+	err = cs.storeSession(ctx, req.UserID, tokenString, now, expiresAt, familyID, *limits, req, refreshNquads)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store session: %w", err)
 	}
 
-	// Emit audit event for session creation (ThemisLog integration point)
-	// TODO: Implement audit logging when ThemisLog is available
-	// ThemisLog.LogEvent("SessionIssued", map[string]string{"userID": req.UserID})
+	// Refresh and step-up carry the assurance over and audit themselves
+	if req.Assurance == nil {
+		auditor.Record("SESSION_ISSUED", cs.sessionRecordType, familyID, req.UserID, themislog.SeverityInfo,
+			fmt.Sprintf("Session issued via %s at %s", req.AuthMethod.AuthType, acrValue(assurance.AAL)))
+	}
 
 	// Return the session response
 	return &SessionResponse{
-		Token:     tokenString,
-		ExpiresAt: expiresAt,
-		IssuedAt:  now,
-		UserID:    req.UserID,
-		Message:   "Session created successfully",
+		Token:            tokenString,
+		ExpiresAt:        expiresAt,
+		IssuedAt:         now,
+		UserID:           req.UserID,
+		Message:          "Session created successfully",
+		AAL:              assurance.AAL,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+		SessionID:        familyID,
+		SessionExpiresAt: limits.AbsoluteExpiresAt,
+		IdleTimeout:      limits.IdleTimeout,
 	}, nil
 }
 
@@ -101,18 +206,12 @@ func (cs *ChronosSession) ValidateSession(ctx context.Context, req *ValidationRe
 		return &ValidationResponse{Valid: false, Message: "token is required"}, nil
 	}
 
-	// Parse the token
-	token, err := jwt.Parse(req.Token, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(cs.secretKey), nil
-	})
+	// Parse the token against the key named in its kid header
+	token, err := cs.keys.parse(req.Token)
 
 	// Check for parsing errors
 	if err != nil {
-		return &ValidationResponse{Valid: false, Message: fmt.Sprintf("invalid token: %s", err.Error())}, nil
+		return &ValidationResponse{Valid: false, Message: tokenErrorMessage(err)}, nil
 	}
 
 	// Check if the token is valid
@@ -126,23 +225,39 @@ func (cs *ChronosSession) ValidateSession(ctx context.Context, req *ValidationRe
 		return &ValidationResponse{Valid: false, Message: "invalid claims"}, nil
 	}
 
+	// Tokens issued to an OAuth client are only good at that client, and
+	// first-party sessions are not accepted on a client's behalf
+	if clientID, _ := claims["client_id"].(string); clientID != req.ClientID {
+		switch {
+		case clientID == "":
+			return &ValidationResponse{Valid: false, Message: "token was not issued to an OAuth client"}, nil
+		case req.ClientID == "":
+			return &ValidationResponse{Valid: false, Message: "token was issued to an OAuth client"}, nil
+		}
+		return &ValidationResponse{Valid: false, Message: "token was issued to another client"}, nil
+	}
+
 	// Extract user ID and expiration time
 	userID, _ := claims["sub"].(string)
 	expFloat, _ := claims["exp"].(float64)
 	expiresAt := time.Unix(int64(expFloat), 0)
+	scope, _ := claims["scope"].(string)
 
-	// Verify the token hasn't been revoked in the database
-	valid, err := cs.isTokenValid(ctx, req.Token)
+	// Verify the token hasn't been revoked in the database or gone idle
+	tokenHash := cs.hashToken(req.Token)
+	session, reason, err := cs.checkStoredSession(ctx, tokenHash, userID)
 	if err != nil {
 		return &ValidationResponse{Valid: false, Message: fmt.Sprintf("error checking token validity: %s", err.Error())}, nil
 	}
 
-	if !valid {
-		return &ValidationResponse{Valid: false, Message: "token has been revoked"}, nil
+	if reason != "" {
+		return &ValidationResponse{Valid: false, Message: reason}, nil
 	}
 
-	// Update last used timestamp
-	cs.updateLastUsed(ctx, req.Token)
+	// Record activity, writing lastUsedAt at most once per interval
+	if validationCache.touch(tokenHash, time.Now()) {
+		cs.updateLastUsed(ctx, session.UID)
+	}
 
 	// Return validation response
 	return &ValidationResponse{
@@ -150,63 +265,218 @@ func (cs *ChronosSession) ValidateSession(ctx context.Context, req *ValidationRe
 		UserID:    userID,
 		ExpiresAt: expiresAt,
 		Message:   "Token is valid",
+		Assurance: assuranceFromClaims(claims),
+		Scope:     scope,
 	}, nil
 }
 
-// RefreshSession extends the lifetime of a valid session
+// tokenErrorMessage explains why a token failed to parse, naming the key
+// problem directly when there is one
+func tokenErrorMessage(err error) string {
+	var keyErr *KeyError
+	if errors.As(err, &keyErr) {
+		return fmt.Sprintf("invalid token: %s", keyErr.Error())
+	}
+	return fmt.Sprintf("invalid token: %s", err.Error())
+}
+
+// JWKS returns the public keys that validate session tokens
+func (cs *ChronosSession) JWKS() JSONWebKeySet {
+	return cs.keys.jwks()
+}
+
+// RefreshSession rotates a refresh token: it is exchanged once for a new
+// access token and refresh token of the same family. Presenting a token that
+// was already rotated revokes the whole family, since one of the two holders
+// must have stolen it.
 func (cs *ChronosSession) RefreshSession(ctx context.Context, req *RefreshRequest) (*SessionResponse, error) {
-	// First validate the token
+	if req.RefreshToken == "" {
+		return nil, errors.New("refresh token is required")
+	}
+
+	record, consumed, err := cs.consumeRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case record == nil:
+		return nil, errors.New("invalid refresh token")
+	case consumed:
+	case record.Revoked:
+		return nil, errors.New("refresh token has been revoked")
+	case record.Used:
+		if err := cs.revokeRefreshFamilies(ctx, []string{record.FamilyID}); err != nil {
+			return nil, err
+		}
+		auditor.Record("REFRESH_TOKEN_REUSE", refreshTokenRecordType, record.UID, record.UserID, themislog.SeverityCritical,
+			fmt.Sprintf("Rotated refresh token presented again; revoked session family %s", record.FamilyID))
+		return nil, ErrRefreshTokenReused
+	default:
+		return nil, errors.New("refresh token has expired")
+	}
+
+	var claims jwt.MapClaims
+	if err := json.Unmarshal([]byte(record.Claims), &claims); err != nil {
+		return nil, fmt.Errorf("failed to read session claims: %w", err)
+	}
+
+	// A refresh token only works for the client it was issued to; one
+	// presented elsewhere has leaked, so its family is revoked
+	if clientID, _ := claims["client_id"].(string); clientID != req.ClientID {
+		if err := cs.revokeRefreshFamilies(ctx, []string{record.FamilyID}); err != nil {
+			return nil, err
+		}
+		auditor.Record("REFRESH_TOKEN_CLIENT_MISMATCH", refreshTokenRecordType, record.UID, record.UserID, themislog.SeverityCritical,
+			fmt.Sprintf("Refresh token of client %q presented by %q; revoked session family %s", clientID, req.ClientID, record.FamilyID))
+		return nil, ErrRefreshTokenClient
+	}
+
+	client := clientInfo{}
+	if record.Session != nil {
+		client = *record.Session
+	}
+
+	// A session left idle for longer than its policy allows can't be
+	// revived by refreshing it
+	if client.idle(client.lastActive(), time.Now()) {
+		if err := cs.expireIdleSession(ctx, record.UserID, record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionIdle
+	}
+
+	// Refreshing keeps the original auth_time, so it never makes a session
+	// look freshly authenticated
+	newSession, err := cs.reissueSession(ctx, record.UserID, claims, assuranceFromClaims(claims), record.FamilyID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	auditor.Record("SESSION_REFRESHED", refreshTokenRecordType, record.FamilyID, record.UserID, themislog.SeverityInfo,
+		"Refresh token rotated")
+
+	return newSession, nil
+}
+
+// CheckAssurance checks that a session reached a required assurance level
+// recently enough, e.g. before grading or exporting personal data
+func (cs *ChronosSession) CheckAssurance(ctx context.Context, req *AssuranceRequest) (*AssuranceResponse, error) {
 	validation, err := cs.ValidateSession(ctx, &ValidationRequest{Token: req.Token})
 	if err != nil {
 		return nil, err
 	}
+	if !validation.Valid {
+		return &AssuranceResponse{Satisfied: false, Message: validation.Message}, nil
+	}
+
+	response := &AssuranceResponse{
+		UserID:    validation.UserID,
+		Assurance: validation.Assurance,
+	}
+
+	if validation.Assurance.Satisfies(req.RequiredAAL, req.MaxAge, time.Now()) {
+		response.Satisfied = true
+		response.Message = "Session meets the required assurance level"
+		return response, nil
+	}
+
+	response.StepUpRequired = true
+	if validation.Assurance.AAL < req.RequiredAAL {
+		response.Message = fmt.Sprintf("This action needs %s; session is %s", acrValue(req.RequiredAAL), acrValue(validation.Assurance.AAL))
+	} else {
+		response.Message = "Please confirm it's you to continue"
+	}
+	return response, nil
+}
 
+// StepUpSession reissues a session after a fresh authentication, adding its
+// methods to amr and resetting auth_time. The old token is revoked; if that
+// fails the step-up fails too.
+func (cs *ChronosSession) StepUpSession(ctx context.Context, req *StepUpRequest) (*SessionResponse, error) {
+	validation, err := cs.ValidateSession(ctx, &ValidationRequest{Token: req.Token})
+	if err != nil {
+		return nil, err
+	}
 	if !validation.Valid {
 		return nil, errors.New(validation.Message)
 	}
 
-	// Check if token is within the refresh window
-	now := time.Now()
-	timeUntilExpiry := validation.ExpiresAt.Sub(now).Seconds()
-	
-	// Only refresh if token is in the refresh window (approaching expiration)
-	// or is past half its lifetime
-	if timeUntilExpiry > float64(cs.refreshWindow) && 
-	   timeUntilExpiry < float64(cs.ttl/2) {
-		return nil, errors.New("token not eligible for refresh yet")
+	fresh := methodReferences(req.Method)
+	if len(fresh) == 0 {
+		return nil, fmt.Errorf("unsupported step-up method: %s", req.Method.AuthType)
 	}
 
-	// Parse the existing token to get the claims
-	token, _ := jwt.Parse(req.Token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cs.secretKey), nil
-	})
+	token, _ := cs.keys.parse(req.Token)
 	claims, _ := token.Claims.(jwt.MapClaims)
-	
-	// Create new session request with the same user ID
+
+	// The stepped-up session stays on the same device in the inventory
+	client, err := cs.sessionClientInfo(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	assurance := newAssurance(mergeAMR(validation.Assurance.AMR, fresh), time.Now())
+	newSession, err := cs.reissueSession(ctx, validation.UserID, claims, assurance, "", client)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both tokens must not stay valid side by side: if the old one can't be
+	// revoked, the step-up fails and the new one is withdrawn
+	if _, err := cs.RevokeSession(ctx, &RevocationRequest{Token: req.Token, Reason: "stepped up"}); err != nil {
+		if _, rollbackErr := cs.RevokeSession(ctx, &RevocationRequest{Token: newSession.Token, Reason: "step-up failed"}); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to revoke the session being stepped up: %v (and the new session: %v)", err, rollbackErr)
+		}
+		return nil, fmt.Errorf("failed to revoke the session being stepped up: %v", err)
+	}
+
+	auditor.Record("SESSION_STEPPED_UP", cs.sessionRecordType, newSession.SessionID, validation.UserID, themislog.SeverityInfo,
+		fmt.Sprintf("Session raised to %s via %s", acrValue(assurance.AAL), req.Method.AuthType))
+
+	newSession.Message = fmt.Sprintf("Session raised to %s", acrValue(assurance.AAL))
+	return newSession, nil
+}
+
+// reissueSession issues a new token for the same user, credential and
+// additional claims as claims, with the given assurance and client details.
+// An empty familyID starts a new refresh token family.
+func (cs *ChronosSession) reissueSession(ctx context.Context, userID string, claims jwt.MapClaims, assurance Assurance, familyID string, client clientInfo) (*SessionResponse, error) {
+	credentialID, _ := claims["cred"].(string)
 	sessionReq := &SessionRequest{
-		UserID: validation.UserID,
+		UserID:       userID,
+		CredentialID: credentialID,
+		DeviceInfo:   client.DeviceInfo,
+		IPAddress:    client.IPAddress,
+		UserAgent:    client.UserAgent,
+		ClientType:   client.ClientType,
+		AuthMethod:   AuthMethod{AuthType: client.Method},
+		Assurance:    &assurance,
+		familyID:     familyID,
 	}
-	
+
+	// Sessions stored before timeout policies resolve theirs afresh
+	if !client.AbsoluteExpiresAt.IsZero() {
+		limits := client.sessionLimits
+		sessionReq.limits = &limits
+	}
+
 	// Copy additional claims from the original token
 	additionalClaims := make(map[string]interface{})
 	for key, value := range claims {
-		// Skip standard claims
-		if key != "sub" && key != "iat" && key != "exp" && key != "jti" {
+		if !reservedClaims[key] {
 			additionalClaims[key] = value
 		}
 	}
 	sessionReq.AdditionalClaims = additionalClaims
-	
-	// Issue a new token
-	newSession, err := cs.IssueSession(ctx, sessionReq)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Revoke the old token
-	cs.RevokeSession(ctx, &RevocationRequest{Token: req.Token, Reason: "refreshed"})
-	
-	return newSession, nil
+
+	return cs.IssueSession(ctx, sessionReq)
+}
+
+// reservedClaims are set by IssueSession itself and never copied over
+var reservedClaims = map[string]bool{
+	"sub": true, "iat": true, "exp": true, "jti": true, "cred": true, "sid": true,
+	"amr": true, "acr": true, "auth_time": true,
 }
 
 // RevokeSession invalidates a session
@@ -220,10 +490,23 @@ func (cs *ChronosSession) RevokeSession(ctx context.Context, req *RevocationRequ
 	if err != nil {
 		return nil, err
 	}
+
+	// Signing out also ends the refresh token family, even if the access
+	// token itself has already expired
+	var userID, familyID string
+	if token, err := cs.keys.parse(req.Token, jwt.WithoutClaimsValidation()); err == nil {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			userID, _ = claims["sub"].(string)
+			if familyID, _ = claims["sid"].(string); familyID != "" {
+				if err := cs.revokeRefreshFamilies(ctx, []string{familyID}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
 	
-	// Emit audit event for session revocation
-	// TODO: Implement audit logging when ThemisLog is available
-	// ThemisLog.LogEvent("SessionRevoked", map[string]string{"reason": req.Reason})
+	auditor.Record("SESSION_REVOKED", cs.sessionRecordType, familyID, userID, themislog.SeverityInfo,
+		fmt.Sprintf("Session revoked: %s", req.Reason))
 	
 	return &RevocationResponse{
 		Revoked:   true,
@@ -232,26 +515,135 @@ func (cs *ChronosSession) RevokeSession(ctx context.Context, req *RevocationRequ
 	}, nil
 }
 
+// RevokeSessionsByCredential invalidates every session created with a
+// WebAuthn credential, e.g. after the user removes that authenticator
+func (cs *ChronosSession) RevokeSessionsByCredential(ctx context.Context, credentialID, reason string) (int, error) {
+	if credentialID == "" {
+		return 0, errors.New("credential ID is required")
+	}
+	return cs.revokeSessionsWhere(ctx, "", fmt.Sprintf("eq(credentialId, %q)", credentialID), reason)
+}
+
+// RevokeSessionsByUser invalidates every session of a user, e.g. after
+// account recovery
+func (cs *ChronosSession) RevokeSessionsByUser(ctx context.Context, userID, reason string) (int, error) {
+	if userID == "" {
+		return 0, errors.New("user ID is required")
+	}
+	return cs.revokeSessionsWhere(ctx, userVar(userID), "uid_in(user, uid(u))", reason)
+}
+
+// revokeSessionsWhere invalidates the valid sessions matching filter. vars
+// declares any query variables the filter uses.
+func (cs *ChronosSession) revokeSessionsWhere(ctx context.Context, vars, filter, reason string) (int, error) {
+	query := fmt.Sprintf(`
+		query {
+			%s
+			sessions(func: eq(valid, true)) @filter(type(%s) AND %s) {
+				uid
+				familyId
+			}
+		}
+	`, vars, cs.sessionRecordType, filter)
+
+	queryObj := dgraph.NewQuery(query)
+	resp, err := dgraph.ExecuteQuery("dgraph", queryObj)
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Sessions []struct {
+			UID      string `json:"uid"`
+			FamilyID string `json:"familyId"`
+		} `json:"sessions"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return 0, err
+		}
+	}
+
+	nquads := ""
+	var families []string
+	revokedUIDs := make(map[string]bool)
+	for _, session := range result.Sessions {
+		nquads += fmt.Sprintf("<%s> <valid> \"false\"^^<xs:boolean> .\n", session.UID)
+		revokedUIDs[session.UID] = true
+		if session.FamilyID != "" {
+			families = append(families, session.FamilyID)
+		}
+	}
+	revoked := len(result.Sessions)
+	if revoked == 0 {
+		return 0, nil
+	}
+
+	mu := dgraph.NewMutation().WithSetNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mu); err != nil {
+		return 0, err
+	}
+	validationCache.forget(func(s *storedSession) bool { return revokedUIDs[s.UID] })
+
+	// Their refresh tokens must not be able to start new sessions
+	if err := cs.revokeRefreshFamilies(ctx, families); err != nil {
+		return 0, err
+	}
+
+	auditor.Record("SESSIONS_REVOKED", cs.sessionRecordType, strings.Join(families, ","), "", themislog.SeverityInfo,
+		fmt.Sprintf("%d session(s) revoked: %s", revoked, reason))
+
+	return revoked, nil
+}
+
 // Helper methods for database operations
 
-// storeSession stores session information in Dgraph
-func (cs *ChronosSession) storeSession(_ context.Context, userID, token string, issuedAt, expiresAt time.Time, req *SessionRequest) error {
+// userVar declares the query variable u as the User a session belongs to.
+// Sessions are issued for the user's uid, or for their DID by older flows.
+func userVar(userID string) string {
+	if isUID(userID) {
+		return fmt.Sprintf("u as var(func: uid(%s))", userID)
+	}
+	return fmt.Sprintf("u as var(func: eq(did, %q)) @filter(type(User))", userID)
+}
+
+// isUID reports whether id is a Dgraph uid such as 0x1a
+func isUID(id string) bool {
+	if !strings.HasPrefix(id, "0x") {
+		return false
+	}
+	_, err := strconv.ParseUint(id[2:], 16, 64)
+	return err == nil
+}
+
+// storeSession stores session information in Dgraph as an AuthSession
+// linked to its User
+func (cs *ChronosSession) storeSession(_ context.Context, userID, token string, issuedAt, expiresAt time.Time, familyID string, limits sessionLimits, req *SessionRequest, refreshNquads string) error {
 	// Hash the token for storage
 	tokenHash := cs.hashToken(token)
-	
+
+	// Resolve the User in the same request
+	query := dgraph.NewQuery(fmt.Sprintf("query {\n\t\t%s\n\t}", userVar(userID)))
+
 	// Create session record in N-Quads format
 	nquads := fmt.Sprintf(`
 		_:session <dgraph.type> %q .
-		_:session <userID> %q .
+		_:session <user> uid(u) .
 		_:session <tokenHash> %q .
-		_:session <issuedAt> %q .
-		_:session <expiresAt> %q .
+		_:session <createdAt> "%s"^^<xs:dateTime> .
+		_:session <expiresAt> "%s"^^<xs:dateTime> .
 		_:session <valid> "true"^^<xs:boolean> .
-	`, cs.sessionRecordType, userID, tokenHash, issuedAt.Format(time.RFC3339), expiresAt.Format(time.RFC3339))
+		_:session <familyId> %q .
+		_:session <idleTimeout> "%d"^^<xs:int> .
+	`, cs.sessionRecordType, tokenHash, issuedAt.Format(time.RFC3339), expiresAt.Format(time.RFC3339), familyID, limits.IdleTimeout)
+	nquads += refreshNquads
+	if !limits.AbsoluteExpiresAt.IsZero() {
+		nquads += fmt.Sprintf(`_:session <absoluteExpiresAt> "%s"^^<xs:dateTime> .`, limits.AbsoluteExpiresAt.Format(time.RFC3339))
+	}
 	
 	// Add optional fields if present
 	if req.DeviceInfo != "" {
-		nquads += fmt.Sprintf(`_:session <deviceInfo> %q .`, req.DeviceInfo)
+		nquads += fmt.Sprintf(`_:session <deviceId> %q .`, req.DeviceInfo)
 	}
 	if req.IPAddress != "" {
 		nquads += fmt.Sprintf(`_:session <ipAddress> %q .`, req.IPAddress)
@@ -259,12 +651,21 @@ func (cs *ChronosSession) storeSession(_ context.Context, userID, token string,
 	if req.UserAgent != "" {
 		nquads += fmt.Sprintf(`_:session <userAgent> %q .`, req.UserAgent)
 	}
+	if req.CredentialID != "" {
+		nquads += fmt.Sprintf(`_:session <credentialId> %q .`, req.CredentialID)
+	}
+	if req.AuthMethod.AuthType != "" {
+		nquads += fmt.Sprintf(`_:session <method> %q .`, req.AuthMethod.AuthType)
+	}
+	if req.ClientType != "" {
+		nquads += fmt.Sprintf(`_:session <clientType> %q .`, req.ClientType)
+	}
 	
 	// Create mutation
 	mu := dgraph.NewMutation().WithSetNquads(nquads)
 	
-	// Execute mutation
-	_, err := dgraph.ExecuteMutations("dgraph", mu)
+	// Execute upsert
+	_, err := dgraph.ExecuteQuery("dgraph", query, mu)
 	return err
 }
 
@@ -275,139 +676,117 @@ func (cs *ChronosSession) hashToken(token string) string {
 	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
 }
 
-// isTokenValid checks if a token is still valid in the database
-func (cs *ChronosSession) isTokenValid(_ context.Context, token string) (bool, error) {
-	tokenHash := cs.hashToken(token)
-	
-	// DQL query to check if token exists and is valid
-	query := fmt.Sprintf(`
-		query {
-			sessions(func: type(%s)) @filter(eq(tokenHash, "%s")) {
-				uid
-				valid
-				expiresAt
-			}
-		}
-	`, cs.sessionRecordType, tokenHash)
-	
-	// Execute query
-	queryObj := dgraph.NewQuery(query)
-	resp, err := dgraph.ExecuteQuery("dgraph", queryObj)
-	if err != nil {
-		return false, err
-	}
-	
-	// Parse response
-	type Result struct {
-		Sessions []struct {
-			UID       string `json:"uid"`
-			Valid     bool   `json:"valid"`
-			ExpiresAt string `json:"expiresAt"`
-		} `json:"sessions"`
-	}
-	
-	var result Result
-	if resp.Json != "" {
-		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
-			return false, err
+// checkStoredSession checks a token's session record: it must exist, be
+// valid, unexpired and not idle for longer than its policy allows. It
+// returns the record and why the session can't be used, or "" if it can.
+// Records are cached briefly, so repeated checks rarely reach Dgraph.
+func (cs *ChronosSession) checkStoredSession(ctx context.Context, tokenHash, userID string) (*storedSession, string, error) {
+	now := time.Now()
+	session, lastSeen, cached := validationCache.get(tokenHash, now)
+	if !cached {
+		var err error
+		if session, err = cs.loadStoredSession(tokenHash); err != nil {
+			return nil, "", err
 		}
+		validationCache.put(tokenHash, session, now)
 	}
-	
+
 	// Check if we found the session and it's valid
-	if len(result.Sessions) == 0 {
-		return false, nil
+	if session == nil || !session.Valid {
+		return nil, "token has been revoked", nil
 	}
-	
-	session := result.Sessions[0]
-	
-	// Check if the session is marked as valid
-	if !session.Valid {
-		return false, nil
-	}
-	
+
 	// Check if the session has expired
-	expiresAt, err := time.Parse(time.RFC3339, session.ExpiresAt)
-	if err != nil {
-		return false, err
+	if now.After(session.ExpiresAt) {
+		return nil, "token has expired", nil
 	}
-	
-	if time.Now().After(expiresAt) {
-		return false, nil
+
+	// Check how long the session has been idle, counting uses this
+	// instance hasn't written yet
+	lastActive := session.lastActive()
+	if lastSeen.After(lastActive) {
+		lastActive = lastSeen
 	}
-	
-	return true, nil
+	if session.idle(lastActive, now) {
+		if err := cs.expireIdleSession(ctx, userID, session.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, ErrSessionIdle.Error(), nil
+	}
+
+	return session, "", nil
 }
 
-// updateLastUsed updates the lastUsed timestamp for a session
-func (cs *ChronosSession) updateLastUsed(_ context.Context, token string) error {
-	tokenHash := cs.hashToken(token)
-	now := time.Now()
-	
-	// First, get the UID of the session
-	query := fmt.Sprintf(`
-		query {
-			sessions(func: type(%s)) @filter(eq(tokenHash, "%s")) {
-				uid
-			}
+// loadStoredSession reads a token's session record, or nil if there is none
+func (cs *ChronosSession) loadStoredSession(tokenHash string) (*storedSession, error) {
+	// Indexed lookup on tokenHash
+	query := dgraph.NewQuery(fmt.Sprintf(`query session($tokenHash: string) {
+		sessions(func: eq(tokenHash, $tokenHash)) @filter(type(%s)) {
+			uid
+			valid
+			expiresAt
+			familyId
+			createdAt
+			lastUsedAt
+			idleTimeout
 		}
-	`, cs.sessionRecordType, tokenHash)
-	
-	// Execute query
-	queryObj := dgraph.NewQuery(query)
-	resp, err := dgraph.ExecuteQuery("dgraph", queryObj)
+	}`, cs.sessionRecordType)).WithVariable("$tokenHash", tokenHash)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
 	// Parse response
-	type Result struct {
-		Sessions []struct {
-			UID string `json:"uid"`
-		} `json:"sessions"`
+	var result struct {
+		Sessions []storedSession `json:"sessions"`
 	}
-	
-	var result Result
 	if resp.Json != "" {
 		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	
 	if len(result.Sessions) == 0 {
-		return errors.New("session not found")
+		return nil, nil
 	}
-	
-	uid := result.Sessions[0].UID
-	
-	// Update lastUsed timestamp
-	nquads := fmt.Sprintf(`
-		<%s> <lastUsed> %q .
-	`, uid, now.Format(time.RFC3339))
-	
-	// Create mutation
-	mu := dgraph.NewMutation().WithSetNquads(nquads)
-	
-	// Execute mutation
-	_, err = dgraph.ExecuteMutations("dgraph", mu)
+	return &result.Sessions[0], nil
+}
+
+// expireIdleSession ends a session family that went idle for too long
+func (cs *ChronosSession) expireIdleSession(ctx context.Context, userID, familyID string) error {
+	if err := cs.revokeRefreshFamilies(ctx, []string{familyID}); err != nil {
+		return err
+	}
+	auditor.Record("SESSION_IDLE_TIMEOUT", cs.sessionRecordType, familyID, userID, themislog.SeverityInfo,
+		"Session signed out after exceeding its idle timeout")
+	return nil
+}
+
+// updateLastUsed updates the lastUsedAt timestamp for a session
+func (cs *ChronosSession) updateLastUsed(_ context.Context, sessionUID string) error {
+	mu := dgraph.NewMutation().
+		WithSetNquads(fmt.Sprintf(`<%s> <lastUsedAt> "%s"^^<xs:dateTime> .`, sessionUID, time.Now().Format(time.RFC3339)))
+
+	_, err := dgraph.ExecuteMutations("dgraph", mu)
 	return err
 }
 
 // invalidateToken marks a token as invalid in the database
 func (cs *ChronosSession) invalidateToken(_ context.Context, token string) error {
-	tokenHash := cs.hashToken(token)
-	
-	// First, get the UID of the session
-	query := fmt.Sprintf(`
-		query {
-			sessions(func: type(%s)) @filter(eq(tokenHash, "%s")) {
-				uid
-			}
+	validationCache.forgetToken(cs.hashToken(token))
+
+	// The query half reports whether the session exists
+	query := dgraph.NewQuery(fmt.Sprintf(`query session($tokenHash: string) {
+		sessions(func: eq(tokenHash, $tokenHash)) @filter(type(%s)) {
+			s as uid
 		}
-	`, cs.sessionRecordType, tokenHash)
-	
-	// Execute query
-	queryObj := dgraph.NewQuery(query)
-	resp, err := dgraph.ExecuteQuery("dgraph", queryObj)
+	}`, cs.sessionRecordType)).WithVariable("$tokenHash", cs.hashToken(token))
+
+	mu := dgraph.NewMutation().
+		WithCondition("@if(eq(len(s), 1))").
+		WithSetNquads(`uid(s) <valid> "false"^^<xs:boolean> .`)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mu)
 	if err != nil {
 		return err
 	}
@@ -429,18 +808,5 @@ func (cs *ChronosSession) invalidateToken(_ context.Context, token string) error
 	if len(result.Sessions) == 0 {
 		return errors.New("session not found")
 	}
-	
-	uid := result.Sessions[0].UID
-	
-	// Mark session as invalid
-	nquads := fmt.Sprintf(`
-		<%s> <valid> "false"^^<xs:boolean> .
-	`, uid)
-	
-	// Create mutation
-	mu := dgraph.NewMutation().WithSetNquads(nquads)
-	
-	// Execute mutation
-	_, err = dgraph.ExecuteMutations("dgraph", mu)
-	return err
+	return nil
 }
@@ -0,0 +1,169 @@
+package ChronosSession
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AMR_VALUES are the authentication method references (RFC 8176) carried in
+// the amr claim
+const (
+	AMR_MCA  = "mca"  // code or link sent to a registered email/phone channel
+	AMR_OTP  = "otp"  // authenticator app code (TOTP)
+	AMR_SWK  = "swk"  // WebAuthn key that may be synced (passkey)
+	AMR_HWK  = "hwk"  // WebAuthn key attested as hardware-bound
+	AMR_USER = "user" // authenticator only tested user presence
+	AMR_MFA  = "mfa"  // authenticator verified the user (WebAuthn UV)
+	AMR_PWD  = "pwd"
+)
+
+// Authenticator assurance levels (NIST SP 800-63B). Every session is at
+// least AAL1.
+const (
+	AAL1 = 1
+	AAL2 = 2
+	AAL3 = 3
+)
+
+// Sensitive actions such as grading or PII export need AAL2 from an
+// authentication within the last 10 minutes
+const (
+	SensitiveActionAAL    = AAL2
+	SensitiveActionMaxAge = 10 * time.Minute
+)
+
+// AuthMethod describes one successful authentication
+type AuthMethod struct {
+	AuthType      string // one of the SESSION_TYPE_* values
+	UserVerified  bool   // WebAuthn only: UV flag was set
+	HardwareBound bool   // WebAuthn only: key attested as non-syncable hardware
+}
+
+// Assurance is what a session says about how its user authenticated
+type Assurance struct {
+	AMR      []string  `json:"amr"`
+	AAL      int       `json:"aal"`
+	AuthTime time.Time `json:"authTime"` // last (step-up) authentication
+}
+
+// methodReferences maps an authentication to its amr values
+func methodReferences(m AuthMethod) []string {
+	switch m.AuthType {
+	case SESSION_TYPE_OTP, SESSION_TYPE_MAGIC_LINK, SESSION_TYPE_RECOVERY:
+		return []string{AMR_MCA}
+	case SESSION_TYPE_TOTP:
+		return []string{AMR_OTP}
+	case SESSION_TYPE_PASSWORD:
+		return []string{AMR_PWD}
+	case SESSION_TYPE_WEBAUTHN:
+		key := AMR_SWK
+		if m.HardwareBound {
+			key = AMR_HWK
+		}
+		if m.UserVerified {
+			return []string{key, AMR_MFA}
+		}
+		return []string{key, AMR_USER}
+	}
+	return nil
+}
+
+// assuranceLevel derives the AAL from amr values. A user-verified WebAuthn
+// key is multi-factor on its own (AAL3 when hardware-bound); otherwise two
+// different authenticator kinds are needed for AAL2.
+func assuranceLevel(amr []string) int {
+	has := make(map[string]bool, len(amr))
+	for _, v := range amr {
+		has[v] = true
+	}
+
+	if has[AMR_MFA] && has[AMR_HWK] {
+		return AAL3
+	}
+	if has[AMR_MFA] {
+		return AAL2
+	}
+
+	factors := 0
+	for _, kind := range []string{AMR_MCA, AMR_OTP, AMR_PWD, AMR_SWK, AMR_HWK} {
+		if has[kind] {
+			factors++
+		}
+	}
+	if factors >= 2 {
+		return AAL2
+	}
+	return AAL1
+}
+
+// mergeAMR adds the values of b that are not already in a
+func mergeAMR(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, v := range b {
+		found := false
+		for _, existing := range merged {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// newAssurance builds the assurance for an authentication made at authTime
+func newAssurance(amr []string, authTime time.Time) Assurance {
+	return Assurance{AMR: amr, AAL: assuranceLevel(amr), AuthTime: authTime}
+}
+
+// acrValue renders an AAL as the acr claim
+func acrValue(aal int) string {
+	return fmt.Sprintf("aal%d", aal)
+}
+
+// setClaims writes the amr, acr and auth_time claims
+func (a Assurance) setClaims(claims jwt.MapClaims) {
+	if len(a.AMR) > 0 {
+		claims["amr"] = a.AMR
+	}
+	claims["acr"] = acrValue(a.AAL)
+	claims["auth_time"] = a.AuthTime.Unix()
+}
+
+// assuranceFromClaims reads the assurance back out of a parsed token. Tokens
+// issued before these claims existed count as AAL1 authenticated at iat.
+func assuranceFromClaims(claims jwt.MapClaims) Assurance {
+	var amr []string
+	if values, ok := claims["amr"].([]interface{}); ok {
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				amr = append(amr, s)
+			}
+		}
+	}
+
+	authTime, ok := claims["auth_time"].(float64)
+	if !ok {
+		authTime, _ = claims["iat"].(float64)
+	}
+
+	// acr is for relying parties; the level is always recomputed from amr
+	return newAssurance(amr, time.Unix(int64(authTime), 0))
+}
+
+// Satisfies reports whether the session reached required and, when maxAge is
+// set, authenticated within maxAge of now
+func (a Assurance) Satisfies(required int, maxAge time.Duration, now time.Time) bool {
+	if a.AAL < required {
+		return false
+	}
+	if maxAge > 0 && now.Sub(a.AuthTime) > maxAge {
+		return false
+	}
+	return true
+}
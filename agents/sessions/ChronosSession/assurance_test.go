@@ -0,0 +1,97 @@
+package ChronosSession
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAssuranceLevelByMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		method AuthMethod
+		want   int
+	}{
+		{"email otp", AuthMethod{AuthType: SESSION_TYPE_OTP}, AAL1},
+		{"magic link", AuthMethod{AuthType: SESSION_TYPE_MAGIC_LINK}, AAL1},
+		{"totp", AuthMethod{AuthType: SESSION_TYPE_TOTP}, AAL1},
+		{"passkey without uv", AuthMethod{AuthType: SESSION_TYPE_WEBAUTHN}, AAL1},
+		{"passkey with uv", AuthMethod{AuthType: SESSION_TYPE_WEBAUTHN, UserVerified: true}, AAL2},
+		{"hardware key with uv", AuthMethod{AuthType: SESSION_TYPE_WEBAUTHN, UserVerified: true, HardwareBound: true}, AAL3},
+	}
+
+	for _, tt := range tests {
+		if got := assuranceLevel(methodReferences(tt.method)); got != tt.want {
+			t.Errorf("%s: expected AAL%d, got AAL%d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestStepUpCombinesFactors(t *testing.T) {
+	amr := mergeAMR(methodReferences(AuthMethod{AuthType: SESSION_TYPE_OTP}), methodReferences(AuthMethod{AuthType: SESSION_TYPE_TOTP}))
+	if got := assuranceLevel(amr); got != AAL2 {
+		t.Errorf("Expected email OTP plus TOTP to be AAL2, got AAL%d", got)
+	}
+
+	// Repeating the same factor does not raise the level
+	amr = mergeAMR(methodReferences(AuthMethod{AuthType: SESSION_TYPE_OTP}), methodReferences(AuthMethod{AuthType: SESSION_TYPE_MAGIC_LINK}))
+	if len(amr) != 1 || assuranceLevel(amr) != AAL1 {
+		t.Errorf("Expected two emailed codes to stay AAL1, got %v", amr)
+	}
+}
+
+func TestAssuranceSatisfies(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := Assurance{AAL: AAL2, AuthTime: now.Add(-5 * time.Minute)}
+
+	if !a.Satisfies(SensitiveActionAAL, SensitiveActionMaxAge, now) {
+		t.Error("Expected recent AAL2 session to satisfy the sensitive-action policy")
+	}
+	if a.Satisfies(AAL3, 0, now) {
+		t.Error("Expected AAL2 session not to satisfy AAL3")
+	}
+	if a.Satisfies(SensitiveActionAAL, SensitiveActionMaxAge, now.Add(10*time.Minute)) {
+		t.Error("Expected stale authentication not to satisfy the sensitive-action policy")
+	}
+	if !a.Satisfies(AAL1, 0, now.Add(24*time.Hour)) {
+		t.Error("Expected no freshness requirement when maxAge is zero")
+	}
+}
+
+func TestAssuranceClaimsRoundTrip(t *testing.T) {
+	ring := testKeyRing(t, testKeyConfig(t, "k1", ALG_ES256, KEY_STATUS_ACTIVE))
+	authTime := time.Unix(1735732800, 0)
+	original := newAssurance([]string{AMR_SWK, AMR_MFA}, authTime)
+
+	claims := jwt.MapClaims{"sub": "0x1"}
+	original.setClaims(claims)
+	signed, err := ring.sign(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	token, err := ring.parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	parsed := token.Claims.(jwt.MapClaims)
+
+	if parsed["acr"] != "aal2" {
+		t.Errorf("Expected acr aal2, got %v", parsed["acr"])
+	}
+	got := assuranceFromClaims(parsed)
+	if got.AAL != AAL2 || len(got.AMR) != 2 || !got.AuthTime.Equal(authTime) {
+		t.Errorf("Unexpected assurance after round trip: %+v", got)
+	}
+}
+
+func TestAssuranceFromLegacyClaims(t *testing.T) {
+	got := assuranceFromClaims(jwt.MapClaims{"sub": "0x1", "iat": float64(1735732800), "acr": "aal3"})
+	if got.AAL != AAL1 {
+		t.Errorf("Expected token without amr to count as AAL1, got AAL%d", got.AAL)
+	}
+	if got.AuthTime.Unix() != 1735732800 {
+		t.Errorf("Expected auth_time to fall back to iat, got %v", got.AuthTime)
+	}
+}
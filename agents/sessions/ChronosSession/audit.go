@@ -0,0 +1,6 @@
+package ChronosSession
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes session events to the audit trail
+var auditor = themislog.Auditor{Source: "ChronosSession", Category: themislog.CategoryAuthentication}
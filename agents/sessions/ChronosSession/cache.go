@@ -0,0 +1,155 @@
+package ChronosSession
+
+import (
+	"sync"
+	"time"
+)
+
+// Validation cache settings. Each Modus instance keeps its own cache in
+// memory and nothing tells it about revocations made by other instances: a
+// session revoked elsewhere is still accepted here until its entry is
+// sessionCacheTTL old. Revocations made through this instance take effect
+// at once.
+const (
+	sessionCacheTTL       = 30 * time.Second
+	lastUsedWriteInterval = time.Minute
+	sessionCacheSize      = 10000
+)
+
+// storedSession is the part of an AuthSession record validation needs
+type storedSession struct {
+	clientInfo
+	UID       string    `json:"uid"`
+	Valid     bool      `json:"valid"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	FamilyID  string    `json:"familyId"`
+}
+
+// sessionCacheEntry is a cached session record, or a cached miss when
+// session is nil
+type sessionCacheEntry struct {
+	session     *storedSession
+	fetchedAt   time.Time
+	lastSeen    time.Time // last validation through this instance
+	lastWritten time.Time // last lastUsedAt write
+}
+
+// sessionCache keeps recently read session records by token hash, so a
+// burst of requests with one token costs one Dgraph read, and coalesces
+// lastUsedAt writes to one per session per interval. A zero ttl disables it.
+type sessionCache struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	writeInterval time.Duration
+	maxEntries    int
+	entries       map[string]*sessionCacheEntry
+}
+
+// validationCache is shared by every ChronosSession of this instance
+var validationCache = newSessionCache(sessionCacheTTL, lastUsedWriteInterval, sessionCacheSize)
+
+func newSessionCache(ttl, writeInterval time.Duration, maxEntries int) *sessionCache {
+	return &sessionCache{
+		ttl:           ttl,
+		writeInterval: writeInterval,
+		maxEntries:    maxEntries,
+		entries:       make(map[string]*sessionCacheEntry),
+	}
+}
+
+// get returns the cached record for a token hash, and when the session was
+// last validated here, if the entry is fresh. A fresh entry with a nil
+// record is a cached miss.
+func (c *sessionCache) get(tokenHash string, now time.Time) (*storedSession, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[tokenHash]
+	if !ok || now.Sub(entry.fetchedAt) >= c.ttl {
+		return nil, time.Time{}, false
+	}
+	return entry.session, entry.lastSeen, true
+}
+
+// put caches a record read from Dgraph, keeping what this instance already
+// knows about the session's activity
+func (c *sessionCache) put(tokenHash string, session *storedSession, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[tokenHash]; ok {
+		entry.session = session
+		entry.fetchedAt = now
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[tokenHash] = &sessionCacheEntry{session: session, fetchedAt: now}
+}
+
+// touch records a validation and reports whether lastUsedAt is due to be
+// written
+func (c *sessionCache) touch(tokenHash string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[tokenHash]
+	if !ok {
+		return true
+	}
+	entry.lastSeen = now
+	if now.Sub(entry.lastWritten) < c.writeInterval {
+		return false
+	}
+	entry.lastWritten = now
+	return true
+}
+
+// forget drops the cached records matching a predicate, e.g. after a
+// revocation
+func (c *sessionCache) forget(match func(*storedSession) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hash, entry := range c.entries {
+		if entry.session != nil && match(entry.session) {
+			delete(c.entries, hash)
+		}
+	}
+}
+
+// forgetToken drops the cached record of one token
+func (c *sessionCache) forgetToken(tokenHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, tokenHash)
+}
+
+// forgetFamilies drops the cached records of the given token families
+func (c *sessionCache) forgetFamilies(familyIDs []string) {
+	if len(familyIDs) == 0 {
+		return
+	}
+	families := make(map[string]bool, len(familyIDs))
+	for _, id := range familyIDs {
+		families[id] = true
+	}
+	c.forget(func(s *storedSession) bool { return families[s.FamilyID] })
+}
+
+// evict makes room by dropping stale entries, or everything if none are
+// stale. Callers hold the lock.
+func (c *sessionCache) evict(now time.Time) {
+	for hash, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) >= c.ttl {
+			delete(c.entries, hash)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.entries = make(map[string]*sessionCacheEntry)
+	}
+}
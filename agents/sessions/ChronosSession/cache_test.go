@@ -0,0 +1,188 @@
+package ChronosSession
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// withValidationCache swaps in a cache for the duration of a test
+func withValidationCache(tb testing.TB, cache *sessionCache) {
+	tb.Helper()
+	previous := validationCache
+	validationCache = cache
+	tb.Cleanup(func() { validationCache = previous })
+}
+
+// cacheValidSession caches a valid record for a token, as if just read
+func cacheValidSession(chronos *ChronosSession, token, familyID string) {
+	validationCache.put(chronos.hashToken(token), &storedSession{
+		UID:       "0x10",
+		Valid:     true,
+		ExpiresAt: time.Now().Add(time.Hour),
+		FamilyID:  familyID,
+		clientInfo: clientInfo{
+			CreatedAt:     time.Now().Format(time.RFC3339),
+			sessionLimits: sessionLimits{IdleTimeout: 1800},
+		},
+	}, time.Now())
+}
+
+func TestSessionCacheExpiresEntries(t *testing.T) {
+	cache := newSessionCache(30*time.Second, time.Minute, 10)
+	now := time.Now()
+	cache.put("hash", &storedSession{UID: "0x1"}, now)
+
+	if session, _, ok := cache.get("hash", now.Add(10*time.Second)); !ok || session.UID != "0x1" {
+		t.Error("Expected a fresh entry to be served from the cache")
+	}
+	if _, _, ok := cache.get("hash", now.Add(31*time.Second)); ok {
+		t.Error("Expected a stale entry to be read again")
+	}
+}
+
+func TestSessionCacheCoalescesLastUsedWrites(t *testing.T) {
+	cache := newSessionCache(30*time.Second, time.Minute, 10)
+	now := time.Now()
+	cache.put("hash", &storedSession{UID: "0x1"}, now)
+
+	if !cache.touch("hash", now) {
+		t.Error("Expected the first use to be written")
+	}
+	if cache.touch("hash", now.Add(30*time.Second)) {
+		t.Error("Expected a use within the interval not to be written")
+	}
+	if !cache.touch("hash", now.Add(61*time.Second)) {
+		t.Error("Expected a use after the interval to be written")
+	}
+}
+
+func TestSessionCacheEvictsWhenFull(t *testing.T) {
+	cache := newSessionCache(30*time.Second, time.Minute, 2)
+	now := time.Now()
+	cache.put("a", &storedSession{}, now)
+	cache.put("b", &storedSession{}, now.Add(40*time.Second))
+	cache.put("c", &storedSession{}, now.Add(40*time.Second))
+
+	if len(cache.entries) != 2 {
+		t.Fatalf("Expected the stale entry to make room, got %d entries", len(cache.entries))
+	}
+	if _, ok := cache.entries["a"]; ok {
+		t.Error("Expected the stale entry to be evicted")
+	}
+}
+
+func TestRevocationReachesOtherInstancesWithinTTL(t *testing.T) {
+	// Two Modus instances, each with the record in its own cache
+	here := newSessionCache(sessionCacheTTL, lastUsedWriteInterval, 10)
+	elsewhere := newSessionCache(sessionCacheTTL, lastUsedWriteInterval, 10)
+	now := time.Now()
+	for _, cache := range []*sessionCache{here, elsewhere} {
+		cache.put("hash", &storedSession{UID: "0x1", Valid: true, FamilyID: "fam_1"}, now)
+	}
+
+	// Revoking here clears only this instance's copy
+	here.forgetFamilies([]string{"fam_1"})
+	if _, _, ok := here.get("hash", now); ok {
+		t.Error("Expected the revoking instance to read the record again")
+	}
+	if session, _, ok := elsewhere.get("hash", now.Add(sessionCacheTTL-time.Second)); !ok || !session.Valid {
+		t.Error("Expected another instance to keep its copy until the TTL")
+	}
+	if _, _, ok := elsewhere.get("hash", now.Add(sessionCacheTTL)); ok {
+		t.Error("Expected another instance to read the record again once the TTL is up")
+	}
+}
+
+func TestValidateSessionServesRepeatsFromCache(t *testing.T) {
+	withValidationCache(t, newSessionCache(30*time.Second, time.Minute, 100))
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	cacheValidSession(chronos, resp.Token, resp.SessionID)
+
+	before := dgraph.DgraphQueryCallStack.Size()
+	for i := 0; i < 5; i++ {
+		validation, err := chronos.ValidateSession(context.Background(), &ValidationRequest{Token: resp.Token})
+		if err != nil || !validation.Valid {
+			t.Fatalf("Expected the cached session to validate, got %v %v", validation, err)
+		}
+	}
+
+	// Only the first use writes lastUsedAt; nothing is read
+	if calls := dgraph.DgraphQueryCallStack.Size() - before; calls != 1 {
+		t.Errorf("Expected 1 Dgraph call for 5 validations, got %d", calls)
+	}
+}
+
+func TestRevokeSessionClearsCachedRecord(t *testing.T) {
+	withValidationCache(t, newSessionCache(30*time.Second, time.Minute, 100))
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	cacheValidSession(chronos, resp.Token, resp.SessionID)
+
+	// The test double can't find the record, but the cache must still drop it
+	chronos.RevokeSession(context.Background(), &RevocationRequest{Token: resp.Token})
+
+	validation, _ := chronos.ValidateSession(context.Background(), &ValidationRequest{Token: resp.Token})
+	if validation.Valid {
+		t.Error("Expected a revoked session to fail validation at once")
+	}
+}
+
+func TestValidateSessionExpiresIdleSessionSeenInCache(t *testing.T) {
+	withValidationCache(t, newSessionCache(30*time.Second, time.Minute, 100))
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	validationCache.put(chronos.hashToken(resp.Token), &storedSession{
+		UID:       "0x10",
+		Valid:     true,
+		ExpiresAt: time.Now().Add(time.Hour),
+		FamilyID:  resp.SessionID,
+		clientInfo: clientInfo{
+			CreatedAt:     time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+			sessionLimits: sessionLimits{IdleTimeout: 1800},
+		},
+	}, time.Now())
+
+	validation, _ := chronos.ValidateSession(context.Background(), &ValidationRequest{Token: resp.Token})
+	if validation.Valid || validation.Message != ErrSessionIdle.Error() {
+		t.Errorf("Expected an idle session to be rejected, got %+v", validation)
+	}
+}
+
+// BenchmarkValidateSessionCached serves repeated validations of one token
+// from the cache, writing lastUsedAt once per interval. The test double
+// holds no session records, so there is no uncached run to compare with:
+// it would only measure the not-found path.
+func BenchmarkValidateSessionCached(b *testing.B) {
+	withValidationCache(b, newSessionCache(sessionCacheTTL, lastUsedWriteInterval, sessionCacheSize))
+	chronos := testChronosSession(b)
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		b.Fatalf("IssueSession failed: %v", err)
+	}
+	cacheValidSession(chronos, resp.Token, resp.SessionID)
+
+	before := dgraph.DgraphQueryCallStack.Size()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chronos.ValidateSession(context.Background(), &ValidationRequest{Token: resp.Token})
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(dgraph.DgraphQueryCallStack.Size()-before)/float64(b.N), "dgraph-calls/op")
+}
@@ -0,0 +1,94 @@
+package ChronosSession
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestIssueIDTokenSetsOIDCClaims(t *testing.T) {
+	chronos := testChronosSession(t)
+	authTime := time.Now().Add(-time.Minute)
+
+	idToken, err := chronos.IssueIDToken(&IDTokenRequest{
+		Issuer:      "https://do-study.hypermode.host",
+		Subject:     "0x1",
+		Audience:    "client-1",
+		Nonce:       "n-0S6_WzA2Mj",
+		AccessToken: "access-token",
+		Assurance:   newAssurance([]string{AMR_HWK, AMR_MFA}, authTime),
+		TTL:         10 * time.Minute,
+		Claims:      map[string]interface{}{"email": "ada@example.com", "sub": "0x2"},
+	})
+	if err != nil {
+		t.Fatalf("IssueIDToken failed: %v", err)
+	}
+
+	token, err := chronos.keys.parse(idToken, jwt.WithAudience("client-1"), jwt.WithIssuer("https://do-study.hypermode.host"))
+	if err != nil {
+		t.Fatalf("Expected the ID token to verify, got %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	sum := sha256.Sum256([]byte("access-token"))
+	if claims["at_hash"] != base64.RawURLEncoding.EncodeToString(sum[:16]) {
+		t.Errorf("Expected at_hash of the access token, got %v", claims["at_hash"])
+	}
+	if claims["sub"] != "0x1" || claims["nonce"] != "n-0S6_WzA2Mj" || claims["email"] != "ada@example.com" {
+		t.Errorf("Expected standard claims to win over extra claims, got %v", claims)
+	}
+	if claims["acr"] != acrValue(AAL3) || int64(claims["auth_time"].(float64)) != authTime.Unix() {
+		t.Errorf("Expected the sign-in's assurance, got acr %v auth_time %v", claims["acr"], claims["auth_time"])
+	}
+}
+
+func TestIssueIDTokenRequiresAudience(t *testing.T) {
+	chronos := testChronosSession(t)
+	if _, err := chronos.IssueIDToken(&IDTokenRequest{Issuer: "https://do-study.hypermode.host", Subject: "0x1"}); err == nil {
+		t.Error("Expected an ID token without audience to be refused")
+	}
+}
+
+func TestValidateSessionChecksClientBinding(t *testing.T) {
+	chronos := testChronosSession(t)
+	ctx := context.Background()
+
+	clientToken, err := chronos.IssueSession(ctx, &SessionRequest{
+		UserID:           "0x1",
+		AuthMethod:       AuthMethod{AuthType: SESSION_TYPE_OAUTH},
+		AdditionalClaims: map[string]interface{}{"client_id": "client-1", "scope": "openid email"},
+	})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	firstParty, err := chronos.IssueSession(ctx, &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		clientID string
+		want     string
+	}{
+		{"client token as first-party session", clientToken.Token, "", "token was issued to an OAuth client"},
+		{"client token at another client", clientToken.Token, "client-2", "token was issued to another client"},
+		{"first-party session at a client", firstParty.Token, "client-1", "token was not issued to an OAuth client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := chronos.ValidateSession(ctx, &ValidationRequest{Token: tt.token, ClientID: tt.clientID})
+			if err != nil {
+				t.Fatalf("ValidateSession failed: %v", err)
+			}
+			if resp.Valid || resp.Message != tt.want {
+				t.Errorf("Expected %q, got valid=%t %q", tt.want, resp.Valid, resp.Message)
+			}
+		})
+	}
+}
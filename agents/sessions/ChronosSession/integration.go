@@ -3,19 +3,22 @@ package ChronosSession
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 // Integration uses SESSION_TYPE constants from types.go
 
 // AuthResult represents the result of authentication from various agents
 type AuthResult struct {
-	UserID        string
-	ChannelDID    string
-	AuthType      string
+	UserID         string
+	ChannelDID     string
+	AuthType       string
 	AdditionalInfo map[string]interface{}
-	IPAddress     string
-	UserAgent     string
-	DeviceInfo    string
+	IPAddress      string
+	UserAgent      string
+	DeviceInfo     string
+	UserVerified   bool // WebAuthn: authenticator verified the user
+	HardwareBound  bool // WebAuthn: attested, non-syncable key
 }
 
 // CreateSessionFromAuth creates a session from successful authentication
@@ -36,6 +39,11 @@ func CreateSessionFromAuth(ctx context.Context, authResult *AuthResult) (*Sessio
 		DeviceInfo: authResult.DeviceInfo,
 		IPAddress:  authResult.IPAddress,
 		UserAgent:  authResult.UserAgent,
+		AuthMethod: AuthMethod{
+			AuthType:      authResult.AuthType,
+			UserVerified:  authResult.UserVerified,
+			HardwareBound: authResult.HardwareBound,
+		},
 	}
 
 	// Add any additional info to claims
@@ -66,8 +74,8 @@ func ValidateSessionToken(ctx context.Context, token string) (*ValidationRespons
 	return chronos.ValidateSession(ctx, req)
 }
 
-// RefreshSessionToken refreshes a session token if it's eligible
-func RefreshSessionToken(ctx context.Context, token string) (*SessionResponse, error) {
+// RefreshSessionToken exchanges a refresh token for a new token pair
+func RefreshSessionToken(ctx context.Context, refreshToken string) (*SessionResponse, error) {
 	// Initialize ChronosSession
 	chronos, err := Initialize()
 	if err != nil {
@@ -76,13 +84,69 @@ func RefreshSessionToken(ctx context.Context, token string) (*SessionResponse, e
 
 	// Create refresh request
 	req := &RefreshRequest{
-		Token: token,
+		RefreshToken: refreshToken,
 	}
 
 	// Refresh the session
 	return chronos.RefreshSession(ctx, req)
 }
 
+// CheckSessionAssurance checks a token against a required AAL and, when
+// maxAge is non-zero, the age of its last authentication
+func CheckSessionAssurance(ctx context.Context, token string, requiredAAL int, maxAge time.Duration) (*AssuranceResponse, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.CheckAssurance(ctx, &AssuranceRequest{
+		Token:       token,
+		RequiredAAL: requiredAAL,
+		MaxAge:      maxAge,
+	})
+}
+
+// RequireSensitiveAction checks a token meets the sensitive-action policy
+// (AAL2, authenticated within the last 10 minutes)
+func RequireSensitiveAction(ctx context.Context, token string) (*AssuranceResponse, error) {
+	return CheckSessionAssurance(ctx, token, SensitiveActionAAL, SensitiveActionMaxAge)
+}
+
+// StepUpSessionToken reissues a token after the user passed a fresh
+// authentication with method
+func StepUpSessionToken(ctx context.Context, token string, method AuthMethod) (*SessionResponse, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.StepUpSession(ctx, &StepUpRequest{Token: token, Method: method})
+}
+
+// RevokeCredentialSessions ends all sessions created with a WebAuthn credential
+func RevokeCredentialSessions(ctx context.Context, credentialID string, reason string) (int, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.RevokeSessionsByCredential(ctx, credentialID, reason)
+}
+
+// RevokeUserSessions ends all sessions of a user
+func RevokeUserSessions(ctx context.Context, userID string, reason string) (int, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.RevokeSessionsByUser(ctx, userID, reason)
+}
+
 // RevokeSessionToken revokes/invalidates a session token
 func RevokeSessionToken(ctx context.Context, token string, reason string) (*RevocationResponse, error) {
 	// Initialize ChronosSession
@@ -100,3 +164,74 @@ func RevokeSessionToken(ctx context.Context, token string, reason string) (*Revo
 	// Revoke the session
 	return chronos.RevokeSession(ctx, req)
 }
+
+// GetJWKS returns the public keys other services use to verify session
+// tokens offline
+func GetJWKS() (*JSONWebKeySet, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	jwks := chronos.JWKS()
+	return &jwks, nil
+}
+
+// SignStatement signs claims about a document with the active session key,
+// for checking offline against GetJWKS
+func SignStatement(issuer string, claims map[string]interface{}) (string, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.SignStatement(issuer, claims)
+}
+
+// ListUserSessions lists a user's signed-in sessions; the one currentToken
+// belongs to is marked current
+func ListUserSessions(ctx context.Context, userID, currentToken string) ([]SessionInfo, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.ListSessions(ctx, userID, currentToken)
+}
+
+// RevokeUserSession signs out one of a user's sessions by session ID
+func RevokeUserSession(ctx context.Context, userID, sessionID, reason string) (bool, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.RevokeSessionByID(ctx, userID, sessionID, reason)
+}
+
+// RevokeOtherUserSessions signs out everywhere except the token's session
+func RevokeOtherUserSessions(ctx context.Context, token, reason string) (int, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.RevokeOtherSessions(ctx, token, reason)
+}
+
+// MigrateSessions converts sessions stored before they followed the
+// AuthSession schema
+func MigrateSessions(ctx context.Context, performedBy string) (*SessionMigrationResult, error) {
+	// Initialize ChronosSession
+	chronos, err := Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ChronosSession: %w", err)
+	}
+
+	return chronos.MigrateSessionRecords(ctx, performedBy)
+}
@@ -0,0 +1,332 @@
+package ChronosSession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// clientInfo is what a session records about the device that signed in.
+// It is carried over when the session is refreshed or stepped up.
+type clientInfo struct {
+	Method     string `json:"method"`
+	DeviceInfo string `json:"deviceId"`
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	ClientType string `json:"clientType"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsed   string `json:"lastUsedAt"`
+	sessionLimits
+}
+
+// lastActive is when the session was last used, or else issued
+func (c clientInfo) lastActive() time.Time {
+	lastActive, _ := time.Parse(time.RFC3339, c.CreatedAt)
+	if lastUsed, err := time.Parse(time.RFC3339, c.LastUsed); err == nil && lastUsed.After(lastActive) {
+		lastActive = lastUsed
+	}
+	return lastActive
+}
+
+// liveFamily is the newest refresh token of a session, with its session
+type liveFamily struct {
+	FamilyID  string      `json:"familyId"`
+	Claims    string      `json:"claims"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	Session   *clientInfo `json:"session"`
+}
+
+// NewSessionInfo describes a session from the client details it recorded
+func NewSessionInfo(sessionID, method, userAgent, ipAddress string, authenticatedAt time.Time) SessionInfo {
+	client := parseUserAgent(userAgent)
+	info := SessionInfo{
+		SessionID:       sessionID,
+		Method:          method,
+		Browser:         client.Browser,
+		OS:              client.OS,
+		Device:          client.Device,
+		IPAddress:       ipAddress,
+		AuthenticatedAt: authenticatedAt,
+	}
+	if ipAddress != "" {
+		info.Location = lookupLocations([]string{ipAddress})[ipAddress]
+	}
+	return info
+}
+
+// ListSessions lists a user's signed-in sessions, newest sign-in first.
+// currentToken, if set, marks the session it belongs to as current.
+func (cs *ChronosSession) ListSessions(ctx context.Context, userID, currentToken string) ([]SessionInfo, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+
+	families, err := cs.liveFamilies(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currentID := cs.sessionIDOf(currentToken)
+
+	var ips []string
+	for _, family := range families {
+		if family.Session != nil && family.Session.IPAddress != "" {
+			ips = append(ips, family.Session.IPAddress)
+		}
+	}
+	locations := lookupLocations(ips)
+
+	sessions := make([]SessionInfo, 0, len(families))
+	for _, family := range families {
+		var claims jwt.MapClaims
+		json.Unmarshal([]byte(family.Claims), &claims)
+		client := clientInfo{}
+		if family.Session != nil {
+			client = *family.Session
+		}
+
+		desc := parseUserAgent(client.UserAgent)
+		info := SessionInfo{
+			SessionID:       family.FamilyID,
+			Current:         family.FamilyID == currentID,
+			Method:          client.Method,
+			Browser:         desc.Browser,
+			OS:              desc.OS,
+			Device:          desc.Device,
+			IPAddress:       client.IPAddress,
+			Location:        locations[client.IPAddress],
+			AuthenticatedAt: assuranceFromClaims(claims).AuthTime,
+			ExpiresAt:       family.ExpiresAt,
+		}
+		if lastUsed, err := time.Parse(time.RFC3339, client.LastUsed); err == nil {
+			info.LastUsedAt = lastUsed
+		}
+		sessions = append(sessions, info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].AuthenticatedAt.After(sessions[j].AuthenticatedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSessionByID signs out one of a user's sessions. It returns false if
+// the user has no such session.
+func (cs *ChronosSession) RevokeSessionByID(ctx context.Context, userID, sessionID, reason string) (bool, error) {
+	if userID == "" || sessionID == "" {
+		return false, errors.New("user ID and session ID are required")
+	}
+
+	families, err := cs.liveFamilies(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, family := range families {
+		if family.FamilyID == sessionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	if err := cs.revokeRefreshFamilies(ctx, []string{sessionID}); err != nil {
+		return false, err
+	}
+
+	auditor.Record("SESSION_REVOKED", cs.sessionRecordType, sessionID, userID, themislog.SeverityInfo,
+		fmt.Sprintf("Session signed out by user: %s", reason))
+	return true, nil
+}
+
+// RevokeOtherSessions signs out every session of the token's user except
+// the one the token belongs to
+func (cs *ChronosSession) RevokeOtherSessions(ctx context.Context, token, reason string) (int, error) {
+	validation, err := cs.ValidateSession(ctx, &ValidationRequest{Token: token})
+	if err != nil {
+		return 0, err
+	}
+	if !validation.Valid {
+		return 0, errors.New(validation.Message)
+	}
+	currentID := cs.sessionIDOf(token)
+
+	families, err := cs.liveFamilies(ctx, validation.UserID)
+	if err != nil {
+		return 0, err
+	}
+	var others []string
+	for _, family := range families {
+		if family.FamilyID != currentID {
+			others = append(others, family.FamilyID)
+		}
+	}
+	if len(others) == 0 {
+		return 0, nil
+	}
+
+	if err := cs.revokeRefreshFamilies(ctx, others); err != nil {
+		return 0, err
+	}
+
+	auditor.Record("SESSIONS_REVOKED", cs.sessionRecordType, validation.UserID, validation.UserID, themislog.SeverityInfo,
+		fmt.Sprintf("Signed out %d other session(s): %s", len(others), reason))
+	return len(others), nil
+}
+
+// liveFamilies returns the newest unused refresh token of each of a user's
+// sessions that can still be refreshed
+func (cs *ChronosSession) liveFamilies(_ context.Context, userID string) ([]liveFamily, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query {
+		%s
+		families(func: eq(revoked, false)) @filter(type(%s) AND uid_in(user, uid(u)) AND eq(used, false) AND gt(expiresAt, %q)) {
+			familyId
+			claims
+			expiresAt
+			session {
+				method
+				deviceId
+				ipAddress
+				userAgent
+				clientType
+				createdAt
+				lastUsedAt
+				idleTimeout
+				absoluteExpiresAt
+			}
+		}
+	}`, userVar(userID), refreshTokenRecordType, time.Now().Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var result struct {
+		Families []liveFamily `json:"families"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, err
+		}
+	}
+	return result.Families, nil
+}
+
+// sessionClientInfo returns the client details recorded for a token
+func (cs *ChronosSession) sessionClientInfo(_ context.Context, token string) (clientInfo, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query session($tokenHash: string) {
+		sessions(func: eq(tokenHash, $tokenHash)) @filter(type(%s)) {
+			method
+			deviceId
+			ipAddress
+			userAgent
+			clientType
+			createdAt
+			lastUsedAt
+			idleTimeout
+			absoluteExpiresAt
+		}
+	}`, cs.sessionRecordType)).WithVariable("$tokenHash", cs.hashToken(token))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return clientInfo{}, err
+	}
+
+	var result struct {
+		Sessions []clientInfo `json:"sessions"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return clientInfo{}, err
+		}
+	}
+	if len(result.Sessions) == 0 {
+		return clientInfo{}, nil
+	}
+	return result.Sessions[0], nil
+}
+
+// sessionIDOf returns the sid claim of a token, or "" if it isn't ours
+func (cs *ChronosSession) sessionIDOf(token string) string {
+	if token == "" {
+		return ""
+	}
+	parsed, err := cs.keys.parse(token, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return ""
+	}
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	sid, _ := claims["sid"].(string)
+	return sid
+}
+
+// lookupLocations resolves IP addresses to an approximate "City, Region,
+// Country" from the IPAddress records. Unknown addresses are left out.
+func lookupLocations(ips []string) map[string]string {
+	locations := make(map[string]string)
+
+	var quoted []string
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		quoted = append(quoted, fmt.Sprintf("%q", ip))
+	}
+	if len(quoted) == 0 {
+		return locations
+	}
+
+	query := fmt.Sprintf(`{
+		addresses(func: eq(address, [%s])) @filter(type(IPAddress)) {
+			address
+			city
+			region
+			country
+		}
+	}`, strings.Join(quoted, ", "))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil || resp.Json == "" {
+		return locations
+	}
+
+	var result struct {
+		Addresses []struct {
+			Address string `json:"address"`
+			City    string `json:"city"`
+			Region  string `json:"region"`
+			Country string `json:"country"`
+		} `json:"addresses"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return locations
+	}
+
+	for _, a := range result.Addresses {
+		var parts []string
+		for _, part := range []string{a.City, a.Region, a.Country} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) > 0 {
+			locations[a.Address] = strings.Join(parts, ", ")
+		}
+	}
+	return locations
+}
@@ -0,0 +1,240 @@
+package ChronosSession
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"modus/config"
+)
+
+// testKeyConfig builds a SigningKeyConfig with a freshly generated key
+func testKeyConfig(t testing.TB, kid, alg, status string) SigningKeyConfig {
+	t.Helper()
+
+	var private crypto.Signer
+	var err error
+	if alg == ALG_EDDSA {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	return SigningKeyConfig{
+		KID:        kid,
+		Alg:        alg,
+		Status:     status,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+}
+
+func testKeyRing(t testing.TB, entries ...SigningKeyConfig) *keyRing {
+	t.Helper()
+
+	config, _ := json.Marshal(entries)
+	ring, err := parseKeyRing(string(config))
+	if err != nil {
+		t.Fatalf("parseKeyRing failed: %v", err)
+	}
+	return ring
+}
+
+func TestSignAndParseWithKid(t *testing.T) {
+	for _, alg := range []string{ALG_ES256, ALG_EDDSA} {
+		ring := testKeyRing(t, testKeyConfig(t, "k1", alg, KEY_STATUS_ACTIVE))
+
+		signed, err := ring.sign(jwt.MapClaims{"sub": "0x1"})
+		if err != nil {
+			t.Fatalf("%s: sign failed: %v", alg, err)
+		}
+
+		token, err := ring.parse(signed)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: expected token to verify, got %v", alg, err)
+		}
+		if token.Header["kid"] != "k1" || token.Header["alg"] != alg {
+			t.Errorf("%s: unexpected header %v", alg, token.Header)
+		}
+	}
+}
+
+func TestRotationKeepsPreviousKeyValid(t *testing.T) {
+	previous := testKeyConfig(t, "2025-04", ALG_ES256, KEY_STATUS_ACTIVE)
+	before := testKeyRing(t, previous)
+	oldToken, _ := before.sign(jwt.MapClaims{"sub": "0x1"})
+
+	previous.Status = KEY_STATUS_VERIFY
+	after := testKeyRing(t, testKeyConfig(t, "2025-10", ALG_EDDSA, KEY_STATUS_ACTIVE), previous)
+
+	if _, err := after.parse(oldToken); err != nil {
+		t.Errorf("Expected token from the previous key to still verify: %v", err)
+	}
+	newToken, _ := after.sign(jwt.MapClaims{"sub": "0x1"})
+	if _, err := before.parse(newToken); err == nil {
+		t.Error("Expected token from an unknown key to fail")
+	}
+}
+
+func TestRetiredKeyIsRejectedWithReason(t *testing.T) {
+	old := testKeyConfig(t, "2024-10", ALG_ES256, KEY_STATUS_ACTIVE)
+	oldToken, _ := testKeyRing(t, old).sign(jwt.MapClaims{"sub": "0x1"})
+
+	ring := testKeyRing(t,
+		testKeyConfig(t, "2025-10", ALG_ES256, KEY_STATUS_ACTIVE),
+		SigningKeyConfig{KID: "2024-10", Status: KEY_STATUS_RETIRED},
+	)
+	chronos := &ChronosSession{keys: ring}
+
+	resp, err := chronos.ValidateSession(context.Background(), &ValidationRequest{Token: oldToken})
+	if err != nil {
+		t.Fatalf("ValidateSession failed: %v", err)
+	}
+	if resp.Valid {
+		t.Fatal("Expected token signed with a retired key to be invalid")
+	}
+	if resp.Message != "invalid token: token was signed with a retired key (kid 2024-10)" {
+		t.Errorf("Unexpected message: %q", resp.Message)
+	}
+}
+
+func TestParseRejectsSymmetricTokens(t *testing.T) {
+	ring := testKeyRing(t, testKeyConfig(t, "k1", ALG_ES256, KEY_STATUS_ACTIVE))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "0x1"})
+	token.Header["kid"] = "k1"
+	signed, _ := token.SignedString([]byte("shared-secret"))
+
+	if _, err := ring.parse(signed); err == nil {
+		t.Error("Expected HS256 token to be rejected")
+	}
+}
+
+func TestParseKeyRingValidation(t *testing.T) {
+	active := testKeyConfig(t, "a", ALG_ES256, KEY_STATUS_ACTIVE)
+	mismatched := testKeyConfig(t, "b", ALG_ES256, KEY_STATUS_VERIFY)
+	mismatched.Alg = ALG_EDDSA
+
+	tests := []struct {
+		name    string
+		entries []SigningKeyConfig
+	}{
+		{"no active key", []SigningKeyConfig{testKeyConfig(t, "a", ALG_ES256, KEY_STATUS_VERIFY)}},
+		{"two active keys", []SigningKeyConfig{active, testKeyConfig(t, "b", ALG_EDDSA, KEY_STATUS_ACTIVE)}},
+		{"duplicate kid", []SigningKeyConfig{active, active}},
+		{"alg does not match key", []SigningKeyConfig{active, mismatched}},
+		{"active without private key", []SigningKeyConfig{{KID: "a", Alg: ALG_ES256, Status: KEY_STATUS_ACTIVE}}},
+	}
+
+	for _, tt := range tests {
+		config, _ := json.Marshal(tt.entries)
+		if _, err := parseKeyRing(string(config)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestJWKSPublishesUsableKeys(t *testing.T) {
+	ring := testKeyRing(t,
+		testKeyConfig(t, "ec", ALG_ES256, KEY_STATUS_ACTIVE),
+		testKeyConfig(t, "ed", ALG_EDDSA, KEY_STATUS_VERIFY),
+		SigningKeyConfig{KID: "old", Status: KEY_STATUS_RETIRED},
+	)
+
+	jwks := ring.jwks()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "ec" || jwks.Keys[1].KeyID != "ed" {
+		t.Fatalf("Expected active then verify key, got %+v", jwks.Keys)
+	}
+	if k := jwks.Keys[0]; k.KeyType != "EC" || k.Curve != "P-256" || len(k.X) != 43 || len(k.Y) != 43 {
+		t.Errorf("Unexpected EC JWK: %+v", k)
+	}
+	if k := jwks.Keys[1]; k.KeyType != "OKP" || k.Curve != "Ed25519" || len(k.X) != 43 || k.Y != "" {
+		t.Errorf("Unexpected OKP JWK: %+v", k)
+	}
+
+	doc, _ := json.Marshal(jwks)
+	if strings.Contains(string(doc), "\"d\"") {
+		t.Error("Expected JWKS to contain no private key material")
+	}
+}
+
+func TestIssueSessionSignsWithActiveKey(t *testing.T) {
+	chronos := &ChronosSession{
+		keys: testKeyRing(t, testKeyConfig(t, "k1", ALG_EDDSA, KEY_STATUS_ACTIVE)),
+		ttl:  3600,
+	}
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+
+	token, _, err := jwt.NewParser().ParseUnverified(resp.Token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if token.Header["kid"] != "k1" || token.Header["alg"] != ALG_EDDSA {
+		t.Errorf("Unexpected header %v", token.Header)
+	}
+	if !resp.ExpiresAt.After(time.Now()) {
+		t.Error("Expected token to expire in the future")
+	}
+}
+
+func TestInitializeBuildsOnce(t *testing.T) {
+	// The first build reads the keys from the shared configuration
+	keys, _ := json.Marshal([]SigningKeyConfig{testKeyConfig(t, "k1", ALG_ES256, KEY_STATUS_ACTIVE)})
+	cfg, _ := config.Defaults(config.Production)
+	cfg.Secrets.SessionSigningKeys = string(keys)
+	if err := config.Set(cfg); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	sessionPoliciesOverride = DefaultSessionPolicies
+	forgetShared()
+	t.Cleanup(func() {
+		signingKeysOverride = nil
+		sessionPoliciesOverride = nil
+		forgetShared()
+	})
+
+	first, err := Initialize()
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if first.keys.active.kid != "k1" {
+		t.Error("Expected Initialize to use the configured signing keys")
+	}
+	if again, _ := Initialize(); again != first {
+		t.Error("Expected Initialize to reuse the ChronosSession it built")
+	}
+
+	// Overriding the keys builds it again with them
+	keys, _ = json.Marshal([]SigningKeyConfig{testKeyConfig(t, "k2", ALG_EDDSA, KEY_STATUS_ACTIVE)})
+	if err := SetSigningKeys(string(keys)); err != nil {
+		t.Fatalf("SetSigningKeys failed: %v", err)
+	}
+	rebuilt, err := Initialize()
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if rebuilt == first || rebuilt.keys.active.kid != "k2" {
+		t.Error("Expected Initialize to pick up the new signing keys")
+	}
+}
@@ -0,0 +1,196 @@
+package ChronosSession
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// migrationBatchSize is how many sessions MigrateSessionRecords converts
+// per mutation
+const migrationBatchSize = 500
+
+// SessionMigrationResult reports a MigrateSessionRecords run
+type SessionMigrationResult struct {
+	Migrated int `json:"migrated"`
+	Orphaned int `json:"orphaned"` // no matching User; invalidated instead
+}
+
+// legacySession is an AuthSession written before sessions followed the
+// schema, keyed by a userID string with issuedAt, deviceInfo and lastUsed
+type legacySession struct {
+	UID        string `json:"uid"`
+	UserID     string `json:"userID"`
+	IssuedAt   string `json:"issuedAt"`
+	DeviceInfo string `json:"deviceInfo"`
+	LastUsed   string `json:"lastUsed"`
+}
+
+// MigrateSessionRecords converts legacy AuthSession records to the schema:
+// the userID string becomes a user edge and issuedAt, deviceInfo and
+// lastUsed move to createdAt, deviceId and lastUsedAt. Sessions whose user
+// no longer exists are invalidated. It is safe to run more than once.
+func (cs *ChronosSession) MigrateSessionRecords(_ context.Context, performedBy string) (*SessionMigrationResult, error) {
+	result := &SessionMigrationResult{}
+
+	for {
+		batch, err := cs.legacySessions()
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		ids := make([]string, 0, len(batch))
+		for _, session := range batch {
+			ids = append(ids, session.UserID)
+		}
+		users, err := resolveUserUIDs(ids)
+		if err != nil {
+			return result, err
+		}
+
+		var set, del strings.Builder
+		for _, session := range batch {
+			userUID := users[session.UserID]
+			s, d := legacySessionNquads(session, userUID)
+			set.WriteString(s)
+			del.WriteString(d)
+			if userUID == "" {
+				result.Orphaned++
+			} else {
+				result.Migrated++
+			}
+		}
+
+		mu := dgraph.NewMutation().WithSetNquads(set.String()).WithDelNquads(del.String())
+		if _, err := dgraph.ExecuteMutations("dgraph", mu); err != nil {
+			return result, fmt.Errorf("failed to migrate sessions: %w", err)
+		}
+	}
+
+	if result.Migrated+result.Orphaned > 0 {
+		auditor.Record("SESSIONS_MIGRATED", cs.sessionRecordType, "", performedBy, themislog.SeverityInfo,
+			fmt.Sprintf("Migrated %d session(s) to the AuthSession schema; invalidated %d without a user", result.Migrated, result.Orphaned))
+	}
+	return result, nil
+}
+
+// legacySessions returns the next batch of sessions still keyed by userID
+func (cs *ChronosSession) legacySessions() ([]legacySession, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query {
+		sessions(func: has(userID), first: %d) @filter(type(%s)) {
+			uid
+			userID
+			issuedAt
+			deviceInfo
+			lastUsed
+		}
+	}`, migrationBatchSize, cs.sessionRecordType))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy sessions: %w", err)
+	}
+
+	var result struct {
+		Sessions []legacySession `json:"sessions"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, err
+		}
+	}
+	return result.Sessions, nil
+}
+
+// legacySessionNquads returns the N-Quads that convert one legacy session.
+// An empty userUID means the user is gone and the session is invalidated.
+func legacySessionNquads(session legacySession, userUID string) (string, string) {
+	var set strings.Builder
+	if userUID != "" {
+		fmt.Fprintf(&set, "<%s> <user> <%s> .\n", session.UID, userUID)
+	} else {
+		fmt.Fprintf(&set, "<%s> <valid> \"false\"^^<xs:boolean> .\n", session.UID)
+	}
+	if issuedAt, err := time.Parse(time.RFC3339, session.IssuedAt); err == nil {
+		fmt.Fprintf(&set, "<%s> <createdAt> \"%s\"^^<xs:dateTime> .\n", session.UID, issuedAt.Format(time.RFC3339))
+	}
+	if session.DeviceInfo != "" {
+		fmt.Fprintf(&set, "<%s> <deviceId> %q .\n", session.UID, session.DeviceInfo)
+	}
+	if lastUsed, err := time.Parse(time.RFC3339, session.LastUsed); err == nil {
+		fmt.Fprintf(&set, "<%s> <lastUsedAt> \"%s\"^^<xs:dateTime> .\n", session.UID, lastUsed.Format(time.RFC3339))
+	}
+
+	var del strings.Builder
+	for _, predicate := range []string{"userID", "issuedAt", "deviceInfo", "lastUsed"} {
+		fmt.Fprintf(&del, "<%s> <%s> * .\n", session.UID, predicate)
+	}
+	return set.String(), del.String()
+}
+
+// resolveUserUIDs maps user IDs, given as uids or DIDs, to the uid of an
+// existing User. Unknown IDs are left out.
+func resolveUserUIDs(ids []string) (map[string]string, error) {
+	var uids, dids []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if isUID(id) {
+			uids = append(uids, id)
+		} else {
+			dids = append(dids, fmt.Sprintf("%q", id))
+		}
+	}
+
+	var blocks []string
+	if len(uids) > 0 {
+		blocks = append(blocks, fmt.Sprintf(`byUID(func: uid(%s)) @filter(type(User)) {
+			uid
+		}`, strings.Join(uids, ", ")))
+	}
+	if len(dids) > 0 {
+		blocks = append(blocks, fmt.Sprintf(`byDID(func: eq(did, [%s])) @filter(type(User)) {
+			uid
+			did
+		}`, strings.Join(dids, ", ")))
+	}
+
+	users := make(map[string]string)
+	if len(blocks) == 0 {
+		return users, nil
+	}
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery("query {\n\t\t"+strings.Join(blocks, "\n\t\t")+"\n\t}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve users: %w", err)
+	}
+
+	var result struct {
+		ByUID []UserRef `json:"byUID"`
+		ByDID []UserRef `json:"byDID"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range result.ByUID {
+		users[u.UID] = u.UID
+	}
+	for _, u := range result.ByDID {
+		users[u.DID] = u.UID
+	}
+	return users, nil
+}
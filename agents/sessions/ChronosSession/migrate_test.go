@@ -0,0 +1,101 @@
+package ChronosSession
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func TestLegacySessionNquadsLinksUser(t *testing.T) {
+	set, del := legacySessionNquads(legacySession{
+		UID:        "0x10",
+		UserID:     "did:example:alice",
+		IssuedAt:   "2025-07-01T10:00:00Z",
+		DeviceInfo: "laptop",
+		LastUsed:   "2025-07-01T10:05:00Z",
+	}, "0x2")
+
+	for _, want := range []string{
+		`<0x10> <user> <0x2> .`,
+		`<0x10> <createdAt> "2025-07-01T10:00:00Z"^^<xs:dateTime> .`,
+		`<0x10> <deviceId> "laptop" .`,
+		`<0x10> <lastUsedAt> "2025-07-01T10:05:00Z"^^<xs:dateTime> .`,
+	} {
+		if !strings.Contains(set, want) {
+			t.Errorf("Expected %q in set N-Quads:\n%s", want, set)
+		}
+	}
+	for _, predicate := range []string{"userID", "issuedAt", "deviceInfo", "lastUsed"} {
+		if !strings.Contains(del, "<0x10> <"+predicate+"> * .") {
+			t.Errorf("Expected legacy predicate %s to be deleted", predicate)
+		}
+	}
+}
+
+func TestLegacySessionNquadsInvalidatesOrphans(t *testing.T) {
+	set, del := legacySessionNquads(legacySession{UID: "0x10", UserID: "did:example:gone"}, "")
+
+	if strings.Contains(set, "<user>") || !strings.Contains(set, `<0x10> <valid> "false"^^<xs:boolean> .`) {
+		t.Errorf("Expected a session without a user to be invalidated, got:\n%s", set)
+	}
+	if !strings.Contains(del, "<0x10> <userID> * .") {
+		t.Error("Expected userID to be deleted so the session is not migrated again")
+	}
+}
+
+func TestMigrateSessionRecordsStopsWhenNothingLeft(t *testing.T) {
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	// The test double returns no legacy sessions
+	result, err := testChronosSession(t).MigrateSessionRecords(context.Background(), "0x1")
+	if err != nil {
+		t.Fatalf("MigrateSessionRecords failed: %v", err)
+	}
+	if result.Migrated != 0 || result.Orphaned != 0 {
+		t.Errorf("Expected nothing to migrate, got %+v", result)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	if !strings.Contains(req.Query.Query, "has(userID)") || len(req.Mutations) != 0 {
+		t.Error("Expected a read-only query for sessions still keyed by userID")
+	}
+}
+
+func TestStoreSessionLinksUserAndIndexesToken(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "did:example:alice", DeviceInfo: "laptop"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+
+	req := lastDgraphRequest() // the session upsert comes last
+	if !strings.Contains(req.Query.Query, `eq(did, "did:example:alice")`) {
+		t.Errorf("Expected the user to be resolved by DID, got %s", req.Query.Query)
+	}
+	nquads := req.Mutations[0].SetNquads
+	for _, want := range []string{
+		"_:session <user> uid(u) .",
+		"_:session <tokenHash> " + `"` + chronos.hashToken(resp.Token) + `"`,
+		"_:session <createdAt> ",
+		`_:session <deviceId> "laptop" .`,
+	} {
+		if !strings.Contains(nquads, want) {
+			t.Errorf("Expected %q in session N-Quads", want)
+		}
+	}
+	if strings.Contains(nquads, "_:session <userID>") {
+		t.Error("Expected sessions not to store the legacy userID predicate")
+	}
+}
+
+func TestUserVar(t *testing.T) {
+	if got := userVar("0x1a"); got != "u as var(func: uid(0x1a))" {
+		t.Errorf("Expected a uid lookup, got %q", got)
+	}
+	if got := userVar("0x1a) OR x"); !strings.Contains(got, "eq(did,") {
+		t.Errorf("Expected a malformed uid to be treated as a DID, got %q", got)
+	}
+}
@@ -0,0 +1,252 @@
+package ChronosSession
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	"modus/config"
+)
+
+// SessionPoliciesSecret is the Modus secret holding the session timeout
+// policies as a JSON array of SessionPolicy. DefaultSessionPolicies apply
+// when it is not set.
+const SessionPoliciesSecret = "SESSION_TIMEOUT_POLICIES"
+
+// Client types a session can be issued to
+const (
+	CLIENT_WEB           = "web"
+	CLIENT_MOBILE        = "mobile"
+	CLIENT_ADMIN_CONSOLE = "admin_console"
+)
+
+// validClientType reports whether t is one of the client types above
+func validClientType(t string) bool {
+	switch t {
+	case CLIENT_WEB, CLIENT_MOBILE, CLIENT_ADMIN_CONSOLE:
+		return true
+	}
+	return false
+}
+
+// SessionPolicy limits how long a session may be idle and how long it may
+// last in total, however often it is refreshed. Role and ClientType select
+// the sessions it applies to; empty matches any.
+type SessionPolicy struct {
+	Role                    string `json:"role,omitempty"`
+	ClientType              string `json:"clientType,omitempty"`
+	IdleTimeoutSeconds      int64  `json:"idleTimeoutSeconds"`
+	AbsoluteLifetimeSeconds int64  `json:"absoluteLifetimeSeconds"`
+}
+
+// DefaultSessionPolicies are used unless SessionPoliciesSecret is set
+var DefaultSessionPolicies = []SessionPolicy{
+	{IdleTimeoutSeconds: 15 * 60, AbsoluteLifetimeSeconds: 8 * 3600},
+	{ClientType: CLIENT_WEB, IdleTimeoutSeconds: 30 * 60, AbsoluteLifetimeSeconds: 12 * 3600},
+	{ClientType: CLIENT_MOBILE, IdleTimeoutSeconds: 14 * 86400, AbsoluteLifetimeSeconds: 30 * 86400},
+	{ClientType: CLIENT_ADMIN_CONSOLE, IdleTimeoutSeconds: 15 * 60, AbsoluteLifetimeSeconds: 8 * 3600},
+	{Role: "admin", IdleTimeoutSeconds: 15 * 60, AbsoluteLifetimeSeconds: 8 * 3600},
+}
+
+var sessionPoliciesOverride []SessionPolicy
+
+func init() {
+	config.RegisterSecret(SessionPoliciesSecret, false, func(value string) error {
+		_, err := parseSessionPolicies(value)
+		return err
+	})
+}
+
+// SetSessionPolicies overrides the policies read from SessionPoliciesSecret
+func SetSessionPolicies(config string) error {
+	policies, err := parseSessionPolicies(config)
+	if err != nil {
+		return err
+	}
+	sessionPoliciesOverride = policies
+	forgetShared()
+	return nil
+}
+
+// loadSessionPolicies returns the configured session timeout policies
+func loadSessionPolicies() ([]SessionPolicy, error) {
+	if sessionPoliciesOverride != nil {
+		return sessionPoliciesOverride, nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.SessionTimeoutPolicies == "" {
+		return DefaultSessionPolicies, nil
+	}
+	return parseSessionPolicies(cfg.Secrets.SessionTimeoutPolicies)
+}
+
+// parseSessionPolicies parses and checks a SessionPoliciesSecret value. One
+// policy must match every session, so none is left without limits, and it
+// must be the strictest, so a session that matches nothing else gets the
+// shortest timeouts.
+func parseSessionPolicies(config string) ([]SessionPolicy, error) {
+	var policies []SessionPolicy
+	if err := json.Unmarshal([]byte(config), &policies); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", SessionPoliciesSecret, err)
+	}
+
+	var catchAll *SessionPolicy
+	seen := make(map[[2]string]bool)
+	for i, p := range policies {
+		key := [2]string{p.Role, p.ClientType}
+		if seen[key] {
+			return nil, fmt.Errorf("invalid %s: duplicate policy for role %q and client %q", SessionPoliciesSecret, p.Role, p.ClientType)
+		}
+		seen[key] = true
+
+		if p.ClientType != "" && !validClientType(p.ClientType) {
+			return nil, fmt.Errorf("invalid %s: unknown client type %q", SessionPoliciesSecret, p.ClientType)
+		}
+		if p.IdleTimeoutSeconds <= 0 || p.AbsoluteLifetimeSeconds <= 0 {
+			return nil, fmt.Errorf("invalid %s: policy for role %q and client %q needs positive timeouts", SessionPoliciesSecret, p.Role, p.ClientType)
+		}
+		if p.IdleTimeoutSeconds > p.AbsoluteLifetimeSeconds {
+			return nil, fmt.Errorf("invalid %s: policy for role %q and client %q has an idle timeout longer than its lifetime", SessionPoliciesSecret, p.Role, p.ClientType)
+		}
+		if p.Role == "" && p.ClientType == "" {
+			catchAll = &policies[i]
+		}
+	}
+	if catchAll == nil {
+		return nil, fmt.Errorf("invalid %s: a default policy without role or client type is required", SessionPoliciesSecret)
+	}
+	for _, p := range policies {
+		if p.IdleTimeoutSeconds < catchAll.IdleTimeoutSeconds || p.AbsoluteLifetimeSeconds < catchAll.AbsoluteLifetimeSeconds {
+			return nil, fmt.Errorf("invalid %s: policy for role %q and client %q is stricter than the default policy", SessionPoliciesSecret, p.Role, p.ClientType)
+		}
+	}
+	return policies, nil
+}
+
+// resolvePolicy picks the policy for a session. A policy naming a role beats
+// one naming only a client type, and one naming both beats either. If
+// several match equally (a user with two roles), the strictest limits win.
+func resolvePolicy(policies []SessionPolicy, roles []string, clientType string) SessionPolicy {
+	hasRole := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		hasRole[r] = true
+	}
+
+	best := SessionPolicy{}
+	bestScore := -1
+	for _, p := range policies {
+		if (p.Role != "" && !hasRole[p.Role]) || (p.ClientType != "" && p.ClientType != clientType) {
+			continue
+		}
+
+		score := 0
+		if p.Role != "" {
+			score += 2
+		}
+		if p.ClientType != "" {
+			score++
+		}
+
+		switch {
+		case score > bestScore:
+			best, bestScore = p, score
+		case score == bestScore:
+			best.IdleTimeoutSeconds = min(best.IdleTimeoutSeconds, p.IdleTimeoutSeconds)
+			best.AbsoluteLifetimeSeconds = min(best.AbsoluteLifetimeSeconds, p.AbsoluteLifetimeSeconds)
+		}
+	}
+	return best
+}
+
+// sessionLimits are the timeouts a session was issued under. They are fixed
+// at sign-in and carried over when the session is refreshed or stepped up.
+type sessionLimits struct {
+	IdleTimeout       int64     `json:"idleTimeout"` // seconds; 0 means none
+	AbsoluteExpiresAt time.Time `json:"absoluteExpiresAt"`
+}
+
+// limitsFrom applies a policy to a session signed in at signedInAt
+func limitsFrom(policy SessionPolicy, signedInAt time.Time) sessionLimits {
+	limits := sessionLimits{IdleTimeout: policy.IdleTimeoutSeconds}
+	if policy.AbsoluteLifetimeSeconds > 0 {
+		limits.AbsoluteExpiresAt = signedInAt.Add(time.Duration(policy.AbsoluteLifetimeSeconds) * time.Second)
+	}
+	return limits
+}
+
+// idle reports whether a session last active at lastActive has been idle
+// for longer than allowed
+func (l sessionLimits) idle(lastActive, now time.Time) bool {
+	return l.IdleTimeout > 0 && now.Sub(lastActive) > time.Duration(l.IdleTimeout)*time.Second
+}
+
+// cap moves t back to the absolute expiry if it is later
+func (l sessionLimits) cap(t time.Time) time.Time {
+	if !l.AbsoluteExpiresAt.IsZero() && t.After(l.AbsoluteExpiresAt) {
+		return l.AbsoluteExpiresAt
+	}
+	return t
+}
+
+// sessionLimitsFor resolves the limits of a new sign-in from the user's
+// roles and the client type. The client type is only the client's word:
+// unless the sign-in is bound to one of the user's WebAuthn credentials, it
+// can tighten the limits the user would get without it but never loosen
+// them.
+func (cs *ChronosSession) sessionLimitsFor(userID, clientType string, credentialBound bool, signedInAt time.Time) (sessionLimits, error) {
+	roles, err := userRoles(userID)
+	if err != nil {
+		return sessionLimits{}, err
+	}
+
+	policy := resolvePolicy(cs.policies, roles, clientType)
+	if clientType != "" && !credentialBound {
+		unclaimed := resolvePolicy(cs.policies, roles, "")
+		policy.IdleTimeoutSeconds = min(policy.IdleTimeoutSeconds, unclaimed.IdleTimeoutSeconds)
+		policy.AbsoluteLifetimeSeconds = min(policy.AbsoluteLifetimeSeconds, unclaimed.AbsoluteLifetimeSeconds)
+	}
+	return limitsFrom(policy, signedInAt), nil
+}
+
+// userRoles returns the names of a user's roles
+func userRoles(userID string) ([]string, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query {
+		%s
+		users(func: uid(u)) {
+			roles {
+				name
+			}
+		}
+	}`, userVar(userID)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user roles: %w", err)
+	}
+
+	var result struct {
+		Users []struct {
+			Roles []struct {
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"users"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, err
+		}
+	}
+
+	var roles []string
+	for _, user := range result.Users {
+		for _, role := range user.Roles {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles, nil
+}
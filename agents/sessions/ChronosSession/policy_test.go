@@ -0,0 +1,138 @@
+package ChronosSession
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestResolvePolicyPrefersMostSpecific(t *testing.T) {
+	policies := []SessionPolicy{
+		{IdleTimeoutSeconds: 3600, AbsoluteLifetimeSeconds: 86400},
+		{ClientType: CLIENT_MOBILE, IdleTimeoutSeconds: 7 * 86400, AbsoluteLifetimeSeconds: 30 * 86400},
+		{Role: "assessor", IdleTimeoutSeconds: 1800, AbsoluteLifetimeSeconds: 12 * 3600},
+		{Role: "admin", IdleTimeoutSeconds: 900, AbsoluteLifetimeSeconds: 8 * 3600},
+		{Role: "student", ClientType: CLIENT_MOBILE, IdleTimeoutSeconds: 14 * 86400, AbsoluteLifetimeSeconds: 60 * 86400},
+	}
+
+	tests := []struct {
+		name       string
+		roles      []string
+		clientType string
+		wantIdle   int64
+	}{
+		{"default", nil, CLIENT_WEB, 3600},
+		{"client type", []string{"registered"}, CLIENT_MOBILE, 7 * 86400},
+		{"role beats client type", []string{"admin"}, CLIENT_MOBILE, 900},
+		{"role and client type", []string{"student"}, CLIENT_MOBILE, 14 * 86400},
+		{"strictest of several roles", []string{"assessor", "admin"}, CLIENT_WEB, 900},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePolicy(policies, tt.roles, tt.clientType); got.IdleTimeoutSeconds != tt.wantIdle {
+				t.Errorf("Expected idle timeout %d, got %d", tt.wantIdle, got.IdleTimeoutSeconds)
+			}
+		})
+	}
+}
+
+func TestParseSessionPoliciesRequiresDefault(t *testing.T) {
+	defaults, _ := json.Marshal(DefaultSessionPolicies)
+	if _, err := parseSessionPolicies(string(defaults)); err != nil {
+		t.Errorf("Expected the default policies to be valid, got %v", err)
+	}
+	if _, err := parseSessionPolicies(`[{"role":"admin","idleTimeoutSeconds":900,"absoluteLifetimeSeconds":28800}]`); err == nil {
+		t.Error("Expected policies without a default to be rejected")
+	}
+	if _, err := parseSessionPolicies(`[{"idleTimeoutSeconds":90000,"absoluteLifetimeSeconds":3600}]`); err == nil {
+		t.Error("Expected an idle timeout longer than the lifetime to be rejected")
+	}
+	if _, err := parseSessionPolicies(`[{"idleTimeoutSeconds":900,"absoluteLifetimeSeconds":3600},{"idleTimeoutSeconds":60,"absoluteLifetimeSeconds":60}]`); err == nil {
+		t.Error("Expected duplicate policies to be rejected")
+	}
+	if _, err := parseSessionPolicies(`[{"idleTimeoutSeconds":900,"absoluteLifetimeSeconds":3600}]`); err != nil {
+		t.Errorf("Expected a default policy to be accepted, got %v", err)
+	}
+	if _, err := parseSessionPolicies(`[{"idleTimeoutSeconds":3600,"absoluteLifetimeSeconds":86400},{"clientType":"web","idleTimeoutSeconds":900,"absoluteLifetimeSeconds":86400}]`); err == nil {
+		t.Error("Expected a policy stricter than the default to be rejected")
+	}
+	if _, err := parseSessionPolicies(`[{"idleTimeoutSeconds":900,"absoluteLifetimeSeconds":3600},{"clientType":"kiosk","idleTimeoutSeconds":900,"absoluteLifetimeSeconds":3600}]`); err == nil {
+		t.Error("Expected an unknown client type to be rejected")
+	}
+}
+
+func TestSessionLimitsIdle(t *testing.T) {
+	now := time.Now()
+	limits := sessionLimits{IdleTimeout: 900}
+
+	if limits.idle(now.Add(-10*time.Minute), now) {
+		t.Error("Expected a session used 10 minutes ago not to be idle")
+	}
+	if !limits.idle(now.Add(-20*time.Minute), now) {
+		t.Error("Expected a session unused for 20 minutes to be idle")
+	}
+	if (sessionLimits{}).idle(now.Add(-240*time.Hour), now) {
+		t.Error("Expected a session without an idle timeout never to be idle")
+	}
+}
+
+func TestIssueSessionNeverOutlivesAbsoluteLifetime(t *testing.T) {
+	chronos := testChronosSession(t)
+	deadline := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{
+		UserID: "0x1",
+		limits: &sessionLimits{IdleTimeout: 900, AbsoluteExpiresAt: deadline},
+	})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if !resp.ExpiresAt.Equal(deadline) || !resp.RefreshExpiresAt.Equal(deadline) {
+		t.Errorf("Expected both tokens to expire at the absolute cap %v, got %v and %v", deadline, resp.ExpiresAt, resp.RefreshExpiresAt)
+	}
+	if !resp.SessionExpiresAt.Equal(deadline) {
+		t.Errorf("Expected the session expiry to be reported, got %v", resp.SessionExpiresAt)
+	}
+}
+
+func TestIssueSessionAppliesClientPolicy(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	// The test double returns no roles, so a client type policy applies to
+	// a sign-in bound to a WebAuthn credential
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1", ClientType: CLIENT_WEB, CredentialID: "cred-1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if resp.IdleTimeout != 30*60 {
+		t.Errorf("Expected the web idle timeout, got %d", resp.IdleTimeout)
+	}
+	if lifetime := resp.SessionExpiresAt.Sub(resp.IssuedAt); lifetime != 12*time.Hour {
+		t.Errorf("Expected a 12 hour lifetime, got %v", lifetime)
+	}
+	if resp.RefreshExpiresAt.After(resp.SessionExpiresAt) {
+		t.Error("Expected the refresh token not to outlive the session")
+	}
+}
+
+func TestIssueSessionDoesNotTrustClaimedClientType(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	// Claiming to be the mobile app without a credential earns nothing
+	// looser than the default policy
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1", ClientType: CLIENT_MOBILE})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if resp.IdleTimeout != 15*60 {
+		t.Errorf("Expected the default idle timeout, got %d", resp.IdleTimeout)
+	}
+	if lifetime := resp.SessionExpiresAt.Sub(resp.IssuedAt); lifetime != 8*time.Hour {
+		t.Errorf("Expected an 8 hour lifetime, got %v", lifetime)
+	}
+
+	if _, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1", ClientType: "kiosk"}); err == nil {
+		t.Error("Expected an unknown client type to be rejected")
+	}
+}
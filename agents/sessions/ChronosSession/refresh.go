@@ -0,0 +1,193 @@
+package ChronosSession
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// refreshTokenRecordType is the Dgraph type refresh tokens are stored as
+const refreshTokenRecordType = "RefreshToken"
+
+// ErrRefreshTokenReused is returned when a refresh token is presented after
+// it was already rotated. Every session of its family has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected; please sign in again")
+
+// ErrRefreshTokenClient is returned when a refresh token is presented by a
+// client it wasn't issued to. Every session of its family has been revoked.
+var ErrRefreshTokenClient = errors.New("refresh token was issued to another client")
+
+// refreshTokenRecord is a refresh token as stored in Dgraph
+type refreshTokenRecord struct {
+	UID       string      `json:"uid"`
+	UserID    string      `json:"-"` // subject of the stored claims
+	FamilyID  string      `json:"familyId"`
+	Claims    string      `json:"claims"` // access token claims to reissue from
+	Used      bool        `json:"used"`
+	Revoked   bool        `json:"revoked"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	Session   *clientInfo `json:"session"` // session issued alongside
+}
+
+// newRefreshToken returns an opaque refresh token
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newFamilyID returns the ID shared by a sign-in's rotated refresh tokens
+func newFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "fam_" + hex.EncodeToString(b), nil
+}
+
+// newTokenID returns the jti of an access token. Tokens issued in the same
+// second for the same user still get distinct IDs.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashRefreshToken returns the hash a refresh token is stored under
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshTokenNquads stores a refresh token for the _:session being created,
+// linked to the same User u
+func refreshTokenNquads(familyID, tokenHash, claims string, issuedAt, expiresAt time.Time) string {
+	return fmt.Sprintf(`
+		_:refresh <dgraph.type> %q .
+		_:refresh <user> uid(u) .
+		_:refresh <familyId> %q .
+		_:refresh <tokenHash> %q .
+		_:refresh <claims> %q .
+		_:refresh <session> _:session .
+		_:refresh <used> "false"^^<xs:boolean> .
+		_:refresh <revoked> "false"^^<xs:boolean> .
+		_:refresh <issuedAt> "%s"^^<xs:dateTime> .
+		_:refresh <expiresAt> "%s"^^<xs:dateTime> .
+	`, refreshTokenRecordType, familyID, tokenHash, claims,
+		issuedAt.Format(time.RFC3339), expiresAt.Format(time.RFC3339))
+}
+
+// consumeRefreshToken marks a refresh token used and ends the session it
+// was issued with, in one upsert. It returns the stored record (nil if the
+// token is unknown) and whether this call consumed it.
+func (cs *ChronosSession) consumeRefreshToken(_ context.Context, token string) (*refreshTokenRecord, bool, error) {
+	now := time.Now()
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query refresh($tokenHash: string) {
+		t as var(func: eq(tokenHash, $tokenHash)) @filter(type(%s) AND eq(used, false) AND eq(revoked, false) AND gt(expiresAt, %q)) {
+			s as session
+		}
+		token(func: eq(tokenHash, $tokenHash)) @filter(type(%s)) {
+			uid
+			familyId
+			claims
+			used
+			revoked
+			expiresAt
+			session {
+				method
+				deviceId
+				ipAddress
+				userAgent
+				clientType
+				createdAt
+				lastUsedAt
+				idleTimeout
+				absoluteExpiresAt
+			}
+		}
+	}`, refreshTokenRecordType, now.Format(time.RFC3339), refreshTokenRecordType)).
+		WithVariable("$tokenHash", hashRefreshToken(token))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(t), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(t) <used> "true"^^<xs:boolean> .
+uid(t) <usedAt> "%s"^^<xs:dateTime> .
+uid(s) <valid> "false"^^<xs:boolean> .`, now.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	var result struct {
+		Token []refreshTokenRecord `json:"token"`
+	}
+	if resp.Json != "" {
+		if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(result.Token) == 0 {
+		return nil, false, nil
+	}
+
+	// The query reads the state before the mutation, so the token was
+	// consumed exactly when it matched the mutation's condition
+	record := &result.Token[0]
+
+	// The token is reissued to the subject it was first issued to
+	var subject struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal([]byte(record.Claims), &subject); err == nil {
+		record.UserID = subject.Sub
+	}
+
+	consumed := !record.Used && !record.Revoked && record.ExpiresAt.After(now)
+	if consumed {
+		validationCache.forgetFamilies([]string{record.FamilyID})
+	}
+	return record, consumed, nil
+}
+
+// revokeRefreshFamilies revokes every refresh token and ends every session
+// of the given token families
+func (cs *ChronosSession) revokeRefreshFamilies(_ context.Context, familyIDs []string) error {
+	if len(familyIDs) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(familyIDs))
+	for i, id := range familyIDs {
+		quoted[i] = fmt.Sprintf("%q", id)
+	}
+	families := strings.Join(quoted, ", ")
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query {
+		r as var(func: eq(familyId, [%s])) @filter(type(%s))
+		s as var(func: eq(familyId, [%s])) @filter(type(%s))
+	}`, families, refreshTokenRecordType, families, cs.sessionRecordType))
+
+	mutation := dgraph.NewMutation().WithSetNquads(`uid(r) <revoked> "true"^^<xs:boolean> .
+uid(s) <valid> "false"^^<xs:boolean> .`)
+
+	if _, err := dgraph.ExecuteQuery("dgraph", query, mutation); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	validationCache.forgetFamilies(familyIDs)
+	return nil
+}
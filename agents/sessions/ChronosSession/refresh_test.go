@@ -0,0 +1,123 @@
+package ChronosSession
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func testChronosSession(t testing.TB) *ChronosSession {
+	t.Helper()
+	return &ChronosSession{
+		keys:              testKeyRing(t, testKeyConfig(t, "k1", ALG_ES256, KEY_STATUS_ACTIVE)),
+		ttl:               900,
+		refreshTTL:        86400,
+		policies:          DefaultSessionPolicies,
+		sessionRecordType: "AuthSession",
+	}
+}
+
+// lastDgraphRequest returns the most recent request sent to the test double,
+// skipping the audit entries ThemisLog appends afterwards
+func lastDgraphRequest() *dgraph.Request {
+	for i := dgraph.DgraphQueryCallStack.Size() - 1; i > 0; i-- {
+		req := dgraph.DgraphQueryCallStack.Items[i][1].(*dgraph.Request)
+		if req.Query == nil || !strings.Contains(req.Query.Query, "AuditChainHead") {
+			return req
+		}
+	}
+	return dgraph.DgraphQueryCallStack.Items[0][1].(*dgraph.Request)
+}
+
+func TestNewRefreshTokenIsOpaqueAndUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := newRefreshToken()
+		if err != nil {
+			t.Fatalf("newRefreshToken failed: %v", err)
+		}
+		if len(token) != 43 || strings.Count(token, ".") != 0 {
+			t.Fatalf("Expected an opaque 32-byte token, got %q", token)
+		}
+		if seen[token] {
+			t.Fatalf("Duplicate refresh token %q", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestIssueSessionGivesEachTokenItsOwnID(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	// Both tokens are issued for the same user within the same second
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 2; i++ {
+		resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+		if err != nil {
+			t.Fatalf("IssueSession failed: %v", err)
+		}
+		token, _ := chronos.keys.parse(resp.Token)
+		jti := token.Claims.(jwt.MapClaims)["jti"]
+		if seen[jti] || strings.Contains(jti.(string), "0x1") {
+			t.Fatalf("Expected a random jti, got %v", jti)
+		}
+		seen[jti] = true
+	}
+}
+
+func TestIssueSessionStoresOnlyRefreshTokenHash(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if resp.RefreshToken == "" || !resp.RefreshExpiresAt.After(resp.ExpiresAt) {
+		t.Fatal("Expected a refresh token outliving the access token")
+	}
+
+	req := lastDgraphRequest() // the session upsert comes last
+	nquads := req.Mutations[0].SetNquads
+	if strings.Contains(nquads, resp.RefreshToken) {
+		t.Error("Expected the raw refresh token not to be stored")
+	}
+	if !strings.Contains(nquads, hashRefreshToken(resp.RefreshToken)) {
+		t.Error("Expected the refresh token hash to be stored with the session")
+	}
+	if !strings.Contains(nquads, "_:refresh <user> uid(u) .") || strings.Contains(nquads, "<userID>") {
+		t.Error("Expected the refresh token to link the User like its session")
+	}
+
+	token, _ := chronos.keys.parse(resp.Token)
+	if sid := token.Claims.(jwt.MapClaims)["sid"]; sid != resp.SessionID || !strings.HasPrefix(resp.SessionID, "fam_") {
+		t.Errorf("Expected sid claim to name the token family, got %v", sid)
+	}
+}
+
+func TestRefreshSessionConsumesTokenAtomically(t *testing.T) {
+	chronos := testChronosSession(t)
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	// The test double finds no stored token
+	_, err := chronos.RefreshSession(context.Background(), &RefreshRequest{RefreshToken: "unknown"})
+	if err == nil || err.Error() != "invalid refresh token" {
+		t.Fatalf("Expected unknown token to be rejected, got %v", err)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	if req.Query == nil || len(req.Mutations) != 1 || req.Mutations[0].Condition != "@if(eq(len(t), 1))" {
+		t.Fatal("Expected the token to be marked used in a conditional upsert")
+	}
+	if !strings.Contains(req.Mutations[0].SetNquads, "uid(s) <valid> \"false\"") {
+		t.Error("Expected the previous access token to be ended in the same upsert")
+	}
+}
+
+func TestRefreshSessionRequiresToken(t *testing.T) {
+	if _, err := testChronosSession(t).RefreshSession(context.Background(), &RefreshRequest{}); err == nil {
+		t.Error("Expected an error without a refresh token")
+	}
+}
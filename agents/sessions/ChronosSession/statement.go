@@ -0,0 +1,29 @@
+package ChronosSession
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SignStatement signs a statement about a document, such as the manifest of
+// an audit export, with the active session key. Anyone holding the JWKS can
+// check it offline; the token has no expiry because the document doesn't
+// expire either.
+func (cs *ChronosSession) SignStatement(issuer string, claims map[string]interface{}) (string, error) {
+	if issuer == "" {
+		return "", errors.New("issuer is required")
+	}
+
+	statement := jwt.MapClaims{
+		"iss": issuer,
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range claims {
+		if _, exists := statement[k]; !exists {
+			statement[k] = v
+		}
+	}
+	return cs.keys.sign(statement)
+}
@@ -0,0 +1,28 @@
+package ChronosSession
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSignStatementVerifiesAgainstKeys(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	signed, err := chronos.SignStatement("ThemisLog", map[string]interface{}{"contentSha256": "abc", "iss": "someone"})
+	if err != nil {
+		t.Fatalf("SignStatement failed: %v", err)
+	}
+
+	token, err := chronos.keys.parse(signed, jwt.WithIssuer("ThemisLog"))
+	if err != nil {
+		t.Fatalf("Expected the statement to verify, got %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["contentSha256"] != "abc" {
+		t.Errorf("Expected the statement's claims, got %v", claims)
+	}
+	if _, ok := claims["exp"]; ok {
+		t.Error("Expected a statement without expiry")
+	}
+}
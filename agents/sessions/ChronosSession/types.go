@@ -6,11 +6,22 @@ import (
 
 // SessionRequest contains data for creating a new session
 type SessionRequest struct {
-	UserID          string                 `json:"userID"`
+	UserID           string                 `json:"userID"`
 	AdditionalClaims map[string]interface{} `json:"additionalClaims,omitempty"`
-	DeviceInfo      string                 `json:"deviceInfo,omitempty"`
-	IPAddress       string                 `json:"ipAddress,omitempty"`
-	UserAgent       string                 `json:"userAgent,omitempty"`
+	DeviceInfo       string                 `json:"deviceInfo,omitempty"`
+	IPAddress        string                 `json:"ipAddress,omitempty"`
+	UserAgent        string                 `json:"userAgent,omitempty"`
+	ClientType       string                 `json:"clientType,omitempty"`   // web, mobile or admin_console; selects the timeout policy
+	CredentialID     string                 `json:"credentialId,omitempty"` // WebAuthn credential used, if any
+	AuthMethod       AuthMethod             `json:"authMethod"`             // how the user authenticated; sets amr/acr
+	// Assurance carries amr/acr/auth_time over unchanged on refresh and
+	// step-up; when nil it is derived from AuthMethod
+	Assurance *Assurance `json:"-"`
+	// familyID continues a refresh token family on rotation
+	familyID string
+	// limits carries the sign-in's timeouts over on refresh and step-up;
+	// when nil they are resolved from the session policies
+	limits *sessionLimits
 }
 
 // SessionResponse contains the resulting session token and metadata
@@ -20,11 +31,23 @@ type SessionResponse struct {
 	IssuedAt  time.Time `json:"issuedAt"`
 	UserID    string    `json:"userID"`
 	Message   string    `json:"message,omitempty"`
+	AAL       int       `json:"aal"`
+	// RefreshToken is opaque and single-use; exchange it with RefreshSession
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	SessionID        string    `json:"sessionID"` // sid claim, shared by a sign-in's rotated tokens
+	// SessionExpiresAt is the absolute end of the session; refreshing never
+	// extends past it
+	SessionExpiresAt time.Time `json:"sessionExpiresAt,omitempty"`
+	IdleTimeout      int64     `json:"idleTimeout,omitempty"` // seconds of inactivity before sign-out
 }
 
 // ValidationRequest for validating an existing session token
 type ValidationRequest struct {
 	Token string `json:"token"`
+	// ClientID accepts only access tokens issued to this OAuth client.
+	// Leave it empty for first-party sessions; client tokens are refused then.
+	ClientID string `json:"clientId,omitempty"`
 }
 
 // ValidationResponse contains the results of token validation
@@ -33,11 +56,39 @@ type ValidationResponse struct {
 	UserID    string    `json:"userID,omitempty"`
 	ExpiresAt time.Time `json:"expiresAt,omitempty"`
 	Message   string    `json:"message,omitempty"`
+	Assurance Assurance `json:"assurance"`
+	Scope     string    `json:"scope,omitempty"` // OAuth client tokens only
 }
 
-// RefreshRequest for extending an existing session
+// AssuranceRequest checks a session against a required assurance level
+type AssuranceRequest struct {
+	Token       string        `json:"token"`
+	RequiredAAL int           `json:"requiredAAL"`
+	MaxAge      time.Duration `json:"maxAge,omitempty"` // max time since auth_time; 0 for any
+}
+
+// AssuranceResponse contains the result of an assurance check
+type AssuranceResponse struct {
+	Satisfied bool      `json:"satisfied"`
+	UserID    string    `json:"userID,omitempty"`
+	Assurance Assurance `json:"assurance"`
+	// StepUpRequired is set when the session is valid but too weak or too
+	// old; the client should re-authenticate with WebAuthn or TOTP
+	StepUpRequired bool   `json:"stepUpRequired"`
+	Message        string `json:"message,omitempty"`
+}
+
+// StepUpRequest raises a session's assurance after a fresh authentication.
+// The caller must have verified that authentication for the session's user.
+type StepUpRequest struct {
+	Token  string     `json:"token"`
+	Method AuthMethod `json:"method"`
+}
+
+// RefreshRequest exchanges a refresh token for a new token pair
 type RefreshRequest struct {
-	Token string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ClientID     string `json:"clientId,omitempty"` // OAuth client the token was issued to, if any
 }
 
 // RevocationRequest for revoking a session
@@ -55,29 +106,56 @@ type RevocationResponse struct {
 
 // SESSION_TYPES are predefined session authentication method types
 const (
-	SESSION_TYPE_OTP       = "otp"
-	SESSION_TYPE_WEBAUTHN  = "webauthn"
-	SESSION_TYPE_PASSWORD  = "password"
-	SESSION_TYPE_OAUTH     = "oauth"
-	SESSION_TYPE_SSO       = "sso"
-	SESSION_TYPE_TEMPORARY = "temporary"
+	SESSION_TYPE_OTP        = "otp"
+	SESSION_TYPE_WEBAUTHN   = "webauthn"
+	SESSION_TYPE_PASSWORD   = "password"
+	SESSION_TYPE_OAUTH      = "oauth"
+	SESSION_TYPE_SSO        = "sso"
+	SESSION_TYPE_TEMPORARY  = "temporary"
+	SESSION_TYPE_TOTP       = "totp"
+	SESSION_TYPE_MAGIC_LINK = "magic_link"
+	SESSION_TYPE_RECOVERY   = "recovery"
 )
 
 // SessionRecord represents a session stored in the database
 // Maps to AuthSession in Dgraph schema
 type SessionRecord struct {
-	UID        string    `json:"uid,omitempty"`
-	User       string    `json:"user"`              // UID reference to user
-	Method     string    `json:"method"`            // Authentication method
-	TokenHash  string    `json:"tokenHash"`         // Internal use only - not in Dgraph schema
-	CreatedAt  time.Time `json:"createdAt"`         // Maps to createdAt in Dgraph
-	ExpiresAt  time.Time `json:"expiresAt"`         // Maps to expiresAt in Dgraph
-	IPAddress  string    `json:"ipAddress,omitempty"`
-	UserAgent  string    `json:"userAgent,omitempty"`
-	DeviceID   string    `json:"deviceId,omitempty"` // Note: ID not Info to match schema
-	Origin     string    `json:"origin,omitempty"`
-	GeoLocation string   `json:"geoLocation,omitempty"` // UID reference to GeoLocation
-	TLSCipher  string    `json:"tlsCipher,omitempty"`
-	Valid      bool      `json:"valid"`             // Internal use only - not in Dgraph schema
-	LastUsed   time.Time `json:"lastUsed,omitempty"` // Internal use only - not in Dgraph schema
+	UID          string    `json:"uid,omitempty"`
+	User         *UserRef  `json:"user,omitempty"` // uid edge to the User
+	Method       string    `json:"method"`         // Authentication method
+	TokenHash    string    `json:"tokenHash"`      // SHA-256 of the access token
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	IPAddress    string    `json:"ipAddress,omitempty"`
+	UserAgent    string    `json:"userAgent,omitempty"`
+	DeviceID     string    `json:"deviceId,omitempty"` // SessionRequest.DeviceInfo
+	Origin       string    `json:"origin,omitempty"`
+	GeoLocation  string    `json:"geoLocation,omitempty"` // UID reference to GeoLocation
+	TLSCipher    string    `json:"tlsCipher,omitempty"`
+	CredentialID string    `json:"credentialId,omitempty"`
+	FamilyID     string    `json:"familyId,omitempty"` // sid claim
+	Valid        bool      `json:"valid"`              // false once revoked, refreshed or stepped up
+	LastUsedAt   time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// UserRef is a uid edge to a User
+type UserRef struct {
+	UID string `json:"uid"`
+	DID string `json:"did,omitempty"`
+}
+
+// SessionInfo describes one signed-in device for the session inventory.
+// A session lives as long as its refresh token family.
+type SessionInfo struct {
+	SessionID       string    `json:"sessionID"` // sid claim
+	Current         bool      `json:"current"`   // the session making the request
+	Method          string    `json:"method,omitempty"`
+	Browser         string    `json:"browser"`
+	OS              string    `json:"os"`
+	Device          string    `json:"device"` // desktop, mobile or tablet
+	IPAddress       string    `json:"ipAddress,omitempty"`
+	Location        string    `json:"location,omitempty"` // approximate, from the IP address
+	AuthenticatedAt time.Time `json:"authenticatedAt"`    // sign-in or last step-up
+	LastUsedAt      time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt       time.Time `json:"expiresAt"` // when the refresh token lapses if unused
 }
@@ -0,0 +1,78 @@
+package ChronosSession
+
+import (
+	"strings"
+)
+
+// Device classes reported in the session inventory
+const (
+	DEVICE_DESKTOP = "desktop"
+	DEVICE_MOBILE  = "mobile"
+	DEVICE_TABLET  = "tablet"
+)
+
+// clientDescription is what the inventory shows about a user agent
+type clientDescription struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+// userAgentRule maps a user agent token to a name. Rules are checked in
+// order, so tokens that other browsers also send (e.g. "Safari") come last.
+type userAgentRule struct {
+	token string
+	name  string
+}
+
+var browserRules = []userAgentRule{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+var osRules = []userAgentRule{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// parseUserAgent picks the browser, OS and device class out of a User-Agent
+// header. It only needs to be good enough for a user to recognise a device.
+func parseUserAgent(userAgent string) clientDescription {
+	desc := clientDescription{Browser: "Unknown browser", OS: "Unknown OS", Device: DEVICE_DESKTOP}
+	if userAgent == "" {
+		return desc
+	}
+
+	for _, rule := range browserRules {
+		if strings.Contains(userAgent, rule.token) {
+			desc.Browser = rule.name
+			break
+		}
+	}
+	for _, rule := range osRules {
+		if strings.Contains(userAgent, rule.token) {
+			desc.OS = rule.name
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(userAgent, "iPad") || (strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile")):
+		desc.Device = DEVICE_TABLET
+	case strings.Contains(userAgent, "Mobile") || strings.Contains(userAgent, "iPhone"):
+		desc.Device = DEVICE_MOBILE
+	}
+	return desc
+}
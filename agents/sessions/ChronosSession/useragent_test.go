@@ -0,0 +1,79 @@
+package ChronosSession
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      clientDescription
+	}{
+		{
+			name:      "Chrome on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+			want:      clientDescription{Browser: "Chrome", OS: "Windows", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0",
+			want:      clientDescription{Browser: "Edge", OS: "Windows", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+			want:      clientDescription{Browser: "Safari", OS: "iOS", Device: DEVICE_MOBILE},
+		},
+		{
+			name:      "Chrome on Android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+			want:      clientDescription{Browser: "Chrome", OS: "Android", Device: DEVICE_TABLET},
+		},
+		{
+			name:      "Firefox on macOS",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.5; rv:127.0) Gecko/20100101 Firefox/127.0",
+			want:      clientDescription{Browser: "Firefox", OS: "macOS", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "missing header",
+			userAgent: "",
+			want:      clientDescription{Browser: "Unknown browser", OS: "Unknown OS", Device: DEVICE_DESKTOP},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUserAgent(tt.userAgent); got != tt.want {
+				t.Errorf("parseUserAgent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionIDOfReadsSidClaim(t *testing.T) {
+	chronos := testChronosSession(t)
+
+	resp, err := chronos.IssueSession(context.Background(), &SessionRequest{UserID: "0x1"})
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if got := chronos.sessionIDOf(resp.Token); got != resp.SessionID {
+		t.Errorf("Expected session ID %q, got %q", resp.SessionID, got)
+	}
+	if got := chronos.sessionIDOf("not-a-token"); got != "" {
+		t.Errorf("Expected no session ID for a foreign token, got %q", got)
+	}
+}
+
+func TestRevokeSessionByIDIgnoresUnknownSession(t *testing.T) {
+	// The test double returns no sessions for the user
+	revoked, err := testChronosSession(t).RevokeSessionByID(context.Background(), "0x1", "fam_other", "test")
+	if err != nil {
+		t.Fatalf("RevokeSessionByID failed: %v", err)
+	}
+	if revoked {
+		t.Error("Expected a session the user doesn't own not to be revoked")
+	}
+}
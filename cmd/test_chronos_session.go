@@ -11,10 +11,9 @@ import (
 )
 
 // Test types matching main.go GraphQL types
-type SessionRequest struct {
-	UserID     string `json:"userId"`
-	ChannelDID string `json:"channelDID"`
-	Action     string `json:"action"`
+type CreateSessionRequest struct {
+	OTPCode   string `json:"otpCode"`
+	Recipient string `json:"recipient"`
 }
 
 type SessionResponse struct {
@@ -78,16 +77,16 @@ func main() {
 	fmt.Println(strings.Repeat("=", 50))
 
 	// Test data
-	testUserID := "test-user-123"
-	testChannelDID := "test-channel-did-456"
-	testAction := "signin"
+	// Send a sign-in OTP to the recipient first; the code is consumed here
+	testRecipient := "test@example.com"
+	testOTPCode := "123456"
 
 	var sessionToken string
 	var refreshedToken string
 
 	// Test 1: Session Creation (Issue)
 	fmt.Println("\n📝 Test 1: Session Creation (Issue)")
-	sessionResp, err := testCreateSession(testUserID, testChannelDID, testAction)
+	sessionResp, err := testCreateSession(testRecipient, testOTPCode)
 	if err != nil {
 		fmt.Printf("❌ Session creation failed: %v\n", err)
 		return
@@ -192,9 +191,9 @@ func min(a, b int) int {
 	return b
 }
 
-func testCreateSession(userID, channelDID, action string) (*SessionResponse, error) {
+func testCreateSession(recipient, otpCode string) (*SessionResponse, error) {
 	query := `
-		mutation CreateSession($req: SessionRequest!) {
+		mutation CreateSession($req: CreateSessionRequest!) {
 			createSession(req: $req) {
 				success
 				sessionId
@@ -207,10 +206,9 @@ func testCreateSession(userID, channelDID, action string) (*SessionResponse, err
 	`
 	
 	variables := map[string]interface{}{
-		"req": SessionRequest{
-			UserID:     userID,
-			ChannelDID: channelDID,
-			Action:     action,
+		"req": CreateSessionRequest{
+			OTPCode:   otpCode,
+			Recipient: recipient,
 		},
 	}
 
@@ -0,0 +1,438 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment selects the defaults a deployment starts from
+type Environment string
+
+const (
+	Development Environment = "development"
+	Staging     Environment = "staging"
+	Production  Environment = "production"
+)
+
+// EnvironmentKey names the setting that selects the environment. Production
+// is assumed when it is not set.
+const EnvironmentKey = "APP_ENV"
+
+// EmailProviders are the email providers services/email can send through
+var EmailProviders = []string{"mailersend"}
+
+// Config is the typed configuration shared by every agent and service
+type Config struct {
+	Environment Environment
+	Session     SessionConfig
+	WebAuthn    WebAuthnConfig
+	Email       EmailConfig
+	OTP         OTPConfig
+	OIDC        OIDCConfig
+	Secrets     SecretsConfig
+}
+
+// SessionConfig holds the token lifetimes used by ChronosSession
+type SessionConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// WebAuthnConfig identifies the relying party to authenticators
+type WebAuthnConfig struct {
+	RPID    string
+	RPName  string
+	Origins []string // accepted in clientDataJSON
+	// FIDO MDS3 blob holding the attestation roots of hardware keys, and the
+	// MDS root certificate (PEM) its signature is checked against. Admins and
+	// assessors can't register passkeys without them.
+	MetadataBLOB    string
+	MetadataRootPEM string
+}
+
+// EmailConfig selects the email provider and what is sent through it
+type EmailConfig struct {
+	Provider    string
+	FromAddress string
+	FromName    string
+	Templates   EmailTemplates
+}
+
+// EmailTemplates are provider template IDs. An empty ID falls back to Default.
+type EmailTemplates struct {
+	OTP           string
+	Welcome       string
+	MagicLink     string
+	SecurityAlert string
+	Default       string
+}
+
+// OTPConfig holds the lifetimes of one-time codes and sign-in links
+type OTPConfig struct {
+	CodeTTL          time.Duration
+	MagicLinkTTL     time.Duration
+	MagicLinkBaseURL string // frontend page that receives magic link tokens
+}
+
+// OIDCConfig identifies this deployment as an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer string // iss of ID tokens; relying parties discover the provider from it
+}
+
+// SecretsConfig holds key material and policies. Their formats belong to
+// the packages that use them, which check them through RegisterSecret.
+type SecretsConfig struct {
+	SessionSigningKeys     string // ChronosSession
+	SessionTimeoutPolicies string // ChronosSession; built-in policies when empty
+	RetentionPolicies      string // ThemisLog; built-in policies when empty
+	TOTPEncryptionKey      string // services/totp
+	PIIMasterKeys          string // services/pii
+}
+
+// Lookup returns the value of a setting and whether it is set
+type Lookup func(key string) (string, bool)
+
+// setting reads one key into a Config
+type setting struct {
+	key   string
+	apply func(c *Config, value string) error
+}
+
+// settings are the keys Load reads, besides EnvironmentKey
+var settings = []setting{
+	{"SESSION_ACCESS_TOKEN_TTL", durationSetting(func(c *Config) *time.Duration { return &c.Session.AccessTokenTTL })},
+	{"SESSION_REFRESH_TOKEN_TTL", durationSetting(func(c *Config) *time.Duration { return &c.Session.RefreshTokenTTL })},
+	{"WEBAUTHN_RP_ID", stringSetting(func(c *Config) *string { return &c.WebAuthn.RPID })},
+	{"WEBAUTHN_RP_NAME", stringSetting(func(c *Config) *string { return &c.WebAuthn.RPName })},
+	{"WEBAUTHN_ORIGINS", listSetting(func(c *Config) *[]string { return &c.WebAuthn.Origins })},
+	{"WEBAUTHN_MDS_BLOB", stringSetting(func(c *Config) *string { return &c.WebAuthn.MetadataBLOB })},
+	{"WEBAUTHN_MDS_ROOT_CERT", stringSetting(func(c *Config) *string { return &c.WebAuthn.MetadataRootPEM })},
+	{"EMAIL_PROVIDER", stringSetting(func(c *Config) *string { return &c.Email.Provider })},
+	{"EMAIL_FROM_ADDRESS", stringSetting(func(c *Config) *string { return &c.Email.FromAddress })},
+	{"EMAIL_FROM_NAME", stringSetting(func(c *Config) *string { return &c.Email.FromName })},
+	{"EMAIL_TEMPLATE_OTP", stringSetting(func(c *Config) *string { return &c.Email.Templates.OTP })},
+	{"EMAIL_TEMPLATE_WELCOME", stringSetting(func(c *Config) *string { return &c.Email.Templates.Welcome })},
+	{"EMAIL_TEMPLATE_MAGIC_LINK", stringSetting(func(c *Config) *string { return &c.Email.Templates.MagicLink })},
+	{"EMAIL_TEMPLATE_SECURITY_ALERT", stringSetting(func(c *Config) *string { return &c.Email.Templates.SecurityAlert })},
+	{"EMAIL_TEMPLATE_DEFAULT", stringSetting(func(c *Config) *string { return &c.Email.Templates.Default })},
+	{"OTP_CODE_TTL", durationSetting(func(c *Config) *time.Duration { return &c.OTP.CodeTTL })},
+	{"MAGIC_LINK_TTL", durationSetting(func(c *Config) *time.Duration { return &c.OTP.MagicLinkTTL })},
+	{"MAGIC_LINK_BASE_URL", stringSetting(func(c *Config) *string { return &c.OTP.MagicLinkBaseURL })},
+	{"OIDC_ISSUER", stringSetting(func(c *Config) *string { return &c.OIDC.Issuer })},
+	{"SESSION_SIGNING_KEYS", stringSetting(func(c *Config) *string { return &c.Secrets.SessionSigningKeys })},
+	{"SESSION_TIMEOUT_POLICIES", stringSetting(func(c *Config) *string { return &c.Secrets.SessionTimeoutPolicies })},
+	{"RETENTION_POLICIES", stringSetting(func(c *Config) *string { return &c.Secrets.RetentionPolicies })},
+	{"TOTP_ENCRYPTION_KEY", stringSetting(func(c *Config) *string { return &c.Secrets.TOTPEncryptionKey })},
+	{"PII_MASTER_KEYS", stringSetting(func(c *Config) *string { return &c.Secrets.PIIMasterKeys })},
+}
+
+// secretCheck validates a secret on behalf of the package that uses it
+type secretCheck struct {
+	key      string
+	required bool
+	parse    func(value string) error
+}
+
+var (
+	checksMu     sync.Mutex
+	secretChecks []secretCheck
+)
+
+// RegisterSecret has Validate check a secret with the parser of the package
+// that uses it. Packages register from init, since config can't import them.
+func RegisterSecret(key string, required bool, parse func(value string) error) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	secretChecks = append(secretChecks, secretCheck{key, required, parse})
+}
+
+// secret returns the value of a SecretsConfig setting
+func (c *Config) secret(key string) (string, bool) {
+	switch key {
+	case "SESSION_SIGNING_KEYS":
+		return c.Secrets.SessionSigningKeys, true
+	case "SESSION_TIMEOUT_POLICIES":
+		return c.Secrets.SessionTimeoutPolicies, true
+	case "RETENTION_POLICIES":
+		return c.Secrets.RetentionPolicies, true
+	case "TOTP_ENCRYPTION_KEY":
+		return c.Secrets.TOTPEncryptionKey, true
+	case "PII_MASTER_KEYS":
+		return c.Secrets.PIIMasterKeys, true
+	}
+	return "", false
+}
+
+func stringSetting(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 15m or 720h, got %q", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func listSetting(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+// Defaults returns the built-in configuration of an environment. Staging
+// has no host defaults, so WEBAUTHN_RP_ID, WEBAUTHN_ORIGINS,
+// MAGIC_LINK_BASE_URL and OIDC_ISSUER must be set there.
+func Defaults(env Environment) (*Config, error) {
+	c := &Config{
+		Environment: env,
+		Session: SessionConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPName: "DO Study LMS",
+		},
+		Email: EmailConfig{
+			Provider:    "mailersend",
+			FromAddress: "darren@darkolive.co.uk",
+			FromName:    "DO Study Platform",
+			Templates: EmailTemplates{
+				OTP:     "neqvygm91v8l0p7w",
+				Welcome: "vywj2lpz701g7oqz",
+				Default: "vywj2lpz701g7oqz",
+			},
+		},
+		OTP: OTPConfig{
+			CodeTTL:      5 * time.Minute,
+			MagicLinkTTL: 15 * time.Minute,
+		},
+	}
+
+	switch env {
+	case Production:
+		c.WebAuthn.RPID = "do-study.hypermode.host"
+		c.WebAuthn.Origins = []string{"https://do-study.hypermode.host"}
+		c.OTP.MagicLinkBaseURL = "https://do-study.hypermode.host/auth/magic-link"
+		c.OIDC.Issuer = "https://do-study.hypermode.host"
+	case Staging:
+	case Development:
+		c.WebAuthn.RPID = "localhost"
+		c.WebAuthn.Origins = []string{"http://localhost:3000"}
+		c.OTP.MagicLinkBaseURL = "http://localhost:3000/auth/magic-link"
+		c.OIDC.Issuer = "http://localhost:3000"
+	default:
+		return nil, fmt.Errorf("invalid configuration: unknown %s %q", EnvironmentKey, env)
+	}
+	return c, nil
+}
+
+// Load builds the configuration from the environment's defaults and the
+// settings found by lookup, and validates it
+func Load(lookup Lookup) (*Config, error) {
+	env := Production
+	if value, ok := lookup(EnvironmentKey); ok {
+		env = Environment(value)
+	}
+
+	c, err := Defaults(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		value, ok := lookup(s.key)
+		if !ok {
+			continue
+		}
+		if err := s.apply(c, value); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %s: %w", s.key, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the configuration is complete and consistent
+func (c *Config) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	// Plain http is only accepted for localhost during development
+	allowHTTP := func(u *url.URL) bool {
+		return c.Environment == Development && u.Scheme == "http" && u.Hostname() == "localhost"
+	}
+
+	if _, err := Defaults(c.Environment); err != nil {
+		fail("unknown %s %q", EnvironmentKey, c.Environment)
+	}
+
+	if c.Session.AccessTokenTTL <= 0 || c.Session.RefreshTokenTTL <= 0 {
+		fail("SESSION_ACCESS_TOKEN_TTL and SESSION_REFRESH_TOKEN_TTL must be positive")
+	} else if c.Session.AccessTokenTTL >= c.Session.RefreshTokenTTL {
+		fail("SESSION_ACCESS_TOKEN_TTL must be shorter than SESSION_REFRESH_TOKEN_TTL")
+	}
+
+	// The RP ID is a bare domain; every origin must be on it or a subdomain
+	rpID := c.WebAuthn.RPID
+	switch {
+	case rpID == "":
+		fail("WEBAUTHN_RP_ID is required")
+	case strings.ContainsAny(rpID, ":/ ") || rpID != strings.ToLower(rpID):
+		fail("WEBAUTHN_RP_ID must be a lowercase domain without scheme or port, got %q", rpID)
+	}
+	if c.WebAuthn.RPName == "" {
+		fail("WEBAUTHN_RP_NAME is required")
+	}
+	if len(c.WebAuthn.Origins) == 0 {
+		fail("WEBAUTHN_ORIGINS is required")
+	}
+	for _, origin := range c.WebAuthn.Origins {
+		u, err := url.Parse(origin)
+		switch {
+		case err != nil || u.Host == "" || strings.TrimRight(u.Path, "/") != "":
+			fail("WEBAUTHN_ORIGINS entry %q is not an origin", origin)
+		case u.Scheme != "https" && !allowHTTP(u):
+			fail("WEBAUTHN_ORIGINS entry %q must use https", origin)
+		case rpID != "" && u.Hostname() != rpID && !strings.HasSuffix(u.Hostname(), "."+rpID):
+			fail("WEBAUTHN_ORIGINS entry %q is not on WEBAUTHN_RP_ID %q", origin, rpID)
+		}
+	}
+
+	// The blob is only trusted once its signature checks out against the root
+	if (c.WebAuthn.MetadataBLOB == "") != (c.WebAuthn.MetadataRootPEM == "") {
+		fail("WEBAUTHN_MDS_BLOB and WEBAUTHN_MDS_ROOT_CERT must be set together")
+	} else if c.WebAuthn.MetadataRootPEM != "" {
+		block, _ := pem.Decode([]byte(c.WebAuthn.MetadataRootPEM))
+		if block == nil || block.Type != "CERTIFICATE" {
+			fail("WEBAUTHN_MDS_ROOT_CERT is not a PEM certificate")
+		} else if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			fail("WEBAUTHN_MDS_ROOT_CERT is not a valid certificate: %v", err)
+		}
+	}
+
+	if !contains(EmailProviders, c.Email.Provider) {
+		fail("EMAIL_PROVIDER must be one of %s, got %q", strings.Join(EmailProviders, ", "), c.Email.Provider)
+	}
+	if _, err := mail.ParseAddress(c.Email.FromAddress); err != nil {
+		fail("EMAIL_FROM_ADDRESS %q is not an email address", c.Email.FromAddress)
+	}
+	if c.Email.FromName == "" {
+		fail("EMAIL_FROM_NAME is required")
+	}
+	if c.Email.Templates.Default == "" {
+		fail("EMAIL_TEMPLATE_DEFAULT is required")
+	}
+
+	if c.OTP.CodeTTL <= 0 || c.OTP.MagicLinkTTL <= 0 {
+		fail("OTP_CODE_TTL and MAGIC_LINK_TTL must be positive")
+	}
+	if c.OTP.MagicLinkBaseURL == "" {
+		fail("MAGIC_LINK_BASE_URL is required")
+	} else if u, err := url.Parse(c.OTP.MagicLinkBaseURL); err != nil || u.Host == "" || u.RawQuery != "" {
+		fail("MAGIC_LINK_BASE_URL %q must be an absolute URL without a query", c.OTP.MagicLinkBaseURL)
+	} else if u.Scheme != "https" && !allowHTTP(u) {
+		fail("MAGIC_LINK_BASE_URL %q must use https", c.OTP.MagicLinkBaseURL)
+	}
+
+	// The issuer must compare equal to the iss relying parties expect, so
+	// it has no query, fragment or trailing slash
+	if c.OIDC.Issuer == "" {
+		fail("OIDC_ISSUER is required")
+	} else if u, err := url.Parse(c.OIDC.Issuer); err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(c.OIDC.Issuer, "/") {
+		fail("OIDC_ISSUER %q must be an absolute URL without a query, fragment or trailing slash", c.OIDC.Issuer)
+	} else if u.Scheme != "https" && !allowHTTP(u) {
+		fail("OIDC_ISSUER %q must use https", c.OIDC.Issuer)
+	}
+
+	// Secrets are only checked for the packages linked into this build
+	checksMu.Lock()
+	checks := append([]secretCheck(nil), secretChecks...)
+	checksMu.Unlock()
+	for _, check := range checks {
+		value, ok := c.secret(check.key)
+		switch {
+		case !ok:
+			fail("%s is not a secret setting", check.key)
+		case value == "":
+			if check.required {
+				fail("%s is required", check.key)
+			}
+		default:
+			if err := check.parse(value); err != nil {
+				fail("%v", err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	mu      sync.Mutex
+	current *Config
+)
+
+// Get returns the configuration, loading it on first use. A configuration
+// that fails validation is not cached, so the error is reported on every call.
+func Get() (*Config, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil {
+		return current, nil
+	}
+	c, err := Load(lookupValue)
+	if err != nil {
+		return nil, err
+	}
+	current = c
+	return current, nil
+}
+
+// Set replaces the loaded configuration, e.g. in tests
+func Set(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+	return nil
+}
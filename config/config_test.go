@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupFrom serves settings from a map
+func lookupFrom(values map[string]string) Lookup {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func TestLoadDefaultsToProduction(t *testing.T) {
+	c, err := Load(lookupFrom(nil))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.Environment != Production || c.WebAuthn.RPID != "do-study.hypermode.host" {
+		t.Errorf("Expected production defaults, got %s with RP ID %q", c.Environment, c.WebAuthn.RPID)
+	}
+	if c.Session.AccessTokenTTL != 15*time.Minute || c.OTP.CodeTTL != 5*time.Minute {
+		t.Errorf("Expected the built-in lifetimes, got %v and %v", c.Session.AccessTokenTTL, c.OTP.CodeTTL)
+	}
+}
+
+func TestLoadAppliesSettings(t *testing.T) {
+	c, err := Load(lookupFrom(map[string]string{
+		EnvironmentKey:             "staging",
+		"WEBAUTHN_RP_ID":           "staging.example.com",
+		"WEBAUTHN_ORIGINS":         "https://staging.example.com, https://admin.staging.example.com/",
+		"MAGIC_LINK_BASE_URL":      "https://staging.example.com/auth/magic-link",
+		"OIDC_ISSUER":              "https://staging.example.com",
+		"SESSION_ACCESS_TOKEN_TTL": "5m",
+		"EMAIL_FROM_ADDRESS":       "no-reply@example.com",
+		"EMAIL_TEMPLATE_OTP":       "otp-staging",
+	}))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.Environment != Staging || c.Session.AccessTokenTTL != 5*time.Minute {
+		t.Errorf("Expected staging with a 5m access token, got %s and %v", c.Environment, c.Session.AccessTokenTTL)
+	}
+	if len(c.WebAuthn.Origins) != 2 || c.WebAuthn.Origins[1] != "https://admin.staging.example.com/" {
+		t.Errorf("Expected both origins, got %v", c.WebAuthn.Origins)
+	}
+	if c.Email.FromAddress != "no-reply@example.com" || c.Email.Templates.OTP != "otp-staging" {
+		t.Errorf("Expected email settings to be applied, got %+v", c.Email)
+	}
+}
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{
+			name:   "unknown environment",
+			values: map[string]string{EnvironmentKey: "qa"},
+			want:   "unknown APP_ENV",
+		},
+		{
+			name:   "staging without hosts",
+			values: map[string]string{EnvironmentKey: "staging"},
+			want:   "WEBAUTHN_RP_ID is required",
+		},
+		{
+			name:   "malformed duration",
+			values: map[string]string{"OTP_CODE_TTL": "five minutes"},
+			want:   "OTP_CODE_TTL",
+		},
+		{
+			name:   "access token outlives refresh token",
+			values: map[string]string{"SESSION_ACCESS_TOKEN_TTL": "1000h"},
+			want:   "shorter than SESSION_REFRESH_TOKEN_TTL",
+		},
+		{
+			name:   "origin on another domain",
+			values: map[string]string{"WEBAUTHN_ORIGINS": "https://do-study.hypermode.host.evil.example"},
+			want:   "is not on WEBAUTHN_RP_ID",
+		},
+		{
+			name:   "plain http in production",
+			values: map[string]string{"WEBAUTHN_RP_ID": "localhost", "WEBAUTHN_ORIGINS": "http://localhost:3000"},
+			want:   "must use https",
+		},
+		{
+			name:   "MDS blob without its root",
+			values: map[string]string{"WEBAUTHN_MDS_BLOB": "eyJhbGciOiJFUzI1NiJ9.e30.sig"},
+			want:   "must be set together",
+		},
+		{
+			name:   "MDS root is not PEM",
+			values: map[string]string{"WEBAUTHN_MDS_BLOB": "eyJhbGciOiJFUzI1NiJ9.e30.sig", "WEBAUTHN_MDS_ROOT_CERT": "root"},
+			want:   "WEBAUTHN_MDS_ROOT_CERT is not a PEM certificate",
+		},
+		{
+			name:   "issuer with trailing slash",
+			values: map[string]string{"OIDC_ISSUER": "https://do-study.hypermode.host/"},
+			want:   "OIDC_ISSUER",
+		},
+		{
+			name:   "unsupported provider",
+			values: map[string]string{"EMAIL_PROVIDER": "carrier-pigeon"},
+			want:   "EMAIL_PROVIDER must be one of",
+		},
+		{
+			name:   "sender is not an address",
+			values: map[string]string{"EMAIL_FROM_ADDRESS": "value"},
+			want:   "EMAIL_FROM_ADDRESS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(lookupFrom(tt.values))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDevelopmentAllowsLocalhost(t *testing.T) {
+	c, err := Load(lookupFrom(map[string]string{EnvironmentKey: "development"}))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.WebAuthn.RPID != "localhost" || !strings.HasPrefix(c.OTP.MagicLinkBaseURL, "http://localhost") {
+		t.Errorf("Expected localhost defaults, got %+v", c)
+	}
+}
+
+func TestSetRejectsInvalidConfig(t *testing.T) {
+	c, _ := Defaults(Production)
+	c.WebAuthn.RPID = ""
+	if err := Set(c); err == nil {
+		t.Error("Expected Set to validate the configuration")
+	}
+}
+
+func TestValidateChecksRegisteredSecrets(t *testing.T) {
+	saved := secretChecks
+	t.Cleanup(func() { secretChecks = saved })
+	secretChecks = nil
+
+	RegisterSecret("SESSION_SIGNING_KEYS", true, func(value string) error {
+		if value != "good" {
+			return errors.New("invalid SESSION_SIGNING_KEYS: malformed")
+		}
+		return nil
+	})
+	RegisterSecret("RETENTION_POLICIES", false, func(string) error { return nil })
+
+	if _, err := Load(lookupFrom(nil)); err == nil || !strings.Contains(err.Error(), "SESSION_SIGNING_KEYS is required") {
+		t.Errorf("Expected a missing required secret to be rejected, got %v", err)
+	}
+	if _, err := Load(lookupFrom(map[string]string{"SESSION_SIGNING_KEYS": "bad"})); err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("Expected a malformed secret to be rejected, got %v", err)
+	}
+	c, err := Load(lookupFrom(map[string]string{"SESSION_SIGNING_KEYS": "good"}))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.Secrets.SessionSigningKeys != "good" {
+		t.Errorf("Expected the secret to be loaded, got %q", c.Secrets.SessionSigningKeys)
+	}
+}
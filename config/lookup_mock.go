@@ -0,0 +1,14 @@
+//go:build !wasip1
+
+package config
+
+import "os"
+
+// lookupValue reads a setting from the process environment. The secrets
+// mock outside WASM answers every name, so it isn't consulted here.
+func lookupValue(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value, true
+	}
+	return "", false
+}
@@ -0,0 +1,21 @@
+//go:build wasip1
+
+package config
+
+import (
+	"os"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/secrets"
+)
+
+// lookupValue reads a setting from the Modus secrets, falling back to the
+// process environment. Empty values count as unset.
+func lookupValue(key string) (string, bool) {
+	if value, err := secrets.GetSecretValue(key); err == nil && value != "" {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value, true
+	}
+	return "", false
+}
@@ -64,13 +64,21 @@ extract_predicates_from_types() {
         
         field_line = $0
         
-        # Split on colon to get field name and type
-        split(field_line, parts, ":")
-        if (length(parts) >= 2) {
-            field_name = parts[1]
+        # Set aside any trailing comment so the terminator goes before it
+        comment = ""
+        if (match(field_line, /[[:space:]]*#/)) {
+            comment = substr(field_line, RSTART)
+            field_line = substr(field_line, 1, RSTART-1)
+        }
+        sub(/[[:space:]]*\.$/, "", field_line)
+        
+        # Split on the first colon to get field name and type
+        colon = index(field_line, ":")
+        if (colon > 0) {
+            field_name = substr(field_line, 1, colon-1)
             gsub(/^[[:space:]]+|[[:space:]]+$/, "", field_name)
             
-            type_part = parts[2]
+            type_part = substr(field_line, colon+1)
             gsub(/^[[:space:]]+/, "", type_part)
             
             # Extract type and any directives
@@ -79,10 +87,10 @@ extract_predicates_from_types() {
                 directives = substr(type_part, RSTART)
                 gsub(/[[:space:]]+$/, "", type_only)
                 gsub(/[[:space:]]+$/, "", directives)
-                print field_name ": " type_only " " directives " ."
+                print field_name ": " type_only " " directives " ." comment
             } else {
                 gsub(/[[:space:]]+$/, "", type_part)
-                print field_name ": " type_part " ."
+                print field_name ": " type_part " ." comment
             }
         }
     }'
@@ -135,10 +143,10 @@ process_dql_file() {
         next
     }
     in_type {
-        # Remove @index and other directives from type field definitions
+        # Remove inline comments, then @index and other directives, from type field definitions
+        gsub(/[[:space:]]*#.*$/, "")
         gsub(/@[^[:space:]]*/, "")
-        # Remove periods and inline comments from type fields
-        gsub(/[[:space:]]*\.[[:space:]]*#.*$/, "")
+        # Remove periods from type fields
         gsub(/[[:space:]]*\.[[:space:]]*$/, "")
         gsub(/[[:space:]]+$/, "")
         if (length($0) > 0) print $0
@@ -179,13 +187,18 @@ if [ -s "$TEMP_PREDICATES" ]; then
         
         # Store the full line for this predicate
         # If we already have this predicate, keep the one with more content (likely has @index)
+        definition = $0
+        sub(/[[:space:]]*#.*$/, "", definition)
         if (predicate_name in predicates) {
-            # Keep the longer/more complex definition (usually the one with @index)
-            if (length($0) > length(predicates[predicate_name])) {
+            # Keep the longer/more complex definition (usually the one with @index),
+            # not counting comments
+            if (length(definition) > length(definitions[predicate_name])) {
                 predicates[predicate_name] = $0
+                definitions[predicate_name] = definition
             }
         } else {
             predicates[predicate_name] = $0
+            definitions[predicate_name] = definition
         }
     }
     END {
@@ -210,7 +223,7 @@ fi
 rm -f "$TEMP_PREDICATES" "$TEMP_TYPES"
 
 # Update the date in the header
-sed -i '' "s/Generated on: \$(date)/Generated on: $(date)/" "$OUTPUT_FILE"
+sed -i.tmp "s/Generated on: \$(date)/Generated on: $(date)/" "$OUTPUT_FILE" && rm -f "$OUTPUT_FILE.tmp"
 
 echo ""
 echo "✅ Schema combination completed!"
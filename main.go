@@ -4,11 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
+	themislog "modus/agents/audit/ThemisLog"
 	charonotp "modus/agents/auth/CharonOTP"
 	cerberusmfa "modus/agents/auth/CerberusMFA"
+	hecateregister "modus/agents/auth/HecateRegister"
+	janusoidc "modus/agents/auth/JanusOIDC"
 	chronossession "modus/agents/sessions/ChronosSession"
+	"modus/config"
+	"modus/services/gdpr"
 	"modus/services/webauthn"
 )
 
@@ -19,21 +25,26 @@ import (
 type OTPRequest struct {
 	Channel   string `json:"channel"`
 	Recipient string `json:"recipient"`
+	IPAddress string `json:"ipAddress,omitempty"` // client IP, used for per-IP send limits
+	Purpose   string `json:"purpose,omitempty"`   // "signin" (default), "signup", "recovery", "step-up", "channel-add" or "account-delete"
 }
 
 // OTPResponse represents the response from OTP generation and sending
 type OTPResponse struct {
-	OTPID     string    `json:"oTPID"`
-	Sent      bool      `json:"sent"`
-	Channel   string    `json:"channel"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	Message   string    `json:"message,omitempty"`
+	OTPID      string    `json:"oTPID"`
+	Sent       bool      `json:"sent"`
+	Channel    string    `json:"channel"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Message    string    `json:"message,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`  // "RATE_LIMITED" when throttled
+	RetryAfter int       `json:"retryAfter,omitempty"` // seconds until another OTP can be sent
 }
 
 // VerifyOTPRequest represents the request to verify an OTP
 type VerifyOTPRequest struct {
 	OTPCode   string `json:"otpCode"`
 	Recipient string `json:"recipient"`
+	Purpose   string `json:"purpose,omitempty"` // must match the purpose the OTP was sent for
 }
 
 // VerifyOTPResponse represents the response after OTP verification
@@ -43,6 +54,32 @@ type VerifyOTPResponse struct {
 	UserID     string `json:"userId,omitempty"`
 	Action     string `json:"action,omitempty"`     // "signin" or "register"
 	ChannelDID string `json:"channelDID,omitempty"` // Unique identifier for the channel
+	Purpose    string `json:"purpose,omitempty"`
+	ErrorCode  string `json:"errorCode,omitempty"`  // "LOCKED_OUT" when the channel is locked
+	RetryAfter int    `json:"retryAfter,omitempty"` // seconds until the lockout ends
+}
+
+// MagicLinkRequest represents the request to email a sign-in link
+type MagicLinkRequest struct {
+	Recipient string `json:"recipient"`
+	Purpose   string `json:"purpose,omitempty"`   // same values as OTPRequest.Purpose
+	DeviceID  string `json:"deviceId,omitempty"`  // binds the link to the requesting device
+	IPAddress string `json:"ipAddress,omitempty"` // client IP, used for per-IP send limits
+}
+
+// MagicLinkResponse represents the response after a sign-in link is sent
+type MagicLinkResponse struct {
+	Sent       bool      `json:"sent"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Message    string    `json:"message,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`
+	RetryAfter int       `json:"retryAfter,omitempty"`
+}
+
+// VerifyMagicLinkRequest represents the token from a clicked sign-in link
+type VerifyMagicLinkRequest struct {
+	Token    string `json:"token"`
+	DeviceID string `json:"deviceId,omitempty"`
 }
 
 // CerberusMFARequest represents the request for MFA flow decision
@@ -65,7 +102,7 @@ type CerberusMFAResponse struct {
 
 // WebAuthnChallengeRequest represents a request for WebAuthn challenge
 type WebAuthnChallengeRequest struct {
-	UserID      string `json:"userId"`
+	AccessToken string `json:"accessToken"`
 	Username    string `json:"username"`
 	DisplayName string `json:"displayName"`
 }
@@ -101,6 +138,7 @@ type PubKeyCredParam struct {
 type AuthenticatorSelection struct {
 	AuthenticatorAttachment string `json:"authenticatorAttachment,omitempty"`
 	RequireResidentKey      bool   `json:"requireResidentKey"`
+	ResidentKey             string `json:"residentKey,omitempty"`
 	UserVerification        string `json:"userVerification"`
 }
 
@@ -112,10 +150,13 @@ type PublicKeyCredDescriptor struct {
 
 // WebAuthnRegistrationRequest represents a WebAuthn registration request
 type WebAuthnRegistrationRequest struct {
-	UserID            string `json:"userId"`
+	AccessToken       string `json:"accessToken"`
 	Challenge         string `json:"challenge"`
 	ClientDataJSON    string `json:"clientDataJSON"`
 	AttestationObject string `json:"attestationObject"`
+	// Transports is the result of the response's getTransports(), used as
+	// hints when the credential is later allowed in a sign-in challenge
+	Transports []string `json:"transports,omitempty"`
 }
 
 // WebAuthnRegistrationResponse represents a WebAuthn registration response
@@ -124,28 +165,36 @@ type WebAuthnRegistrationResponse struct {
 	CredentialID string `json:"credentialId"`
 	Message      string `json:"message"`
 	UserID       string `json:"userId"`
+	// RecoveryCodes is set on the user's first enrolment; show them once
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
 }
 
 // WebAuthnAuthRequest represents a WebAuthn authentication request
 type WebAuthnAuthRequest struct {
 	UserID            string `json:"userId"`
+	CredentialID      string `json:"credentialId"`
 	Challenge         string `json:"challenge"`
 	ClientDataJSON    string `json:"clientDataJSON"`
 	AuthenticatorData string `json:"authenticatorData"`
 	Signature         string `json:"signature"`
 	UserHandle        string `json:"userHandle,omitempty"`
+	IPAddress         string `json:"ipAddress,omitempty"` // client details for the session inventory
+	UserAgent         string `json:"userAgent,omitempty"`
+	ClientType        string `json:"clientType,omitempty"` // web, mobile or admin_console; selects the timeout policy
 }
 
 // WebAuthnAuthResponse represents a WebAuthn authentication response
 type WebAuthnAuthResponse struct {
-	Success     bool   `json:"success"`
-	UserID      string `json:"userId"`
-	Message     string `json:"message"`
-	SessionID   string `json:"sessionId,omitempty"`
-	AccessToken string `json:"accessToken,omitempty"`
+	Success      bool   `json:"success"`
+	UserID       string `json:"userId"`
+	Message      string `json:"message"`
+	SessionID    string `json:"sessionId,omitempty"`
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
-// WebAuthnAssertionChallengeRequest represents a request for assertion challenge
+// WebAuthnAssertionChallengeRequest represents a request for assertion challenge.
+// Leave UserID empty for usernameless passkey sign-in.
 type WebAuthnAssertionChallengeRequest struct {
 	UserID string `json:"userId,omitempty"`
 }
@@ -159,13 +208,187 @@ type WebAuthnAssertionChallengeResponse struct {
 	UserVerification string                    `json:"userVerification"`
 }
 
+// WebAuthnChallengeSweepRequest removes expired WebAuthn challenges (admin only)
+type WebAuthnChallengeSweepRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// WebAuthnCredentialsRequest lists the authenticators of the signed-in user
+type WebAuthnCredentialsRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// WebAuthnCredentialInfo describes a registered authenticator
+type WebAuthnCredentialInfo struct {
+	CredentialID      string   `json:"credentialId"`
+	Nickname          string   `json:"nickname,omitempty"`
+	AuthenticatorName string   `json:"authenticatorName"`
+	AAGUID            string   `json:"aaguid,omitempty"`
+	AttestationFormat string   `json:"attestationFormat,omitempty"`
+	Transports        []string `json:"transports,omitempty"`
+	CloneWarning      bool     `json:"cloneWarning"`
+	CreatedAt         string   `json:"createdAt"`
+	LastUsedAt        string   `json:"lastUsedAt,omitempty"`
+}
+
+// RenameWebAuthnCredentialRequest sets the nickname of an authenticator
+type RenameWebAuthnCredentialRequest struct {
+	AccessToken  string `json:"accessToken"`
+	CredentialID string `json:"credentialId"`
+	Nickname     string `json:"nickname"`
+}
+
+// RevokeWebAuthnCredentialRequest removes an authenticator. OTPCode and
+// Recipient are only needed when removing the user's last authenticator,
+// and the OTP must have been sent with purpose "step-up".
+type RevokeWebAuthnCredentialRequest struct {
+	AccessToken  string `json:"accessToken"`
+	CredentialID string `json:"credentialId"`
+	Reason       string `json:"reason,omitempty"`
+	OTPCode      string `json:"otpCode,omitempty"`
+	Recipient    string `json:"recipient,omitempty"`
+}
+
+// WebAuthnCredentialResponse represents the result of a credential change
+type WebAuthnCredentialResponse struct {
+	Success      bool   `json:"success"`
+	CredentialID string `json:"credentialId,omitempty"`
+	Message      string `json:"message"`
+}
+
+// TOTP Types
+
+// TOTPEnrollmentRequest starts authenticator app enrolment for the signed-in user
+type TOTPEnrollmentRequest struct {
+	AccessToken string `json:"accessToken"`
+	AccountName string `json:"accountName"` // label shown in the app, e.g. the user's email
+}
+
+// TOTPEnrollmentResponse carries the secret for the authenticator app
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	Issuer string `json:"issuer"`
+	Digits int    `json:"digits"`
+	Period int    `json:"period"`
+}
+
+// ConfirmTOTPEnrollmentRequest confirms enrolment with a first code from the app
+type ConfirmTOTPEnrollmentRequest struct {
+	AccessToken string `json:"accessToken"`
+	Code        string `json:"code"`
+}
+
+// TOTPVerifyResponse represents the result of a TOTP check
+type TOTPVerifyResponse struct {
+	Success bool   `json:"success"`
+	UserID  string `json:"userId,omitempty"`
+	Message string `json:"message"`
+	// RecoveryCodes is set when enrolment issued the user's first codes
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// Account Recovery Types
+
+// StartAccountRecoveryRequest starts recovery with an OTP sent with purpose "recovery"
+type StartAccountRecoveryRequest struct {
+	Recipient string `json:"recipient"`
+	OTPCode   string `json:"otpCode"`
+}
+
+// CompleteAccountRecoveryRequest finishes recovery with a recovery code, or
+// without one once an admin has approved the request
+type CompleteAccountRecoveryRequest struct {
+	RecoveryToken string `json:"recoveryToken"`
+	RecoveryCode  string `json:"recoveryCode,omitempty"`
+	Recipient     string `json:"recipient,omitempty"` // address the recovery OTP went to, for the security alert
+	IPAddress     string `json:"ipAddress,omitempty"` // client details for the session inventory
+	UserAgent     string `json:"userAgent,omitempty"`
+	ClientType    string `json:"clientType,omitempty"` // web, mobile or admin_console; selects the timeout policy
+}
+
+// AccountRecoveryApprovalRequest asks an admin to approve a recovery
+type AccountRecoveryApprovalRequest struct {
+	RecoveryToken string `json:"recoveryToken"`
+	Reason        string `json:"reason"`
+}
+
+// PendingAccountRecoveriesRequest lists recoveries awaiting approval (admins only)
+type PendingAccountRecoveriesRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// PendingAccountRecovery describes a recovery awaiting approval
+type PendingAccountRecovery struct {
+	RecoveryID string `json:"recoveryId"`
+	UserID     string `json:"userId"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"createdAt"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
+// ApproveAccountRecoveryRequest approves a recovery (admins only)
+type ApproveAccountRecoveryRequest struct {
+	AccessToken string `json:"accessToken"`
+	RecoveryID  string `json:"recoveryId"`
+}
+
+// AccountRecoveryResponse represents the state of an account recovery
+type AccountRecoveryResponse struct {
+	Success            bool   `json:"success"`
+	Status             string `json:"status,omitempty"`
+	RecoveryToken      string `json:"recoveryToken,omitempty"`
+	ExpiresAt          string `json:"expiresAt,omitempty"`
+	UserID             string `json:"userId,omitempty"`
+	RevokedSessions    int    `json:"revokedSessions,omitempty"`
+	RevokedCredentials int    `json:"revokedCredentials,omitempty"`
+	Message            string `json:"message"`
+	SessionID          string `json:"sessionId,omitempty"`
+	AccessToken        string `json:"accessToken,omitempty"`
+	RefreshToken       string `json:"refreshToken,omitempty"`
+}
+
+// RecoveryCodesRequest regenerates the signed-in user's recovery codes
+type RecoveryCodesRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// RecoveryCodesResponse carries freshly issued recovery codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+	Message       string   `json:"message"`
+}
+
 // Session Management Types
 
+// CreateSessionRequest signs a user in with proof that they hold a channel:
+// the OTP code sent to Recipient, or a magic link token. The session is for
+// the user the channel belongs to; a new user is registered on it first.
+type CreateSessionRequest struct {
+	OTPCode        string `json:"otpCode,omitempty"`
+	Recipient      string `json:"recipient,omitempty"`
+	Purpose        string `json:"purpose,omitempty"` // "signin" (default) or "signup"
+	MagicLinkToken string `json:"magicLinkToken,omitempty"`
+	DeviceID       string `json:"deviceId,omitempty"` // as sent with the magic link request
+	// Client details for the session inventory and sign-in notifications
+	IPAddress string `json:"ipAddress,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// ClientType (web, mobile or admin_console) selects the timeout policy
+	ClientType string `json:"clientType,omitempty"`
+}
+
 // SessionRequest represents a request to create a session after successful authentication
 type SessionRequest struct {
 	UserID     string `json:"userId"`
 	ChannelDID string `json:"channelDID"`
 	Action     string `json:"action"` // "signin" or "register"
+	// CredentialID binds the session to the WebAuthn credential used, if any
+	CredentialID string `json:"credentialId,omitempty"`
+	// Client details for the session inventory and sign-in notifications
+	IPAddress string `json:"ipAddress,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// ClientType (web, mobile or admin_console) selects the timeout policy
+	ClientType string `json:"clientType,omitempty"`
 }
 
 // SessionResponse represents the response containing session information
@@ -176,6 +399,14 @@ type SessionResponse struct {
 	ExpiresAt   int64  `json:"expiresAt"`
 	Message     string `json:"message"`
 	UserID      string `json:"userId"`
+	AAL         int    `json:"aal"` // authenticator assurance level (1-3)
+	// RefreshToken is single-use: RefreshSession returns a new one each time
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt"`
+	// SessionExpiresAt is when the user must sign in again, however often
+	// the session is refreshed; IdleTimeout is in seconds
+	SessionExpiresAt int64 `json:"sessionExpiresAt,omitempty"`
+	IdleTimeout      int64 `json:"idleTimeout,omitempty"`
 }
 
 // ValidateSessionRequest represents a request to validate an existing session
@@ -201,22 +432,73 @@ type ValidationRequest struct {
 
 // ValidationResponse for ChronosSession token validation results
 type ValidationResponse struct {
-	Valid     bool   `json:"valid"`
-	UserID    string `json:"userId,omitempty"`
-	ExpiresAt int64  `json:"expiresAt,omitempty"`
-	Message   string `json:"message,omitempty"`
+	Valid     bool     `json:"valid"`
+	UserID    string   `json:"userId,omitempty"`
+	ExpiresAt int64    `json:"expiresAt,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	AAL       int      `json:"aal,omitempty"`
+	AuthTime  int64    `json:"authTime,omitempty"`
+}
+
+// SessionAssuranceRequest checks a session before a sensitive action
+type SessionAssuranceRequest struct {
+	AccessToken   string `json:"accessToken"`
+	RequiredAAL   int    `json:"requiredAal"`
+	MaxAgeSeconds int    `json:"maxAgeSeconds,omitempty"` // 0 means no freshness requirement
 }
 
-// RefreshRequest for extending an existing session
+// SessionAssuranceResponse tells the client whether to prompt for step-up
+type SessionAssuranceResponse struct {
+	Satisfied      bool     `json:"satisfied"`
+	StepUpRequired bool     `json:"stepUpRequired"`
+	AMR            []string `json:"amr,omitempty"`
+	AAL            int      `json:"aal"`
+	AuthTime       int64    `json:"authTime,omitempty"`
+	Message        string   `json:"message"`
+}
+
+// StepUpTOTPRequest raises a session with an authenticator app code
+type StepUpTOTPRequest struct {
+	AccessToken string `json:"accessToken"`
+	Code        string `json:"code"`
+}
+
+// StepUpWebAuthnRequest raises a session with a WebAuthn assertion
+type StepUpWebAuthnRequest struct {
+	AccessToken string              `json:"accessToken"`
+	Assertion   WebAuthnAuthRequest `json:"assertion"`
+}
+
+// JSONWebKey is a public session signing key (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JSONWebKeySet is the JWKS document for session tokens
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// RefreshRequest exchanges a refresh token for a new token pair
 type RefreshRequest struct {
-	Token string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 // RefreshResponse for session refresh results
 type RefreshResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expiresAt"`
-	Message   string `json:"message,omitempty"`
+	Token            string `json:"token"`
+	ExpiresAt        int64  `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt"`
+	SessionExpiresAt int64  `json:"sessionExpiresAt,omitempty"`
+	Message          string `json:"message,omitempty"`
 }
 
 // RevocationRequest for revoking a session
@@ -232,13 +514,448 @@ type RevocationResponse struct {
 	Timestamp string `json:"timestamp,omitempty"`
 }
 
+// SessionListRequest lists the signed-in sessions of the token's user
+type SessionListRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// SessionInfo describes one signed-in session in the inventory
+type SessionInfo struct {
+	SessionID       string `json:"sessionId"`
+	Current         bool   `json:"current"`
+	Method          string `json:"method,omitempty"`
+	Browser         string `json:"browser"`
+	OS              string `json:"os"`
+	Device          string `json:"device"`
+	IPAddress       string `json:"ipAddress,omitempty"`
+	Location        string `json:"location,omitempty"`
+	AuthenticatedAt string `json:"authenticatedAt"`
+	LastUsedAt      string `json:"lastUsedAt,omitempty"`
+	ExpiresAt       string `json:"expiresAt"`
+}
+
+// SignOutSessionRequest signs out one of the token user's sessions
+type SignOutSessionRequest struct {
+	AccessToken string `json:"accessToken"`
+	SessionID   string `json:"sessionId"`
+}
+
+// SignOutOtherSessionsRequest signs out everywhere but the current session
+type SignOutOtherSessionsRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// AdminSignOutRequest signs out every session of a user, a role or a centre
+type AdminSignOutRequest struct {
+	AccessToken string `json:"accessToken"`
+	UserID      string `json:"userId,omitempty"`
+	Role        string `json:"role,omitempty"`
+	CentreID    string `json:"centreId,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// SessionMigrationRequest converts legacy session records (admin only)
+type SessionMigrationRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// SessionMigrationResponse for session migration results
+type SessionMigrationResponse struct {
+	Migrated int    `json:"migrated"`
+	Orphaned int    `json:"orphaned"`
+	Message  string `json:"message"`
+}
+
+// AuditChainVerificationRequest walks the audit hash chain (admin only)
+type AuditChainVerificationRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// AuditChainProblem is one place where the audit chain doesn't hold
+type AuditChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	EntryID  string `json:"entryId"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+// AuditChainVerificationResponse for audit chain verification results
+type AuditChainVerificationResponse struct {
+	Valid          bool                `json:"valid"`
+	EntriesChecked int                 `json:"entriesChecked"`
+	HeadSequence   int64               `json:"headSequence"`
+	Problems       []AuditChainProblem `json:"problems"`
+	VerifiedAt     string              `json:"verifiedAt"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything; from
+// (inclusive) and to (exclusive) are RFC3339 times.
+type AuditFilter struct {
+	Category    string `json:"category"`
+	Action      string `json:"action"`
+	ObjectType  string `json:"objectType"`
+	ObjectID    string `json:"objectId"`
+	PerformedBy string `json:"performedBy"`
+	Severity    string `json:"severity"`
+	Source      string `json:"source"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+}
+
+// AuditQueryRequest reads audit entries, newest first (admin and verifier only)
+type AuditQueryRequest struct {
+	AccessToken string      `json:"accessToken"`
+	Filter      AuditFilter `json:"filter"`
+	Cursor      string      `json:"cursor"` // nextCursor of the previous page
+	Limit       int         `json:"limit"`
+}
+
+// AuditEntryInfo is one entry of the audit trail
+type AuditEntryInfo struct {
+	Sequence      int64  `json:"sequence"`
+	ID            string `json:"id"`
+	PreviousHash  string `json:"previousHash"`
+	Hash          string `json:"hash"`
+	Category      string `json:"category"`
+	Action        string `json:"action"`
+	ObjectType    string `json:"objectType"`
+	ObjectID      string `json:"objectId"`
+	PerformedBy   string `json:"performedBy"`
+	Timestamp     string `json:"timestamp"`
+	Details       string `json:"details"`
+	PreviousValue string `json:"previousValue"`
+	NewValue      string `json:"newValue"`
+	Severity      string `json:"severity"`
+	Source        string `json:"source"`
+}
+
+// AuditQueryResponse for audit entry queries
+type AuditQueryResponse struct {
+	Entries    []AuditEntryInfo `json:"entries"`
+	NextCursor string           `json:"nextCursor"`
+}
+
+// AuditActionCountRequest counts audit entries per action and day (admin
+// and verifier only); from and to are required and at most a year apart
+type AuditActionCountRequest struct {
+	AccessToken string      `json:"accessToken"`
+	Filter      AuditFilter `json:"filter"`
+}
+
+// AuditActionCount is how often an action was logged on a day (UTC)
+type AuditActionCount struct {
+	Day    string `json:"day"`
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+}
+
+// AuditExportRequest exports audit entries as "csv" or "jsonl" (admin and
+// verifier only)
+type AuditExportRequest struct {
+	AccessToken string      `json:"accessToken"`
+	Filter      AuditFilter `json:"filter"`
+	Format      string      `json:"format"`
+}
+
+// AuditExportManifest describes an export's content
+type AuditExportManifest struct {
+	FileName      string `json:"fileName"`
+	Format        string `json:"format"`
+	EntryCount    int    `json:"entryCount"`
+	FirstSequence int64  `json:"firstSequence"`
+	LastSequence  int64  `json:"lastSequence"`
+	HeadSequence  int64  `json:"headSequence"`
+	HeadHash      string `json:"headHash"`
+	ContentSHA256 string `json:"contentSha256"`
+	ExportedBy    string `json:"exportedBy"`
+	ExportedAt    string `json:"exportedAt"`
+}
+
+// AuditExportResponse for audit exports. The signature is a JWS over the
+// manifest, verifiable with GetSessionJWKS.
+type AuditExportResponse struct {
+	Manifest  AuditExportManifest `json:"manifest"`
+	Content   string              `json:"content"`
+	Signature string              `json:"signature"`
+}
+
+// LegalHoldRequest places a legal hold on a user or a centre (admin only)
+type LegalHoldRequest struct {
+	AccessToken string `json:"accessToken"`
+	UserID      string `json:"userId"`   // set either userId
+	CentreID    string `json:"centreId"` // or centreId
+	Reason      string `json:"reason"`
+}
+
+// LegalHoldReleaseRequest releases a legal hold (admin only)
+type LegalHoldReleaseRequest struct {
+	AccessToken string `json:"accessToken"`
+	HoldID      string `json:"holdId"`
+	Reason      string `json:"reason"`
+}
+
+// LegalHoldListRequest lists legal holds (admin and verifier only)
+type LegalHoldListRequest struct {
+	AccessToken string `json:"accessToken"`
+	ActiveOnly  bool   `json:"activeOnly"`
+}
+
+// LegalHoldInfo describes a legal hold
+type LegalHoldInfo struct {
+	HoldID     string `json:"holdId"`
+	UserID     string `json:"userId"`
+	CentreID   string `json:"centreId"`
+	Reason     string `json:"reason"`
+	PlacedBy   string `json:"placedBy"`
+	PlacedAt   string `json:"placedAt"`
+	Active     bool   `json:"active"`
+	ReleasedBy string `json:"releasedBy"`
+	ReleasedAt string `json:"releasedAt"`
+}
+
+// UserContactRequest adds a contact detail for the token's user. The value
+// is kept in the PII vault; the contact stores its token.
+type UserContactRequest struct {
+	AccessToken string `json:"accessToken"`
+	ContactType string `json:"contactType"` // EMAIL, PHONE or OTHER
+	Purpose     string `json:"purpose"`     // e.g. SUPPORT, BILLING
+	Value       string `json:"value"`
+}
+
+// EmergencyContactRequest adds an emergency contact for the token's user.
+// Their name, phone and email are kept in the PII vault.
+type EmergencyContactRequest struct {
+	AccessToken  string `json:"accessToken"`
+	ContactName  string `json:"contactName"`
+	Relationship string `json:"relationship"`
+	Phone        string `json:"phone"` // phone, email or both
+	Email        string `json:"email"`
+}
+
+// SubjectAccessRequest exports the token's user's data, or another user's
+// when userId is set (admin only)
+type SubjectAccessRequest struct {
+	AccessToken string `json:"accessToken"`
+	UserID      string `json:"userId,omitempty"`
+}
+
+// SubjectArchiveSection is how many records a section of an archive holds
+type SubjectArchiveSection struct {
+	Section string `json:"section"`
+	Records int    `json:"records"`
+}
+
+// SubjectAccessResponse carries a subject access archive. The content is
+// a JSON document with one section per kind of record.
+type SubjectAccessResponse struct {
+	FileName      string                  `json:"fileName"`
+	ContentType   string                  `json:"contentType"`
+	Content       string                  `json:"content"`
+	ContentSHA256 string                  `json:"contentSha256"`
+	Sections      []SubjectArchiveSection `json:"sections"`
+	GeneratedAt   string                  `json:"generatedAt"`
+}
+
+// DataErasureRequest asks for the token's user's data to be erased. The
+// OTP must have been sent with purpose "account-delete" to recipient.
+type DataErasureRequest struct {
+	AccessToken string `json:"accessToken"`
+	Recipient   string `json:"recipient"`
+	OTPCode     string `json:"otpCode"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// ErasureListRequest lists erasure requests in a status (admin only)
+type ErasureListRequest struct {
+	AccessToken string `json:"accessToken"`
+	Status      string `json:"status,omitempty"` // pending_approval if empty
+}
+
+// ErasureReviewRequest approves or rejects an erasure request (admin only)
+type ErasureReviewRequest struct {
+	AccessToken string `json:"accessToken"`
+	RequestID   string `json:"requestId"`
+	Note        string `json:"note,omitempty"` // required to reject
+}
+
+// ErasureRequestInfo describes an erasure request
+type ErasureRequestInfo struct {
+	RequestID   string `json:"requestId"`
+	UserID      string `json:"userId"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+	ChannelDID  string `json:"channelDID"`
+	CreatedAt   string `json:"createdAt"`
+	DueAt       string `json:"dueAt"`
+	ReviewedBy  string `json:"reviewedBy"`
+	ReviewedAt  string `json:"reviewedAt"`
+	ReviewNote  string `json:"reviewNote"`
+	CompletedAt string `json:"completedAt"`
+}
+
+// ErasedRecordCount is how many records of a type an erasure deleted
+type ErasedRecordCount struct {
+	RecordType string `json:"recordType"`
+	Count      int    `json:"count"`
+}
+
+// ErasureResponse for erasure requests and their review
+type ErasureResponse struct {
+	Success           bool                `json:"success"`
+	Status            string              `json:"status,omitempty"`
+	RequestID         string              `json:"requestId,omitempty"`
+	DueAt             string              `json:"dueAt,omitempty"`
+	Erased            []ErasedRecordCount `json:"erased,omitempty"`
+	Pseudonymised     int                 `json:"pseudonymised,omitempty"` // progress records kept without IP or user agent
+	VaultValuesErased int                 `json:"vaultValuesErased,omitempty"`
+	Message           string              `json:"message"`
+}
+
+// RetentionResultInfo is what one retention policy removed
+type RetentionResultInfo struct {
+	RecordType string `json:"recordType"`
+	Category   string `json:"category"`
+	Cutoff     string `json:"cutoff"`
+	Removed    int    `json:"removed"`
+	Sequences  string `json:"sequences"` // archived audit entries, e.g. "1-40,52"
+}
+
+// RetentionRunRequest applies the retention policies (admin only)
+type RetentionRunRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// RetentionRunResponse summarises a retention run
+type RetentionRunResponse struct {
+	StartedAt      string                `json:"startedAt"`
+	FinishedAt     string                `json:"finishedAt"`
+	ActiveHolds    int                   `json:"activeHolds"`
+	HeldUsers      int                   `json:"heldUsers"`
+	Results        []RetentionResultInfo `json:"results"`
+	SummaryEntryID string                `json:"summaryEntryId"`
+}
+
+// SignOutResponse for session sign-out results
+type SignOutResponse struct {
+	Success         bool   `json:"success"`
+	UsersAffected   int    `json:"usersAffected,omitempty"`
+	SessionsRevoked int    `json:"sessionsRevoked"`
+	Message         string `json:"message"`
+}
+
+// OAuthClientRegistrationRequest registers a relying party (admin only)
+type OAuthClientRegistrationRequest struct {
+	AccessToken  string   `json:"accessToken"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+	Trusted      bool     `json:"trusted"`
+}
+
+// OAuthClientRegistrationResponse carries the new client's credentials.
+// The secret is shown only once.
+type OAuthClientRegistrationResponse struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// OAuthAuthorizeRequest is an authorization request from the frontend's
+// authorize page, with the user's session if they are signed in
+type OAuthAuthorizeRequest struct {
+	SessionToken        string `json:"sessionToken,omitempty"`
+	ClientID            string `json:"clientId"`
+	RedirectURI         string `json:"redirectUri"`
+	ResponseType        string `json:"responseType"`
+	Scope               string `json:"scope"`
+	State               string `json:"state,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"codeChallenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod"`
+	Prompt              string `json:"prompt,omitempty"`
+	MaxAge              int64  `json:"maxAge,omitempty"`
+	Consent             string `json:"consent,omitempty"` // "approve" or "deny"
+}
 
+// OAuthAuthorizeResponse says where to send the browser, or that the user
+// must sign in or consent first
+type OAuthAuthorizeResponse struct {
+	RedirectURL     string   `json:"redirectUrl,omitempty"`
+	LoginRequired   bool     `json:"loginRequired"`
+	ConsentRequired bool     `json:"consentRequired"`
+	ClientName      string   `json:"clientName"`
+	Scopes          []string `json:"scopes"`
+}
+
+// OAuthTokenRequest is a token endpoint request
+type OAuthTokenRequest struct {
+	GrantType    string `json:"grantType"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RedirectURI  string `json:"redirectUri,omitempty"`
+	CodeVerifier string `json:"codeVerifier,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// OAuthTokenResponse for token endpoint results
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	IDToken      string `json:"idToken,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthConsentRevocationRequest withdraws the token user's consent for a client
+type OAuthConsentRevocationRequest struct {
+	AccessToken string `json:"accessToken"`
+	ClientID    string `json:"clientId"`
+}
+
+// OAuthUserInfoRequest asks for the claims of a client's access token
+type OAuthUserInfoRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+// OAuthUserInfo is the userinfo response; claims outside the token's
+// scopes are left empty
+type OAuthUserInfo struct {
+	Sub           string   `json:"sub"`
+	Name          string   `json:"name,omitempty"`
+	GivenName     string   `json:"givenName,omitempty"`
+	FamilyName    string   `json:"familyName,omitempty"`
+	Zoneinfo      string   `json:"zoneinfo,omitempty"`
+	Locale        string   `json:"locale,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"emailVerified"`
+	Roles         []string `json:"roles,omitempty"`
+}
+
+// OpenIDConfiguration is the provider's discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorizationEndpoint"`
+	TokenEndpoint                     string   `json:"tokenEndpoint"`
+	UserInfoEndpoint                  string   `json:"userinfoEndpoint"`
+	JWKSURI                           string   `json:"jwksUri"`
+	ScopesSupported                   []string `json:"scopesSupported"`
+	ResponseTypesSupported            []string `json:"responseTypesSupported"`
+	GrantTypesSupported               []string `json:"grantTypesSupported"`
+	SubjectTypesSupported             []string `json:"subjectTypesSupported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"idTokenSigningAlgValuesSupported"`
+	TokenEndpointAuthMethodsSupported []string `json:"tokenEndpointAuthMethodsSupported"`
+	CodeChallengeMethodsSupported     []string `json:"codeChallengeMethodsSupported"`
+	ClaimsSupported                   []string `json:"claimsSupported"`
+}
 
 // Convert main package verify types to charonotp package types
 func convertToCharonVerifyRequest(req VerifyOTPRequest) charonotp.VerifyOTPRequest {
 	return charonotp.VerifyOTPRequest{
 		OTPCode:   req.OTPCode,
 		Recipient: req.Recipient,
+		Purpose:   req.Purpose,
 	}
 }
 
@@ -249,6 +966,9 @@ func convertFromCharonVerifyResponse(resp charonotp.VerifyOTPResponse) VerifyOTP
 		UserID:     resp.UserID,
 		Action:     resp.Action,
 		ChannelDID: resp.ChannelDID,
+		Purpose:    resp.Purpose,
+		ErrorCode:  resp.ErrorCode,
+		RetryAfter: resp.RetryAfter,
 	}
 }
 
@@ -258,6 +978,8 @@ func SendOTP(req OTPRequest) (OTPResponse, error) {
 	charonReq := charonotp.OTPRequest{
 		Channel:   req.Channel,
 		Recipient: req.Recipient,
+		IPAddress: req.IPAddress,
+		Purpose:   req.Purpose,
 	}
 	
 	// Call the charonotp agent to send OTP
@@ -268,11 +990,13 @@ func SendOTP(req OTPRequest) (OTPResponse, error) {
 	
 	// Convert response back to main types
 	return OTPResponse{
-		OTPID:     resp.OTPID,
-		Sent:      resp.Sent,
-		Channel:   resp.Channel,
-		ExpiresAt: resp.ExpiresAt,
-		Message:   resp.Message,
+		OTPID:      resp.OTPID,
+		Sent:       resp.Sent,
+		Channel:    resp.Channel,
+		ExpiresAt:  resp.ExpiresAt,
+		Message:    resp.Message,
+		ErrorCode:  resp.ErrorCode,
+		RetryAfter: resp.RetryAfter,
 	}, nil
 }
 
@@ -291,6 +1015,41 @@ func VerifyOTP(req VerifyOTPRequest) (VerifyOTPResponse, error) {
 	return convertFromCharonVerifyResponse(resp), nil
 }
 
+// SendMagicLink is the exported wrapper that emails a sign-in link instead of a code
+func SendMagicLink(req MagicLinkRequest) (MagicLinkResponse, error) {
+	resp, err := charonotp.SendMagicLink(context.Background(), charonotp.SendMagicLinkRequest{
+		Recipient: req.Recipient,
+		Purpose:   req.Purpose,
+		DeviceID:  req.DeviceID,
+		IPAddress: req.IPAddress,
+	})
+	if err != nil {
+		return MagicLinkResponse{}, err
+	}
+
+	return MagicLinkResponse{
+		Sent:       resp.Sent,
+		ExpiresAt:  resp.ExpiresAt,
+		Message:    resp.Message,
+		ErrorCode:  resp.ErrorCode,
+		RetryAfter: resp.RetryAfter,
+	}, nil
+}
+
+// VerifyMagicLink is the exported wrapper that verifies a sign-in link token.
+// It returns the same routing as VerifyOTP for CerberusMFA.
+func VerifyMagicLink(req VerifyMagicLinkRequest) (VerifyOTPResponse, error) {
+	resp, err := charonotp.VerifyMagicLink(charonotp.VerifyMagicLinkRequest{
+		Token:    req.Token,
+		DeviceID: req.DeviceID,
+	})
+	if err != nil {
+		return VerifyOTPResponse{}, err
+	}
+
+	return convertFromCharonVerifyResponse(resp), nil
+}
+
 // Convert main package types to cerberusmfa package types
 func convertToCerberusMFARequest(req CerberusMFARequest) cerberusmfa.CerberusMFARequest {
 	return cerberusmfa.CerberusMFARequest{
@@ -383,10 +1142,16 @@ func RegisterUser(req CerberusMFARequest) (CerberusMFAResponse, error) {
 
 // WebAuthn Integration Functions
 
-// CreateWebAuthnRegistrationChallenge creates a WebAuthn registration challenge
+// CreateWebAuthnRegistrationChallenge creates a WebAuthn registration
+// challenge for the signed-in user
 func CreateWebAuthnRegistrationChallenge(req WebAuthnChallengeRequest) (WebAuthnChallengeResponse, error) {
+	userID, err := enrolmentUserID(req.AccessToken)
+	if err != nil {
+		return WebAuthnChallengeResponse{}, err
+	}
+
 	// Call CerberusMFA integration function
-	response, err := cerberusmfa.InitiateWebAuthnRegistration(req.UserID, req.Username, req.DisplayName)
+	response, err := cerberusmfa.InitiateWebAuthnRegistration(userID, req.Username, req.DisplayName)
 	if err != nil {
 		return WebAuthnChallengeResponse{}, err
 	}
@@ -395,14 +1160,21 @@ func CreateWebAuthnRegistrationChallenge(req WebAuthnChallengeRequest) (WebAuthn
 	return convertFromWebAuthnChallengeResponse(*response), nil
 }
 
-// VerifyWebAuthnRegistration verifies a WebAuthn registration
+// VerifyWebAuthnRegistration verifies a WebAuthn registration for the
+// signed-in user
 func VerifyWebAuthnRegistration(req WebAuthnRegistrationRequest) (WebAuthnRegistrationResponse, error) {
+	userID, err := enrolmentUserID(req.AccessToken)
+	if err != nil {
+		return WebAuthnRegistrationResponse{}, err
+	}
+
 	// Convert to service types
 	serviceReq := webauthn.RegistrationRequest{
-		UserID:            req.UserID,
+		UserID:            userID,
 		Challenge:         req.Challenge,
 		ClientDataJSON:    req.ClientDataJSON,
 		AttestationObject: req.AttestationObject,
+		Transports:        req.Transports,
 	}
 
 	// Call CerberusMFA integration function
@@ -412,7 +1184,11 @@ func VerifyWebAuthnRegistration(req WebAuthnRegistrationRequest) (WebAuthnRegist
 	}
 
 	// Convert response
-	return convertFromWebAuthnRegistrationResponse(*response), nil
+	result := convertFromWebAuthnRegistrationResponse(*response)
+	if result.Success {
+		result.RecoveryCodes = initialRecoveryCodes(userID)
+	}
+	return result, nil
 }
 
 // CreateWebAuthnAuthenticationChallenge creates a WebAuthn authentication challenge
@@ -430,14 +1206,7 @@ func CreateWebAuthnAuthenticationChallenge(req WebAuthnAssertionChallengeRequest
 // VerifyWebAuthnAuthentication verifies a WebAuthn authentication
 func VerifyWebAuthnAuthentication(req WebAuthnAuthRequest) (WebAuthnAuthResponse, error) {
 	// Convert to service types
-	serviceReq := webauthn.AuthenticationRequest{
-		UserID:            req.UserID,
-		Challenge:         req.Challenge,
-		ClientDataJSON:    req.ClientDataJSON,
-		AuthenticatorData: req.AuthenticatorData,
-		Signature:         req.Signature,
-		UserHandle:        req.UserHandle,
-	}
+	serviceReq := convertToWebAuthnAuthRequest(req)
 
 	// Call CerberusMFA integration function
 	response, err := cerberusmfa.VerifyWebAuthnAuthentication(serviceReq)
@@ -446,24 +1215,376 @@ func VerifyWebAuthnAuthentication(req WebAuthnAuthRequest) (WebAuthnAuthResponse
 	}
 
 	// Convert response
-	return convertFromWebAuthnAuthResponse(*response), nil
+	return convertFromWebAuthnAuthResponse(*response, req), nil
 }
 
-// Conversion Functions for WebAuthn
+// SweepExpiredWebAuthnChallenges deletes expired WebAuthn challenges and
+// returns how many were removed. Intended to be called on a schedule.
+// Requires an admin session that meets the sensitive-action policy.
+func SweepExpiredWebAuthnChallenges(req WebAuthnChallengeSweepRequest) (int, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return 0, err
+	}
 
-func convertFromWebAuthnChallengeResponse(resp webauthn.ChallengeResponse) WebAuthnChallengeResponse {
-	return WebAuthnChallengeResponse{
-		Challenge: resp.Challenge,
-		RelyingParty: RelyingPartyInfo{
-			ID:   resp.RelyingParty.ID,
-			Name: resp.RelyingParty.Name,
-		},
-		User: UserInfo{
-			ID:          resp.User.ID,
-			Name:        resp.User.Name,
-			DisplayName: resp.User.DisplayName,
-		},
-		PubKeyCredParams:      convertPubKeyCredParams(resp.PubKeyCredParams),
+	return cerberusmfa.SweepWebAuthnChallengesAsAdmin(adminUserID)
+}
+
+// ListWebAuthnCredentials lists the authenticators registered by the signed-in user
+func ListWebAuthnCredentials(req WebAuthnCredentialsRequest) ([]WebAuthnCredentialInfo, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := cerberusmfa.ListWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]WebAuthnCredentialInfo, len(credentials))
+	for i, c := range credentials {
+		result[i] = convertFromWebAuthnCredentialInfo(c)
+	}
+	return result, nil
+}
+
+// RenameWebAuthnCredential sets the nickname of one of the signed-in user's authenticators
+func RenameWebAuthnCredential(req RenameWebAuthnCredentialRequest) (WebAuthnCredentialResponse, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return WebAuthnCredentialResponse{}, err
+	}
+
+	response, err := cerberusmfa.RenameWebAuthnCredential(userID, req.CredentialID, req.Nickname)
+	if err != nil {
+		return WebAuthnCredentialResponse{}, err
+	}
+
+	return convertFromWebAuthnCredentialResponse(*response), nil
+}
+
+// RevokeWebAuthnCredential removes one of the signed-in user's authenticators
+// and signs out every session created with it
+func RevokeWebAuthnCredential(req RevokeWebAuthnCredentialRequest) (WebAuthnCredentialResponse, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return WebAuthnCredentialResponse{}, err
+	}
+
+	var otp *charonotp.VerifyOTPRequest
+	if req.OTPCode != "" {
+		otp = &charonotp.VerifyOTPRequest{
+			OTPCode:   req.OTPCode,
+			Recipient: req.Recipient,
+		}
+	}
+
+	response, err := cerberusmfa.RevokeWebAuthnCredential(context.Background(), userID, req.CredentialID, req.Reason, otp)
+	if err != nil {
+		return WebAuthnCredentialResponse{}, err
+	}
+
+	return convertFromWebAuthnCredentialResponse(*response), nil
+}
+
+// TOTP Integration Functions
+
+// BeginTOTPEnrollment starts authenticator app enrolment for the signed-in
+// user. Once the account has a factor, the session must meet the
+// sensitive-action policy, so a weaker session can't replace it.
+func BeginTOTPEnrollment(req TOTPEnrollmentRequest) (TOTPEnrollmentResponse, error) {
+	userID, err := enrolmentUserID(req.AccessToken)
+	if err != nil {
+		return TOTPEnrollmentResponse{}, err
+	}
+
+	response, err := cerberusmfa.BeginTOTPEnrollment(userID, req.AccountName)
+	if err != nil {
+		return TOTPEnrollmentResponse{}, err
+	}
+
+	return TOTPEnrollmentResponse{
+		Secret: response.Secret,
+		URI:    response.URI,
+		Issuer: response.Issuer,
+		Digits: response.Digits,
+		Period: response.Period,
+	}, nil
+}
+
+// ConfirmTOTPEnrollment activates the pending enrolment of the signed-in user
+func ConfirmTOTPEnrollment(req ConfirmTOTPEnrollmentRequest) (TOTPVerifyResponse, error) {
+	userID, err := enrolmentUserID(req.AccessToken)
+	if err != nil {
+		return TOTPVerifyResponse{}, err
+	}
+
+	response, err := cerberusmfa.ConfirmTOTPEnrollment(userID, req.Code)
+	if err != nil {
+		return TOTPVerifyResponse{}, err
+	}
+
+	result := TOTPVerifyResponse{
+		Success: response.Success,
+		UserID:  response.UserID,
+		Message: response.Message,
+	}
+	if result.Success {
+		result.RecoveryCodes = initialRecoveryCodes(userID)
+	}
+	return result, nil
+}
+
+// Account Recovery Functions
+
+// StartAccountRecovery verifies a recovery OTP and returns a recovery token
+func StartAccountRecovery(req StartAccountRecoveryRequest) (AccountRecoveryResponse, error) {
+	response, err := cerberusmfa.StartAccountRecovery(req.Recipient, req.OTPCode)
+	if err != nil {
+		return AccountRecoveryResponse{}, err
+	}
+
+	return convertFromAccountRecoveryResponse(*response), nil
+}
+
+// CompleteAccountRecovery finishes recovery with a recovery code or an
+// approved request. Every existing session and passkey is revoked, and on
+// success a new session is created so the user can enrol a new authenticator.
+func CompleteAccountRecovery(req CompleteAccountRecoveryRequest) (AccountRecoveryResponse, error) {
+	ctx := context.Background()
+
+	var response *cerberusmfa.AccountRecoveryResponse
+	var err error
+	if req.RecoveryCode != "" {
+		response, err = cerberusmfa.CompleteRecoveryWithCode(ctx, req.RecoveryToken, req.RecoveryCode, req.Recipient)
+	} else {
+		response, err = cerberusmfa.CompleteRecoveryWithApproval(ctx, req.RecoveryToken, req.Recipient)
+	}
+	if err != nil {
+		return AccountRecoveryResponse{}, err
+	}
+
+	result := convertFromAccountRecoveryResponse(*response)
+	if !result.Success {
+		return result, nil
+	}
+
+	sessionResp, err := issueSession(SessionRequest{
+		UserID:     response.UserID,
+		ChannelDID: response.UserID,
+		Action:     "recovery",
+		IPAddress:  req.IPAddress,
+		UserAgent:  req.UserAgent,
+		ClientType: req.ClientType,
+	}, chronossession.AuthMethod{AuthType: chronossession.SESSION_TYPE_RECOVERY})
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to create session after account recovery: %v", err)
+		return result, nil
+	}
+
+	result.SessionID = sessionResp.SessionID
+	result.AccessToken = sessionResp.AccessToken
+	result.RefreshToken = sessionResp.RefreshToken
+	return result, nil
+}
+
+// RequestAccountRecoveryApproval asks an admin to approve a recovery when
+// the user has no recovery codes left
+func RequestAccountRecoveryApproval(req AccountRecoveryApprovalRequest) (AccountRecoveryResponse, error) {
+	response, err := cerberusmfa.RequestRecoveryApproval(req.RecoveryToken, req.Reason)
+	if err != nil {
+		return AccountRecoveryResponse{}, err
+	}
+
+	return convertFromAccountRecoveryResponse(*response), nil
+}
+
+// ListPendingAccountRecoveries lists recoveries awaiting approval (admins only)
+func ListPendingAccountRecoveries(req PendingAccountRecoveriesRequest) ([]PendingAccountRecovery, error) {
+	adminUserID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := cerberusmfa.ListPendingRecoveries(adminUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PendingAccountRecovery, len(requests))
+	for i, r := range requests {
+		result[i] = PendingAccountRecovery{
+			RecoveryID: r.UID,
+			UserID:     r.UserID,
+			Reason:     r.Reason,
+			CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+			ExpiresAt:  r.ExpiresAt.Format(time.RFC3339),
+		}
+	}
+	return result, nil
+}
+
+// ApproveAccountRecovery approves a pending recovery (admins only)
+func ApproveAccountRecovery(req ApproveAccountRecoveryRequest) (AccountRecoveryResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return AccountRecoveryResponse{}, err
+	}
+
+	response, err := cerberusmfa.ApproveAccountRecovery(adminUserID, req.RecoveryID)
+	if err != nil {
+		return AccountRecoveryResponse{}, err
+	}
+
+	return convertFromAccountRecoveryResponse(*response), nil
+}
+
+// RegenerateRecoveryCodes replaces the signed-in user's recovery codes
+func RegenerateRecoveryCodes(req RecoveryCodesRequest) (RecoveryCodesResponse, error) {
+	userID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+
+	codes, err := cerberusmfa.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+
+	return RecoveryCodesResponse{
+		RecoveryCodes: codes,
+		Message:       "New recovery codes issued. Your previous codes no longer work",
+	}, nil
+}
+
+// initialRecoveryCodes issues recovery codes after a factor is enrolled if
+// the user has none. userID must come from the enrolling session (see
+// enrolmentUserID), never from the request. Enrolment still succeeds if
+// this fails.
+func initialRecoveryCodes(userID string) []string {
+	codes, err := cerberusmfa.IssueInitialRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to issue recovery codes for user %s: %v", userID, err)
+		return nil
+	}
+	return codes
+}
+
+func convertFromAccountRecoveryResponse(resp cerberusmfa.AccountRecoveryResponse) AccountRecoveryResponse {
+	result := AccountRecoveryResponse{
+		Success:            resp.Success,
+		Status:             resp.Status,
+		RecoveryToken:      resp.RecoveryToken,
+		UserID:             resp.UserID,
+		RevokedSessions:    resp.RevokedSessions,
+		RevokedCredentials: resp.RevokedCredentials,
+		Message:            resp.Message,
+	}
+	if !resp.ExpiresAt.IsZero() {
+		result.ExpiresAt = resp.ExpiresAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+// authenticatedUserID resolves the user behind a ChronosSession access token
+func authenticatedUserID(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("access token is required")
+	}
+
+	validation, err := chronossession.ValidateSessionToken(context.Background(), token)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate session: %v", err)
+	}
+	if !validation.Valid || validation.UserID == "" {
+		return "", fmt.Errorf("invalid or expired session")
+	}
+
+	return validation.UserID, nil
+}
+
+// sensitiveActionUserID resolves the user behind a token that meets the
+// sensitive-action policy (AAL2, authenticated in the last 10 minutes)
+func sensitiveActionUserID(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("access token is required")
+	}
+
+	response, err := chronossession.RequireSensitiveAction(context.Background(), token)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate session: %v", err)
+	}
+	if response.StepUpRequired {
+		return "", fmt.Errorf("step-up required: %s", response.Message)
+	}
+	if !response.Satisfied || response.UserID == "" {
+		return "", fmt.Errorf("invalid or expired session")
+	}
+
+	return response.UserID, nil
+}
+
+// enrolmentUserID resolves the user adding a passkey or authenticator app.
+// Any session may enrol the first factor; once the account has one, adding
+// or replacing a factor needs the sensitive-action policy.
+func enrolmentUserID(token string) (string, error) {
+	userID, err := authenticatedUserID(token)
+	if err != nil {
+		return "", err
+	}
+
+	enrolled, err := cerberusmfa.HasEnrolledFactor(userID)
+	if err != nil {
+		return "", err
+	}
+	if !enrolled {
+		return userID, nil
+	}
+
+	return sensitiveActionUserID(token)
+}
+
+// Conversion Functions for WebAuthn
+
+func convertFromWebAuthnCredentialInfo(c webauthn.CredentialInfo) WebAuthnCredentialInfo {
+	info := WebAuthnCredentialInfo{
+		CredentialID:      c.CredentialID,
+		Nickname:          c.Nickname,
+		AuthenticatorName: c.AuthenticatorName,
+		AAGUID:            c.AAGUID,
+		AttestationFormat: c.AttestationFormat,
+		Transports:        c.Transports,
+		CloneWarning:      c.CloneWarning,
+		CreatedAt:         c.CreatedAt.Format(time.RFC3339),
+	}
+	if c.LastUsedAt != nil {
+		info.LastUsedAt = c.LastUsedAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+func convertFromWebAuthnCredentialResponse(resp webauthn.CredentialResponse) WebAuthnCredentialResponse {
+	return WebAuthnCredentialResponse{
+		Success:      resp.Success,
+		CredentialID: resp.CredentialID,
+		Message:      resp.Message,
+	}
+}
+
+func convertFromWebAuthnChallengeResponse(resp webauthn.ChallengeResponse) WebAuthnChallengeResponse {
+	return WebAuthnChallengeResponse{
+		Challenge: resp.Challenge,
+		RelyingParty: RelyingPartyInfo{
+			ID:   resp.RelyingParty.ID,
+			Name: resp.RelyingParty.Name,
+		},
+		User: UserInfo{
+			ID:          resp.User.ID,
+			Name:        resp.User.Name,
+			DisplayName: resp.User.DisplayName,
+		},
+		PubKeyCredParams:      convertPubKeyCredParams(resp.PubKeyCredParams),
 		AuthenticatorSelection: convertAuthenticatorSelection(resp.AuthenticatorSelection),
 		Timeout:               resp.Timeout,
 		Attestation:           resp.Attestation,
@@ -490,45 +1611,64 @@ func convertFromWebAuthnAssertionResponse(resp webauthn.AssertionChallengeRespon
 	}
 }
 
-func convertFromWebAuthnAuthResponse(resp webauthn.AuthenticationResponse) WebAuthnAuthResponse {
+func convertToWebAuthnAuthRequest(req WebAuthnAuthRequest) webauthn.AuthenticationRequest {
+	return webauthn.AuthenticationRequest{
+		UserID:            req.UserID,
+		CredentialID:      req.CredentialID,
+		Challenge:         req.Challenge,
+		ClientDataJSON:    req.ClientDataJSON,
+		AuthenticatorData: req.AuthenticatorData,
+		Signature:         req.Signature,
+		UserHandle:        req.UserHandle,
+	}
+}
+
+func convertFromWebAuthnAuthResponse(resp webauthn.AuthenticationResponse, req WebAuthnAuthRequest) WebAuthnAuthResponse {
 	// If authentication was successful, create a session with ChronosSession
 	if resp.Success {
 		// Create session request
 		sessionReq := SessionRequest{
-			UserID:     resp.UserID,
-			ChannelDID: resp.UserID, // Use UserID as ChannelDID for WebAuthn
-			Action:     "signin",
+			UserID:       resp.UserID,
+			ChannelDID:   resp.UserID, // Use UserID as ChannelDID for WebAuthn
+			Action:       "signin",
+			CredentialID: resp.CredentialID,
+			IPAddress:    req.IPAddress,
+			UserAgent:    req.UserAgent,
+			ClientType:   req.ClientType,
 		}
-		
-		// Generate JWT token through CreateSession
-		sessionResp, err := CreateSession(sessionReq)
+
+		// Generate JWT token; the assurance level depends on the key and UV flag
+		sessionResp, err := issueSession(sessionReq, chronossession.AuthMethod{
+			AuthType:      chronossession.SESSION_TYPE_WEBAUTHN,
+			UserVerified:  resp.UserVerified,
+			HardwareBound: resp.HardwareBound,
+		})
 		if err != nil {
 			log.Printf("⚠️ Warning: Failed to create session after WebAuthn auth: %v", err)
 			// Return basic response without JWT token
 			return WebAuthnAuthResponse{
-				Success:   resp.Success,
-				UserID:    resp.UserID,
-				Message:   resp.Message,
-				SessionID: resp.SessionID,
+				Success: resp.Success,
+				UserID:  resp.UserID,
+				Message: resp.Message,
 			}
 		}
-		
+
 		// Return response with JWT token
 		return WebAuthnAuthResponse{
-			Success:     resp.Success,
-			UserID:      resp.UserID,
-			Message:     resp.Message,
-			SessionID:   sessionResp.SessionID,   // JWT token
-			AccessToken: sessionResp.AccessToken, // Same JWT token
+			Success:      resp.Success,
+			UserID:       resp.UserID,
+			Message:      resp.Message,
+			SessionID:    sessionResp.SessionID,   // JWT token
+			AccessToken:  sessionResp.AccessToken, // Same JWT token
+			RefreshToken: sessionResp.RefreshToken,
 		}
 	}
-	
+
 	// Return basic response for failed authentication
 	return WebAuthnAuthResponse{
-		Success:   resp.Success,
-		UserID:    resp.UserID,
-		Message:   resp.Message,
-		SessionID: resp.SessionID,
+		Success: resp.Success,
+		UserID:  resp.UserID,
+		Message: resp.Message,
 	}
 }
 
@@ -548,6 +1688,7 @@ func convertAuthenticatorSelection(sel webauthn.AuthenticatorSelection) Authenti
 	return AuthenticatorSelection{
 		AuthenticatorAttachment: sel.AuthenticatorAttachment,
 		RequireResidentKey:      sel.RequireResidentKey,
+		ResidentKey:             sel.ResidentKey,
 		UserVerification:        sel.UserVerification,
 	}
 }
@@ -564,11 +1705,29 @@ func convertPublicKeyCredDescriptors(descs []webauthn.PublicKeyCredDescriptor) [
 	return result
 }
 
+func init() {
+	// Validate the configuration as the module loads, so a bad deployment
+	// is reported straight away rather than by the first request using it
+	if _, err := config.Get(); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
+
 func main() {
 	// This function is required by Modus but can be empty
 	// All functionality is exposed through exported functions
 }
 
+// CheckConfiguration validates the deployment's configuration and returns
+// the environment it runs as, for smoke tests after a deploy
+func CheckConfiguration() (string, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return "", err
+	}
+	return string(cfg.Environment), nil
+}
+
 // TestSimpleFunction is a basic test function to check GraphQL discovery
 func TestSimpleFunction(input string) (string, error) {
 	return fmt.Sprintf("Hello, %s! GraphQL is working.", input), nil
@@ -576,8 +1735,65 @@ func TestSimpleFunction(input string) (string, error) {
 
 // Session Management Functions
 
-// CreateSession creates a secure session after successful OTP verification and authentication
-func CreateSession(req SessionRequest) (SessionResponse, error) {
+// CreateSession verifies an OTP code or magic link and signs in the user
+// the channel belongs to. The code or link is consumed, so it is sent here
+// instead of to VerifyOTP or VerifyMagicLink. Only codes and links sent for
+// sign-in or sign-up are accepted.
+func CreateSession(req CreateSessionRequest) (SessionResponse, error) {
+	var proof charonotp.VerifyOTPResponse
+	var err error
+	method := chronossession.AuthMethod{AuthType: chronossession.SESSION_TYPE_OTP}
+	if req.MagicLinkToken != "" {
+		method.AuthType = chronossession.SESSION_TYPE_MAGIC_LINK
+		proof, err = charonotp.VerifyMagicLink(charonotp.VerifyMagicLinkRequest{
+			Token:    req.MagicLinkToken,
+			DeviceID: req.DeviceID,
+		})
+	} else {
+		proof, err = charonotp.VerifyOTP(charonotp.VerifyOTPRequest{
+			OTPCode:   req.OTPCode,
+			Recipient: req.Recipient,
+			Purpose:   req.Purpose,
+		})
+	}
+	if err != nil {
+		return SessionResponse{}, err
+	}
+	if !proof.Verified {
+		return SessionResponse{Success: false, Message: proof.Message}, nil
+	}
+	if proof.Purpose != charonotp.OTPPurposeSignin && proof.Purpose != charonotp.OTPPurposeSignup {
+		return SessionResponse{Success: false, Message: "This code can't be used to sign in"}, nil
+	}
+
+	// A channel nobody holds yet is registered to a new user
+	userID := proof.UserID
+	if userID == "" {
+		routed, err := cerberusmfa.CerberusMFA(cerberusmfa.CerberusMFARequest{
+			ChannelDID:  proof.ChannelDID,
+			ChannelType: proof.ChannelType,
+		})
+		if err != nil {
+			return SessionResponse{}, err
+		}
+		if routed.UserID == "" {
+			return SessionResponse{Success: false, Message: routed.Message}, nil
+		}
+		userID = routed.UserID
+	}
+
+	return issueSession(SessionRequest{
+		UserID:     userID,
+		ChannelDID: proof.ChannelDID,
+		Action:     proof.Action,
+		IPAddress:  req.IPAddress,
+		UserAgent:  req.UserAgent,
+		ClientType: req.ClientType,
+	}, method)
+}
+
+// issueSession creates a session recording how the user authenticated
+func issueSession(req SessionRequest, method chronossession.AuthMethod) (SessionResponse, error) {
 	ctx := context.Background()
 	
 	// Initialize ChronosSession agent
@@ -588,8 +1804,13 @@ func CreateSession(req SessionRequest) (SessionResponse, error) {
 	
 	// Create session request for ChronosSession agent
 	sessionReq := &chronossession.SessionRequest{
-		UserID:     req.UserID,
-		DeviceInfo: fmt.Sprintf("ChannelDID: %s, Action: %s", req.ChannelDID, req.Action),
+		UserID:       req.UserID,
+		DeviceInfo:   fmt.Sprintf("ChannelDID: %s, Action: %s", req.ChannelDID, req.Action),
+		CredentialID: req.CredentialID,
+		AuthMethod:   method,
+		IPAddress:    req.IPAddress,
+		UserAgent:    req.UserAgent,
+		ClientType:   req.ClientType,
 	}
 	
 	// Create session using ChronosSession agent
@@ -597,14 +1818,25 @@ func CreateSession(req SessionRequest) (SessionResponse, error) {
 	if err != nil {
 		return SessionResponse{}, fmt.Errorf("failed to create session: %v", err)
 	}
+
+	// Tell the user about the new sign-in; the session stands if this fails
+	signIn := chronossession.NewSessionInfo(sessionResp.SessionID, method.AuthType, req.UserAgent, req.IPAddress, sessionResp.IssuedAt)
+	if err := cerberusmfa.NotifyNewSignIn(req.UserID, signIn); err != nil {
+		log.Printf("⚠️ Warning: Failed to record sign-in notification: %v", err)
+	}
 	
 	return SessionResponse{
-		Success:     true,
-		SessionID:   sessionResp.Token, // Use token as sessionID
-		AccessToken: sessionResp.Token,
-		ExpiresAt:   sessionResp.ExpiresAt.Unix(),
-		Message:     sessionResp.Message,
-		UserID:      sessionResp.UserID,
+		Success:          true,
+		SessionID:        sessionResp.Token, // Use token as sessionID
+		AccessToken:      sessionResp.Token,
+		ExpiresAt:        sessionResp.ExpiresAt.Unix(),
+		Message:          sessionResp.Message,
+		UserID:           sessionResp.UserID,
+		AAL:              sessionResp.AAL,
+		RefreshToken:     sessionResp.RefreshToken,
+		RefreshExpiresAt: sessionResp.RefreshExpiresAt.Unix(),
+		SessionExpiresAt: unixOrZero(sessionResp.SessionExpiresAt),
+		IdleTimeout:      sessionResp.IdleTimeout,
 	}, nil
 }
 
@@ -629,12 +1861,113 @@ func ValidateSession(req ValidationRequest) (ValidationResponse, error) {
 		return ValidationResponse{}, fmt.Errorf("failed to validate session: %v", err)
 	}
 	
-	return ValidationResponse{
+	result := ValidationResponse{
 		Valid:     validationResp.Valid,
 		UserID:    validationResp.UserID,
 		ExpiresAt: validationResp.ExpiresAt.Unix(),
 		Message:   validationResp.Message,
-	}, nil
+	}
+	if validationResp.Valid {
+		result.AMR = validationResp.Assurance.AMR
+		result.AAL = validationResp.Assurance.AAL
+		result.AuthTime = validationResp.Assurance.AuthTime.Unix()
+	}
+	return result, nil
+}
+
+// CheckSessionAssurance reports whether a session is at the required level
+// and recent enough; if not, the client should prompt for step-up
+func CheckSessionAssurance(req SessionAssuranceRequest) (SessionAssuranceResponse, error) {
+	maxAge := time.Duration(req.MaxAgeSeconds) * time.Second
+	response, err := chronossession.CheckSessionAssurance(context.Background(), req.AccessToken, req.RequiredAAL, maxAge)
+	if err != nil {
+		return SessionAssuranceResponse{}, fmt.Errorf("failed to check session assurance: %v", err)
+	}
+
+	return convertFromAssuranceResponse(*response), nil
+}
+
+// StepUpWithTOTP re-authenticates with an authenticator app code and returns
+// a new token at the raised level. The old token is revoked.
+func StepUpWithTOTP(req StepUpTOTPRequest) (SessionResponse, error) {
+	sessionResp, err := cerberusmfa.StepUpWithTOTP(context.Background(), req.AccessToken, req.Code)
+	if err != nil {
+		return SessionResponse{}, err
+	}
+
+	return convertFromChronosSessionResponse(*sessionResp), nil
+}
+
+// StepUpWithWebAuthn re-authenticates with a WebAuthn assertion and returns a
+// new token at the raised level. The old token is revoked.
+func StepUpWithWebAuthn(req StepUpWebAuthnRequest) (SessionResponse, error) {
+	sessionResp, err := cerberusmfa.StepUpWithWebAuthn(context.Background(), req.AccessToken, convertToWebAuthnAuthRequest(req.Assertion))
+	if err != nil {
+		return SessionResponse{}, err
+	}
+
+	return convertFromChronosSessionResponse(*sessionResp), nil
+}
+
+func convertFromChronosSessionResponse(resp chronossession.SessionResponse) SessionResponse {
+	return SessionResponse{
+		Success:          true,
+		SessionID:        resp.Token,
+		AccessToken:      resp.Token,
+		ExpiresAt:        resp.ExpiresAt.Unix(),
+		Message:          resp.Message,
+		UserID:           resp.UserID,
+		AAL:              resp.AAL,
+		RefreshToken:     resp.RefreshToken,
+		RefreshExpiresAt: resp.RefreshExpiresAt.Unix(),
+		SessionExpiresAt: unixOrZero(resp.SessionExpiresAt),
+		IdleTimeout:      resp.IdleTimeout,
+	}
+}
+
+// unixOrZero converts an optional time to Unix seconds, leaving it 0 if unset
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func convertFromAssuranceResponse(resp chronossession.AssuranceResponse) SessionAssuranceResponse {
+	result := SessionAssuranceResponse{
+		Satisfied:      resp.Satisfied,
+		StepUpRequired: resp.StepUpRequired,
+		AMR:            resp.Assurance.AMR,
+		AAL:            resp.Assurance.AAL,
+		Message:        resp.Message,
+	}
+	if !resp.Assurance.AuthTime.IsZero() {
+		result.AuthTime = resp.Assurance.AuthTime.Unix()
+	}
+	return result
+}
+
+// GetSessionJWKS publishes the public keys that verify session tokens, so
+// other services can validate them without calling this API
+func GetSessionJWKS() (JSONWebKeySet, error) {
+	jwks, err := chronossession.GetJWKS()
+	if err != nil {
+		return JSONWebKeySet{}, err
+	}
+
+	result := JSONWebKeySet{Keys: make([]JSONWebKey, len(jwks.Keys))}
+	for i, k := range jwks.Keys {
+		result.Keys[i] = JSONWebKey{
+			Kty: k.KeyType,
+			Crv: k.Curve,
+			X:   k.X,
+			Y:   k.Y,
+			Kid: k.KeyID,
+			Alg: k.Algorithm,
+			Use: k.Use,
+		}
+	}
+	return result, nil
 }
 
 // RefreshSession extends an existing session using ChronosSession
@@ -649,7 +1982,7 @@ func RefreshSession(req RefreshRequest) (RefreshResponse, error) {
 	
 	// Create refresh request for ChronosSession agent
 	refreshReq := &chronossession.RefreshRequest{
-		Token: req.Token,
+		RefreshToken: req.RefreshToken,
 	}
 	
 	// Refresh session using ChronosSession agent
@@ -659,9 +1992,12 @@ func RefreshSession(req RefreshRequest) (RefreshResponse, error) {
 	}
 	
 	return RefreshResponse{
-		Token:     refreshResp.Token,
-		ExpiresAt: refreshResp.ExpiresAt.Unix(),
-		Message:   refreshResp.Message,
+		Token:            refreshResp.Token,
+		ExpiresAt:        refreshResp.ExpiresAt.Unix(),
+		RefreshToken:     refreshResp.RefreshToken,
+		RefreshExpiresAt: refreshResp.RefreshExpiresAt.Unix(),
+		SessionExpiresAt: unixOrZero(refreshResp.SessionExpiresAt),
+		Message:          refreshResp.Message,
 	}, nil
 }
 
@@ -693,3 +2029,703 @@ func RevokeSession(req RevocationRequest) (RevocationResponse, error) {
 		Timestamp: revocationResp.Timestamp,
 	}, nil
 }
+
+// Session Inventory Functions
+
+// ListSessions lists the signed-in sessions of the token's user, marking the
+// one the token belongs to as current
+func ListSessions(req SessionListRequest) ([]SessionInfo, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := chronossession.ListUserSessions(context.Background(), userID, req.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, convertFromChronosSessionInfo(session))
+	}
+	return result, nil
+}
+
+// SignOutSession signs out one of the token user's sessions
+func SignOutSession(req SignOutSessionRequest) (SignOutResponse, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return SignOutResponse{}, err
+	}
+
+	revoked, err := chronossession.RevokeUserSession(context.Background(), userID, req.SessionID, "signed out from session list")
+	if err != nil {
+		return SignOutResponse{}, fmt.Errorf("failed to sign out session: %v", err)
+	}
+	if !revoked {
+		return SignOutResponse{Success: false, Message: "Session not found"}, nil
+	}
+
+	return SignOutResponse{Success: true, SessionsRevoked: 1, Message: "Session signed out"}, nil
+}
+
+// SignOutOtherSessions signs out every session of the token's user except
+// the current one
+func SignOutOtherSessions(req SignOutOtherSessionsRequest) (SignOutResponse, error) {
+	if req.AccessToken == "" {
+		return SignOutResponse{}, fmt.Errorf("access token is required")
+	}
+
+	revoked, err := chronossession.RevokeOtherUserSessions(context.Background(), req.AccessToken, "signed out everywhere else")
+	if err != nil {
+		return SignOutResponse{}, fmt.Errorf("failed to sign out other sessions: %v", err)
+	}
+
+	return SignOutResponse{
+		Success:         true,
+		SessionsRevoked: revoked,
+		Message:         fmt.Sprintf("Signed out %d other session(s)", revoked),
+	}, nil
+}
+
+// AdminSignOutSessions signs out every session of a user, everyone with a
+// role, or everyone in a centre. Requires an admin session that meets the
+// sensitive-action policy.
+func AdminSignOutSessions(req AdminSignOutRequest) (SignOutResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return SignOutResponse{}, err
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "signed out by administrator"
+	}
+	scope := cerberusmfa.SessionScope{UserID: req.UserID, Role: req.Role, CentreID: req.CentreID}
+
+	users, revoked, err := cerberusmfa.RevokeSessionsAsAdmin(context.Background(), adminUserID, scope, reason)
+	if err != nil {
+		return SignOutResponse{}, err
+	}
+
+	return SignOutResponse{
+		Success:         true,
+		UsersAffected:   users,
+		SessionsRevoked: revoked,
+		Message:         fmt.Sprintf("Signed out %d session(s) for %d user(s)", revoked, users),
+	}, nil
+}
+
+// MigrateAuthSessions converts sessions stored before they followed the
+// AuthSession schema. Requires an admin session that meets the
+// sensitive-action policy.
+func MigrateAuthSessions(req SessionMigrationRequest) (SessionMigrationResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return SessionMigrationResponse{}, err
+	}
+
+	result, err := cerberusmfa.MigrateSessionsAsAdmin(context.Background(), adminUserID)
+	if err != nil {
+		return SessionMigrationResponse{}, err
+	}
+
+	return SessionMigrationResponse{
+		Migrated: result.Migrated,
+		Orphaned: result.Orphaned,
+		Message:  fmt.Sprintf("Migrated %d session(s); invalidated %d without a user", result.Migrated, result.Orphaned),
+	}, nil
+}
+
+// VerifyAuditChain checks that no audit entry was changed, removed or
+// inserted since it was written. Requires an admin session that meets the
+// sensitive-action policy.
+func VerifyAuditChain(req AuditChainVerificationRequest) (AuditChainVerificationResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return AuditChainVerificationResponse{}, err
+	}
+
+	report, err := cerberusmfa.VerifyAuditChainAsAdmin(adminUserID)
+	if err != nil {
+		return AuditChainVerificationResponse{}, err
+	}
+
+	problems := make([]AuditChainProblem, len(report.Problems))
+	for i, problem := range report.Problems {
+		problems[i] = AuditChainProblem{
+			Sequence: problem.Sequence,
+			EntryID:  problem.EntryID,
+			Kind:     problem.Kind,
+			Detail:   problem.Detail,
+		}
+	}
+
+	return AuditChainVerificationResponse{
+		Valid:          report.Valid,
+		EntriesChecked: report.EntriesChecked,
+		HeadSequence:   report.HeadSequence,
+		Problems:       problems,
+		VerifiedAt:     report.VerifiedAt.Format(time.RFC3339),
+	}, nil
+}
+
+// QueryAuditEntries reads the audit trail, newest first. Requires the admin
+// or verifier role.
+func QueryAuditEntries(req AuditQueryRequest) (AuditQueryResponse, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return AuditQueryResponse{}, err
+	}
+	filter, err := convertToThemisLogFilter(req.Filter)
+	if err != nil {
+		return AuditQueryResponse{}, err
+	}
+
+	page, err := cerberusmfa.QueryAuditAsReviewer(userID, filter, req.Cursor, req.Limit)
+	if err != nil {
+		return AuditQueryResponse{}, err
+	}
+
+	entries := make([]AuditEntryInfo, len(page.Entries))
+	for i, entry := range page.Entries {
+		entries[i] = convertFromAuditEntry(entry)
+	}
+	return AuditQueryResponse{Entries: entries, NextCursor: page.NextCursor}, nil
+}
+
+// CountAuditActions counts audit entries per action and day, e.g. for a
+// compliance dashboard. Requires the admin or verifier role.
+func CountAuditActions(req AuditActionCountRequest) ([]AuditActionCount, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := convertToThemisLogFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := cerberusmfa.CountAuditActionsAsReviewer(userID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AuditActionCount, len(counts))
+	for i, count := range counts {
+		result[i] = AuditActionCount{Day: count.Day, Action: count.Action, Count: count.Count}
+	}
+	return result, nil
+}
+
+// ExportAuditEntries exports audit entries as a signed CSV or JSON-lines
+// bundle for auditors. Requires the admin or verifier role and a session
+// that meets the sensitive-action policy.
+func ExportAuditEntries(req AuditExportRequest) (AuditExportResponse, error) {
+	userID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return AuditExportResponse{}, err
+	}
+	filter, err := convertToThemisLogFilter(req.Filter)
+	if err != nil {
+		return AuditExportResponse{}, err
+	}
+
+	bundle, err := cerberusmfa.ExportAuditAsReviewer(userID, filter, req.Format)
+	if err != nil {
+		return AuditExportResponse{}, err
+	}
+
+	m := bundle.Manifest
+	return AuditExportResponse{
+		Manifest: AuditExportManifest{
+			FileName:      m.FileName,
+			Format:        m.Format,
+			EntryCount:    m.EntryCount,
+			FirstSequence: m.FirstSequence,
+			LastSequence:  m.LastSequence,
+			HeadSequence:  m.HeadSequence,
+			HeadHash:      m.HeadHash,
+			ContentSHA256: m.ContentSHA256,
+			ExportedBy:    m.ExportedBy,
+			ExportedAt:    m.ExportedAt.Format(time.RFC3339),
+		},
+		Content:   bundle.Content,
+		Signature: bundle.Signature,
+	}, nil
+}
+
+// ApplyRetentionPolicies archives audit entries and deletes OTPs, WebAuthn
+// challenges and sessions past their retention policy, except those under
+// a legal hold. Intended to be called on a schedule. Requires an admin
+// session that meets the sensitive-action policy.
+func ApplyRetentionPolicies(req RetentionRunRequest) (RetentionRunResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return RetentionRunResponse{}, err
+	}
+
+	report, err := cerberusmfa.ApplyRetentionAsAdmin(adminUserID)
+	if report == nil {
+		return RetentionRunResponse{}, err
+	}
+
+	results := make([]RetentionResultInfo, len(report.Results))
+	for i, r := range report.Results {
+		results[i] = RetentionResultInfo{
+			RecordType: r.RecordType,
+			Category:   r.Category,
+			Cutoff:     r.Cutoff.Format(time.RFC3339),
+			Removed:    r.Removed,
+			Sequences:  r.Sequences,
+		}
+	}
+	return RetentionRunResponse{
+		StartedAt:      report.StartedAt.Format(time.RFC3339),
+		FinishedAt:     report.FinishedAt.Format(time.RFC3339),
+		ActiveHolds:    report.ActiveHolds,
+		HeldUsers:      report.HeldUsers,
+		Results:        results,
+		SummaryEntryID: report.SummaryEntryID,
+	}, err
+}
+
+// PlaceLegalHold keeps a user's or centre's records from retention purges.
+// Requires an admin session that meets the sensitive-action policy.
+func PlaceLegalHold(req LegalHoldRequest) (LegalHoldInfo, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return LegalHoldInfo{}, err
+	}
+
+	hold, err := cerberusmfa.PlaceLegalHoldAsAdmin(adminUserID, req.UserID, req.CentreID, req.Reason)
+	if hold == nil {
+		return LegalHoldInfo{}, err
+	}
+	return convertFromLegalHold(*hold), err
+}
+
+// ReleaseLegalHold lets retention apply again to the records a hold
+// covered. Requires an admin session that meets the sensitive-action
+// policy.
+func ReleaseLegalHold(req LegalHoldReleaseRequest) (bool, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return false, err
+	}
+
+	return cerberusmfa.ReleaseLegalHoldAsAdmin(adminUserID, req.HoldID, req.Reason)
+}
+
+// ListLegalHolds lists legal holds, newest first. Requires the admin or
+// verifier role.
+func ListLegalHolds(req LegalHoldListRequest) ([]LegalHoldInfo, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := cerberusmfa.ListLegalHoldsAsReviewer(userID, req.ActiveOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]LegalHoldInfo, len(holds))
+	for i, hold := range holds {
+		result[i] = convertFromLegalHold(hold)
+	}
+	return result, nil
+}
+
+// AddUserContact adds a contact detail for the signed-in user and returns
+// the token stored in its place
+func AddUserContact(req UserContactRequest) (string, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return "", err
+	}
+
+	return hecateregister.AddUserContact(userID, req.ContactType, req.Purpose, req.Value)
+}
+
+// AddEmergencyContact adds an emergency contact for the signed-in user
+func AddEmergencyContact(req EmergencyContactRequest) (bool, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return false, err
+	}
+
+	err = hecateregister.AddEmergencyContact(userID, hecateregister.EmergencyContact{
+		Name:         req.ContactName,
+		Relationship: req.Relationship,
+		Phone:        req.Phone,
+		Email:        req.Email,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExportSubjectData returns the signed-in user's personal data as a JSON
+// archive, or with userId another user's for a request received outside
+// the app (admins only). Requires a session that meets the
+// sensitive-action policy.
+func ExportSubjectData(req SubjectAccessRequest) (SubjectAccessResponse, error) {
+	userID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return SubjectAccessResponse{}, err
+	}
+
+	var archive *gdpr.SubjectArchive
+	if req.UserID == "" || req.UserID == userID {
+		archive, err = cerberusmfa.ExportSubjectData(userID)
+	} else {
+		archive, err = cerberusmfa.ExportSubjectDataAsAdmin(userID, req.UserID)
+	}
+	if err != nil {
+		return SubjectAccessResponse{}, err
+	}
+
+	sections := make([]SubjectArchiveSection, len(archive.Sections))
+	for i, section := range archive.Sections {
+		sections[i] = SubjectArchiveSection{Section: section.Section, Records: section.Records}
+	}
+	return SubjectAccessResponse{
+		FileName:      archive.FileName,
+		ContentType:   archive.ContentType,
+		Content:       archive.Content,
+		ContentSHA256: archive.ContentSHA256,
+		Sections:      sections,
+		GeneratedAt:   archive.GeneratedAt.Format(time.RFC3339),
+	}, nil
+}
+
+// RequestDataErasure asks for the signed-in user's data to be erased. The
+// request is queued for admin approval. Requires a session that meets the
+// sensitive-action policy and an "account-delete" OTP.
+func RequestDataErasure(req DataErasureRequest) (ErasureResponse, error) {
+	userID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+
+	response, err := cerberusmfa.RequestErasure(userID, req.Recipient, req.OTPCode, req.Reason)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+	return convertFromErasureResponse(*response), nil
+}
+
+// ListErasureRequests lists erasure requests, oldest first (admins only)
+func ListErasureRequests(req ErasureListRequest) ([]ErasureRequestInfo, error) {
+	adminUserID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := cerberusmfa.ListErasureRequestsAsAdmin(adminUserID, req.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ErasureRequestInfo, len(requests))
+	for i, r := range requests {
+		result[i] = convertFromErasureRequest(r)
+	}
+	return result, nil
+}
+
+// ApproveDataErasure approves an erasure request and erases the user
+// (admins only). Requires a session that meets the sensitive-action policy.
+func ApproveDataErasure(req ErasureReviewRequest) (ErasureResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+
+	response, err := cerberusmfa.ApproveErasureAsAdmin(context.Background(), adminUserID, req.RequestID)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+	return convertFromErasureResponse(*response), nil
+}
+
+// RejectDataErasure rejects an erasure request with a note for the user
+// (admins only). Requires a session that meets the sensitive-action policy.
+func RejectDataErasure(req ErasureReviewRequest) (ErasureResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+
+	response, err := cerberusmfa.RejectErasureAsAdmin(adminUserID, req.RequestID, req.Note)
+	if err != nil {
+		return ErasureResponse{}, err
+	}
+	return convertFromErasureResponse(*response), nil
+}
+
+func convertFromErasureResponse(r cerberusmfa.ErasureResponse) ErasureResponse {
+	response := ErasureResponse{
+		Success:   r.Success,
+		Status:    r.Status,
+		RequestID: r.RequestID,
+		Message:   r.Message,
+	}
+	if !r.DueAt.IsZero() {
+		response.DueAt = r.DueAt.Format(time.RFC3339)
+	}
+	if r.Report != nil {
+		for recordType, count := range r.Report.Erased {
+			response.Erased = append(response.Erased, ErasedRecordCount{RecordType: recordType, Count: count})
+		}
+		sort.Slice(response.Erased, func(i, j int) bool { return response.Erased[i].RecordType < response.Erased[j].RecordType })
+		response.Pseudonymised = r.Report.Pseudonymised
+		response.VaultValuesErased = r.Report.VaultValuesErased
+	}
+	return response
+}
+
+func convertFromErasureRequest(r gdpr.ErasureRequest) ErasureRequestInfo {
+	info := ErasureRequestInfo{
+		RequestID:  r.UID,
+		UserID:     r.UserID,
+		Status:     r.Status,
+		Reason:     r.Reason,
+		ChannelDID: r.ChannelDID,
+		CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+		DueAt:      r.DueAt.Format(time.RFC3339),
+		ReviewedBy: r.ReviewedBy,
+		ReviewNote: r.ReviewNote,
+	}
+	if r.ReviewedAt != nil {
+		info.ReviewedAt = r.ReviewedAt.Format(time.RFC3339)
+	}
+	if r.CompletedAt != nil {
+		info.CompletedAt = r.CompletedAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+func convertFromLegalHold(hold themislog.LegalHold) LegalHoldInfo {
+	info := LegalHoldInfo{
+		HoldID:     hold.ID,
+		UserID:     hold.UserID,
+		CentreID:   hold.CentreID,
+		Reason:     hold.Reason,
+		PlacedBy:   hold.PlacedBy,
+		PlacedAt:   hold.PlacedAt.Format(time.RFC3339),
+		Active:     hold.Active,
+		ReleasedBy: hold.ReleasedBy,
+	}
+	if hold.ReleasedAt != nil {
+		info.ReleasedAt = hold.ReleasedAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+func convertToThemisLogFilter(f AuditFilter) (themislog.Filter, error) {
+	filter := themislog.Filter{
+		Category:    f.Category,
+		Action:      f.Action,
+		ObjectType:  f.ObjectType,
+		ObjectID:    f.ObjectID,
+		PerformedBy: f.PerformedBy,
+		Severity:    f.Severity,
+		Source:      f.Source,
+	}
+	var err error
+	if f.From != "" {
+		if filter.From, err = time.Parse(time.RFC3339, f.From); err != nil {
+			return filter, fmt.Errorf("from must be an RFC3339 time: %v", err)
+		}
+	}
+	if f.To != "" {
+		if filter.To, err = time.Parse(time.RFC3339, f.To); err != nil {
+			return filter, fmt.Errorf("to must be an RFC3339 time: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+func convertFromAuditEntry(entry themislog.AuditEntry) AuditEntryInfo {
+	return AuditEntryInfo{
+		Sequence:      entry.Sequence,
+		ID:            entry.ID,
+		PreviousHash:  entry.PreviousHash,
+		Hash:          entry.Hash,
+		Category:      entry.Category,
+		Action:        entry.Action,
+		ObjectType:    entry.ObjectType,
+		ObjectID:      entry.ObjectID,
+		PerformedBy:   entry.PerformedBy,
+		Timestamp:     entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		Details:       entry.Details,
+		PreviousValue: entry.PreviousValue,
+		NewValue:      entry.NewValue,
+		Severity:      entry.Severity,
+		Source:        entry.Source,
+	}
+}
+
+func convertFromChronosSessionInfo(session chronossession.SessionInfo) SessionInfo {
+	info := SessionInfo{
+		SessionID:       session.SessionID,
+		Current:         session.Current,
+		Method:          session.Method,
+		Browser:         session.Browser,
+		OS:              session.OS,
+		Device:          session.Device,
+		IPAddress:       session.IPAddress,
+		Location:        session.Location,
+		AuthenticatedAt: session.AuthenticatedAt.Format(time.RFC3339),
+		ExpiresAt:       session.ExpiresAt.Format(time.RFC3339),
+	}
+	if !session.LastUsedAt.IsZero() {
+		info.LastUsedAt = session.LastUsedAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+// OpenID Connect Provider Functions
+
+// RegisterOAuthClient registers a relying party such as the content
+// authoring tool. Requires an admin session that meets the sensitive-action
+// policy.
+func RegisterOAuthClient(req OAuthClientRegistrationRequest) (OAuthClientRegistrationResponse, error) {
+	adminUserID, err := sensitiveActionUserID(req.AccessToken)
+	if err != nil {
+		return OAuthClientRegistrationResponse{}, err
+	}
+
+	client, err := janusoidc.RegisterClient(adminUserID, janusoidc.ClientRegistration{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		Confidential: req.Confidential,
+		Trusted:      req.Trusted,
+	})
+	if err != nil {
+		return OAuthClientRegistrationResponse{}, err
+	}
+
+	return OAuthClientRegistrationResponse{ClientID: client.ClientID, ClientSecret: client.ClientSecret}, nil
+}
+
+// AuthorizeOAuthClient handles the authorization endpoint of the
+// authorization-code flow with PKCE
+func AuthorizeOAuthClient(req OAuthAuthorizeRequest) (OAuthAuthorizeResponse, error) {
+	resp, err := janusoidc.Authorize(context.Background(), janusoidc.AuthorizationRequest{
+		SessionToken:        req.SessionToken,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		ResponseType:        req.ResponseType,
+		Scope:               req.Scope,
+		State:               req.State,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Prompt:              req.Prompt,
+		MaxAge:              req.MaxAge,
+		Consent:             req.Consent,
+	})
+	if err != nil {
+		return OAuthAuthorizeResponse{}, err
+	}
+
+	return OAuthAuthorizeResponse{
+		RedirectURL:     resp.RedirectURL,
+		LoginRequired:   resp.LoginRequired,
+		ConsentRequired: resp.ConsentRequired,
+		ClientName:      resp.ClientName,
+		Scopes:          resp.Scopes,
+	}, nil
+}
+
+// ExchangeOAuthToken handles the token endpoint: authorization codes and
+// refresh tokens are exchanged for tokens here
+func ExchangeOAuthToken(req OAuthTokenRequest) (OAuthTokenResponse, error) {
+	resp, err := janusoidc.Token(context.Background(), janusoidc.TokenRequest{
+		GrantType:    req.GrantType,
+		ClientID:     req.ClientID,
+		ClientSecret: req.ClientSecret,
+		Code:         req.Code,
+		RedirectURI:  req.RedirectURI,
+		CodeVerifier: req.CodeVerifier,
+		RefreshToken: req.RefreshToken,
+	})
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	return OAuthTokenResponse{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		ExpiresIn:    resp.ExpiresIn,
+		RefreshToken: resp.RefreshToken,
+		IDToken:      resp.IDToken,
+		Scope:        resp.Scope,
+	}, nil
+}
+
+// GetOAuthUserInfo returns the claims a client's access token allows
+func GetOAuthUserInfo(req OAuthUserInfoRequest) (OAuthUserInfo, error) {
+	claims, err := janusoidc.UserInfo(context.Background(), req.AccessToken)
+	if err != nil {
+		return OAuthUserInfo{}, err
+	}
+
+	info := OAuthUserInfo{}
+	info.Sub, _ = claims["sub"].(string)
+	info.Name, _ = claims["name"].(string)
+	info.GivenName, _ = claims["given_name"].(string)
+	info.FamilyName, _ = claims["family_name"].(string)
+	info.Zoneinfo, _ = claims["zoneinfo"].(string)
+	info.Locale, _ = claims["locale"].(string)
+	info.Email, _ = claims["email"].(string)
+	info.EmailVerified, _ = claims["email_verified"].(bool)
+	info.Roles, _ = claims["roles"].([]string)
+	return info, nil
+}
+
+// RevokeOAuthConsent withdraws what the token's user allowed a client; they
+// are asked again on the client's next authorization request
+func RevokeOAuthConsent(req OAuthConsentRevocationRequest) (bool, error) {
+	userID, err := authenticatedUserID(req.AccessToken)
+	if err != nil {
+		return false, err
+	}
+
+	if err := janusoidc.RevokeConsent(userID, req.ClientID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOpenIDConfiguration returns the discovery document relying parties
+// fetch from /.well-known/openid-configuration. ID tokens are verified
+// against GetSessionJWKS.
+func GetOpenIDConfiguration() (OpenIDConfiguration, error) {
+	metadata, err := janusoidc.Discovery()
+	if err != nil {
+		return OpenIDConfiguration{}, err
+	}
+
+	return OpenIDConfiguration{
+		Issuer:                            metadata.Issuer,
+		AuthorizationEndpoint:             metadata.AuthorizationEndpoint,
+		TokenEndpoint:                     metadata.TokenEndpoint,
+		UserInfoEndpoint:                  metadata.UserInfoEndpoint,
+		JWKSURI:                           metadata.JWKSURI,
+		ScopesSupported:                   metadata.ScopesSupported,
+		ResponseTypesSupported:            metadata.ResponseTypesSupported,
+		GrantTypesSupported:               metadata.GrantTypesSupported,
+		SubjectTypesSupported:             metadata.SubjectTypesSupported,
+		IDTokenSigningAlgValuesSupported:  metadata.IDTokenSigningAlgValuesSupported,
+		TokenEndpointAuthMethodsSupported: metadata.TokenEndpointAuthMethodsSupported,
+		CodeChallengeMethodsSupported:     metadata.CodeChallengeMethodsSupported,
+		ClaimsSupported:                   metadata.ClaimsSupported,
+	}, nil
+}
@@ -3,12 +3,12 @@ package email
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/console"
-)
 
-// mail_service defines which email service to use (mailersend, resend, etc.)
-var mail_service = "mailersend"
+	"modus/config"
+)
 
 // EmailRequest represents the parameters needed to send an email
 type EmailRequest struct {
@@ -33,6 +33,8 @@ type EmailProvider interface {
 	SendEmail(req EmailRequest) (*EmailResponse, error)
 	SendOTPEmail(to, otpCode string) (*EmailResponse, error)
 	SendWelcomeEmail(to, userName string) (*EmailResponse, error)
+	SendMagicLinkEmail(to, link, expires string) (*EmailResponse, error)
+	SendSecurityAlertEmail(to, event, details string) (*EmailResponse, error)
 	GetProviderName() string
 }
 
@@ -44,77 +46,164 @@ type EmailService struct {
 	asyncQueue        *AsyncEmailQueue
 	useAsyncQueue     bool
 	mutex            sync.RWMutex
+	settings         config.EmailConfig // sender and template IDs for queued emails
+	otpTTL           time.Duration
 }
 
-// Global email service instance
-var defaultService *EmailService
+// Global email service instance, built on first use
+var (
+	defaultService *EmailService
+	defaultMutex   sync.Mutex
+)
+
+// getDefaultService returns the email service, creating it with the
+// provider named by EMAIL_PROVIDER in the shared configuration
+func getDefaultService() (*EmailService, error) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	if defaultService != nil {
+		return defaultService, nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
 
-// Initialize the email service with the configured mail provider
-func init() {
 	var primaryProvider EmailProvider
-	
-	// Use the mail_service variable to determine which provider to initialize
-	switch mail_service {
+	switch cfg.Email.Provider {
 	case "mailersend":
-		primaryProvider = NewMailerSendProvider()
+		primaryProvider = NewMailerSendProvider(cfg)
 	default:
-		// Default to MailerSend if unknown service specified
-		primaryProvider = NewMailerSendProvider()
+		return nil, fmt.Errorf("unsupported email provider %q", cfg.Email.Provider)
 	}
-	
+
 	defaultService = &EmailService{
 		primaryProvider:  primaryProvider,
 		fallbackProvider: nil, // Can be set later for redundancy
 		enableFallback:   false,
 		// Note: Async queue disabled due to WASM goroutine limitations
-		asyncQueue:       nil,
-		useAsyncQueue:    false,
+		asyncQueue:    nil,
+		useAsyncQueue: false,
+		settings:      cfg.Email,
+		otpTTL:        cfg.OTP.CodeTTL,
 	}
+	return defaultService, nil
 }
 
 // SetPrimaryProvider allows switching the primary email provider
-func SetPrimaryProvider(provider EmailProvider) {
-	defaultService.primaryProvider = provider
+func SetPrimaryProvider(provider EmailProvider) error {
+	s, err := getDefaultService()
+	if err != nil {
+		return err
+	}
+	s.primaryProvider = provider
+	return nil
 }
 
 // SetFallbackProvider sets a fallback provider for redundancy
-func SetFallbackProvider(provider EmailProvider) {
-	defaultService.fallbackProvider = provider
-	defaultService.enableFallback = true
+func SetFallbackProvider(provider EmailProvider) error {
+	s, err := getDefaultService()
+	if err != nil {
+		return err
+	}
+	s.fallbackProvider = provider
+	s.enableFallback = true
+	return nil
 }
 
 // SendEmail sends an email using the configured provider
 func SendEmail(req EmailRequest) (*EmailResponse, error) {
-	return defaultService.SendEmail(req)
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendEmail(req)
 }
 
 // SendOTPEmail sends an OTP email using the configured provider
 func SendOTPEmail(to, otpCode string) (*EmailResponse, error) {
-	return defaultService.SendOTPEmail(to, otpCode)
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendOTPEmail(to, otpCode)
 }
 
 // SendOTPEmailAsync queues an OTP email for async sending
 func SendOTPEmailAsync(to, otpCode string) (*EmailResponse, error) {
-	return defaultService.SendOTPEmailAsync(to, otpCode)
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendOTPEmailAsync(to, otpCode)
 }
 
 // SendWelcomeEmail sends a welcome email using the configured provider
 func SendWelcomeEmail(to, userName string) (*EmailResponse, error) {
-	return defaultService.SendWelcomeEmail(to, userName)
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendWelcomeEmail(to, userName)
 }
 
 // SendWelcomeEmailAsync queues a welcome email for async sending
 func SendWelcomeEmailAsync(to, userName string) (*EmailResponse, error) {
-	return defaultService.SendWelcomeEmailAsync(to, userName)
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendWelcomeEmailAsync(to, userName)
+}
+
+// SendMagicLinkEmail sends a sign-in link email using the configured provider
+func SendMagicLinkEmail(to, link, expires string) (*EmailResponse, error) {
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendMagicLinkEmail(to, link, expires)
+}
+
+// SendSecurityAlertEmail notifies a user about a security-relevant account change
+func SendSecurityAlertEmail(to, event, details string) (*EmailResponse, error) {
+	s, err := getDefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return s.SendSecurityAlertEmail(to, event, details)
 }
 
 // GetProviderInfo returns information about the current email provider
 func GetProviderInfo() string {
-	return defaultService.primaryProvider.GetProviderName()
+	s, err := getDefaultService()
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v", err)
+	}
+	return s.primaryProvider.GetProviderName()
 }
 
 // EmailService methods
 
+// templateID returns a configured template ID, or the default template
+// until one is published
+func templateID(settings config.EmailConfig, id string) string {
+	if id == "" {
+		return settings.Templates.Default
+	}
+	return id
+}
+
+// formatMinutes renders a lifetime for email copy, e.g. "5 minutes"
+func formatMinutes(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
 func (s *EmailService) SendEmail(req EmailRequest) (*EmailResponse, error) {
 	console.Log("📧 EmailService: Starting email send process")
 	console.Log(fmt.Sprintf("📧 EmailService: Provider=%s, To=%s, Subject=%s", s.primaryProvider.GetProviderName(), req.To, req.Subject))
@@ -185,6 +274,36 @@ func (s *EmailService) SendWelcomeEmail(to, userName string) (*EmailResponse, er
 	return response, err
 }
 
+func (s *EmailService) SendMagicLinkEmail(to, link, expires string) (*EmailResponse, error) {
+	console.Log("🔗 EmailService: Sending magic link email")
+	console.Log(fmt.Sprintf("🔗 EmailService: To=%s, Provider=%s", to, s.primaryProvider.GetProviderName()))
+
+	response, err := s.primaryProvider.SendMagicLinkEmail(to, link, expires)
+
+	if err != nil {
+		console.Error(fmt.Sprintf("🚨 EmailService: Magic link email failed: %v", err))
+	} else {
+		console.Log("✅ EmailService: Magic link email sent successfully")
+	}
+
+	return response, err
+}
+
+func (s *EmailService) SendSecurityAlertEmail(to, event, details string) (*EmailResponse, error) {
+	console.Log("🛡️ EmailService: Sending security alert email")
+	console.Log(fmt.Sprintf("🛡️ EmailService: To=%s, Event=%s, Provider=%s", to, event, s.primaryProvider.GetProviderName()))
+
+	response, err := s.primaryProvider.SendSecurityAlertEmail(to, event, details)
+
+	if err != nil {
+		console.Error(fmt.Sprintf("🚨 EmailService: Security alert email failed: %v", err))
+	} else {
+		console.Log("✅ EmailService: Security alert email sent successfully")
+	}
+
+	return response, err
+}
+
 // SendOTPEmailAsync queues an OTP email for async processing
 func (s *EmailService) SendOTPEmailAsync(to, otpCode string) (*EmailResponse, error) {
 	console.Log("⚡ EmailService: Queuing OTP email for async processing")
@@ -197,13 +316,13 @@ func (s *EmailService) SendOTPEmailAsync(to, otpCode string) (*EmailResponse, er
 	// Use the provider's SendOTPEmail method via queue
 	req := EmailRequest{
 		To:         to,
-		From:       "darren@darkolive.co.uk",
+		From:       s.settings.FromAddress,
 		Subject:    "Your OTP Code",
-		TemplateID: "neqvygm91v8l0p7w", // OTP template
+		TemplateID: templateID(s.settings, s.settings.Templates.OTP),
 		Variables: map[string]string{
 			"otp_code": otpCode,
 			"purpose":  "authentication",
-			"expires":  "5 minutes",
+			"expires":  formatMinutes(s.otpTTL),
 		},
 	}
 	
@@ -240,9 +359,9 @@ func (s *EmailService) SendWelcomeEmailAsync(to, userName string) (*EmailRespons
 	// Use the provider's SendWelcomeEmail method via queue
 	req := EmailRequest{
 		To:         to,
-		From:       "darren@darkolive.co.uk",
+		From:       s.settings.FromAddress,
 		Subject:    "Welcome to DO Study!",
-		TemplateID: "neqvygm91v8l0p7w", // You can use different template for welcome
+		TemplateID: templateID(s.settings, s.settings.Templates.Welcome),
 		Variables: map[string]string{
 			"user_name": userName,
 		},
@@ -3,28 +3,27 @@ package email
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/http"
-)
 
-// Template ID configuration
-var (
-	OTPTemplateID     = "neqvygm91v8l0p7w"   // OTP email template ID
-	WelcomeTemplateID = "vywj2lpz701g7oqz"  // Welcome email template ID
-	DefaultTemplateID = "vywj2lpz701g7oqz"   // Default fallback template ID
+	"modus/config"
 )
 
-
-
 // MailerSendProvider implements the EmailProvider interface for MailerSend
 type MailerSendProvider struct {
 	// No API key needed - authentication handled by Modus manifest
+	settings config.EmailConfig // sender and template IDs
+	otpTTL   time.Duration      // shown in OTP emails
 }
 
 // NewMailerSendProvider creates a new MailerSend provider instance
-func NewMailerSendProvider() EmailProvider {
+func NewMailerSendProvider(cfg *config.Config) EmailProvider {
 	// Authentication is handled by Modus manifest, no API key needed
-	return &MailerSendProvider{}
+	return &MailerSendProvider{
+		settings: cfg.Email,
+		otpTTL:   cfg.OTP.CodeTTL,
+	}
 }
 
 // SendEmail implements the EmailProvider interface for MailerSend
@@ -37,7 +36,7 @@ func (m *MailerSendProvider) SendEmail(req EmailRequest) (*EmailResponse, error)
 	payload := map[string]interface{}{
 		"from": map[string]string{
 			"email": req.From,
-			"name":  "DO Study Platform",
+			"name":  m.settings.FromName,
 		},
 		"to": []map[string]string{
 			{
@@ -161,21 +160,15 @@ func (m *MailerSendProvider) SendEmail(req EmailRequest) (*EmailResponse, error)
 
 // SendOTPEmail implements the EmailProvider interface for OTP emails with MailerSend defaults
 func (m *MailerSendProvider) SendOTPEmail(to, otpCode string) (*EmailResponse, error) {
-	// Use configured template ID, fallback to default if empty
-	templateID := OTPTemplateID
-	if templateID == "" {
-		templateID = DefaultTemplateID
-	}
-	
 	req := EmailRequest{
 		To:         to,
-		From:       "darren@darkolive.co.uk",
+		From:       m.settings.FromAddress,
 		Subject:    "Your OTP Code",
-		TemplateID: templateID,
+		TemplateID: templateID(m.settings, m.settings.Templates.OTP),
 		Variables: map[string]string{
 			"otp_code": otpCode,
 			"purpose":  "authentication",
-			"expires":  "5 minutes",
+			"expires":  formatMinutes(m.otpTTL),
 		},
 	}
 	return m.SendEmail(req)
@@ -183,17 +176,11 @@ func (m *MailerSendProvider) SendOTPEmail(to, otpCode string) (*EmailResponse, e
 
 // SendWelcomeEmail implements the EmailProvider interface for welcome emails with MailerSend defaults
 func (m *MailerSendProvider) SendWelcomeEmail(to, userName string) (*EmailResponse, error) {
-	// Use configured template ID, fallback to default if empty
-	templateID := WelcomeTemplateID
-	if templateID == "" {
-		templateID = DefaultTemplateID
-	}
-	
 	req := EmailRequest{
 		To:         to,
-		From:       "darren@darkolive.co.uk",
+		From:       m.settings.FromAddress,
 		Subject:    "Welcome to DO Study!",
-		TemplateID: templateID,
+		TemplateID: templateID(m.settings, m.settings.Templates.Welcome),
 		Variables: map[string]string{
 			"user_name": userName,
 		},
@@ -201,6 +188,37 @@ func (m *MailerSendProvider) SendWelcomeEmail(to, userName string) (*EmailRespon
 	return m.SendEmail(req)
 }
 
+// SendMagicLinkEmail implements the EmailProvider interface for sign-in link emails with MailerSend defaults
+func (m *MailerSendProvider) SendMagicLinkEmail(to, link, expires string) (*EmailResponse, error) {
+	req := EmailRequest{
+		To:         to,
+		From:       m.settings.FromAddress,
+		Subject:    "Your DO Study sign-in link",
+		TemplateID: templateID(m.settings, m.settings.Templates.MagicLink),
+		Variables: map[string]string{
+			"magic_link": link,
+			"purpose":    "authentication",
+			"expires":    expires,
+		},
+	}
+	return m.SendEmail(req)
+}
+
+// SendSecurityAlertEmail implements the EmailProvider interface for security alert emails with MailerSend defaults
+func (m *MailerSendProvider) SendSecurityAlertEmail(to, event, details string) (*EmailResponse, error) {
+	req := EmailRequest{
+		To:         to,
+		From:       m.settings.FromAddress,
+		Subject:    "Security alert for your DO Study account",
+		TemplateID: templateID(m.settings, m.settings.Templates.SecurityAlert),
+		Variables: map[string]string{
+			"event":   event,
+			"details": details,
+		},
+	}
+	return m.SendEmail(req)
+}
+
 // GetProviderName returns the name of this email provider
 func (m *MailerSendProvider) GetProviderName() string {
 	return "MailerSend"
@@ -0,0 +1,397 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/services/pii"
+)
+
+// ArchiveFormat identifies the layout of a subject access archive
+const ArchiveFormat = "modus-subject-access/v1"
+
+// SectionCount is how many records a section of an archive holds
+type SectionCount struct {
+	Section string `json:"section"`
+	Records int    `json:"records"`
+}
+
+// SubjectArchive is a user's personal data as a machine-readable archive
+type SubjectArchive struct {
+	FileName      string         `json:"fileName"`
+	ContentType   string         `json:"contentType"`
+	Content       string         `json:"content"` // JSON, see subjectDocument
+	ContentSHA256 string         `json:"contentSha256"`
+	Sections      []SectionCount `json:"sections"`
+	GeneratedAt   time.Time      `json:"generatedAt"`
+}
+
+// subjectDocument is the archive content. Sections are keyed by name and
+// hold the records as stored, with vault tokens replaced by their values.
+type subjectDocument struct {
+	Format      string                              `json:"format"`
+	SubjectID   string                              `json:"subjectId"`
+	GeneratedAt time.Time                           `json:"generatedAt"`
+	Sections    map[string][]map[string]interface{} `json:"sections"`
+}
+
+// subjectQuery reads the records linked to a user, one block per section.
+// Secrets (OAuth tokens, credential keys, OTP, code and token hashes) are
+// left out: they are of no use to the user and a risk in an archive.
+const subjectQuery = `{
+		u as var(func: uid(%[1]s)) @filter(type(User))
+		students as var(func: type(Student)) @filter(uid_in(user, uid(u)))
+
+		account(func: uid(u)) {
+			uid
+			status
+			did
+			createdAt
+			updatedAt
+			lastSignin
+			roles {
+				name
+			}
+		}
+		identityDocuments(func: uid(u)) {
+			identityDocuments {
+				uid
+				type
+				externalReference
+				issuedDate
+				verifiedAt
+				reviewedAt
+			}
+		}
+		profiles(func: eq(userId, "%[1]s")) @filter(type(UserProfile)) {
+			uid
+			firstName
+			lastName
+			displayName
+			preferredPronouns
+			languagePreference
+			nationality
+			birthYear
+		}
+		contacts(func: eq(userId, "%[1]s")) @filter(type(UserContact)) {
+			uid
+			contactType
+			purpose
+			token
+			verified
+			verifiedAt
+			addedAt
+			postalAddress {
+				street1
+				street2
+				city
+				region
+				postalCode
+				country
+			}
+		}
+		emergencyContacts(func: eq(userId, "%[1]s")) @filter(type(UserEmergency)) {
+			uid
+			contactName
+			relationship
+			phone
+			email
+			lastVerified
+		}
+		medical(func: eq(userId, "%[1]s")) @filter(type(UserMedical)) {
+			uid
+			accessibilityNeeds
+			consented
+			lastUpdated
+			diagnoses {
+				condition
+				notes
+				disclosedByUser
+			}
+		}
+		socialAccounts(func: eq(userId, "%[1]s")) @filter(type(UserSocial)) {
+			uid
+			platform
+			username
+			linkedAt
+			expiry
+			scopes
+			enabledFeatures
+		}
+		channels(func: eq(userId, "%[1]s")) @filter(type(UserChannels)) {
+			uid
+			channelType
+			verified
+			primary
+			createdAt
+			lastUsedAt
+		}
+		consents(func: uid(students)) {
+			consentRecords {
+				uid
+				type
+				given
+				givenAt
+				revokedAt
+			}
+		}
+		progress(func: type(ProgressStatement), orderasc: timestamp) @filter(uid_in(student, uid(students))) {
+			uid
+			verb
+			objectType
+			objectId
+			timestamp
+			duration
+			result {
+				score
+				success
+				completion
+				response
+			}
+			context {
+				platform
+				language
+				ipAddress
+				userAgent
+				additional
+			}
+		}
+		sessions(func: type(AuthSession), orderdesc: createdAt) @filter(uid_in(user, uid(u))) {
+			uid
+			method
+			createdAt
+			expiresAt
+			lastUsedAt
+			ipAddress
+			userAgent
+			deviceId
+			origin
+			clientType
+		}
+		passkeys(func: type(WebAuthnCredential)) @filter(uid_in(user, uid(u))) {
+			uid
+			nickname
+			aaguid
+			transports
+			addedAt
+			lastUsedAt
+		}
+		oauthConsents(func: type(OAuthConsent)) @filter(uid_in(user, uid(u))) {
+			uid
+			clientId
+			scopes
+			grantedAt
+		}
+	}`
+
+// subjectSections are the blocks of subjectQuery
+var subjectSections = []string{
+	"account", "identityDocuments", "profiles", "contacts", "emergencyContacts", "medical",
+	"socialAccounts", "channels", "consents", "progress", "sessions", "passkeys", "oauthConsents",
+}
+
+// nestedSections are read through an edge of a single root; the archive
+// lists the records behind the edge
+var nestedSections = map[string]string{
+	"identityDocuments": "identityDocuments",
+	"consents":          "consentRecords",
+}
+
+// tokenFields are the fields holding PII vault tokens, by section. A
+// revealed value is written to the field named in the second column.
+var tokenFields = map[string][][2]string{
+	"profiles":          {{"firstName", "firstName"}, {"lastName", "lastName"}},
+	"contacts":          {{"token", "value"}},
+	"emergencyContacts": {{"contactName", "contactName"}, {"phone", "phone"}, {"email", "email"}},
+}
+
+// ExportSubjectData gathers everything linked to a user into a JSON archive
+// for a subject access request: their records, with vault tokens replaced
+// by the values, and the audit entries about them or made by them.
+func ExportSubjectData(userID, requestedBy string) (*SubjectArchive, error) {
+	if !isUID(userID) {
+		return nil, errors.New("invalid user ID")
+	}
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(fmt.Sprintf(subjectQuery, userID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subject data: %v", err)
+	}
+
+	var blocks map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resp.Json), &blocks); err != nil {
+		return nil, fmt.Errorf("failed to parse subject data: %v", err)
+	}
+	sections := make(map[string][]map[string]interface{}, len(subjectSections))
+	for _, section := range subjectSections {
+		records := []map[string]interface{}{}
+		if data, ok := blocks[section]; ok {
+			if err := json.Unmarshal(data, &records); err != nil {
+				return nil, fmt.Errorf("failed to parse subject %s: %v", section, err)
+			}
+		}
+		sections[section] = records
+	}
+	if len(sections["account"]) == 0 {
+		return nil, ErrUnknownUser
+	}
+	for section, edge := range nestedSections {
+		sections[section] = flattenEdge(sections[section], edge)
+	}
+
+	if err := revealTokens(sections, requestedBy); err != nil {
+		return nil, err
+	}
+
+	audit, err := subjectAuditEntries(userID)
+	if err != nil {
+		return nil, err
+	}
+	sections["auditTrail"] = audit
+
+	now := time.Now().UTC()
+	doc := subjectDocument{
+		Format:      ArchiveFormat,
+		SubjectID:   userID,
+		GeneratedAt: now,
+		Sections:    sections,
+	}
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subject archive: %v", err)
+	}
+	sum := sha256.Sum256(content)
+
+	archive := &SubjectArchive{
+		FileName:      fmt.Sprintf("subject_access_%s_%s.json", userID, now.Format("20060102T150405Z")),
+		ContentType:   "application/json",
+		Content:       string(content),
+		ContentSHA256: hex.EncodeToString(sum[:]),
+		GeneratedAt:   now,
+	}
+	for section, records := range sections {
+		archive.Sections = append(archive.Sections, SectionCount{Section: section, Records: len(records)})
+	}
+	sort.Slice(archive.Sections, func(i, j int) bool { return archive.Sections[i].Section < archive.Sections[j].Section })
+
+	piiAccessAuditor.Record("SUBJECT_ACCESS_EXPORTED", "User", userID, requestedBy, themislog.SeverityWarning,
+		fmt.Sprintf("Subject access archive %s (sha256 %s)", archive.FileName, archive.ContentSHA256))
+
+	log.Printf("📦 GDPR: Subject access archive for user %s exported by %s", userID, requestedBy)
+	return archive, nil
+}
+
+// revealTokens replaces vault tokens in the archive with their values.
+// Tokens the vault doesn't hold are left as stored.
+func revealTokens(sections map[string][]map[string]interface{}, requestedBy string) error {
+	var tokens []string
+	for section, fields := range tokenFields {
+		for _, record := range sections[section] {
+			for _, field := range fields {
+				if token, ok := record[field[0]].(string); ok {
+					tokens = append(tokens, token)
+				}
+			}
+		}
+	}
+
+	values, err := pii.NewVault(pii.DefaultTenant).Detokenize(pii.PurposeSubjectAccess, requestedBy, tokens...)
+	if err != nil {
+		return fmt.Errorf("failed to reveal vault values: %v", err)
+	}
+
+	for section, fields := range tokenFields {
+		for _, record := range sections[section] {
+			for _, field := range fields {
+				token, ok := record[field[0]].(string)
+				if !ok {
+					continue
+				}
+				value, ok := values[token]
+				if !ok {
+					continue
+				}
+				delete(record, field[0])
+				record[field[1]] = value
+			}
+		}
+	}
+	return nil
+}
+
+// subjectAuditEntries reads the audit entries about a user or made by them,
+// oldest first
+func subjectAuditEntries(userID string) ([]map[string]interface{}, error) {
+	bySequence := map[int64]themislog.AuditEntry{}
+	for _, filter := range []themislog.Filter{{ObjectID: userID}, {PerformedBy: userID}} {
+		cursor := ""
+		for {
+			page, err := themislog.QueryEntries(filter, cursor, themislog.MaxPageSize)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read audit entries: %v", err)
+			}
+			for _, entry := range page.Entries {
+				bySequence[entry.Sequence] = entry
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	sequences := make([]int64, 0, len(bySequence))
+	for sequence := range bySequence {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+
+	entries := make([]map[string]interface{}, 0, len(sequences))
+	for _, sequence := range sequences {
+		entry := bySequence[sequence]
+		entries = append(entries, map[string]interface{}{
+			"id":          entry.ID,
+			"sequence":    entry.Sequence,
+			"timestamp":   entry.Timestamp,
+			"category":    entry.Category,
+			"action":      entry.Action,
+			"objectType":  entry.ObjectType,
+			"objectId":    entry.ObjectID,
+			"performedBy": entry.PerformedBy,
+			"details":     entry.Details,
+			"source":      entry.Source,
+		})
+	}
+	return entries, nil
+}
+
+// flattenEdge lists the records behind an edge of the roots
+func flattenEdge(roots []map[string]interface{}, edge string) []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, root := range roots {
+		children, _ := root[edge].([]interface{})
+		for _, child := range children {
+			if record, ok := child.(map[string]interface{}); ok {
+				records = append(records, record)
+			}
+		}
+	}
+	return records
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,10 @@
+package gdpr
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// Auditors for data-subject events: changes to erasure requests, and the
+// exports and erasures of personal data themselves
+var (
+	adminAuditor     = themislog.Auditor{Source: "GDPR", Category: themislog.CategoryAdministration}
+	piiAccessAuditor = themislog.Auditor{Source: "GDPR", Category: themislog.CategoryPIIAccess}
+)
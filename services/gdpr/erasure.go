@@ -0,0 +1,230 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/services/pii"
+)
+
+// ErasedStatus is the User.status of an erased user
+const ErasedStatus = "deleted"
+
+// Erasure errors
+var (
+	ErrUnknownUser = errors.New("no such user")
+	ErrLegalHold   = errors.New("user is under a legal hold")
+)
+
+// erasedRecord is a type whose records are deleted with the user. They are
+// found by linkedBy: the user edge, or the userId string holding the
+// user's uid. children are edges to records deleted along with them.
+type erasedRecord struct {
+	recordType string
+	linkedBy   string
+	children   map[string]string // edge -> type of the record it points to
+}
+
+// erasedRecords are deleted outright. Audit entries and learning records
+// (ProgressStatement, ProgressResult, StudentProgress, assessments) are kept:
+// the audit trail is hash-chained and leaves through retention, and
+// assessment results must be kept for certification.
+var erasedRecords = []erasedRecord{
+	{"UserProfile", "userId", nil},
+	{"UserContact", "userId", map[string]string{"postalAddress": "PostalAddress"}},
+	{"UserEmergency", "userId", nil},
+	{"UserMedical", "userId", map[string]string{"diagnoses": "UserDiagnosis"}},
+	{"UserSocial", "userId", nil},
+	{"UserChannels", "userId", nil},
+	{"SecurityNotification", "userId", nil},
+	{"WebAuthnChallenge", "userId", nil},
+	{"RefreshToken", "user", nil},
+	{"AuthSession", "user", nil},
+	{"PasswordlessSession", "user", nil},
+	{"ChannelOTP", "user", nil},
+	{"TOTPCredential", "user", nil},
+	{"WebAuthnCredential", "user", nil},
+	{"RecoveryCode", "user", nil},
+	{"PasswordRecovery", "user", nil},
+	{"PasswordReset", "user", nil},
+	{"OAuthConsent", "user", nil},
+	{"OAuthAuthorizationCode", "user", nil},
+}
+
+// erasedUserPredicates identify the user or describe their activity; they
+// are removed from the User node, which stays as the subject of the
+// retained records
+var erasedUserPredicates = []string{
+	"did", "emailDID", "phoneDID", "emailVerified", "phoneVerified",
+	"lastSignin", "roles", "wallet", "identityDocuments",
+}
+
+// pseudonymisedContextPredicates are removed from the ProgressContext of
+// the user's learning records
+var pseudonymisedContextPredicates = []string{"ipAddress", "userAgent"}
+
+// ErasureReport is what an erasure removed
+type ErasureReport struct {
+	UserID            string         `json:"userId"`
+	Erased            map[string]int `json:"erased"`        // records deleted, by type
+	Pseudonymised     int            `json:"pseudonymised"` // progress contexts stripped of IP and user agent
+	VaultValuesErased int            `json:"vaultValuesErased"`
+	ErasedAt          time.Time      `json:"erasedAt"`
+}
+
+// EraseSubject erases a user's personal data. Their profile, contacts,
+// medical and social records, credentials and sessions are deleted, their
+// vault values are erased so tokens held anywhere else can't be reversed,
+// and their learning records are kept without IP addresses or user agents.
+// The User node stays, marked deleted, so retained records still point at
+// something. Users under a legal hold can't be erased.
+func EraseSubject(userID, performedBy string) (*ErasureReport, error) {
+	if !isUID(userID) {
+		return nil, errors.New("invalid user ID")
+	}
+
+	held, err := themislog.UserHeld(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check legal holds: %v", err)
+	}
+	if held {
+		piiAccessAuditor.Record("ERASURE_BLOCKED", "User", userID, performedBy, themislog.SeverityWarning,
+			"Erasure refused: the user is under a legal hold")
+		return nil, ErrLegalHold
+	}
+
+	now := time.Now().UTC()
+	query, counted := erasureQuery(userID)
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(u), 1))").
+		WithDelNquads(erasureDelNquads(userID, counted)).
+		WithSetNquads(fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <erasedAt> "%s"^^<xs:dateTime> .
+<%s> <updatedAt> "%s"^^<xs:dateTime> .`,
+			userID, ErasedStatus, userID, now.Format(time.RFC3339), userID, now.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query), mutation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase user: %v", err)
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse erasure result: %v", err)
+	}
+	if blockCount(result, "user") != 1 {
+		return nil, ErrUnknownUser
+	}
+
+	report := &ErasureReport{
+		UserID:        userID,
+		Erased:        map[string]int{},
+		Pseudonymised: blockCount(result, "count_contexts"),
+		ErasedAt:      now,
+	}
+	for variable, recordType := range counted {
+		if n := blockCount(result, "count_"+variable); n > 0 {
+			report.Erased[recordType] += n
+		}
+	}
+
+	// Graph first, vault second: both are safe to repeat if either fails
+	vaultErased, err := pii.NewVault(pii.DefaultTenant).EraseSubject(userID, performedBy)
+	if err != nil {
+		return report, fmt.Errorf("user erased but their vault values were not: %v", err)
+	}
+	report.VaultValuesErased = vaultErased
+
+	details, _ := json.Marshal(report)
+	piiAccessAuditor.Record("SUBJECT_ERASED", "User", userID, performedBy, themislog.SeverityCritical, string(details))
+
+	log.Printf("🗑️ GDPR: User %s erased by %s", userID, performedBy)
+	return report, nil
+}
+
+// erasureQuery selects everything EraseSubject removes and counts it. It
+// returns the query and the record type behind each counted variable.
+func erasureQuery(userID string) (string, map[string]string) {
+	var q strings.Builder
+	counted := map[string]string{}
+
+	fmt.Fprintf(&q, "{\n\t\tu as var(func: uid(%s)) @filter(type(User)) {\n\t\t\tdocs as identityDocuments\n\t\t}\n", userID)
+	counted["docs"] = "IdentityDocument"
+
+	for i, rec := range erasedRecords {
+		variable := fmt.Sprintf("r%d", i)
+		counted[variable] = rec.recordType
+
+		if rec.linkedBy == "user" {
+			fmt.Fprintf(&q, "\t\t%s as var(func: type(%s)) @filter(uid_in(user, %s))", variable, rec.recordType, userID)
+		} else {
+			fmt.Fprintf(&q, "\t\t%s as var(func: eq(%s, %q)) @filter(type(%s))", variable, rec.linkedBy, userID, rec.recordType)
+		}
+		if len(rec.children) == 0 {
+			q.WriteString("\n")
+			continue
+		}
+		q.WriteString(" {\n")
+		for _, edge := range sortedKeys(rec.children) {
+			child := fmt.Sprintf("%s_%s", variable, edge)
+			counted[child] = rec.children[edge]
+			fmt.Fprintf(&q, "\t\t\t%s as %s\n", child, edge)
+		}
+		q.WriteString("\t\t}\n")
+	}
+
+	// Learning records stay; their consents go and their contexts lose the
+	// IP address and user agent
+	fmt.Fprintf(&q, `		students as var(func: type(Student)) @filter(uid_in(user, %s)) {
+			consents as consentRecords
+		}
+		var(func: type(ProgressStatement)) @filter(uid_in(student, uid(students))) {
+			contexts as context
+		}
+`, userID)
+	counted["consents"] = "ConsentRecord"
+
+	q.WriteString("\n\t\tuser(func: uid(u)) {\n\t\t\tcount(uid)\n\t\t}\n")
+	q.WriteString("\t\tcount_contexts(func: uid(contexts)) {\n\t\t\tcount(uid)\n\t\t}\n")
+	for _, variable := range sortedKeys(counted) {
+		fmt.Fprintf(&q, "\t\tcount_%s(func: uid(%s)) {\n\t\t\tcount(uid)\n\t\t}\n", variable, variable)
+	}
+	q.WriteString("\t}")
+	return q.String(), counted
+}
+
+// erasureDelNquads deletes the counted records and strips the User node,
+// the students' links to deleted records and the progress contexts
+func erasureDelNquads(userID string, counted map[string]string) string {
+	var lines []string
+	for _, variable := range sortedKeys(counted) {
+		lines = append(lines, fmt.Sprintf("uid(%s) * * .", variable))
+	}
+	for _, predicate := range pseudonymisedContextPredicates {
+		lines = append(lines, fmt.Sprintf("uid(contexts) <%s> * .", predicate))
+	}
+	for _, edge := range []string{"profile", "medicalRecord", "emergencyContact", "consentRecords"} {
+		lines = append(lines, fmt.Sprintf("uid(students) <%s> * .", edge))
+	}
+	for _, predicate := range erasedUserPredicates {
+		lines = append(lines, fmt.Sprintf("<%s> <%s> * .", userID, predicate))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blockCount reads the count(uid) of a query block
+func blockCount(result map[string]json.RawMessage, block string) int {
+	var counts []struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(result[block], &counts); err != nil || len(counts) == 0 {
+		return 0
+	}
+	return counts[0].Count
+}
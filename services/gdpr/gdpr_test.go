@@ -0,0 +1,162 @@
+package gdpr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func lastRequest(t *testing.T) *dgraph.Request {
+	t.Helper()
+	size := dgraph.DgraphQueryCallStack.Size()
+	if size == 0 {
+		t.Fatal("Expected a Dgraph call")
+	}
+	return dgraph.DgraphQueryCallStack.Items[size-1][1].(*dgraph.Request)
+}
+
+func TestSubjectQueryLeavesOutSecrets(t *testing.T) {
+	for _, secret := range []string{"accessToken", "refreshToken", "publicKey", "tokenHash", "codeHash", "valueCiphertext"} {
+		if strings.Contains(subjectQuery, secret) {
+			t.Errorf("Expected the archive not to include %s", secret)
+		}
+	}
+	for _, section := range subjectSections {
+		if !strings.Contains(subjectQuery, section+"(func:") {
+			t.Errorf("Expected a %s block in the subject query", section)
+		}
+	}
+}
+
+func TestExportSubjectDataReadsTheUser(t *testing.T) {
+	if _, err := ExportSubjectData("alice", "0x1"); err == nil {
+		t.Error("Expected an invalid user ID to be rejected")
+	}
+
+	// The mock has no such user
+	if _, err := ExportSubjectData("0x2a", "0x2a"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Expected ErrUnknownUser, got %v", err)
+	}
+	if query := lastRequest(t).Query.Query; !strings.Contains(query, "uid(0x2a)") || !strings.Contains(query, `eq(userId, "0x2a")`) {
+		t.Errorf("Expected the query to select the user, got %s", query)
+	}
+}
+
+func TestFlattenEdge(t *testing.T) {
+	roots := []map[string]interface{}{
+		{"consentRecords": []interface{}{map[string]interface{}{"type": "PRIVACY"}, map[string]interface{}{"type": "PHOTO_RELEASE"}}},
+		{"consentRecords": []interface{}{map[string]interface{}{"type": "MEDICAL"}}},
+		{},
+	}
+	records := flattenEdge(roots, "consentRecords")
+	if len(records) != 3 || records[2]["type"] != "MEDICAL" {
+		t.Errorf("Expected the three consent records, got %v", records)
+	}
+	if records := flattenEdge(nil, "consentRecords"); records == nil || len(records) != 0 {
+		t.Errorf("Expected an empty section, got %v", records)
+	}
+}
+
+func TestErasureKeepsAuditAndAssessmentRecords(t *testing.T) {
+	query, counted := erasureQuery("0x2a")
+	nquads := erasureDelNquads("0x2a", counted)
+
+	for _, recordType := range []string{"UserProfile", "UserContact", "UserEmergency", "UserMedical", "UserSocial", "UserChannels", "AuthSession", "WebAuthnCredential"} {
+		if !strings.Contains(query, "type("+recordType+")") {
+			t.Errorf("Expected %s to be erased", recordType)
+		}
+	}
+	for _, recordType := range []string{"PostalAddress", "UserDiagnosis", "ConsentRecord", "IdentityDocument"} {
+		found := false
+		for _, counted := range counted {
+			found = found || counted == recordType
+		}
+		if !found {
+			t.Errorf("Expected %s to be erased", recordType)
+		}
+	}
+
+	if strings.Contains(query, "AuditEntry") || strings.Contains(nquads, "uid(students) * * .") {
+		t.Error("Expected audit entries and student records to be kept")
+	}
+	if strings.Contains(nquads, "uid(contexts) * * .") || !strings.Contains(nquads, "uid(contexts) <ipAddress> * .") {
+		t.Errorf("Expected progress contexts to be pseudonymised, not deleted:\n%s", nquads)
+	}
+	if !strings.Contains(nquads, "<0x2a> <emailDID> * .") || strings.Contains(nquads, "<0x2a> * * .") {
+		t.Errorf("Expected the User node to be stripped, not deleted:\n%s", nquads)
+	}
+}
+
+func TestEraseSubjectOnlyTouchesAnExistingUser(t *testing.T) {
+	if _, err := EraseSubject("0x2a OR 1", "0x1"); err == nil {
+		t.Error("Expected an invalid user ID to be rejected")
+	}
+
+	// The mock has no such user, so the conditional mutation didn't apply
+	if _, err := EraseSubject("0x2a", "0x1"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Expected ErrUnknownUser, got %v", err)
+	}
+
+	mutation := lastRequest(t).Mutations[0]
+	if mutation.Condition != "@if(eq(len(u), 1))" {
+		t.Errorf("Expected the erasure to require the user, got %s", mutation.Condition)
+	}
+	if !strings.Contains(mutation.SetNquads, `<0x2a> <status> "deleted" .`) {
+		t.Errorf("Expected the user to be marked deleted, got %s", mutation.SetNquads)
+	}
+}
+
+func TestOpenErasureRequest(t *testing.T) {
+	if _, err := OpenErasureRequest("0x2a", "", "moving on"); err == nil {
+		t.Error("Expected the verified channel to be required")
+	}
+
+	before := dgraph.DgraphQueryCallStack.Size()
+	req, err := OpenErasureRequest("0x2a", "did:email:abc", " moving on ")
+	if err != nil {
+		t.Fatalf("OpenErasureRequest failed: %v", err)
+	}
+	if req.Status != StatusPendingApproval || req.Reason != "moving on" {
+		t.Errorf("Unexpected request %+v", req)
+	}
+	if due := req.DueAt.Sub(req.CreatedAt); due != ResponseDays*24*time.Hour {
+		t.Errorf("Expected the request to be due in %d days, got %v", ResponseDays, due)
+	}
+
+	// The request is stored first, then audited
+	mutation := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request).Mutations[0]
+	if mutation.Condition != "@if(eq(len(open), 0))" || !strings.Contains(mutation.SetNquads, `_:erasure <user> <0x2a> .`) {
+		t.Errorf("Expected one open request per user, got %s\n%s", mutation.Condition, mutation.SetNquads)
+	}
+}
+
+func TestErasureRequestTransitions(t *testing.T) {
+	req := &ErasureRequest{UID: "0x10", UserID: "0x2a", Status: StatusPendingApproval}
+
+	if _, err := RejectErasureRequest(req, "0x1", "  "); err == nil {
+		t.Error("Expected a rejection to need a reason")
+	}
+	if _, err := CompleteErasureRequest(req, "0x1"); err == nil {
+		t.Error("Expected only approved requests to be completed")
+	}
+
+	// The mock holds no request, so the transition finds nothing to claim
+	approved, err := ApproveErasureRequest(req, "0x1")
+	if err != nil || approved {
+		t.Errorf("Expected no transition, got %t, %v", approved, err)
+	}
+	if query := lastRequest(t).Query.Query; !strings.Contains(query, `eq(status, "pending_approval")`) {
+		t.Errorf("Expected approval to require a pending request, got %s", query)
+	}
+}
+
+func TestIsUID(t *testing.T) {
+	for id, want := range map[string]bool{"0x2a": true, "0xABC": true, "0x": false, "2a": false, "0x2a)": false} {
+		if got := isUID(id); got != want {
+			t.Errorf("isUID(%q) = %t, want %t", id, got, want)
+		}
+	}
+}
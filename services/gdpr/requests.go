@@ -0,0 +1,318 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// ResponseDays is how long we have to act on an erasure request: one month
+// under GDPR Art. 12(3), taken as 30 days
+const ResponseDays = 30
+
+// Erasure request states (ErasureRequest.status)
+const (
+	StatusPendingApproval = "pending_approval"
+	StatusApproved        = "approved"
+	StatusRejected        = "rejected"
+	StatusCompleted       = "completed"
+)
+
+// ErrRequestOpen is returned when the user already has an erasure request
+// awaiting approval or erasure
+var ErrRequestOpen = errors.New("an erasure request is already open for this user")
+
+// ErasureRequest represents a stored ErasureRequest
+type ErasureRequest struct {
+	UID         string     `json:"uid,omitempty"`
+	UserID      string     `json:"-"`
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	ChannelDID  string     `json:"channelDID"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DueAt       time.Time  `json:"dueAt"`
+	ReviewedBy  string     `json:"-"`
+	ReviewedAt  *time.Time `json:"reviewedAt,omitempty"`
+	ReviewNote  string     `json:"reviewNote,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Erased      string     `json:"erasedCounts,omitempty"` // JSON of records erased per type
+	User        []struct {
+		UID string `json:"uid"`
+	} `json:"user,omitempty"`
+	Reviewer []struct {
+		UID string `json:"uid"`
+	} `json:"reviewedBy,omitempty"`
+}
+
+// requestFields are the ErasureRequest predicates read back
+const requestFields = `uid
+			user {
+				uid
+			}
+			status
+			reason
+			channelDID
+			createdAt
+			dueAt
+			reviewedBy {
+				uid
+			}
+			reviewedAt
+			reviewNote
+			completedAt
+			erasedCounts`
+
+// OpenErasureRequest queues a user's request to have their data erased.
+// channelDID is the channel the user proved they control just before
+// asking. A user can only have one open request at a time.
+func OpenErasureRequest(userID, channelDID, reason string) (*ErasureRequest, error) {
+	if !isUID(userID) || channelDID == "" {
+		return nil, errors.New("user ID and channel DID are required")
+	}
+	reason = strings.TrimSpace(reason)
+
+	now := time.Now().UTC()
+	dueAt := now.Add(ResponseDays * 24 * time.Hour)
+
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		open as var(func: type(ErasureRequest)) @filter(uid_in(user, %s) AND (eq(status, "%s") OR eq(status, "%s")))
+		open(func: uid(open)) {
+			uid
+		}
+	}`, userID, StatusPendingApproval, StatusApproved))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(open), 0))").
+		WithSetNquads(fmt.Sprintf(`_:erasure <dgraph.type> "ErasureRequest" .
+_:erasure <user> <%s> .
+_:erasure <status> "%s" .
+_:erasure <reason> %q .
+_:erasure <channelDID> %q .
+_:erasure <createdAt> "%s"^^<xs:dateTime> .
+_:erasure <dueAt> "%s"^^<xs:dateTime> .`,
+			userID, StatusPendingApproval, reason, channelDID,
+			now.Format(time.RFC3339), dueAt.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store erasure request: %v", err)
+	}
+
+	var result struct {
+		Open []struct {
+			UID string `json:"uid"`
+		} `json:"open"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+	if len(result.Open) > 0 {
+		return nil, ErrRequestOpen
+	}
+
+	req := &ErasureRequest{
+		UID:        resp.Uids["erasure"],
+		UserID:     userID,
+		Status:     StatusPendingApproval,
+		Reason:     reason,
+		ChannelDID: channelDID,
+		CreatedAt:  now,
+		DueAt:      dueAt,
+	}
+
+	adminAuditor.Record("ERASURE_REQUESTED", "ErasureRequest", req.UID, userID, themislog.SeverityWarning,
+		fmt.Sprintf("Erasure requested for user %s, due by %s", userID, dueAt.Format(time.RFC3339)))
+
+	log.Printf("🗑️ GDPR: Erasure requested by user %s", userID)
+	return req, nil
+}
+
+// GetErasureRequest loads an erasure request by its uid
+func GetErasureRequest(requestID string) (*ErasureRequest, error) {
+	if !isUID(requestID) {
+		return nil, errors.New("invalid erasure request ID")
+	}
+
+	query := fmt.Sprintf(`{
+		erasure(func: uid(%s)) @filter(type(ErasureRequest)) {
+			%s
+		}
+	}`, requestID, requestFields)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Erasure []ErasureRequest `json:"erasure"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+	if len(result.Erasure) == 0 {
+		return nil, nil
+	}
+	req := result.Erasure[0]
+	req.resolveEdges()
+	return &req, nil
+}
+
+// ListErasureRequests returns the requests in a status, oldest first; the
+// pending and approved ones are the approval queue
+func ListErasureRequests(status string) ([]ErasureRequest, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`query erasures($status: string) {
+		erasures(func: eq(status, $status), orderasc: createdAt) @filter(type(ErasureRequest)) {
+			%s
+		}
+	}`, requestFields)).WithVariable("$status", status)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Erasures []ErasureRequest `json:"erasures"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+	for i := range result.Erasures {
+		result.Erasures[i].resolveEdges()
+	}
+	return result.Erasures, nil
+}
+
+// ApproveErasureRequest approves a pending request. The erasure itself is
+// run by CompleteErasureRequest, which can be retried if it fails.
+func ApproveErasureRequest(req *ErasureRequest, adminUserID string) (bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <reviewedBy> <%s> .
+<%s> <reviewedAt> "%s"^^<xs:dateTime> .`,
+		req.UID, StatusApproved, req.UID, adminUserID, req.UID, now)
+
+	ok, err := transition(req.UID, StatusPendingApproval, mutation)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	adminAuditor.Record("ERASURE_APPROVED", "ErasureRequest", req.UID, adminUserID, themislog.SeverityCritical,
+		fmt.Sprintf("Erasure of user %s approved", req.UserID))
+	return true, nil
+}
+
+// RejectErasureRequest rejects a pending request, e.g. when the data must
+// be kept to meet a legal obligation. The note is shown to the user.
+func RejectErasureRequest(req *ErasureRequest, adminUserID, note string) (bool, error) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return false, errors.New("a rejection needs a reason")
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <reviewedBy> <%s> .
+<%s> <reviewedAt> "%s"^^<xs:dateTime> .
+<%s> <reviewNote> %q .`,
+		req.UID, StatusRejected, req.UID, adminUserID, req.UID, now, req.UID, note)
+
+	ok, err := transition(req.UID, StatusPendingApproval, mutation)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	adminAuditor.Record("ERASURE_REJECTED", "ErasureRequest", req.UID, adminUserID, themislog.SeverityWarning,
+		fmt.Sprintf("Erasure of user %s rejected: %s", req.UserID, note))
+	return true, nil
+}
+
+// CompleteErasureRequest erases the user of an approved request and closes
+// it. If the erasure fails, e.g. under a legal hold, the request stays
+// approved and can be completed later.
+func CompleteErasureRequest(req *ErasureRequest, performedBy string) (*ErasureReport, error) {
+	if req.Status != StatusApproved {
+		return nil, errors.New("erasure request is not approved")
+	}
+
+	report, err := EraseSubject(req.UserID, performedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, _ := json.Marshal(report.Erased)
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <completedAt> "%s"^^<xs:dateTime> .
+<%s> <erasedCounts> %q .`,
+		req.UID, StatusCompleted, req.UID, report.ErasedAt.Format(time.RFC3339), req.UID, string(counts))
+
+	ok, err := transition(req.UID, StatusApproved, mutation)
+	if err != nil {
+		return report, fmt.Errorf("user erased but the request was not closed: %v", err)
+	}
+	if !ok {
+		log.Printf("⚠️ Warning: Erasure request %s was closed by someone else", req.UID)
+	}
+	return report, nil
+}
+
+// transition applies nquads only while the request is still in fromStatus
+func transition(requestUID, fromStatus, nquads string) (bool, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		r as var(func: uid(%s)) @filter(type(ErasureRequest) AND eq(status, "%s"))
+		claimed(func: uid(r)) {
+			uid
+		}
+	}`, requestUID, fromStatus))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(r), 1))").
+		WithSetNquads(nquads)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return false, fmt.Errorf("failed to update erasure request: %v", err)
+	}
+
+	var result struct {
+		Claimed []struct {
+			UID string `json:"uid"`
+		} `json:"claimed"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, err
+	}
+	return len(result.Claimed) == 1, nil
+}
+
+// resolveEdges copies the uids of the user and reviewer edges
+func (r *ErasureRequest) resolveEdges() {
+	if len(r.User) > 0 {
+		r.UserID = r.User[0].UID
+	}
+	if len(r.Reviewer) > 0 {
+		r.ReviewedBy = r.Reviewer[0].UID
+	}
+}
+
+// isUID checks s looks like a Dgraph uid before it is put into a query
+func isUID(s string) bool {
+	if len(s) < 3 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	for _, r := range s[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
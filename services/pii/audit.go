@@ -0,0 +1,8 @@
+package pii
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes PII vault events to the audit trail. Unlike most agents,
+// the vault uses Log and returns audit failures: a value is only revealed
+// once its access is on the audit trail.
+var auditor = themislog.Auditor{Source: "PIIVault", Category: themislog.CategoryPIIAccess}
@@ -0,0 +1,116 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ciphertextVersion prefixes stored ciphertexts so the scheme can change later
+const ciphertextVersion = "v1:"
+
+// dataKeySize is the length of a per-value data key in bytes (AES-256)
+const dataKeySize = 32
+
+// envelope is a value sealed with its own data key, and that data key
+// sealed with the tenant key derived from a master key
+type envelope struct {
+	KeyID      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey"`
+	Ciphertext string `json:"valueCiphertext"`
+}
+
+// seal encrypts a value for a tenant under the active master key. The
+// value is bound to its tenant, kind and token, and the data key to its
+// tenant and master key, so neither can be moved onto another record.
+func seal(ring *keyRing, tenantID, kind, token, value string) (*envelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := sealWith(dataKey, []byte(value), valueAAD(tenantID, kind, token))
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := sealWith(ring.active.tenantKey(tenantID), dataKey, keyAAD(tenantID, ring.active.kid))
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{KeyID: ring.active.kid, WrappedKey: wrappedKey, Ciphertext: ciphertext}, nil
+}
+
+// open decrypts a value sealed by seal for the same tenant, kind and token
+func open(ring *keyRing, env *envelope, tenantID, kind, token string) (string, error) {
+	master, err := ring.lookup(env.KeyID)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := openWith(master.tenantKey(tenantID), env.WrappedKey, keyAAD(tenantID, env.KeyID))
+	if err != nil {
+		return "", errors.New("failed to unwrap PII data key")
+	}
+	value, err := openWith(dataKey, env.Ciphertext, valueAAD(tenantID, kind, token))
+	if err != nil {
+		return "", errors.New("failed to decrypt PII value")
+	}
+	return string(value), nil
+}
+
+func valueAAD(tenantID, kind, token string) []byte {
+	return []byte(strings.Join([]string{"value", tenantID, kind, token}, "\x00"))
+}
+
+func keyAAD(tenantID, kid string) []byte {
+	return []byte(strings.Join([]string{"key", tenantID, kid}, "\x00"))
+}
+
+// sealWith encrypts with AES-256-GCM and returns the versioned, base64
+// encoded nonce and ciphertext
+func sealWith(key, plaintext, additionalData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return ciphertextVersion + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openWith decrypts a ciphertext written by sealWith
+func openWith(key []byte, ciphertext string, additionalData []byte) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, ciphertextVersion) {
+		return nil, errors.New("unsupported PII ciphertext encoding")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, ciphertextVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("PII ciphertext too short")
+	}
+
+	nonce, body := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, body, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
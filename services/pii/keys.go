@@ -0,0 +1,157 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"modus/config"
+)
+
+// MasterKeysSecret is the Modus secret holding the vault master keys as a
+// JSON array, e.g.
+//
+//	[{"kid": "2026-10", "status": "active", "key": "<base64 of 32 random bytes>"},
+//	 {"kid": "2025-10", "status": "decrypt", "key": "..."},
+//	 {"kid": "2024-10", "status": "retired"}]
+//
+// Exactly one key is active and wraps the data keys of new values. Decrypt
+// keys still open values stored before a rotation; values under a retired
+// key can no longer be read.
+const MasterKeysSecret = "PII_MASTER_KEYS"
+
+// Key statuses
+const (
+	KeyStatusActive  = "active"
+	KeyStatusDecrypt = "decrypt"
+	KeyStatusRetired = "retired"
+)
+
+// masterKeySize is the length of a master key in bytes (AES-256)
+const masterKeySize = 32
+
+// ErrRetiredKey is returned for a value whose master key has been retired
+var ErrRetiredKey = errors.New("PII value is under a retired master key")
+
+// MasterKeyConfig is one entry of MasterKeysSecret
+type MasterKeyConfig struct {
+	KID    string `json:"kid"`
+	Status string `json:"status"`
+	Key    string `json:"key,omitempty"` // base64; not needed for retired keys
+}
+
+// masterKey is a parsed MasterKeyConfig
+type masterKey struct {
+	kid    string
+	status string
+	key    []byte
+}
+
+// keyRing holds every configured master key by kid
+type keyRing struct {
+	active *masterKey
+	keys   map[string]*masterKey
+}
+
+var masterKeysOverride *keyRing
+
+func init() {
+	config.RegisterSecret(MasterKeysSecret, true, func(value string) error {
+		_, err := parseKeyRing(value)
+		return err
+	})
+}
+
+// SetMasterKeys overrides the keys read from MasterKeysSecret
+func SetMasterKeys(config string) error {
+	ring, err := parseKeyRing(config)
+	if err != nil {
+		return err
+	}
+	masterKeysOverride = ring
+	return nil
+}
+
+// loadKeyRing returns the configured master keys
+func loadKeyRing() (*keyRing, error) {
+	if masterKeysOverride != nil {
+		return masterKeysOverride, nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.PIIMasterKeys == "" {
+		return nil, fmt.Errorf("%s is not configured", MasterKeysSecret)
+	}
+	return parseKeyRing(cfg.Secrets.PIIMasterKeys)
+}
+
+// parseKeyRing parses and checks a MasterKeysSecret value
+func parseKeyRing(config string) (*keyRing, error) {
+	var entries []MasterKeyConfig
+	if err := json.Unmarshal([]byte(config), &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", MasterKeysSecret, err)
+	}
+
+	ring := &keyRing{keys: make(map[string]*masterKey)}
+	for _, entry := range entries {
+		if entry.KID == "" {
+			return nil, fmt.Errorf("invalid %s: key without kid", MasterKeysSecret)
+		}
+		if _, exists := ring.keys[entry.KID]; exists {
+			return nil, fmt.Errorf("invalid %s: duplicate kid %q", MasterKeysSecret, entry.KID)
+		}
+
+		key := &masterKey{kid: entry.KID, status: entry.Status}
+		switch entry.Status {
+		case KeyStatusRetired:
+		case KeyStatusActive, KeyStatusDecrypt:
+			raw, err := base64.StdEncoding.DecodeString(entry.Key)
+			if err != nil || len(raw) != masterKeySize {
+				return nil, fmt.Errorf("invalid %s: key %q must be %d base64-encoded bytes", MasterKeysSecret, entry.KID, masterKeySize)
+			}
+			key.key = raw
+		default:
+			return nil, fmt.Errorf("invalid %s: key %q has unknown status %q", MasterKeysSecret, entry.KID, entry.Status)
+		}
+		ring.keys[key.kid] = key
+
+		if key.status == KeyStatusActive {
+			if ring.active != nil {
+				return nil, fmt.Errorf("invalid %s: more than one active key", MasterKeysSecret)
+			}
+			ring.active = key
+		}
+	}
+
+	if ring.active == nil {
+		return nil, fmt.Errorf("invalid %s: no active key", MasterKeysSecret)
+	}
+	return ring, nil
+}
+
+// lookup returns the master key a value was wrapped with
+func (r *keyRing) lookup(kid string) (*masterKey, error) {
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown PII master key %q", kid)
+	}
+	if key.status == KeyStatusRetired {
+		return nil, ErrRetiredKey
+	}
+	return key, nil
+}
+
+// tenantKey derives the key that wraps a tenant's data keys from a master
+// key, so no tenant can open another tenant's values and no per-tenant key
+// material has to be stored
+func (k *masterKey) tenantKey(tenantID string) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("pii-tenant-key:v1:"))
+	mac.Write([]byte(tenantID))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,352 @@
+package pii
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// Kinds of PII the vault holds. The kind decides the token format and the
+// purposes a value may be revealed for.
+const (
+	KindEmail = "email"
+	KindPhone = "phone"
+	KindName  = "name"
+	KindText  = "text"
+)
+
+// Purposes a token may be detokenised for
+const (
+	PurposeOIDCClaims       = "oidc_claims"       // claims for an OIDC client the user consented to
+	PurposeNotification     = "notification"      // contacting the user
+	PurposeEmergencyContact = "emergency_contact" // reaching a user's emergency contact
+	PurposeSubjectAccess    = "subject_access"    // giving users their own data
+)
+
+// purposeKinds lists the kinds each purpose may reveal
+var purposeKinds = map[string][]string{
+	PurposeOIDCClaims:       {KindEmail, KindName},
+	PurposeNotification:     {KindEmail, KindPhone, KindName},
+	PurposeEmergencyContact: {KindEmail, KindPhone, KindName},
+	PurposeSubjectAccess:    {KindEmail, KindPhone, KindName, KindText},
+}
+
+// DefaultTenant holds the PII of users not yet placed in a tenant
+const DefaultTenant = "platform"
+
+// maxTokenAttempts bounds retries after a generated token collides
+const maxTokenAttempts = 3
+
+// Vault errors
+var (
+	ErrUnknownPurpose    = errors.New("unknown PII access purpose")
+	ErrPurposeNotAllowed = errors.New("PII access purpose does not allow this data")
+	ErrTokenCollision    = errors.New("could not generate a unique PII token")
+	ErrRequesterRequired = errors.New("PII access needs the requesting user or client")
+)
+
+// Vault tokenises and detokenises the PII of one tenant. Values are
+// envelope-encrypted: each with its own data key, wrapped by a key derived
+// for the tenant from the active master key.
+type Vault struct {
+	tenantID string
+}
+
+// NewVault creates a vault for a tenant's PII
+func NewVault(tenantID string) *Vault {
+	return &Vault{tenantID: tenantID}
+}
+
+// vaultRecord is a PIIVaultRecord as stored in Dgraph
+type vaultRecord struct {
+	UID       string `json:"uid"`
+	Token     string `json:"token"`
+	Kind      string `json:"piiKind"`
+	TenantID  string `json:"tenantId"`
+	SubjectID string `json:"userId"`
+	envelope
+}
+
+// Tokenize stores a value for the user it belongs to and returns a token
+// in the same format, to be stored in its place
+func (v *Vault) Tokenize(subjectID, kind, value string) (string, error) {
+	if v.tenantID == "" || subjectID == "" {
+		return "", errors.New("tokenising PII needs a tenant and a user")
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("cannot tokenise an empty value")
+	}
+	if err := validateValue(kind, value); err != nil {
+		return "", err
+	}
+
+	ring, err := loadKeyRing()
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < maxTokenAttempts; attempt++ {
+		token, err := newToken(kind, value)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate PII token: %w", err)
+		}
+		env, err := seal(ring, v.tenantID, kind, token, value)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt PII: %w", err)
+		}
+
+		stored, err := storeRecord(v.tenantID, subjectID, kind, token, env)
+		if err != nil {
+			return "", err
+		}
+		if stored {
+			return token, nil
+		}
+	}
+	return "", ErrTokenCollision
+}
+
+// storeRecord writes a vault record unless its token is taken. It reports
+// whether the record was written.
+func storeRecord(tenantID, subjectID, kind, token string, env *envelope) (bool, error) {
+	query := dgraph.NewQuery(`query record($token: string) {
+		existing as var(func: eq(token, $token)) @filter(type(PIIVaultRecord))
+
+		taken(func: uid(existing)) {
+			count(uid)
+		}
+	}`).WithVariable("$token", token)
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(existing), 0))").
+		WithSetNquads(fmt.Sprintf(`_:record <dgraph.type> "PIIVaultRecord" .
+_:record <token> %q .
+_:record <piiKind> %q .
+_:record <tenantId> %q .
+_:record <userId> %q .
+_:record <keyId> %q .
+_:record <wrappedKey> %q .
+_:record <valueCiphertext> %q .
+_:record <createdAt> "%s"^^<xs:dateTime> .`,
+			token, kind, tenantID, subjectID, env.KeyID, env.WrappedKey, env.Ciphertext,
+			time.Now().UTC().Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return false, fmt.Errorf("failed to store PII: %w", err)
+	}
+
+	var result struct {
+		Taken []struct {
+			Count int `json:"count"`
+		} `json:"taken"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, fmt.Errorf("failed to parse PII store result: %w", err)
+	}
+	return len(result.Taken) == 0 || result.Taken[0].Count == 0, nil
+}
+
+// Detokenize returns the values behind tokens, by token, for a purpose that
+// allows their kinds. The access is written to ThemisLog, per user, before
+// anything is returned; if any token's kind isn't allowed nothing is. Tokens
+// the vault doesn't hold for this tenant are left out of the result.
+func (v *Vault) Detokenize(purpose, requestedBy string, tokens ...string) (map[string]string, error) {
+	allowed, ok := purposeKinds[purpose]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPurpose, purpose)
+	}
+	if requestedBy == "" {
+		return nil, ErrRequesterRequired
+	}
+
+	wanted := uniqueTokens(tokens)
+	values := make(map[string]string, len(wanted))
+	if len(wanted) == 0 {
+		return values, nil
+	}
+
+	records, err := v.findRecords(wanted)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return values, nil
+	}
+
+	bySubject := map[string][]vaultRecord{}
+	var denied []vaultRecord
+	for _, rec := range records {
+		if !contains(allowed, rec.Kind) {
+			denied = append(denied, rec)
+		}
+		bySubject[rec.SubjectID] = append(bySubject[rec.SubjectID], rec)
+	}
+
+	if len(denied) > 0 {
+		for _, rec := range denied {
+			details := accessDetails(purpose, []vaultRecord{rec})
+			if err := auditor.Log("PII_ACCESS_DENIED", "User", rec.SubjectID, requestedBy, themislog.SeverityWarning, details); err != nil {
+				return nil, fmt.Errorf("%w (and the denial was not audited: %v)", ErrPurposeNotAllowed, err)
+			}
+		}
+		return nil, ErrPurposeNotAllowed
+	}
+
+	ring, err := loadKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		value, err := open(ring, &rec.envelope, rec.TenantID, rec.Kind, rec.Token)
+		if err != nil {
+			return nil, fmt.Errorf("PII token %s: %w", rec.Token, err)
+		}
+		values[rec.Token] = value
+	}
+
+	for _, subjectID := range sortedKeys(bySubject) {
+		details := accessDetails(purpose, bySubject[subjectID])
+		if err := auditor.Log("PII_DETOKENIZED", "User", subjectID, requestedBy, themislog.SeverityInfo, details); err != nil {
+			return nil, fmt.Errorf("PII access could not be audited: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// EraseSubject deletes every value the vault holds for a user in this
+// tenant. Their tokens can't be detokenised afterwards, wherever they are
+// stored. It returns how many values were erased.
+func (v *Vault) EraseSubject(subjectID, performedBy string) (int, error) {
+	if subjectID == "" {
+		return 0, errors.New("erasing PII needs a user")
+	}
+
+	query := dgraph.NewQuery(`query subject($userId: string, $tenantId: string) {
+		records as var(func: eq(userId, $userId)) @filter(type(PIIVaultRecord) AND eq(tenantId, $tenantId))
+
+		erased(func: uid(records)) {
+			count(uid)
+		}
+	}`).WithVariable("$userId", subjectID).WithVariable("$tenantId", v.tenantID)
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(gt(len(records), 0))").
+		WithDelNquads(`uid(records) * * .`)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to erase PII: %w", err)
+	}
+
+	var result struct {
+		Erased []struct {
+			Count int `json:"count"`
+		} `json:"erased"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return 0, fmt.Errorf("failed to parse PII erasure: %w", err)
+	}
+	erased := 0
+	if len(result.Erased) > 0 {
+		erased = result.Erased[0].Count
+	}
+
+	details := fmt.Sprintf(`{"tenantId":%s,"erased":%d}`, strconv.Quote(v.tenantID), erased)
+	if err := auditor.Log("PII_ERASED", "User", subjectID, performedBy, themislog.SeverityWarning, details); err != nil {
+		return erased, fmt.Errorf("PII erased but not audited: %w", err)
+	}
+	return erased, nil
+}
+
+// findRecords reads the vault records of this tenant for tokens
+func (v *Vault) findRecords(tokens []string) ([]vaultRecord, error) {
+	quoted := make([]string, len(tokens))
+	for i, t := range tokens {
+		quoted[i] = strconv.Quote(t)
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query records($tenantId: string) {
+		records(func: eq(token, [%s])) @filter(type(PIIVaultRecord) AND eq(tenantId, $tenantId)) {
+			uid
+			token
+			piiKind
+			tenantId
+			userId
+			keyId
+			wrappedKey
+			valueCiphertext
+		}
+	}`, strings.Join(quoted, ", "))).WithVariable("$tenantId", v.tenantID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PII: %w", err)
+	}
+
+	var result struct {
+		Records []vaultRecord `json:"records"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse PII records: %w", err)
+	}
+	return result.Records, nil
+}
+
+// accessDetails describes an access for the audit trail: the purpose, and
+// the tokens and kinds accessed, never the values
+func accessDetails(purpose string, records []vaultRecord) string {
+	details := struct {
+		Purpose string   `json:"purpose"`
+		Tokens  []string `json:"tokens"`
+		Kinds   []string `json:"kinds"`
+	}{Purpose: purpose}
+	for _, rec := range records {
+		details.Tokens = append(details.Tokens, rec.Token)
+		if !contains(details.Kinds, rec.Kind) {
+			details.Kinds = append(details.Kinds, rec.Kind)
+		}
+	}
+	data, _ := json.Marshal(details)
+	return string(data)
+}
+
+// uniqueTokens drops empty and repeated tokens
+func uniqueTokens(tokens []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+func sortedKeys(m map[string][]vaultRecord) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package pii
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testRing(t *testing.T, config string) *keyRing {
+	t.Helper()
+	ring, err := parseKeyRing(config)
+	if err != nil {
+		t.Fatalf("parseKeyRing failed: %v", err)
+	}
+	return ring
+}
+
+func TestTokensKeepTheFormatOfTheValue(t *testing.T) {
+	email, err := newToken(KindEmail, "ada.lovelace.countess@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(email, "@"+TokenEmailDomain) || len(email) != len("ada.lovelace.countess@")+len(TokenEmailDomain) {
+		t.Errorf("Expected an email token with a local part as long as the value's, got %s", email)
+	}
+	if err := validateValue(KindEmail, email); err != nil {
+		t.Errorf("Expected the email token to be a valid email: %v", err)
+	}
+
+	phone, err := newToken(KindPhone, "+44 7700 900123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(phone, TokenPhonePrefix) || countDigits(phone) != 12 || len(phone) != 13 {
+		t.Errorf("Expected a 12-digit phone token under %s, got %s", TokenPhonePrefix, phone)
+	}
+
+	name, err := newToken(KindName, "Mary-Jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name) != minNameLetters+1 || name[4] != '-' || name[0] < 'A' || name[0] > 'Z' || name[5] < 'A' || name[5] > 'Z' {
+		t.Errorf("Expected a padded name token keeping case and the hyphen, got %s", name)
+	}
+
+	text, err := newToken(KindText, "Flat 2, 10 Downing Street")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, TokenTextPrefix) || len(text) != len(TokenTextPrefix)+textTokenLength {
+		t.Errorf("Unexpected text token %s", text)
+	}
+}
+
+func TestValidateValue(t *testing.T) {
+	tests := []struct {
+		kind, value string
+		valid       bool
+	}{
+		{KindEmail, "ada@example.com", true},
+		{KindEmail, "ada.example.com", false},
+		{KindEmail, "@example.com", false},
+		{KindPhone, "+44 7700 900123", true},
+		{KindPhone, "12345", false},
+		{KindName, "Ada", true},
+		{"ssn", "123-45-6789", false},
+	}
+	for _, tt := range tests {
+		if err := validateValue(tt.kind, tt.value); (err == nil) != tt.valid {
+			t.Errorf("validateValue(%s, %q) = %v, want valid %t", tt.kind, tt.value, err, tt.valid)
+		}
+	}
+}
+
+func TestEnvelopeIsBoundToTenantKindAndToken(t *testing.T) {
+	ring := testRing(t, fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}]`, testKey(t)))
+
+	env, err := seal(ring, "centre-a", KindEmail, "tok@pii.invalid", "ada@example.com")
+	if err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+	if env.KeyID != "k1" || strings.Contains(env.Ciphertext, "ada") {
+		t.Errorf("Unexpected envelope %+v", env)
+	}
+
+	value, err := open(ring, env, "centre-a", KindEmail, "tok@pii.invalid")
+	if err != nil || value != "ada@example.com" {
+		t.Fatalf("open = %q, %v", value, err)
+	}
+
+	if _, err := open(ring, env, "centre-b", KindEmail, "tok@pii.invalid"); err == nil {
+		t.Error("Expected another tenant not to open the value")
+	}
+	if _, err := open(ring, env, "centre-a", KindName, "tok@pii.invalid"); err == nil {
+		t.Error("Expected the value not to open as another kind")
+	}
+	if _, err := open(ring, env, "centre-a", KindEmail, "other@pii.invalid"); err == nil {
+		t.Error("Expected the value not to open under another token")
+	}
+}
+
+func TestRotatedMasterKeys(t *testing.T) {
+	oldKey, newKey := testKey(t), testKey(t)
+	before := testRing(t, fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}]`, oldKey))
+	env, err := seal(before, DefaultTenant, KindName, "Qwertyuiopas", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testRing(t, fmt.Sprintf(`[{"kid": "k2", "status": "active", "key": %q}, {"kid": "k1", "status": "decrypt", "key": %q}]`, newKey, oldKey))
+	if value, err := open(rotated, env, DefaultTenant, KindName, "Qwertyuiopas"); err != nil || value != "Ada" {
+		t.Errorf("Expected a decrypt key to open older values, got %q, %v", value, err)
+	}
+	if fresh, _ := seal(rotated, DefaultTenant, KindName, "Asdfghjklzxc", "Ada"); fresh.KeyID != "k2" {
+		t.Errorf("Expected new values under the active key, got %s", fresh.KeyID)
+	}
+
+	retired := testRing(t, fmt.Sprintf(`[{"kid": "k2", "status": "active", "key": %q}, {"kid": "k1", "status": "retired"}]`, newKey))
+	if _, err := open(retired, env, DefaultTenant, KindName, "Qwertyuiopas"); !errors.Is(err, ErrRetiredKey) {
+		t.Errorf("Expected ErrRetiredKey, got %v", err)
+	}
+}
+
+func TestParseKeyRingRejectsBadConfig(t *testing.T) {
+	key := testKey(t)
+	short := base64.StdEncoding.EncodeToString([]byte("too short"))
+	configs := map[string]string{
+		"not json":    `{`,
+		"no active":   fmt.Sprintf(`[{"kid": "k1", "status": "decrypt", "key": %q}]`, key),
+		"two active":  fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}, {"kid": "k2", "status": "active", "key": %q}]`, key, key),
+		"short key":   fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}]`, short),
+		"missing kid": fmt.Sprintf(`[{"status": "active", "key": %q}]`, key),
+		"bad status":  fmt.Sprintf(`[{"kid": "k1", "status": "enabled", "key": %q}]`, key),
+	}
+	for name, config := range configs {
+		if _, err := parseKeyRing(config); err == nil {
+			t.Errorf("%s: expected the key ring to be rejected", name)
+		}
+	}
+}
+
+func TestTokenizeStoresOnlyTheCiphertext(t *testing.T) {
+	if err := SetMasterKeys(fmt.Sprintf(`[{"kid": "k1", "status": "active", "key": %q}]`, testKey(t))); err != nil {
+		t.Fatal(err)
+	}
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	token, err := NewVault(DefaultTenant).Tokenize("0x2a", KindEmail, " ada@example.com ")
+	if err != nil {
+		t.Fatalf("Tokenize failed: %v", err)
+	}
+	if !strings.HasSuffix(token, "@"+TokenEmailDomain) {
+		t.Errorf("Expected an email token, got %s", token)
+	}
+
+	req := dgraph.DgraphQueryCallStack.Items[before][1].(*dgraph.Request)
+	nquads := req.Mutations[0].SetNquads
+	if strings.Contains(nquads, "ada") || !strings.Contains(nquads, fmt.Sprintf("<token> %q", token)) {
+		t.Errorf("Expected the token and no plaintext to be stored, got %s", nquads)
+	}
+	if req.Mutations[0].Condition != "@if(eq(len(existing), 0))" {
+		t.Errorf("Expected the record to be written only if the token is free, got %s", req.Mutations[0].Condition)
+	}
+}
+
+func TestDetokenizeNeedsAKnownPurposeAndRequester(t *testing.T) {
+	vault := NewVault(DefaultTenant)
+	if _, err := vault.Detokenize("marketing", "0x2a", "tok"); !errors.Is(err, ErrUnknownPurpose) {
+		t.Errorf("Expected ErrUnknownPurpose, got %v", err)
+	}
+	if _, err := vault.Detokenize(PurposeSubjectAccess, "", "tok"); !errors.Is(err, ErrRequesterRequired) {
+		t.Errorf("Expected ErrRequesterRequired, got %v", err)
+	}
+	if values, err := vault.Detokenize(PurposeSubjectAccess, "0x2a", "", ""); err != nil || len(values) != 0 {
+		t.Errorf("Expected nothing to look up, got %v, %v", values, err)
+	}
+}
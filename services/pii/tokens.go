@@ -0,0 +1,172 @@
+package pii
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Tokens keep the format of the value they stand for, so they pass the same
+// validation and fit the same fields. They are random, not derived from the
+// value: only the vault can map a token back.
+const (
+	// TokenEmailDomain is reserved (RFC 2606), so mail to a token bounces
+	TokenEmailDomain = "pii.invalid"
+	// TokenPhonePrefix is an unassigned country calling code
+	TokenPhonePrefix = "+999"
+	// TokenTextPrefix starts tokens for free text
+	TokenTextPrefix = "tok_"
+)
+
+// Token lengths, bounded so a short value still gets a token that is
+// unlikely to collide and a long one doesn't make an unwieldy token
+const (
+	minEmailLocalLength = 16
+	maxEmailLocalLength = 64
+	minPhoneDigits      = 10
+	maxPhoneDigits      = 15 // E.164
+	minNameLetters      = 12
+	textTokenLength     = 26
+)
+
+const (
+	lowerAlphabet     = "abcdefghijklmnopqrstuvwxyz"
+	upperAlphabet     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitAlphabet     = "0123456789"
+	emailAlphabet     = lowerAlphabet + digitAlphabet
+	textTokenAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+// validateValue checks a value is of the kind it is being tokenised as
+func validateValue(kind, value string) error {
+	switch kind {
+	case KindEmail:
+		at := strings.LastIndex(value, "@")
+		if at < 1 || at == len(value)-1 {
+			return fmt.Errorf("%q is not an email address", value)
+		}
+	case KindPhone:
+		if n := countDigits(value); n < 7 || n > maxPhoneDigits {
+			return fmt.Errorf("%q is not a phone number", value)
+		}
+	case KindName, KindText:
+	default:
+		return fmt.Errorf("unknown PII kind %q", kind)
+	}
+	return nil
+}
+
+// newToken returns a random token in the format of a value of the kind:
+//
+//	email  ada@example.com   -> k3v9q0z1m4x7c2bw@pii.invalid
+//	phone  +44 7700 900123   -> +999418305527
+//	name   Mary-Jane         -> Qkzm-Xpwqrhtu
+//	text   anything          -> tok_3mzq7...
+func newToken(kind, value string) (string, error) {
+	switch kind {
+	case KindEmail:
+		local := strings.LastIndex(value, "@")
+		local = clamp(local, minEmailLocalLength, maxEmailLocalLength)
+		name, err := randomString(emailAlphabet, local)
+		if err != nil {
+			return "", err
+		}
+		return name + "@" + TokenEmailDomain, nil
+
+	case KindPhone:
+		digits := clamp(countDigits(value), minPhoneDigits, maxPhoneDigits)
+		number, err := randomString(digitAlphabet, digits-(len(TokenPhonePrefix)-1))
+		if err != nil {
+			return "", err
+		}
+		return TokenPhonePrefix + number, nil
+
+	case KindName:
+		return nameToken(value)
+
+	case KindText:
+		body, err := randomString(textTokenAlphabet, textTokenLength)
+		if err != nil {
+			return "", err
+		}
+		return TokenTextPrefix + body, nil
+	}
+	return "", fmt.Errorf("unknown PII kind %q", kind)
+}
+
+// nameToken replaces every letter with a random one of the same case and
+// keeps spaces, hyphens and apostrophes, then pads short names
+func nameToken(value string) (string, error) {
+	var token strings.Builder
+	letters := 0
+	for _, r := range value {
+		if !unicode.IsLetter(r) {
+			token.WriteRune(r)
+			continue
+		}
+		alphabet := lowerAlphabet
+		if unicode.IsUpper(r) {
+			alphabet = upperAlphabet
+		}
+		c, err := randomString(alphabet, 1)
+		if err != nil {
+			return "", err
+		}
+		token.WriteString(c)
+		letters++
+	}
+	if letters < minNameLetters {
+		pad, err := randomString(lowerAlphabet, minNameLetters-letters)
+		if err != nil {
+			return "", err
+		}
+		token.WriteString(pad)
+	}
+	return token.String(), nil
+}
+
+// randomString returns n characters drawn uniformly from alphabet
+func randomString(alphabet string, n int) (string, error) {
+	// Reject bytes past the largest multiple of the alphabet size so every
+	// character is equally likely
+	limit := 256 - 256%len(alphabet)
+
+	chars := make([]byte, 0, n)
+	buf := make([]byte, n*2)
+	for len(chars) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			chars = append(chars, alphabet[int(b)%len(alphabet)])
+			if len(chars) == n {
+				break
+			}
+		}
+	}
+	return string(chars), nil
+}
+
+func countDigits(value string) int {
+	n := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
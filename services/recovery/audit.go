@@ -0,0 +1,6 @@
+package recovery
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes account recovery events to the audit trail
+var auditor = themislog.Auditor{Source: "Recovery", Category: themislog.CategoryAuthentication}
@@ -0,0 +1,207 @@
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// RecoveryCodeCount is how many one-time codes are issued at a time
+const RecoveryCodeCount = 10
+
+// Codes are two groups of five characters, e.g. "k7pqm-x3ndt". The alphabet
+// leaves out 0/o and 1/i/l so codes can be copied from paper without mistakes.
+const (
+	codeAlphabet    = "abcdefghjkmnpqrstuvwxyz23456789"
+	codeGroupLength = 5
+)
+
+// RecoveryCodeService issues and redeems one-time recovery codes
+type RecoveryCodeService struct{}
+
+// NewRecoveryCodeService creates a new recovery code service instance
+func NewRecoveryCodeService() *RecoveryCodeService {
+	return &RecoveryCodeService{}
+}
+
+// generateRecoveryCode creates a random code in "xxxxx-xxxxx" form
+func generateRecoveryCode() (string, error) {
+	// Reject bytes past the largest multiple of the alphabet size so every
+	// character is equally likely
+	limit := byte(256 - 256%len(codeAlphabet))
+
+	chars := make([]byte, 0, codeGroupLength*2)
+	buf := make([]byte, codeGroupLength*4)
+	for len(chars) < codeGroupLength*2 {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b >= limit {
+				continue
+			}
+			chars = append(chars, codeAlphabet[int(b)%len(codeAlphabet)])
+			if len(chars) == codeGroupLength*2 {
+				break
+			}
+		}
+	}
+	return string(chars[:codeGroupLength]) + "-" + string(chars[codeGroupLength:]), nil
+}
+
+// normalizeRecoveryCode lowercases a code and drops spaces and dashes, so
+// "K7PQM X3NDT" and "k7pqm-x3ndt" are the same code
+func normalizeRecoveryCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(code) {
+		if r == '-' || r == ' ' || r == '\t' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// hashRecoveryCode hashes a code together with its owner, so the same code
+// issued to two users never produces the same stored hash
+func hashRecoveryCode(userID, code string) string {
+	sum := sha256.Sum256([]byte(userID + ":" + normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate replaces the user's recovery codes with a fresh set. The codes
+// are only returned here; just their hashes are stored.
+func (s *RecoveryCodeService) Generate(userID string) ([]string, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	seen := make(map[string]bool, RecoveryCodeCount)
+	for len(codes) < RecoveryCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	var nquads strings.Builder
+	for i, code := range codes {
+		fmt.Fprintf(&nquads, `_:code%d <dgraph.type> "RecoveryCode" .
+_:code%d <user> <%s> .
+_:code%d <codeHash> "%s" .
+_:code%d <used> "false"^^<xs:boolean> .
+_:code%d <createdAt> "%s"^^<xs:dateTime> .
+`, i, i, userID, i, hashRecoveryCode(userID, code), i, i, now)
+	}
+
+	// Old codes stop working as soon as the new set exists
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		previous as var(func: type(RecoveryCode)) @filter(uid_in(user, <%s>))
+	}`, userID))
+
+	deleteMutation := dgraph.NewMutation().WithDelNquads(`uid(previous) * * .`)
+	setMutation := dgraph.NewMutation().WithSetNquads(nquads.String())
+
+	if _, err := dgraph.ExecuteQuery("dgraph", query, deleteMutation, setMutation); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %v", err)
+	}
+
+	auditor.Record("RECOVERY_CODES_GENERATED", "RecoveryCode", userID, userID, themislog.SeverityInfo,
+		fmt.Sprintf("%d recovery codes issued; earlier codes invalidated", RecoveryCodeCount))
+
+	log.Printf("🔑 Recovery: Issued %d recovery codes for user %s", RecoveryCodeCount, userID)
+	return codes, nil
+}
+
+// Remaining returns how many unused recovery codes the user has left
+func (s *RecoveryCodeService) Remaining(userID string) (int, error) {
+	if userID == "" {
+		return 0, errors.New("user ID is required")
+	}
+
+	query := fmt.Sprintf(`{
+		codes(func: type(RecoveryCode)) @filter(uid_in(user, <%s>) AND eq(used, false)) {
+			count(uid)
+		}
+	}`, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Codes []struct {
+			Count int `json:"count"`
+		} `json:"codes"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return 0, err
+	}
+
+	if len(result.Codes) == 0 {
+		return 0, nil
+	}
+	return result.Codes[0].Count, nil
+}
+
+// Consume redeems one of the user's recovery codes. The code is marked used
+// in the same upsert that finds it, so it can't be redeemed twice.
+func (s *RecoveryCodeService) Consume(userID, code string) (bool, error) {
+	if userID == "" {
+		return false, errors.New("user ID is required")
+	}
+	if normalizeRecoveryCode(code) == "" {
+		return false, nil
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query consume($codeHash: string) {
+		c as var(func: eq(codeHash, $codeHash)) @filter(type(RecoveryCode) AND uid_in(user, <%s>) AND eq(used, false))
+		consumed(func: uid(c)) {
+			uid
+		}
+	}`, userID)).WithVariable("$codeHash", hashRecoveryCode(userID, code))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(c), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(c) <used> "true"^^<xs:boolean> .
+uid(c) <usedAt> "%s"^^<xs:dateTime> .`, time.Now().Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem recovery code: %v", err)
+	}
+
+	var result struct {
+		Consumed []struct {
+			UID string `json:"uid"`
+		} `json:"consumed"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, err
+	}
+	if len(result.Consumed) != 1 {
+		return false, nil
+	}
+
+	auditor.Record("RECOVERY_CODE_USED", "RecoveryCode", result.Consumed[0].UID, userID, themislog.SeverityWarning,
+		"Recovery code redeemed")
+	return true, nil
+}
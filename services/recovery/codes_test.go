@@ -0,0 +1,103 @@
+package recovery
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+var recoveryCodePattern = regexp.MustCompile(`^[a-hjkmnp-z2-9]{5}-[a-hjkmnp-z2-9]{5}$`)
+
+func TestGenerateRecoveryCodeFormat(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			t.Fatalf("generateRecoveryCode failed: %v", err)
+		}
+		if !recoveryCodePattern.MatchString(code) {
+			t.Fatalf("Unexpected code format: %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("Duplicate code generated: %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, input := range []string{"k7pqm-x3ndt", "K7PQM-X3NDT", " k7pqm x3ndt ", "k7pqmx3ndt"} {
+		if got := normalizeRecoveryCode(input); got != "k7pqmx3ndt" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q", input, got)
+		}
+	}
+}
+
+func TestHashRecoveryCodeIsBoundToUser(t *testing.T) {
+	if hashRecoveryCode("0x1", "k7pqm-x3ndt") != hashRecoveryCode("0x1", "K7PQM X3NDT") {
+		t.Error("Expected formatting differences to hash the same")
+	}
+	if hashRecoveryCode("0x1", "k7pqm-x3ndt") == hashRecoveryCode("0x2", "k7pqm-x3ndt") {
+		t.Error("Expected the same code to hash differently for another user")
+	}
+}
+
+func TestGenerateReplacesCodesInOneUpsert(t *testing.T) {
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	codes, err := NewRecoveryCodeService().Generate("0x2a")
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	// The store is the first request; the audit entry follows it
+	call := dgraph.DgraphQueryCallStack.Items[before]
+	req := call[1].(*dgraph.Request)
+	if req.Query == nil || len(req.Mutations) != 2 || req.Mutations[0].DelNquads == "" {
+		t.Fatal("Expected old codes to be deleted in the same upsert that stores the new ones")
+	}
+	for _, code := range codes {
+		if strings.Contains(req.Mutations[1].SetNquads, code) {
+			t.Fatal("Expected only code hashes to be stored")
+		}
+	}
+}
+
+func TestRecoveryRequestOpen(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	req := &RecoveryRequest{Status: StatusOTPVerified, ExpiresAt: now.Add(time.Minute)}
+
+	if !req.Open(StatusOTPVerified, now) {
+		t.Error("Expected unexpired request to be open")
+	}
+	if req.Open(StatusApproved, now) {
+		t.Error("Expected request in another state to be closed")
+	}
+	if req.Open(StatusOTPVerified, now.Add(2*time.Minute)) {
+		t.Error("Expected expired request to be closed")
+	}
+
+	var missing *RecoveryRequest
+	if missing.Open(StatusOTPVerified, now) {
+		t.Error("Expected missing request to be closed")
+	}
+}
+
+func TestIsUID(t *testing.T) {
+	for _, uid := range []string{"0x1", "0x2a", "0xABC"} {
+		if !isUID(uid) {
+			t.Errorf("Expected %q to be accepted", uid)
+		}
+	}
+	for _, uid := range []string{"", "0x", "42", "0x1) { uid }", "user_123"} {
+		if isUID(uid) {
+			t.Errorf("Expected %q to be rejected", uid)
+		}
+	}
+}
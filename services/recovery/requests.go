@@ -0,0 +1,406 @@
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// How long each stage of a recovery stays open
+const (
+	RequestExpiryMinutes = 15 // to redeem a recovery code after the OTP
+	ApprovalWindowHours  = 72 // for an admin to approve a reset
+	ResetGrantHours      = 24 // to complete recovery once approved
+)
+
+// MaxCodeAttempts is how many wrong recovery codes end a recovery request
+const MaxCodeAttempts = 5
+
+// Recovery request states (PasswordRecovery.status)
+const (
+	StatusOTPVerified     = "otp_verified"
+	StatusPendingApproval = "pending_approval"
+	StatusApproved        = "approved"
+	StatusRejected        = "rejected"
+	StatusSuperseded      = "superseded"
+	StatusCompleted       = "completed"
+)
+
+// How a recovery was completed (PasswordRecovery.method)
+const (
+	MethodRecoveryCode  = "recovery_code"
+	MethodAdminApproval = "admin_approval"
+)
+
+// recoveryTokenBytes is the token entropy (256 bits)
+const recoveryTokenBytes = 32
+
+// RecoveryRequest represents a stored PasswordRecovery
+type RecoveryRequest struct {
+	UID            string     `json:"uid,omitempty"`
+	UserID         string     `json:"-"`
+	Status         string     `json:"status"`
+	Method         string     `json:"method,omitempty"`
+	ChannelDID     string     `json:"channelDID"`
+	FailedAttempts int        `json:"failedAttempts"`
+	Reason         string     `json:"reason,omitempty"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ApprovedAt     *time.Time `json:"approvedAt,omitempty"`
+	User           []struct {
+		UID string `json:"uid"`
+	} `json:"user,omitempty"`
+}
+
+// RecoveryService tracks account recovery requests
+type RecoveryService struct{}
+
+// NewRecoveryService creates a new recovery service instance
+func NewRecoveryService() *RecoveryService {
+	return &RecoveryService{}
+}
+
+// generateRecoveryToken creates the random token that identifies a recovery
+// request to the client
+func generateRecoveryToken() (string, error) {
+	b := make([]byte, recoveryTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored in place of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Start opens a recovery request for a user who has just verified an OTP on
+// channelDID. Any earlier open request for the user is superseded.
+func (s *RecoveryService) Start(userID, channelDID string) (string, time.Time, error) {
+	if userID == "" || channelDID == "" {
+		return "", time.Time{}, errors.New("user ID and channel DID are required")
+	}
+
+	token, err := generateRecoveryToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate recovery token: %v", err)
+	}
+	now := time.Now()
+	expiresAt := now.Add(RequestExpiryMinutes * time.Minute)
+
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		open as var(func: type(PasswordRecovery)) @filter(uid_in(user, <%s>) AND (eq(status, "%s") OR eq(status, "%s") OR eq(status, "%s")))
+	}`, userID, StatusOTPVerified, StatusPendingApproval, StatusApproved))
+
+	mutation := dgraph.NewMutation().WithSetNquads(fmt.Sprintf(`uid(open) <status> "%s" .
+_:recovery <dgraph.type> "PasswordRecovery" .
+_:recovery <user> <%s> .
+_:recovery <token> "%s" .
+_:recovery <status> "%s" .
+_:recovery <channelDID> "%s" .
+_:recovery <failedAttempts> "0"^^<xs:int> .
+_:recovery <expiresAt> "%s"^^<xs:dateTime> .
+_:recovery <createdAt> "%s"^^<xs:dateTime> .`,
+		StatusSuperseded, userID, hashToken(token), StatusOTPVerified, channelDID,
+		expiresAt.Format(time.RFC3339), now.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store recovery request: %v", err)
+	}
+
+	auditor.Record("ACCOUNT_RECOVERY_STARTED", "PasswordRecovery", resp.Uids["recovery"], userID, themislog.SeverityWarning,
+		"Account recovery started after channel OTP verification")
+
+	log.Printf("🔑 Recovery: Request started for user %s", userID)
+	return token, expiresAt, nil
+}
+
+// Get loads the recovery request identified by token
+func (s *RecoveryService) Get(token string) (*RecoveryRequest, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	query := dgraph.NewQuery(`query recovery($token: string) {
+		recovery(func: eq(token, $token)) @filter(type(PasswordRecovery)) {
+			uid
+			user {
+				uid
+			}
+			status
+			method
+			channelDID
+			failedAttempts
+			reason
+			expiresAt
+			createdAt
+			approvedAt
+		}
+	}`).WithVariable("$token", hashToken(token))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecoveryRequest(resp.Json, "recovery")
+}
+
+// GetByID loads a recovery request by its uid, for admin review
+func (s *RecoveryService) GetByID(recoveryID string) (*RecoveryRequest, error) {
+	if !isUID(recoveryID) {
+		return nil, errors.New("invalid recovery ID")
+	}
+
+	query := fmt.Sprintf(`{
+		recovery(func: uid(%s)) @filter(type(PasswordRecovery)) {
+			uid
+			user {
+				uid
+			}
+			status
+			method
+			channelDID
+			failedAttempts
+			reason
+			expiresAt
+			createdAt
+			approvedAt
+		}
+	}`, recoveryID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return parseRecoveryRequest(resp.Json, "recovery")
+}
+
+// ListPending returns the unexpired requests awaiting admin approval, oldest first
+func (s *RecoveryService) ListPending() ([]RecoveryRequest, error) {
+	query := dgraph.NewQuery(`query pending($status: string, $now: string) {
+		recoveries(func: eq(status, $status), orderasc: createdAt) @filter(type(PasswordRecovery) AND gt(expiresAt, $now)) {
+			uid
+			user {
+				uid
+			}
+			status
+			channelDID
+			reason
+			expiresAt
+			createdAt
+		}
+	}`).WithVariable("$status", StatusPendingApproval).WithVariable("$now", time.Now().Format(time.RFC3339))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Recoveries []RecoveryRequest `json:"recoveries"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+
+	for i := range result.Recoveries {
+		if len(result.Recoveries[i].User) > 0 {
+			result.Recoveries[i].UserID = result.Recoveries[i].User[0].UID
+		}
+	}
+	return result.Recoveries, nil
+}
+
+// parseRecoveryRequest reads a single PasswordRecovery from a query block
+func parseRecoveryRequest(data, block string) (*RecoveryRequest, error) {
+	var result map[string][]RecoveryRequest
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return nil, err
+	}
+
+	requests := result[block]
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	req := requests[0]
+	if len(req.User) > 0 {
+		req.UserID = req.User[0].UID
+	}
+	return &req, nil
+}
+
+// Open reports whether the request is in status and not yet expired
+func (r *RecoveryRequest) Open(status string, now time.Time) bool {
+	return r != nil && r.Status == status && now.Before(r.ExpiresAt)
+}
+
+// RecordCodeFailure counts a wrong recovery code and rejects the request at
+// MaxCodeAttempts. It reports whether the request was rejected.
+func (s *RecoveryService) RecordCodeFailure(req *RecoveryRequest) bool {
+	attempts := req.FailedAttempts + 1
+	rejected := attempts >= MaxCodeAttempts
+
+	nquads := fmt.Sprintf(`<%s> <failedAttempts> "%d"^^<xs:int> .`, req.UID, attempts)
+	if rejected {
+		nquads += fmt.Sprintf("\n<%s> <status> \"%s\" .", req.UID, StatusRejected)
+	}
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		log.Printf("⚠️ Warning: Failed to record recovery code failure: %v", err)
+	}
+
+	if rejected {
+		auditor.Record("ACCOUNT_RECOVERY_REJECTED", "PasswordRecovery", req.UID, req.UserID, themislog.SeverityCritical,
+			fmt.Sprintf("Recovery request rejected after %d wrong recovery codes", attempts))
+	}
+	return rejected
+}
+
+// RequestApproval moves an OTP-verified request into the admin approval
+// queue, for users who no longer have a recovery code
+func (s *RecoveryService) RequestApproval(req *RecoveryRequest, reason string) (bool, error) {
+	expiresAt := time.Now().Add(ApprovalWindowHours * time.Hour)
+
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <reason> %q .
+<%s> <expiresAt> "%s"^^<xs:dateTime> .`,
+		req.UID, StatusPendingApproval, req.UID, reason, req.UID, expiresAt.Format(time.RFC3339))
+
+	ok, err := transition(req.UID, StatusOTPVerified, mutation, false)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	auditor.Record("ACCOUNT_RECOVERY_APPROVAL_REQUESTED", "PasswordRecovery", req.UID, req.UserID, themislog.SeverityWarning,
+		fmt.Sprintf("Admin approval requested: %s", reason))
+	return true, nil
+}
+
+// Approve grants a pending request. A PasswordReset records the grant and
+// the request stays open for ResetGrantHours.
+func (s *RecoveryService) Approve(req *RecoveryRequest, adminUserID string) (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(ResetGrantHours * time.Hour)
+
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <approvedBy> <%s> .
+<%s> <approvedAt> "%s"^^<xs:dateTime> .
+<%s> <expiresAt> "%s"^^<xs:dateTime> .
+_:reset <dgraph.type> "PasswordReset" .
+_:reset <user> <%s> .
+_:reset <recovery> <%s> .
+_:reset <used> "false"^^<xs:boolean> .
+_:reset <expiresAt> "%s"^^<xs:dateTime> .
+_:reset <createdAt> "%s"^^<xs:dateTime> .`,
+		req.UID, StatusApproved, req.UID, adminUserID, req.UID, now.Format(time.RFC3339),
+		req.UID, expiresAt.Format(time.RFC3339),
+		req.UserID, req.UID, expiresAt.Format(time.RFC3339), now.Format(time.RFC3339))
+
+	ok, err := transition(req.UID, StatusPendingApproval, mutation, false)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	auditor.Record("ACCOUNT_RECOVERY_APPROVED", "PasswordRecovery", req.UID, adminUserID, themislog.SeverityCritical,
+		fmt.Sprintf("Account recovery for user %s approved", req.UserID))
+	return true, nil
+}
+
+// Complete claims an open request so it can only be used once. fromStatus is
+// the state the caller checked; if another request got there first the
+// claim fails and nothing should be revoked.
+func (s *RecoveryService) Complete(req *RecoveryRequest, fromStatus, method string) (bool, error) {
+	now := time.Now().Format(time.RFC3339)
+
+	mutation := fmt.Sprintf(`<%s> <status> "%s" .
+<%s> <method> "%s" .
+<%s> <completedAt> "%s"^^<xs:dateTime> .`,
+		req.UID, StatusCompleted, req.UID, method, req.UID, now)
+	if method == MethodAdminApproval {
+		mutation += fmt.Sprintf(`
+uid(grant) <used> "true"^^<xs:boolean> .
+uid(grant) <usedAt> "%s"^^<xs:dateTime> .`, now)
+	}
+
+	ok, err := transition(req.UID, fromStatus, mutation, method == MethodAdminApproval)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	auditor.Record("ACCOUNT_RECOVERED", "PasswordRecovery", req.UID, req.UserID, themislog.SeverityCritical,
+		fmt.Sprintf("Account recovered via %s", method))
+	return true, nil
+}
+
+// transition applies nquads only while the request is still in fromStatus
+// (and, with requireGrant, still has an unused reset grant). The query
+// exposes the request as r and the grant as grant.
+func transition(recoveryUID, fromStatus, nquads string, requireGrant bool) (bool, error) {
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		r as var(func: uid(%s)) @filter(type(PasswordRecovery) AND eq(status, "%s"))
+		grant as var(func: type(PasswordReset)) @filter(uid_in(recovery, %s) AND eq(used, false))
+		claimed(func: uid(r)) {
+			uid
+		}
+		grant(func: uid(grant)) {
+			uid
+		}
+	}`, recoveryUID, fromStatus, recoveryUID))
+
+	condition := "@if(eq(len(r), 1))"
+	if requireGrant {
+		condition = "@if(eq(len(r), 1) AND eq(len(grant), 1))"
+	}
+	mutation := dgraph.NewMutation().
+		WithCondition(condition).
+		WithSetNquads(nquads)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return false, fmt.Errorf("failed to update recovery request: %v", err)
+	}
+
+	var result struct {
+		Claimed []struct {
+			UID string `json:"uid"`
+		} `json:"claimed"`
+		Grant []struct {
+			UID string `json:"uid"`
+		} `json:"grant"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return false, err
+	}
+	if requireGrant && len(result.Grant) != 1 {
+		return false, nil
+	}
+	return len(result.Claimed) == 1, nil
+}
+
+// isUID checks s looks like a Dgraph uid before it is put into a query
+func isUID(s string) bool {
+	if len(s) < 3 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	for _, r := range s[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,6 @@
+package totp
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes TOTP security events to the audit trail
+var auditor = themislog.Auditor{Source: "TOTP", Category: themislog.CategoryAuthentication}
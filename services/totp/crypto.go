@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"modus/config"
+)
+
+// EncryptionKeySecret is the Modus secret holding the key TOTP secrets are
+// encrypted with. Any string works; it is hashed to a 256-bit AES key.
+const EncryptionKeySecret = "TOTP_ENCRYPTION_KEY"
+
+// ciphertextVersion prefixes stored secrets so the scheme can change later
+const ciphertextVersion = "v1:"
+
+var encryptionKeyOverride []byte
+
+func init() {
+	config.RegisterSecret(EncryptionKeySecret, true, func(string) error { return nil })
+}
+
+// SetEncryptionKey overrides the key read from EncryptionKeySecret
+func SetEncryptionKey(key string) {
+	sum := sha256.Sum256([]byte(key))
+	encryptionKeyOverride = sum[:]
+}
+
+func encryptionKey() ([]byte, error) {
+	if encryptionKeyOverride != nil {
+		return encryptionKeyOverride, nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.TOTPEncryptionKey == "" {
+		return nil, fmt.Errorf("%s is not configured", EncryptionKeySecret)
+	}
+	sum := sha256.Sum256([]byte(cfg.Secrets.TOTPEncryptionKey))
+	return sum[:], nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret seals a TOTP secret with AES-256-GCM. The user ID is bound
+// as additional data so a ciphertext can't be copied onto another account.
+func encryptSecret(secret []byte, userID string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, secret, []byte(userID))
+	return ciphertextVersion + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret opens a secret sealed by encryptSecret for the same user
+func decryptSecret(ciphertext, userID string) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, ciphertextVersion) {
+		return nil, errors.New("unsupported TOTP secret encoding")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, ciphertextVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("TOTP secret ciphertext too short")
+	}
+
+	nonce, body := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, body, []byte(userID))
+	if err != nil {
+		return nil, errors.New("failed to decrypt TOTP secret")
+	}
+	return secret, nil
+}
@@ -0,0 +1,295 @@
+package totp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// TOTPService handles TOTP enrolment and verification
+type TOTPService struct {
+	issuer string
+}
+
+// NewTOTPService creates a new TOTP service instance
+func NewTOTPService() *TOTPService {
+	return &TOTPService{
+		issuer: DefaultIssuer,
+	}
+}
+
+// BeginEnrollment creates a new secret for the user and returns it as an
+// otpauth:// URI. The enrolment stays pending until ConfirmEnrollment sees a
+// valid code, so a half-finished setup never becomes a sign-in factor.
+func (s *TOTPService) BeginEnrollment(req EnrollmentRequest) (EnrollmentResponse, error) {
+	if req.UserID == "" || req.AccountName == "" {
+		return EnrollmentResponse{}, errors.New("user ID and account name are required")
+	}
+
+	// A new enrolment would reset the attempt counter of a locked one
+	pending, err := s.getCredential(req.UserID, false)
+	if err != nil {
+		return EnrollmentResponse{}, err
+	}
+	if pending != nil && pending.LockedUntil != nil && time.Now().Before(*pending.LockedUntil) {
+		return EnrollmentResponse{}, fmt.Errorf("too many failed attempts; try again after %s", pending.LockedUntil.Format(time.RFC3339))
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return EnrollmentResponse{}, fmt.Errorf("failed to generate secret: %v", err)
+	}
+	ciphertext, err := encryptSecret(secret, req.UserID)
+	if err != nil {
+		return EnrollmentResponse{}, fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	// Replace any earlier pending enrolment
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		pending as var(func: type(TOTPCredential)) @filter(uid_in(user, <%s>) AND eq(confirmed, false))
+	}`, req.UserID))
+
+	deleteMutation := dgraph.NewMutation().WithDelNquads(`uid(pending) * * .`)
+	setMutation := dgraph.NewMutation().WithSetNquads(fmt.Sprintf(`_:totp <dgraph.type> "TOTPCredential" .
+_:totp <user> <%s> .
+_:totp <secretCiphertext> "%s" .
+_:totp <confirmed> "false"^^<xs:boolean> .
+_:totp <lastUsedStep> "0"^^<xs:int> .
+_:totp <failedAttempts> "0"^^<xs:int> .
+_:totp <createdAt> "%s"^^<xs:dateTime> .`,
+		req.UserID, ciphertext, time.Now().Format(time.RFC3339)))
+
+	if _, err := dgraph.ExecuteQuery("dgraph", query, deleteMutation, setMutation); err != nil {
+		return EnrollmentResponse{}, fmt.Errorf("failed to store TOTP enrolment: %v", err)
+	}
+
+	log.Printf("🔐 TOTP: Enrolment started for user %s", req.UserID)
+	return EnrollmentResponse{
+		Secret: encodeSecret(secret),
+		URI:    provisioningURI(s.issuer, req.AccountName, secret),
+		Issuer: s.issuer,
+		Digits: Digits,
+		Period: Period,
+	}, nil
+}
+
+// ConfirmEnrollment activates a pending enrolment once the user proves their
+// app produces valid codes. Any previously confirmed TOTP is replaced. Wrong
+// codes count towards the same lockout as Verify.
+func (s *TOTPService) ConfirmEnrollment(req VerifyRequest) (VerifyResponse, error) {
+	cred, err := s.getCredential(req.UserID, false)
+	if err != nil {
+		return VerifyResponse{}, err
+	}
+	if cred == nil {
+		return VerifyResponse{Success: false, Message: "No pending authenticator app enrolment"}, nil
+	}
+
+	now := time.Now()
+	if response, locked := lockedOut(cred, now); locked {
+		return response, nil
+	}
+
+	secret, err := decryptSecret(cred.SecretCiphertext, req.UserID)
+	if err != nil {
+		return VerifyResponse{}, err
+	}
+
+	step, ok := validateCode(secret, req.Code, now, cred.LastUsedStep)
+	if !ok {
+		s.recordFailure(cred, req.UserID, now)
+		return VerifyResponse{Success: false, Message: "Invalid code. Check the time on your device and try again"}, nil
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		previous as var(func: type(TOTPCredential)) @filter(uid_in(user, <%s>) AND eq(confirmed, true))
+	}`, req.UserID))
+
+	deleteMutation := dgraph.NewMutation().WithDelNquads(`uid(previous) * * .`)
+	setMutation := dgraph.NewMutation().WithSetNquads(fmt.Sprintf(`<%s> <confirmed> "true"^^<xs:boolean> .
+<%s> <confirmedAt> "%s"^^<xs:dateTime> .
+<%s> <lastUsedStep> "%d"^^<xs:int> .
+<%s> <failedAttempts> "0"^^<xs:int> .`,
+		cred.UID, cred.UID, now.Format(time.RFC3339), cred.UID, step, cred.UID))
+
+	if _, err := dgraph.ExecuteQuery("dgraph", query, deleteMutation, setMutation); err != nil {
+		return VerifyResponse{}, fmt.Errorf("failed to confirm TOTP enrolment: %v", err)
+	}
+
+	auditor.Record("TOTP_ENROLLED", "TOTPCredential", cred.UID, req.UserID, themislog.SeverityInfo,
+		"Authenticator app enrolled")
+
+	log.Printf("✅ TOTP: Enrolment confirmed for user %s", req.UserID)
+	return VerifyResponse{Success: true, UserID: req.UserID, Message: "Authenticator app enrolled"}, nil
+}
+
+// Verify checks a sign-in code against the user's confirmed TOTP. Each time
+// step is accepted at most once, and repeated failures lock the factor.
+func (s *TOTPService) Verify(req VerifyRequest) (VerifyResponse, error) {
+	cred, err := s.getCredential(req.UserID, true)
+	if err != nil {
+		return VerifyResponse{}, err
+	}
+	if cred == nil {
+		return VerifyResponse{Success: false, Message: "Authenticator app is not set up"}, nil
+	}
+
+	now := time.Now()
+	if response, locked := lockedOut(cred, now); locked {
+		return response, nil
+	}
+
+	secret, err := decryptSecret(cred.SecretCiphertext, req.UserID)
+	if err != nil {
+		return VerifyResponse{}, err
+	}
+
+	step, ok := validateCode(secret, req.Code, now, cred.LastUsedStep)
+	if !ok {
+		s.recordFailure(cred, req.UserID, now)
+		return VerifyResponse{Success: false, Message: "Invalid or already used code"}, nil
+	}
+
+	// Claim the step atomically so two requests with the same code can't both pass
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		c as var(func: uid(%s)) @filter(lt(lastUsedStep, %d))
+		claimed(func: uid(c)) {
+			uid
+		}
+	}`, cred.UID, step))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(c), 1))").
+		WithSetNquads(fmt.Sprintf(`uid(c) <lastUsedStep> "%d"^^<xs:int> .
+uid(c) <failedAttempts> "0"^^<xs:int> .
+uid(c) <lastUsedAt> "%s"^^<xs:dateTime> .`, step, now.Format(time.RFC3339)))
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return VerifyResponse{}, fmt.Errorf("failed to record TOTP use: %v", err)
+	}
+
+	var result struct {
+		Claimed []struct {
+			UID string `json:"uid"`
+		} `json:"claimed"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return VerifyResponse{}, err
+	}
+	if len(result.Claimed) != 1 {
+		auditor.Record("TOTP_CODE_REUSED", "TOTPCredential", cred.UID, req.UserID, themislog.SeverityWarning,
+			fmt.Sprintf("Code for time step %d was already used", step))
+		return VerifyResponse{Success: false, Message: "Invalid or already used code"}, nil
+	}
+
+	log.Printf("✅ TOTP: Code verified for user %s", req.UserID)
+	return VerifyResponse{Success: true, UserID: req.UserID, Message: "Authenticator app code verified"}, nil
+}
+
+// HasTOTP reports whether the user has a confirmed TOTP enrolment
+func (s *TOTPService) HasTOTP(userID string) (bool, error) {
+	cred, err := s.getCredential(userID, true)
+	if err != nil {
+		return false, err
+	}
+	return cred != nil, nil
+}
+
+// lockedOut reports whether the credential is locked after failed attempts,
+// with the response to return if it is
+func lockedOut(cred *TOTPCredential, now time.Time) (VerifyResponse, bool) {
+	if cred.LockedUntil == nil || !now.Before(*cred.LockedUntil) {
+		return VerifyResponse{}, false
+	}
+	return VerifyResponse{
+		Success: false,
+		Message: fmt.Sprintf("Too many failed attempts. Try again after %s", cred.LockedUntil.Format(time.RFC3339)),
+	}, true
+}
+
+// recordFailure counts a wrong code and locks the factor at MaxFailedAttempts
+func (s *TOTPService) recordFailure(cred *TOTPCredential, userID string, now time.Time) {
+	attempts := cred.FailedAttempts + 1
+	nquads := fmt.Sprintf(`<%s> <failedAttempts> "%d"^^<xs:int> .`, cred.UID, attempts)
+
+	locked := attempts >= MaxFailedAttempts
+	if locked {
+		lockedUntil := now.Add(LockoutMinutes * time.Minute)
+		// Start counting again once the lockout ends
+		nquads = fmt.Sprintf(`<%s> <failedAttempts> "0"^^<xs:int> .
+<%s> <lockedUntil> "%s"^^<xs:dateTime> .`, cred.UID, cred.UID, lockedUntil.Format(time.RFC3339))
+	}
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		log.Printf("⚠️ Warning: Failed to record TOTP failure: %v", err)
+		return
+	}
+
+	if locked {
+		auditor.Record("TOTP_LOCKED", "TOTPCredential", cred.UID, userID, themislog.SeverityWarning,
+			fmt.Sprintf("Authenticator app locked for %d minutes after %d failed attempts", LockoutMinutes, attempts))
+	}
+}
+
+// getCredential loads the user's confirmed or pending TOTP enrolment. Every
+// entry point reads the credential first, so this also vets the user ID
+// before it is put into any query.
+func (s *TOTPService) getCredential(userID string, confirmed bool) (*TOTPCredential, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	if !isUID(userID) {
+		return nil, errors.New("invalid user ID")
+	}
+
+	query := fmt.Sprintf(`{
+		credentials(func: type(TOTPCredential), orderdesc: createdAt, first: 1) @filter(uid_in(user, <%s>) AND eq(confirmed, %t)) {
+			uid
+			secretCiphertext
+			confirmed
+			lastUsedStep
+			failedAttempts
+			lockedUntil
+			createdAt
+		}
+	}`, userID, confirmed)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Credentials []TOTPCredential `json:"credentials"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Credentials) == 0 {
+		return nil, nil
+	}
+	return &result.Credentials[0], nil
+}
+
+// isUID checks s looks like a Dgraph uid before it is put into a query
+func isUID(s string) bool {
+	if len(s) < 3 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	for _, r := range s[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters. These are the defaults every authenticator app
+// supports, so they are not configurable per user.
+const (
+	Digits     = 6
+	Period     = 30 // seconds per time step
+	SecretSize = 20 // bytes (160 bits, RFC 4226 recommendation)
+
+	// Skew is how many time steps either side of now are accepted, to allow
+	// for clock drift on the user's phone
+	Skew = 1
+)
+
+// DefaultIssuer is shown as the account label in authenticator apps
+const DefaultIssuer = "DO Study"
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateSecret creates a random TOTP shared secret
+func generateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// encodeSecret renders a secret the way authenticator apps expect it
+func encodeSecret(secret []byte) string {
+	return base32NoPadding.EncodeToString(secret)
+}
+
+// hotp computes an RFC 4226 HOTP value for a counter
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 §5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// timeStep returns the RFC 6238 time step for t
+func timeStep(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// generateCode returns the code for the time step containing t
+func generateCode(secret []byte, t time.Time) string {
+	return hotp(secret, uint64(timeStep(t)))
+}
+
+// validateCode checks code against the time steps within Skew of now and
+// returns the matching step. Steps at or before lastStep have already been
+// used and are rejected, so a code can't be replayed inside its window.
+func validateCode(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := timeStep(now)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// provisioningURI builds the otpauth:// URI shown as a QR code during enrolment
+func provisioningURI(issuer, accountName string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + accountName)
+
+	params := url.Values{}
+	params.Set("secret", encodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
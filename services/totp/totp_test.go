@@ -0,0 +1,126 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B SHA-1 vectors, truncated to 6 digits
+func TestGenerateCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		if got := generateCode(secret, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("generateCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1234567890, 0)
+	current := timeStep(now)
+
+	step, ok := validateCode(secret, generateCode(secret, now), now, 0)
+	if !ok || step != current {
+		t.Fatalf("Expected current code to validate at step %d, got %d (%t)", current, step, ok)
+	}
+
+	// Clock drift of one step either way is tolerated
+	if _, ok := validateCode(secret, generateCode(secret, now.Add(-Period*time.Second)), now, 0); !ok {
+		t.Error("Expected previous step to validate")
+	}
+	if _, ok := validateCode(secret, generateCode(secret, now.Add(Period*time.Second)), now, 0); !ok {
+		t.Error("Expected next step to validate")
+	}
+	if _, ok := validateCode(secret, generateCode(secret, now.Add(-2*Period*time.Second)), now, 0); ok {
+		t.Error("Expected code two steps old to be rejected")
+	}
+
+	// Reuse inside the same window is blocked
+	if _, ok := validateCode(secret, generateCode(secret, now), now, current); ok {
+		t.Error("Expected replayed code to be rejected")
+	}
+
+	if _, ok := validateCode(secret, "12345", now, 0); ok {
+		t.Error("Expected short code to be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	uri, err := url.Parse(provisioningURI(DefaultIssuer, "learner@example.com", secret))
+	if err != nil {
+		t.Fatalf("Invalid URI: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	if !strings.HasPrefix(uri.Path, "/DO Study:learner@example.com") {
+		t.Errorf("Unexpected label: %s", uri.Path)
+	}
+	q := uri.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != DefaultIssuer {
+		t.Errorf("Unexpected parameters: %v", q)
+	}
+	if q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("Unexpected code parameters: %v", q)
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+	SetEncryptionKey("test-key")
+	defer func() { encryptionKeyOverride = nil }()
+
+	secret, err := generateSecret()
+	if err != nil {
+		t.Fatalf("generateSecret failed: %v", err)
+	}
+
+	ciphertext, err := encryptSecret(secret, "0x2a")
+	if err != nil {
+		t.Fatalf("encryptSecret failed: %v", err)
+	}
+	if strings.Contains(ciphertext, encodeSecret(secret)) {
+		t.Error("Expected secret not to appear in ciphertext")
+	}
+
+	plain, err := decryptSecret(ciphertext, "0x2a")
+	if err != nil || string(plain) != string(secret) {
+		t.Fatalf("Expected secret to round-trip: %v", err)
+	}
+	if _, err := decryptSecret(ciphertext, "0x2b"); err == nil {
+		t.Error("Expected ciphertext bound to another user to be rejected")
+	}
+
+	SetEncryptionKey("other-key")
+	if _, err := decryptSecret(ciphertext, "0x2a"); err == nil {
+		t.Error("Expected decryption with the wrong key to fail")
+	}
+}
+
+func TestServiceRejectsMalformedUserIDs(t *testing.T) {
+	service := NewTOTPService()
+	for _, userID := range []string{"alice", "0x", "0x1>) OR uid_in(user, <0x2"} {
+		if _, err := service.Verify(VerifyRequest{UserID: userID, Code: "123456"}); err == nil {
+			t.Errorf("Expected Verify to reject user ID %q", userID)
+		}
+		if _, err := service.BeginEnrollment(EnrollmentRequest{UserID: userID, AccountName: "alice"}); err == nil {
+			t.Errorf("Expected BeginEnrollment to reject user ID %q", userID)
+		}
+	}
+}
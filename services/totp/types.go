@@ -0,0 +1,50 @@
+package totp
+
+import "time"
+
+// Lockout after repeated wrong codes. Six digits with a ±1 step window
+// leaves a 1 in 333,333 chance per guess, so guesses must be capped.
+const (
+	MaxFailedAttempts = 5
+	LockoutMinutes    = 15
+)
+
+// EnrollmentRequest starts TOTP enrolment for a signed-in user
+type EnrollmentRequest struct {
+	UserID      string `json:"userId"`
+	AccountName string `json:"accountName"` // shown in the authenticator app, e.g. the user's email
+}
+
+// EnrollmentResponse carries the secret to load into an authenticator app.
+// The secret is only ever returned here; it is stored encrypted.
+type EnrollmentResponse struct {
+	Secret string `json:"secret"` // base32, for manual entry
+	URI    string `json:"uri"`    // otpauth:// URI, for the QR code
+	Issuer string `json:"issuer"`
+	Digits int    `json:"digits"`
+	Period int    `json:"period"`
+}
+
+// VerifyRequest carries a code for enrolment confirmation or sign-in
+type VerifyRequest struct {
+	UserID string `json:"userId"`
+	Code   string `json:"code"`
+}
+
+// VerifyResponse represents the result of checking a TOTP code
+type VerifyResponse struct {
+	Success bool   `json:"success"`
+	UserID  string `json:"userId,omitempty"`
+	Message string `json:"message"`
+}
+
+// TOTPCredential represents a stored TOTP enrolment
+type TOTPCredential struct {
+	UID              string     `json:"uid,omitempty"`
+	SecretCiphertext string     `json:"secretCiphertext"`
+	Confirmed        bool       `json:"confirmed"`
+	LastUsedStep     int64      `json:"lastUsedStep"`
+	FailedAttempts   int        `json:"failedAttempts"`
+	LockedUntil      *time.Time `json:"lockedUntil,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,173 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Authenticator data flags (WebAuthn §6.1)
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagBackupEligible         = 0x08
+	flagBackupState            = 0x10
+	flagAttestedCredentialData = 0x40
+	flagExtensionData          = 0x80
+)
+
+// authenticatorData is the parsed form of the authData byte array
+type authenticatorData struct {
+	Raw       []byte
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Attested credential data, present during registration only
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+	PublicKey           *coseKey
+
+	Extensions map[interface{}]interface{}
+}
+
+// UserPresent reports whether the UP flag is set
+func (a *authenticatorData) UserPresent() bool {
+	return a.Flags&flagUserPresent != 0
+}
+
+// UserVerified reports whether the UV flag is set
+func (a *authenticatorData) UserVerified() bool {
+	return a.Flags&flagUserVerified != 0
+}
+
+// attestation is the parsed attestationObject returned by navigator.credentials.create()
+type attestation struct {
+	Format   string
+	AttStmt  map[interface{}]interface{}
+	AuthData *authenticatorData
+}
+
+// parseAttestationObject decodes the base64url CBOR attestationObject and
+// the authenticator data embedded in it
+func parseAttestationObject(attestationObject string) (*attestation, error) {
+	decoded, err := decodeBase64URL(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+
+	value, n, err := decodeCBOR(decoded)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(decoded) {
+		return nil, errors.New("trailing data after attestation object")
+	}
+
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+
+	format, ok := m["fmt"].(string)
+	if !ok {
+		return nil, errors.New("attestation object missing fmt")
+	}
+	attStmt, ok := m["attStmt"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object missing attStmt")
+	}
+	rawAuthData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object missing authData")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.PublicKey == nil {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+
+	return &attestation{
+		Format:   format,
+		AttStmt:  attStmt,
+		AuthData: authData,
+	}, nil
+}
+
+// parseAuthenticatorData decodes authenticator data (WebAuthn §6.1)
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("authenticator data too short: %d bytes", len(raw))
+	}
+
+	data := &authenticatorData{
+		Raw:       raw,
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if data.Flags&flagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		data.AAGUID = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("invalid credential ID length")
+		}
+		data.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		key, n, err := parseCOSEKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		data.PublicKey = key
+		data.CredentialPublicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if data.Flags&flagExtensionData != 0 {
+		value, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension data: %v", err)
+		}
+		extensions, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("extension data is not a map")
+		}
+		data.Extensions = extensions
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after authenticator data")
+	}
+
+	return data, nil
+}
+
+// decodeBase64URL decodes base64url input with or without padding. Browsers
+// send unpadded base64url, while older clients of this API sent padded values.
+func decodeBase64URL(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// encodeBase64URL encodes bytes as unpadded base64url, matching the
+// credential.id value reported by browsers
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,298 @@
+package webauthn
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"modus/config"
+)
+
+// attestationVector is a registration carrying a non-trivial attestation
+// statement, stored in testdata/attestation_vectors.json together with the
+// test attestation root and an MDS blob describing the vectors
+type attestationVector struct {
+	Name              string `json:"name"`
+	Format            string `json:"format"`
+	AttestationType   string `json:"attestationType"`
+	AAGUID            string `json:"aaguid"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+	Trusted           bool   `json:"trusted"`
+}
+
+type attestationFixtures struct {
+	AttestationRoot string              `json:"attestationRoot"`
+	MDSRoot         string              `json:"mdsRoot"`
+	MDSBlob         string              `json:"mdsBlob"`
+	Vectors         []attestationVector `json:"vectors"`
+}
+
+func loadAttestationFixtures(t *testing.T) attestationFixtures {
+	t.Helper()
+	data, err := os.ReadFile("testdata/attestation_vectors.json")
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	var fixtures attestationFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("Failed to parse vectors: %v", err)
+	}
+	return fixtures
+}
+
+// verifyVector parses and verifies a vector's attestation statement
+func verifyVector(t *testing.T, v attestationVector) (*attestation, *attestationResult) {
+	t.Helper()
+	att, err := parseAttestationObject(v.AttestationObject)
+	if err != nil {
+		t.Fatalf("parseAttestationObject failed: %v", err)
+	}
+	clientData, err := parseClientDataJSON(v.ClientDataJSON)
+	if err != nil {
+		t.Fatalf("parseClientDataJSON failed: %v", err)
+	}
+	hash := sha256.Sum256(clientData.raw)
+	result, err := verifyAttestationStatement(att, hash[:])
+	if err != nil {
+		t.Fatalf("verifyAttestationStatement failed: %v", err)
+	}
+	return att, result
+}
+
+// newTestTrustAnchors loads the MDS blob (verified against the MDS root)
+// and trusts the attestation root for the apple format
+func newTestTrustAnchors(t *testing.T, fixtures attestationFixtures) *TrustAnchorStore {
+	t.Helper()
+	mdsRoots, err := parsePEMCertificates([]byte(fixtures.MDSRoot))
+	if err != nil {
+		t.Fatalf("Failed to parse MDS root: %v", err)
+	}
+	store := NewTrustAnchorStore()
+	if err := store.LoadMetadataBLOB([]byte(fixtures.MDSBlob), mdsRoots[0]); err != nil {
+		t.Fatalf("LoadMetadataBLOB failed: %v", err)
+	}
+	if err := store.AddRootsPEMForFormat(FormatApple, []byte(fixtures.AttestationRoot)); err != nil {
+		t.Fatalf("AddRootsPEMForFormat failed: %v", err)
+	}
+	return store
+}
+
+func TestVerifyAttestationStatement(t *testing.T) {
+	for _, v := range loadAttestationFixtures(t).Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			_, result := verifyVector(t, v)
+			if result.Format != v.Format {
+				t.Errorf("Format mismatch: got %s, want %s", result.Format, v.Format)
+			}
+			if result.Type != v.AttestationType {
+				t.Errorf("Attestation type mismatch: got %s, want %s", result.Type, v.AttestationType)
+			}
+			if (result.Type == AttestationTypeSelf) != (len(result.Chain) == 0) {
+				t.Errorf("Unexpected chain length %d for %s attestation", len(result.Chain), result.Type)
+			}
+		})
+	}
+}
+
+func TestVerifyAttestationStatementRejectsWrongClientData(t *testing.T) {
+	for _, v := range loadAttestationFixtures(t).Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			att, err := parseAttestationObject(v.AttestationObject)
+			if err != nil {
+				t.Fatalf("parseAttestationObject failed: %v", err)
+			}
+			wrongHash := sha256.Sum256([]byte("another registration"))
+			if _, err := verifyAttestationStatement(att, wrongHash[:]); err == nil {
+				t.Error("Expected failure for mismatched clientDataHash")
+			}
+		})
+	}
+}
+
+func TestVerifyAttestationStatementNone(t *testing.T) {
+	for _, v := range loadAuthenticatorVectors(t) {
+		att, err := parseAttestationObject(v.AttestationObject)
+		if err != nil {
+			t.Fatalf("parseAttestationObject failed: %v", err)
+		}
+		result, err := verifyAttestationStatement(att, make([]byte, 32))
+		if err != nil {
+			t.Fatalf("verifyAttestationStatement failed for %s: %v", v.Name, err)
+		}
+		if result.Type != AttestationTypeNone {
+			t.Errorf("Expected none attestation for %s, got %s", v.Name, result.Type)
+		}
+	}
+}
+
+func TestTrustAnchorStoreVerifyChain(t *testing.T) {
+	fixtures := loadAttestationFixtures(t)
+	store := newTestTrustAnchors(t, fixtures)
+
+	for _, v := range fixtures.Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			_, result := verifyVector(t, v)
+			trusted, err := store.verifyChain(result, v.AAGUID)
+
+			if v.Name == "packed-revoked" {
+				if err == nil {
+					t.Error("Expected revoked authenticator to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyChain failed: %v", err)
+			}
+			if trusted != v.Trusted {
+				t.Errorf("Trusted mismatch: got %t, want %t", trusted, v.Trusted)
+			}
+		})
+	}
+
+	if m, ok := store.Metadata("A4E9FC6D-4CBE-4758-B8BA-37598BB5BBAA"); !ok || m.Description != "DO Study Test Security Key" {
+		t.Errorf("Unexpected metadata: %+v (found %t)", m, ok)
+	}
+}
+
+func TestTrustAnchorStoreWithoutAnchors(t *testing.T) {
+	fixtures := loadAttestationFixtures(t)
+	store := NewTrustAnchorStore()
+	for _, v := range fixtures.Vectors {
+		_, result := verifyVector(t, v)
+		trusted, err := store.verifyChain(result, v.AAGUID)
+		if err != nil || trusted {
+			t.Errorf("%s: expected untrusted without error, got trusted=%t err=%v", v.Name, trusted, err)
+		}
+	}
+}
+
+func TestLoadMetadataBLOBRejectsTampering(t *testing.T) {
+	fixtures := loadAttestationFixtures(t)
+	mdsRoots, err := parsePEMCertificates([]byte(fixtures.MDSRoot))
+	if err != nil {
+		t.Fatalf("Failed to parse MDS root: %v", err)
+	}
+
+	parts := strings.Split(fixtures.MDSBlob, ".")
+	payload, err := decodeBase64URL(parts[1])
+	if err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	tampered := strings.Replace(string(payload), "ATTESTATION_KEY_COMPROMISE", "FIDO_CERTIFIED_L1", 1)
+	blob := parts[0] + "." + encodeBase64URL([]byte(tampered)) + "." + parts[2]
+
+	if err := NewTrustAnchorStore().LoadMetadataBLOB([]byte(blob), mdsRoots[0]); err == nil {
+		t.Error("Expected tampered MDS blob to be rejected")
+	}
+
+	// The attestation root did not sign the blob
+	attRoots, _ := parsePEMCertificates([]byte(fixtures.AttestationRoot))
+	if err := NewTrustAnchorStore().LoadMetadataBLOB([]byte(fixtures.MDSBlob), attRoots[0]); err == nil {
+		t.Error("Expected MDS blob signed under another root to be rejected")
+	}
+}
+
+func TestAttestationPolicyEvaluate(t *testing.T) {
+	packed := &attestationResult{Format: FormatPacked, Type: AttestationTypeBasic}
+	none := &attestationResult{Format: FormatNone, Type: AttestationTypeNone}
+	const yubikey = "a4e9fc6d-4cbe-4758-b8ba-37598bb5bbaa"
+
+	custom := AttestationPolicy{
+		Default: AttestationAccept,
+		Formats: map[string]AttestationDecision{FormatNone: AttestationWarn},
+		AAGUIDs: map[string]AttestationDecision{
+			yubikey:                                AttestationWarn,
+			"c6e1bd8f-2233-4d6a-8e4f-7b2c3d4e5f60": AttestationReject,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		policy  AttestationPolicy
+		result  *attestationResult
+		aaguid  string
+		trusted bool
+		want    AttestationDecision
+	}{
+		{"student none", DefaultAttestationPolicy(), none, zeroAAGUID, false, AttestationAccept},
+		{"student untrusted packed", DefaultAttestationPolicy(), packed, yubikey, false, AttestationAccept},
+		{"hardware none", HardwareKeyAttestationPolicy(), none, zeroAAGUID, false, AttestationReject},
+		{"hardware untrusted packed", HardwareKeyAttestationPolicy(), packed, yubikey, false, AttestationReject},
+		{"hardware trusted packed", HardwareKeyAttestationPolicy(), packed, yubikey, true, AttestationAccept},
+		{"aaguid rule when trusted", custom, packed, yubikey, true, AttestationWarn},
+		{"aaguid rule ignored when untrusted", custom, packed, yubikey, false, AttestationAccept},
+		{"aaguid reject always applies", custom, packed, "C6E1BD8F-2233-4D6A-8E4F-7B2C3D4E5F60", false, AttestationReject},
+		{"format rule", custom, none, zeroAAGUID, false, AttestationWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := tt.policy.Evaluate(tt.result, tt.aaguid, tt.trusted)
+			if got != tt.want {
+				t.Errorf("Got %s (%s), want %s", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyForRoles(t *testing.T) {
+	w := newTestService(t)
+
+	tests := []struct {
+		roles          []string
+		wantConveyance string
+	}{
+		{nil, AttestationNone},
+		{[]string{"registered"}, AttestationNone},
+		{[]string{"admin"}, AttestationDirect},
+		{[]string{"registered", "assessor"}, AttestationDirect},
+	}
+
+	for _, tt := range tests {
+		policy := w.policyForRoles(tt.roles)
+		if policy.Conveyance != tt.wantConveyance {
+			t.Errorf("Roles %v: got conveyance %s, want %s", tt.roles, policy.Conveyance, tt.wantConveyance)
+		}
+	}
+}
+
+func TestLoadDefaultTrustAnchorsFromConfig(t *testing.T) {
+	fixtures := loadAttestationFixtures(t)
+	t.Cleanup(func() { SetDefaultTrustAnchors(NewTrustAnchorStore()) })
+
+	trustAnchorsLoaded = false
+	if _, err := loadDefaultTrustAnchors(config.WebAuthnConfig{MetadataBLOB: fixtures.MDSBlob, MetadataRootPEM: fixtures.AttestationRoot}); err == nil {
+		t.Error("Expected a blob signed under another root to be refused")
+	}
+	if trustAnchorsLoaded {
+		t.Error("Expected a failed load to be retried")
+	}
+
+	store, err := loadDefaultTrustAnchors(config.WebAuthnConfig{MetadataBLOB: fixtures.MDSBlob, MetadataRootPEM: fixtures.MDSRoot})
+	if err != nil {
+		t.Fatalf("loadDefaultTrustAnchors failed: %v", err)
+	}
+	if store.Empty() || DefaultTrustAnchors() != store {
+		t.Error("Expected the MDS roots to become the default trust anchors")
+	}
+}
+
+func TestRequireTrustedNeedsTrustAnchors(t *testing.T) {
+	w := newTestService(t)
+
+	w.SetTrustAnchors(NewTrustAnchorStore())
+	if err := w.checkTrustAnchorsFor(HardwareKeyAttestationPolicy()); err == nil {
+		t.Error("Expected hardware key registration to fail without trust anchors")
+	}
+	if err := w.checkTrustAnchorsFor(DefaultAttestationPolicy()); err != nil {
+		t.Errorf("Expected the default policy to work without trust anchors, got %v", err)
+	}
+
+	w.SetTrustAnchors(newTestTrustAnchors(t, loadAttestationFixtures(t)))
+	if err := w.checkTrustAnchorsFor(HardwareKeyAttestationPolicy()); err != nil {
+		t.Errorf("Expected hardware key registration to work with trust anchors, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+package webauthn
+
+import themislog "modus/agents/audit/ThemisLog"
+
+// auditor writes WebAuthn security events to the audit trail
+var auditor = themislog.Auditor{Source: "WebAuthn", Category: themislog.CategoryAuthentication}
@@ -0,0 +1,232 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Minimal CBOR (RFC 8949) decoder covering the subset used by WebAuthn
+// attestation objects and COSE keys. Authenticators emit CTAP2 canonical
+// CBOR, so indefinite-length items are rejected rather than supported.
+//
+// Decoded values map to Go types as follows:
+//   unsigned/negative integers -> int64
+//   byte strings               -> []byte
+//   text strings               -> string
+//   arrays                     -> []interface{}
+//   maps                       -> map[interface{}]interface{}
+//   true/false/null            -> bool / nil
+//   floats                     -> float64
+// Tags are unwrapped and their content returned.
+
+const cborMaxDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeCBOR decodes the first CBOR item in data and returns it together with
+// the number of bytes consumed, so callers can locate trailing data (e.g. the
+// extensions that follow a credential public key in authenticator data).
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := &cborDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.pos, nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: maximum nesting depth exceeded")
+	}
+	if d.pos >= len(d.data) {
+		return nil, errCBORTruncated
+	}
+
+	initial := d.data[d.pos]
+	d.pos++
+	major := initial >> 5
+	info := initial & 0x1f
+
+	// Floats and simple values use the additional info differently
+	if major == 7 {
+		return d.decodeSimple(info)
+	}
+
+	arg, err := d.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: unsigned integer overflows int64")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		b, err := d.readBytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(b))
+		copy(out, b)
+		return out, nil
+	case 3:
+		b, err := d.readBytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := m[key]; exists {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+			m[key] = value
+		}
+		return m, nil
+	case 6:
+		// Tagged item - return the tagged content
+		return d.decode(depth + 1)
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// readArgument reads the argument that follows the initial byte
+func (d *cborDecoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	case info == 31:
+		return 0, errors.New("cbor: indefinite-length items are not supported")
+	}
+	return 0, fmt.Errorf("cbor: invalid additional info %d", info)
+}
+
+// decodeSimple handles major type 7 (simple values and floats)
+func (d *cborDecoder) decodeSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(halfToFloat32(binary.BigEndian.Uint16(b))), nil
+	case 26:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func (d *cborDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// halfToFloat32 converts an IEEE 754 half-precision float to float32
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		// Subnormal
+		f := float32(frac) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// clientDataJSON type values (WebAuthn §5.8.1)
+const (
+	ClientDataTypeCreate = "webauthn.create"
+	ClientDataTypeGet    = "webauthn.get"
+)
+
+// authenticatorTransports are the AuthenticatorTransport values of WebAuthn §5.8.4
+var authenticatorTransports = []string{"usb", "nfc", "ble", "smart-card", "hybrid", "internal"}
+
+// SetAllowedOrigins replaces the origins accepted in clientDataJSON
+func (w *WebAuthnService) SetAllowedOrigins(origins []string) {
+	w.allowedOrigins = make([]string, 0, len(origins))
+	for _, origin := range origins {
+		w.allowedOrigins = append(w.allowedOrigins, strings.TrimRight(origin, "/"))
+	}
+}
+
+// SetUserVerification sets the userVerification requirement sent in
+// challenges and enforced on the UV flag ("required", "preferred" or "discouraged")
+func (w *WebAuthnService) SetUserVerification(requirement string) {
+	w.userVerification = requirement
+}
+
+// verifyClientData checks the ceremony type, challenge and origin of clientDataJSON
+func (w *WebAuthnService) verifyClientData(clientData *ClientData, expectedType, challenge string) error {
+	if clientData.Type != expectedType {
+		return fmt.Errorf("unexpected client data type %q, expected %q", clientData.Type, expectedType)
+	}
+
+	// Browsers send unpadded base64url; stored challenges may be padded
+	if strings.TrimRight(clientData.Challenge, "=") != strings.TrimRight(challenge, "=") {
+		return errors.New("challenge mismatch")
+	}
+
+	if !containsString(w.allowedOrigins, strings.TrimRight(clientData.Origin, "/")) {
+		return fmt.Errorf("origin %q is not allowed", clientData.Origin)
+	}
+	if clientData.CrossOrigin {
+		return errors.New("cross-origin ceremonies are not allowed")
+	}
+
+	return nil
+}
+
+// assertionUserVerification is the userVerification requirement for an
+// assertion. Usernameless (passkey) sign-in is a single step, so the
+// authenticator must verify the user itself.
+func (w *WebAuthnService) assertionUserVerification(userID string) string {
+	if userID == "" {
+		return UserVerificationRequired
+	}
+	return w.userVerification
+}
+
+// hardwareBound reports whether an assertion came from a key that can't leave
+// its authenticator: the model was attested by a trusted chain and the key is
+// not backup eligible (synced passkeys set BE)
+func hardwareBound(credential *WebAuthnCredential, authData *authenticatorData) bool {
+	return credential.AttestationTrusted && authData.Flags&flagBackupEligible == 0
+}
+
+// verifyAuthenticatorFlags checks the rpIdHash and flags in authenticator
+// data against the relying party and the requested user verification
+func (w *WebAuthnService) verifyAuthenticatorFlags(authData *authenticatorData, userVerification string) error {
+	rpIDHash := sha256.Sum256([]byte(w.rpID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return errors.New("rpIdHash does not match relying party")
+	}
+	if !authData.UserPresent() {
+		return errors.New("user presence flag not set")
+	}
+	if userVerification == UserVerificationRequired && !authData.UserVerified() {
+		return errors.New("user verification required but not performed")
+	}
+	if authData.Flags&flagBackupState != 0 && authData.Flags&flagBackupEligible == 0 {
+		return errors.New("backup state set on a credential that is not backup eligible")
+	}
+	return nil
+}
+
+// verifyUserHandle checks the userHandle returned by the authenticator
+// against the owner of the asserted credential. The user handle is the
+// base64url encoding of the user ID sent as user.id at registration.
+// Usernameless sign-in has no other way to bind the assertion to a user, so
+// the handle is required there.
+func verifyUserHandle(cred *WebAuthnCredential, userHandle string, discoverable bool) error {
+	if userHandle == "" {
+		if discoverable {
+			return errors.New("user handle is required for passkey sign-in")
+		}
+		return nil
+	}
+
+	handle, err := decodeBase64URL(userHandle)
+	if err != nil {
+		return fmt.Errorf("invalid user handle: %v", err)
+	}
+	if string(handle) != cred.UserID {
+		return errors.New("user handle does not match credential owner")
+	}
+	return nil
+}
+
+// signCountRegressed reports whether a received signature counter indicates
+// a cloned authenticator (WebAuthn §6.1.1). Authenticators that do not
+// implement a counter always report zero.
+func signCountRegressed(stored, received uint32) bool {
+	if stored == 0 && received == 0 {
+		return false
+	}
+	return received <= stored
+}
+
+// knownTransports keeps the transports the client reported through
+// getTransports() that this version of the spec defines. Unknown values are
+// dropped, as clients must ignore them anyway.
+func knownTransports(transports []string) []string {
+	var known []string
+	for _, transport := range transports {
+		for _, t := range authenticatorTransports {
+			if transport == t {
+				known = append(known, transport)
+				break
+			}
+		}
+	}
+	return known
+}
@@ -0,0 +1,182 @@
+package webauthn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyClientData(t *testing.T) {
+	w := newTestService(t)
+	origin := w.allowedOrigins[0]
+
+	tests := []struct {
+		name       string
+		clientData ClientData
+		wantType   string
+		wantErr    bool
+	}{
+		{
+			name:       "valid get",
+			clientData: ClientData{Type: ClientDataTypeGet, Challenge: "abc", Origin: origin},
+			wantType:   ClientDataTypeGet,
+		},
+		{
+			name:       "trailing slash in origin",
+			clientData: ClientData{Type: ClientDataTypeCreate, Challenge: "abc", Origin: origin + "/"},
+			wantType:   ClientDataTypeCreate,
+		},
+		{
+			name:       "create used for login",
+			clientData: ClientData{Type: ClientDataTypeCreate, Challenge: "abc", Origin: origin},
+			wantType:   ClientDataTypeGet,
+			wantErr:    true,
+		},
+		{
+			name:       "phishing origin",
+			clientData: ClientData{Type: ClientDataTypeGet, Challenge: "abc", Origin: "https://do-study.hypermode.host.evil.example"},
+			wantType:   ClientDataTypeGet,
+			wantErr:    true,
+		},
+		{
+			name:       "http origin",
+			clientData: ClientData{Type: ClientDataTypeGet, Challenge: "abc", Origin: "http://" + w.rpID},
+			wantType:   ClientDataTypeGet,
+			wantErr:    true,
+		},
+		{
+			name:       "cross origin iframe",
+			clientData: ClientData{Type: ClientDataTypeGet, Challenge: "abc", Origin: origin, CrossOrigin: true},
+			wantType:   ClientDataTypeGet,
+			wantErr:    true,
+		},
+		{
+			name:       "wrong challenge",
+			clientData: ClientData{Type: ClientDataTypeGet, Challenge: "abd", Origin: origin},
+			wantType:   ClientDataTypeGet,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := w.verifyClientData(&tt.clientData, tt.wantType, "abc=")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyClientData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	w.SetAllowedOrigins([]string{"http://localhost:3000/"})
+	local := ClientData{Type: ClientDataTypeGet, Challenge: "abc", Origin: "http://localhost:3000"}
+	if err := w.verifyClientData(&local, ClientDataTypeGet, "abc"); err != nil {
+		t.Errorf("Expected configured origin to be allowed: %v", err)
+	}
+}
+
+func TestVerifyAuthenticatorFlags(t *testing.T) {
+	for _, v := range loadAuthenticatorVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			raw, err := decodeBase64URL(v.AuthenticatorData)
+			if err != nil {
+				t.Fatalf("Failed to decode authenticator data: %v", err)
+			}
+			parse := func(mutate func([]byte)) *authenticatorData {
+				data := append([]byte{}, raw...)
+				if mutate != nil {
+					mutate(data)
+				}
+				authData, err := parseAuthenticatorData(data)
+				if err != nil {
+					t.Fatalf("parseAuthenticatorData failed: %v", err)
+				}
+				return authData
+			}
+
+			w := newTestService(t)
+			if err := w.verifyAuthenticatorFlags(parse(nil), w.userVerification); err != nil {
+				t.Errorf("Expected valid authenticator data: %v", err)
+			}
+			if err := w.verifyAuthenticatorFlags(parse(func(d []byte) { d[0] ^= 0xff }), w.userVerification); err == nil {
+				t.Error("Expected rpIdHash mismatch to be rejected")
+			}
+			if err := w.verifyAuthenticatorFlags(parse(func(d []byte) { d[32] &^= flagUserPresent }), w.userVerification); err == nil {
+				t.Error("Expected missing UP flag to be rejected")
+			}
+			if err := w.verifyAuthenticatorFlags(parse(func(d []byte) { d[32] |= flagBackupState }), w.userVerification); err == nil {
+				t.Error("Expected BS without BE to be rejected")
+			}
+
+			noUV := parse(func(d []byte) { d[32] &^= flagUserVerified })
+			if err := w.verifyAuthenticatorFlags(noUV, w.userVerification); err != nil {
+				t.Errorf("Expected missing UV to pass when preferred: %v", err)
+			}
+			if err := w.verifyAuthenticatorFlags(noUV, w.assertionUserVerification("")); err == nil {
+				t.Error("Expected missing UV to be rejected for passkey sign-in")
+			}
+			w.SetUserVerification(UserVerificationRequired)
+			if err := w.verifyAuthenticatorFlags(noUV, w.userVerification); err == nil {
+				t.Error("Expected missing UV to be rejected when required")
+			}
+
+			other := newTestService(t)
+			other.rpID = "example.com"
+			if err := other.verifyAuthenticatorFlags(parse(nil), other.userVerification); err == nil {
+				t.Error("Expected authenticator data for another RP to be rejected")
+			}
+		})
+	}
+}
+
+func TestVerifyUserHandle(t *testing.T) {
+	for _, v := range loadAuthenticatorVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			cred := registerVector(t, v)
+			cred.UserID = "0x2a"
+
+			if err := verifyUserHandle(cred, v.UserHandle, true); err != nil {
+				t.Errorf("Expected user handle to resolve to the credential owner: %v", err)
+			}
+			if err := verifyUserHandle(cred, "", false); err != nil {
+				t.Errorf("Expected missing user handle to pass when the user is known: %v", err)
+			}
+			if err := verifyUserHandle(cred, "", true); err == nil {
+				t.Error("Expected missing user handle to be rejected for passkey sign-in")
+			}
+
+			cred.UserID = "0x2b"
+			if err := verifyUserHandle(cred, v.UserHandle, true); err == nil {
+				t.Error("Expected user handle for another user to be rejected")
+			}
+		})
+	}
+}
+
+func TestSignCountRegressed(t *testing.T) {
+	tests := []struct {
+		stored, received uint32
+		want             bool
+	}{
+		{0, 0, false}, // authenticator without a counter
+		{0, 1, false},
+		{7, 8, false},
+		{7, 7, true},
+		{7, 3, true},
+		{7, 0, true},
+	}
+
+	for _, tt := range tests {
+		if got := signCountRegressed(tt.stored, tt.received); got != tt.want {
+			t.Errorf("signCountRegressed(%d, %d) = %t, want %t", tt.stored, tt.received, got, tt.want)
+		}
+	}
+}
+
+func TestKnownTransports(t *testing.T) {
+	got := knownTransports([]string{"internal", "hybrid", "carrier-pigeon", "usb"})
+	if strings.Join(got, ",") != "internal,hybrid,usb" {
+		t.Errorf("Expected unknown transports to be dropped, got %v", got)
+	}
+	if got := knownTransports(nil); len(got) != 0 {
+		t.Errorf("Expected no transports when the client reports none, got %v", got)
+	}
+}
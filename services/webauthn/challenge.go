@@ -0,0 +1,136 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// WebAuthnChallenge type values. A challenge can only be consumed by the
+// ceremony it was issued for.
+const (
+	ChallengeTypeRegistration   = "registration"
+	ChallengeTypeAuthentication = "authentication"
+)
+
+// consumeChallenge looks up a challenge issued for userID and challengeType
+// and deletes it in the same upsert, so each challenge is accepted at most
+// once. Two concurrent consumers of the same challenge conflict on the
+// delete and only one transaction commits. Expired challenges are deleted
+// too, but rejected.
+func (w *WebAuthnService) consumeChallenge(challenge, userID, challengeType string) error {
+	query, mutation := challengeConsumeUpsert(challenge, userID, challengeType)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		log.Printf("❌ WebAuthn: Challenge upsert failed: %v", err)
+		return err
+	}
+
+	return checkConsumedChallenge(resp.Json, time.Now())
+}
+
+// challengeConsumeUpsert builds the query and conditional delete used by
+// consumeChallenge. Usernameless (passkey) challenges have no userId.
+func challengeConsumeUpsert(challenge, userID, challengeType string) (*dgraph.Query, *dgraph.Mutation) {
+	userFilter := "NOT has(userId)"
+	if userID != "" {
+		userFilter = "eq(userId, $userId)"
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`query consume($challenge: string, $type: string, $userId: string) {
+		c as var(func: eq(challenge, $challenge)) @filter(type(WebAuthnChallenge) AND eq(type, $type) AND %s)
+
+		challenges(func: uid(c)) {
+			uid
+			expiresAt
+		}
+	}`, userFilter)).
+		WithVariable("$challenge", challenge).
+		WithVariable("$type", challengeType).
+		WithVariable("$userId", userID)
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(eq(len(c), 1))").
+		WithDelNquads(`uid(c) * * .`)
+
+	return query, mutation
+}
+
+// checkConsumedChallenge inspects the query half of the consume upsert. The
+// query sees the challenge as it was before the delete, so a replayed
+// challenge finds nothing.
+func checkConsumedChallenge(respJSON string, now time.Time) error {
+	var result struct {
+		Challenges []struct {
+			UID       string `json:"uid"`
+			ExpiresAt string `json:"expiresAt"`
+		} `json:"challenges"`
+	}
+
+	if err := json.Unmarshal([]byte(respJSON), &result); err != nil {
+		return err
+	}
+
+	// No match (or a duplicate, which the upsert leaves alone) is treated the
+	// same: the caller gets nothing to distinguish a replay from a bad ID
+	if len(result.Challenges) != 1 {
+		return errors.New("challenge not found")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, result.Challenges[0].ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	if now.After(expiresAt) {
+		return errors.New("challenge expired")
+	}
+
+	return nil
+}
+
+// SweepExpiredChallenges deletes every challenge past its expiry and
+// returns how many were removed
+func (w *WebAuthnService) SweepExpiredChallenges() (int, error) {
+	query := dgraph.NewQuery(`query sweep($now: string) {
+		expired as var(func: type(WebAuthnChallenge)) @filter(lt(expiresAt, $now))
+
+		swept(func: uid(expired)) {
+			count(uid)
+		}
+	}`).WithVariable("$now", time.Now().Format(time.RFC3339))
+
+	mutation := dgraph.NewMutation().
+		WithCondition("@if(gt(len(expired), 0))").
+		WithDelNquads(`uid(expired) * * .`)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sweep challenges: %v", err)
+	}
+
+	var result struct {
+		Swept []struct {
+			Count int `json:"count"`
+		} `json:"swept"`
+	}
+
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	if len(result.Swept) > 0 {
+		swept = result.Swept[0].Count
+	}
+
+	if swept > 0 {
+		log.Printf("🧹 WebAuthn: Swept %d expired challenges", swept)
+	}
+	return swept, nil
+}
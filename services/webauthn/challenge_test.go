@@ -0,0 +1,72 @@
+package webauthn
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+func TestChallengeConsumeUpsert(t *testing.T) {
+	query, mutation := challengeConsumeUpsert("abc", "0x2a", ChallengeTypeAuthentication)
+
+	if !strings.Contains(query.Query, "eq(type, $type)") || query.Variables["$type"] != ChallengeTypeAuthentication {
+		t.Error("Expected challenge lookup to be bound to the ceremony type")
+	}
+	if !strings.Contains(query.Query, "eq(userId, $userId)") || query.Variables["$userId"] != "0x2a" {
+		t.Error("Expected challenge lookup to be bound to the user")
+	}
+	if query.Variables["$challenge"] != "abc" {
+		t.Errorf("Expected challenge variable to be set, got %q", query.Variables["$challenge"])
+	}
+	if mutation.Condition != "@if(eq(len(c), 1))" || mutation.DelNquads != "uid(c) * * ." {
+		t.Errorf("Expected conditional delete of the matched challenge, got %q / %q", mutation.Condition, mutation.DelNquads)
+	}
+
+	passkey, _ := challengeConsumeUpsert("abc", "", ChallengeTypeAuthentication)
+	if !strings.Contains(passkey.Query, "NOT has(userId)") {
+		t.Error("Expected usernameless challenge lookup to exclude user-bound challenges")
+	}
+}
+
+func TestConsumeChallengeIsSingleUpsert(t *testing.T) {
+	w := newTestService(t)
+	before := dgraph.DgraphQueryCallStack.Size()
+
+	// The mock returns no challenges, so the consume must fail closed
+	if err := w.consumeChallenge("abc", "0x2a", ChallengeTypeRegistration); err == nil {
+		t.Error("Expected unknown challenge to be rejected")
+	}
+
+	if got := dgraph.DgraphQueryCallStack.Size() - before; got != 1 {
+		t.Fatalf("Expected one Dgraph request, got %d", got)
+	}
+	call := dgraph.DgraphQueryCallStack.Items[dgraph.DgraphQueryCallStack.Size()-1]
+	req := call[1].(*dgraph.Request)
+	if req.Query == nil || len(req.Mutations) != 1 || req.Mutations[0].Condition == "" {
+		t.Error("Expected lookup and delete to be sent as a single conditional upsert")
+	}
+}
+
+func TestCheckConsumedChallengeReplay(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	live := `{"challenges":[{"uid":"0x1","expiresAt":"2025-01-01T12:05:00Z"}]}`
+
+	// First use sees the challenge; the upsert deletes it in the same transaction
+	if err := checkConsumedChallenge(live, now); err != nil {
+		t.Fatalf("Expected first use to succeed: %v", err)
+	}
+	// A replay, or a challenge issued for another type or user, matches nothing
+	if err := checkConsumedChallenge(`{"challenges":[]}`, now); err == nil {
+		t.Error("Expected replayed challenge to be rejected")
+	}
+	if err := checkConsumedChallenge(live, now.Add(ChallengeExpiryMinutes*time.Minute+time.Second)); err == nil {
+		t.Error("Expected expired challenge to be rejected")
+	}
+
+	duplicate := `{"challenges":[{"uid":"0x1","expiresAt":"2025-01-01T12:05:00Z"},{"uid":"0x2","expiresAt":"2025-01-01T12:05:00Z"}]}`
+	if err := checkConsumedChallenge(duplicate, now); err == nil {
+		t.Error("Expected ambiguous challenge to be rejected")
+	}
+}
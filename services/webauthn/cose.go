@@ -0,0 +1,181 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE key parameters (RFC 9053) used by WebAuthn credential public keys
+const (
+	coseKeyKty = 1
+	coseKeyAlg = 3
+
+	// EC2 / OKP parameters
+	coseKeyCrv = -1
+	coseKeyX   = -2
+	coseKeyY   = -3
+
+	// RSA parameters
+	coseKeyN = -1
+	coseKeyE = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// COSE algorithm identifiers supported for credential public keys
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// coseKey is a parsed COSE_Key holding one of the supported public key types
+type coseKey struct {
+	Kty int64
+	Alg int64
+	Crv int64
+	X   []byte
+	Y   []byte
+	N   []byte
+	E   []byte
+}
+
+// parseCOSEKey decodes a CBOR-encoded COSE_Key and returns it with the
+// number of bytes consumed
+func parseCOSEKey(raw []byte) (*coseKey, int, error) {
+	decoded, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid COSE key: %v", err)
+	}
+
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("invalid COSE key: not a map")
+	}
+
+	key := &coseKey{}
+	if key.Kty, ok = m[int64(coseKeyKty)].(int64); !ok {
+		return nil, 0, errors.New("invalid COSE key: missing kty")
+	}
+	if key.Alg, ok = m[int64(coseKeyAlg)].(int64); !ok {
+		return nil, 0, errors.New("invalid COSE key: missing alg")
+	}
+
+	switch key.Kty {
+	case coseKtyEC2:
+		key.Crv, _ = m[int64(coseKeyCrv)].(int64)
+		key.X, _ = m[int64(coseKeyX)].([]byte)
+		key.Y, _ = m[int64(coseKeyY)].([]byte)
+		if key.Alg != COSEAlgES256 || key.Crv != coseCrvP256 {
+			return nil, 0, fmt.Errorf("unsupported EC2 key: alg %d, crv %d", key.Alg, key.Crv)
+		}
+		if len(key.X) != 32 || len(key.Y) != 32 {
+			return nil, 0, errors.New("invalid EC2 key coordinates")
+		}
+	case coseKtyRSA:
+		key.N, _ = m[int64(coseKeyN)].([]byte)
+		key.E, _ = m[int64(coseKeyE)].([]byte)
+		if key.Alg != COSEAlgRS256 {
+			return nil, 0, fmt.Errorf("unsupported RSA key: alg %d", key.Alg)
+		}
+		if len(key.N) == 0 || len(key.E) == 0 {
+			return nil, 0, errors.New("invalid RSA key parameters")
+		}
+	case coseKtyOKP:
+		key.Crv, _ = m[int64(coseKeyCrv)].(int64)
+		key.X, _ = m[int64(coseKeyX)].([]byte)
+		if key.Alg != COSEAlgEdDSA || key.Crv != coseCrvEd25519 {
+			return nil, 0, fmt.Errorf("unsupported OKP key: alg %d, crv %d", key.Alg, key.Crv)
+		}
+		if len(key.X) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 public key")
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported COSE key type %d", key.Kty)
+	}
+
+	return key, n, nil
+}
+
+// publicKey converts the COSE key to a crypto.PublicKey
+func (k *coseKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case coseKtyEC2:
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(k.X),
+			Y:     new(big.Int).SetBytes(k.Y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC2 public key is not on curve P-256")
+		}
+		return pub, nil
+	case coseKtyRSA:
+		e := new(big.Int).SetBytes(k.E)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, errors.New("invalid RSA public exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(k.N), E: int(e.Int64())}, nil
+	case coseKtyOKP:
+		return ed25519.PublicKey(k.X), nil
+	}
+	return nil, fmt.Errorf("unsupported COSE key type %d", k.Kty)
+}
+
+// verifySignature checks sig over data using the key's algorithm
+func (k *coseKey) verifySignature(data, sig []byte) error {
+	pub, err := k.publicKey()
+	if err != nil {
+		return err
+	}
+	return verifyWithAlgorithm(pub, k.Alg, data, sig)
+}
+
+// verifyWithAlgorithm checks sig over data for a COSE algorithm identifier.
+// Shared by credential assertions and attestation statements.
+func verifyWithAlgorithm(pub crypto.PublicKey, alg int64, data, sig []byte) error {
+	switch alg {
+	case COSEAlgES256:
+		ecPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 requires an ECDSA public key")
+		}
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(ecPub, digest[:], sig) {
+			return errors.New("ES256 signature verification failed")
+		}
+		return nil
+	case COSEAlgRS256:
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 requires an RSA public key")
+		}
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("RS256 signature verification failed")
+		}
+		return nil
+	case COSEAlgEdDSA:
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("EdDSA requires an Ed25519 public key")
+		}
+		if !ed25519.Verify(edPub, data, sig) {
+			return errors.New("EdDSA signature verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signature algorithm %d", alg)
+}
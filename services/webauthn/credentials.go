@@ -0,0 +1,247 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+)
+
+// MaxNicknameLength bounds user-chosen credential nicknames
+const MaxNicknameLength = 64
+
+// knownAuthenticators names common authenticators whose AAGUID is stable
+// but which are not listed in the FIDO MDS blob (mostly passkey providers)
+var knownAuthenticators = map[string]string{
+	"fbfc3007-154e-4ecc-8c0b-6e020557d7bd": "iCloud Keychain",
+	"ea9b8d66-4d01-1d21-3ce4-b6b48cb575d4": "Google Password Manager",
+	"adce0002-35bc-c60a-648b-0b25f1f05503": "Chrome on Mac",
+	"08987058-cadc-4b81-b6e1-30de50dcbe96": "Windows Hello",
+	"9ddd1817-af5a-4672-a2b9-3e3dd95000a9": "Windows Hello",
+	"6028b017-b1d4-4c02-b4b3-afcdafc96bb2": "Windows Hello",
+	"53414d53-554e-4700-0000-000000000000": "Samsung Pass",
+	"bada5566-a7aa-401f-bd96-45619a55120d": "1Password",
+	"d548826e-79b4-db40-a3d8-11116f7e8349": "Bitwarden",
+	"cb69481e-8ff7-4039-93ec-0a2729a154a8": "YubiKey 5 Series",
+	"ee882879-721c-4913-9775-3dfcce97072a": "YubiKey 5 Series",
+}
+
+// authenticatorName derives a display name for a credential from its AAGUID
+func (w *WebAuthnService) authenticatorName(aaguid string) string {
+	aaguid = strings.ToLower(aaguid)
+	if m, ok := w.trustAnchors.Metadata(aaguid); ok && m.Description != "" {
+		return m.Description
+	}
+	if name, ok := knownAuthenticators[aaguid]; ok {
+		return name
+	}
+	if aaguid == "" || aaguid == zeroAAGUID {
+		return "Security key or passkey"
+	}
+	return "Unknown authenticator"
+}
+
+// ListCredentials returns the authenticators registered by a user
+func (w *WebAuthnService) ListCredentials(userID string) ([]CredentialInfo, error) {
+	query := fmt.Sprintf(`{
+		credentials(func: type(WebAuthnCredential)) @filter(uid_in(user, <%s>)) {
+			credentialId
+			nickname
+			aaguid
+			attestationFormat
+			transports
+			cloneWarning
+			addedAt
+			lastUsedAt
+		}
+	}`, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Credentials []struct {
+			CredentialID      string     `json:"credentialId"`
+			Nickname          string     `json:"nickname"`
+			AAGUID            string     `json:"aaguid"`
+			AttestationFormat string     `json:"attestationFormat"`
+			Transports        []string   `json:"transports"`
+			CloneWarning      bool       `json:"cloneWarning"`
+			AddedAt           time.Time  `json:"addedAt"`
+			LastUsedAt        *time.Time `json:"lastUsedAt"`
+		} `json:"credentials"`
+	}
+
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+
+	credentials := make([]CredentialInfo, 0, len(result.Credentials))
+	for _, cred := range result.Credentials {
+		credentials = append(credentials, CredentialInfo{
+			CredentialID:      cred.CredentialID,
+			Nickname:          cred.Nickname,
+			AuthenticatorName: w.authenticatorName(cred.AAGUID),
+			AAGUID:            cred.AAGUID,
+			AttestationFormat: cred.AttestationFormat,
+			Transports:        cred.Transports,
+			CloneWarning:      cred.CloneWarning,
+			CreatedAt:         cred.AddedAt,
+			LastUsedAt:        cred.LastUsedAt,
+		})
+	}
+
+	return credentials, nil
+}
+
+// RenameCredential sets the nickname shown for one of the user's credentials
+func (w *WebAuthnService) RenameCredential(req RenameCredentialRequest) (CredentialResponse, error) {
+	nickname, err := normalizeNickname(req.Nickname)
+	if err != nil {
+		return CredentialResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	credential, err := w.getOwnedCredential(req.UserID, req.CredentialID)
+	if err != nil {
+		return CredentialResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	nquads := fmt.Sprintf(`<%s> <nickname> %q .`, credential.UID, nickname)
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		return CredentialResponse{}, fmt.Errorf("failed to rename credential: %v", err)
+	}
+
+	auditor.Record("WEBAUTHN_CREDENTIAL_RENAMED", "WebAuthnCredential", credential.UID, req.UserID, themislog.SeverityInfo,
+		fmt.Sprintf("Credential %s renamed from %q to %q", credential.CredentialID, credential.Nickname, nickname))
+
+	return CredentialResponse{
+		Success:      true,
+		CredentialID: credential.CredentialID,
+		Message:      "Authenticator renamed",
+	}, nil
+}
+
+// RevokeCredential deletes one of the user's credentials. The last
+// remaining credential can only be removed once the caller has verified
+// another factor, so users can't lock themselves out. Sessions created
+// with the credential are ended by the caller (see CerberusMFA).
+func (w *WebAuthnService) RevokeCredential(req RevokeCredentialRequest) (CredentialResponse, error) {
+	credential, err := w.getOwnedCredential(req.UserID, req.CredentialID)
+	if err != nil {
+		return CredentialResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	remaining, err := w.getUserCredentials(req.UserID)
+	if err != nil {
+		return CredentialResponse{}, fmt.Errorf("failed to count credentials: %v", err)
+	}
+	if len(remaining) <= 1 && !req.OtherFactorVerified {
+		return CredentialResponse{
+			Success: false,
+			Message: "Verify another factor before removing your last authenticator",
+		}, nil
+	}
+
+	nquads := fmt.Sprintf(`<%s> * * .`, credential.UID)
+	mutationObj := dgraph.NewMutation().WithDelNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		return CredentialResponse{}, fmt.Errorf("failed to revoke credential: %v", err)
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "user request"
+	}
+	auditor.Record("WEBAUTHN_CREDENTIAL_REVOKED", "WebAuthnCredential", credential.UID, req.UserID, themislog.SeverityWarning,
+		fmt.Sprintf("Credential %s (%s) revoked: %s", credential.CredentialID, w.authenticatorName(credential.AAGUID), reason))
+
+	log.Printf("✅ WebAuthn: Credential %s revoked for user %s", credential.CredentialID, req.UserID)
+	return CredentialResponse{
+		Success:      true,
+		CredentialID: credential.CredentialID,
+		Message:      "Authenticator removed",
+	}, nil
+}
+
+// RevokeAllCredentials deletes every credential of a user, e.g. after account
+// recovery when any of them may be in the wrong hands
+func (w *WebAuthnService) RevokeAllCredentials(userID, reason string) (int, error) {
+	if userID == "" {
+		return 0, errors.New("user ID is required")
+	}
+
+	query := dgraph.NewQuery(fmt.Sprintf(`{
+		c as var(func: type(WebAuthnCredential)) @filter(uid_in(user, <%s>))
+		credentials(func: uid(c)) {
+			credentialId
+		}
+	}`, userID))
+
+	mutationObj := dgraph.NewMutation().WithDelNquads(`uid(c) * * .`)
+	resp, err := dgraph.ExecuteQuery("dgraph", query, mutationObj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke credentials: %v", err)
+	}
+
+	var result struct {
+		Credentials []struct {
+			CredentialID string `json:"credentialId"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return 0, err
+	}
+
+	revoked := len(result.Credentials)
+	if revoked > 0 {
+		auditor.Record("WEBAUTHN_CREDENTIALS_REVOKED", "WebAuthnCredential", userID, userID, themislog.SeverityCritical,
+			fmt.Sprintf("All %d credential(s) revoked: %s", revoked, reason))
+	}
+
+	log.Printf("✅ WebAuthn: %d credential(s) revoked for user %s", revoked, userID)
+	return revoked, nil
+}
+
+// getOwnedCredential loads a credential and checks it belongs to userID
+func (w *WebAuthnService) getOwnedCredential(userID, credentialID string) (*WebAuthnCredential, error) {
+	if userID == "" || credentialID == "" {
+		return nil, errors.New("user ID and credential ID are required")
+	}
+	credential, err := w.getCredentialByID(credentialID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.UserID != userID {
+		// Same message as a missing credential so IDs can't be probed
+		return nil, WebAuthnError{Code: ErrorInvalidCredential, Message: "credential not found"}
+	}
+	return credential, nil
+}
+
+// normalizeNickname trims a nickname and rejects empty, overlong or
+// control-character values
+func normalizeNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if nickname == "" {
+		return "", errors.New("nickname is required")
+	}
+	if len([]rune(nickname)) > MaxNicknameLength {
+		return "", fmt.Errorf("nickname must be at most %d characters", MaxNicknameLength)
+	}
+	for _, r := range nickname {
+		if unicode.IsControl(r) {
+			return "", errors.New("nickname contains invalid characters")
+		}
+	}
+	return nickname, nil
+}
@@ -0,0 +1,48 @@
+package webauthn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeNickname(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickname string
+		want     string
+		wantErr  bool
+	}{
+		{name: "plain", nickname: "Work laptop", want: "Work laptop"},
+		{name: "trimmed", nickname: "  YubiKey  ", want: "YubiKey"},
+		{name: "unicode", nickname: "Téléphone 📱", want: "Téléphone 📱"},
+		{name: "empty", nickname: "   ", wantErr: true},
+		{name: "too long", nickname: strings.Repeat("a", MaxNicknameLength+1), wantErr: true},
+		{name: "control character", nickname: "key\nname", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeNickname(tt.nickname)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeNickname() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeNickname() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorName(t *testing.T) {
+	w := newTestService(t)
+
+	if got := w.authenticatorName("FBFC3007-154E-4ECC-8C0B-6E020557D7BD"); got != "iCloud Keychain" {
+		t.Errorf("Expected known AAGUID to be named, got %q", got)
+	}
+	if got := w.authenticatorName(zeroAAGUID); got != "Security key or passkey" {
+		t.Errorf("Expected zero AAGUID to get the generic name, got %q", got)
+	}
+	if got := w.authenticatorName("01234567-89ab-cdef-0123-456789abcdef"); got != "Unknown authenticator" {
+		t.Errorf("Expected unlisted AAGUID to be unknown, got %q", got)
+	}
+}
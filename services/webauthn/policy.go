@@ -0,0 +1,170 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+)
+
+// AttestationDecision is the outcome of evaluating an attestation against a policy
+type AttestationDecision string
+
+const (
+	AttestationAccept AttestationDecision = "accept"
+	AttestationWarn   AttestationDecision = "warn"
+	AttestationReject AttestationDecision = "reject"
+)
+
+// AttestationPolicy decides which authenticators may be registered.
+// AAGUID rules take precedence over format rules, which take precedence
+// over Default. Reject rules always apply; accept/warn rules for an AAGUID
+// only apply when the attestation chained to a trust anchor, because an
+// unattested AAGUID is just a claim by the client.
+type AttestationPolicy struct {
+	// Attestation conveyance requested in the registration challenge
+	Conveyance string
+	// Reject registrations whose attestation is not trusted
+	RequireTrusted bool
+	Default        AttestationDecision
+	Formats        map[string]AttestationDecision
+	AAGUIDs        map[string]AttestationDecision
+}
+
+// DefaultAttestationPolicy accepts any authenticator without asking for attestation
+func DefaultAttestationPolicy() AttestationPolicy {
+	return AttestationPolicy{
+		Conveyance: AttestationNone,
+		Default:    AttestationAccept,
+	}
+}
+
+// HardwareKeyAttestationPolicy only accepts authenticators whose attestation
+// chains to a configured trust anchor
+func HardwareKeyAttestationPolicy() AttestationPolicy {
+	return AttestationPolicy{
+		Conveyance:     AttestationDirect,
+		RequireTrusted: true,
+		Default:        AttestationAccept,
+		Formats: map[string]AttestationDecision{
+			FormatNone: AttestationReject,
+		},
+	}
+}
+
+// Evaluate applies the policy to a verified attestation and returns the
+// decision with a reason suitable for logs and error messages
+func (p AttestationPolicy) Evaluate(result *attestationResult, aaguid string, trusted bool) (AttestationDecision, string) {
+	aaguidDecision, hasAAGUID := p.AAGUIDs[strings.ToLower(aaguid)]
+	formatDecision, hasFormat := p.Formats[result.Format]
+
+	switch {
+	case hasAAGUID && aaguidDecision == AttestationReject:
+		return AttestationReject, fmt.Sprintf("authenticator %s is not allowed", aaguid)
+	case hasFormat && formatDecision == AttestationReject:
+		return AttestationReject, fmt.Sprintf("attestation format %q is not allowed", result.Format)
+	case p.RequireTrusted && !trusted:
+		return AttestationReject, "authenticator attestation is not trusted"
+	case hasAAGUID && trusted:
+		return aaguidDecision, fmt.Sprintf("authenticator %s", aaguid)
+	case hasFormat:
+		return formatDecision, fmt.Sprintf("attestation format %q", result.Format)
+	}
+
+	if p.Default == "" {
+		return AttestationAccept, "default"
+	}
+	return p.Default, "default"
+}
+
+// Roles that must register attested hardware authenticators
+var hardwareKeyRoles = []string{"admin", "assessor"}
+
+// defaultRolePolicies returns the per-role attestation policies a new service starts with
+func defaultRolePolicies() map[string]AttestationPolicy {
+	policies := make(map[string]AttestationPolicy)
+	for _, role := range hardwareKeyRoles {
+		policies[role] = HardwareKeyAttestationPolicy()
+	}
+	return policies
+}
+
+// SetAttestationPolicy sets the policy applied to users holding role
+func (w *WebAuthnService) SetAttestationPolicy(role string, policy AttestationPolicy) {
+	w.rolePolicies[role] = policy
+}
+
+// SetDefaultAttestationPolicy sets the policy for users without a role-specific policy
+func (w *WebAuthnService) SetDefaultAttestationPolicy(policy AttestationPolicy) {
+	w.defaultPolicy = policy
+}
+
+// SetTrustAnchors replaces the trust anchors used to verify attestation chains
+func (w *WebAuthnService) SetTrustAnchors(store *TrustAnchorStore) {
+	w.trustAnchors = store
+}
+
+// policyForRoles picks the policy for a user. When several roles have a
+// policy the strictest (RequireTrusted) one wins.
+func (w *WebAuthnService) policyForRoles(roles []string) AttestationPolicy {
+	policy := w.defaultPolicy
+	found := false
+	for _, role := range roles {
+		rolePolicy, ok := w.rolePolicies[role]
+		if !ok {
+			continue
+		}
+		if !found || (rolePolicy.RequireTrusted && !policy.RequireTrusted) {
+			policy = rolePolicy
+			found = true
+		}
+	}
+	return policy
+}
+
+// attestationPolicyForUser resolves the policy from the roles stored on the
+// user node, never from anything the client sends
+func (w *WebAuthnService) attestationPolicyForUser(userID string) (AttestationPolicy, error) {
+	roles, err := getUserRoles(userID)
+	if err != nil {
+		return AttestationPolicy{}, err
+	}
+	return w.policyForRoles(roles), nil
+}
+
+// getUserRoles returns the role names assigned to a user
+func getUserRoles(userID string) ([]string, error) {
+	query := fmt.Sprintf(`{
+		user(func: uid(%s)) {
+			roles {
+				name
+			}
+		}
+	}`, userID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		User []struct {
+			Roles []struct {
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"user"`
+	}
+
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	for _, user := range result.User {
+		for _, role := range user.Roles {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles, nil
+}
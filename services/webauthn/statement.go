@@ -0,0 +1,494 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation statement formats (WebAuthn §8)
+const (
+	FormatNone       = "none"
+	FormatPacked     = "packed"
+	FormatFIDOU2F    = "fido-u2f"
+	FormatTPM        = "tpm"
+	FormatAndroidKey = "android-key"
+	FormatApple      = "apple"
+)
+
+// Attestation types reported after statement verification
+const (
+	AttestationTypeNone  = "none"
+	AttestationTypeSelf  = "self"
+	AttestationTypeBasic = "basic"
+	AttestationTypeAttCA = "attca"
+)
+
+// Certificate extension OIDs used by attestation formats
+var (
+	oidFIDOGenCEAAGUID       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+	oidAndroidKeyDescription = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 17}
+	oidAppleNonce            = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+	oidTCGKpAIKCertificate   = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+)
+
+// attestationResult is the outcome of verifying an attestation statement
+type attestationResult struct {
+	Format string
+	Type   string
+	// Attestation certificate chain, leaf first. Empty for none/self.
+	Chain []*x509.Certificate
+	// Trusted is set once the chain has been verified against a trust anchor
+	Trusted bool
+}
+
+// verifyAttestationStatement verifies attStmt for the attestation's format
+// against authData and the clientDataJSON hash. It does not decide whether
+// the chain is trusted - see TrustAnchorStore.verifyChain.
+func verifyAttestationStatement(att *attestation, clientDataHash []byte) (*attestationResult, error) {
+	signedData := make([]byte, 0, len(att.AuthData.Raw)+len(clientDataHash))
+	signedData = append(signedData, att.AuthData.Raw...)
+	signedData = append(signedData, clientDataHash...)
+
+	switch att.Format {
+	case FormatNone:
+		if len(att.AttStmt) != 0 {
+			return nil, errors.New("none attestation must have an empty attStmt")
+		}
+		return &attestationResult{Format: FormatNone, Type: AttestationTypeNone}, nil
+	case FormatPacked:
+		return verifyPackedStatement(att, signedData)
+	case FormatFIDOU2F:
+		return verifyFIDOU2FStatement(att, clientDataHash)
+	case FormatTPM:
+		return verifyTPMStatement(att, signedData)
+	case FormatAndroidKey:
+		return verifyAndroidKeyStatement(att, signedData, clientDataHash)
+	case FormatApple:
+		return verifyAppleStatement(att, signedData)
+	}
+	return nil, fmt.Errorf("unsupported attestation format %q", att.Format)
+}
+
+// verifyPackedStatement implements §8.2 packed attestation
+func verifyPackedStatement(att *attestation, signedData []byte) (*attestationResult, error) {
+	alg, ok := att.AttStmt["alg"].(int64)
+	if !ok {
+		return nil, errors.New("packed: missing alg")
+	}
+	sig, ok := att.AttStmt["sig"].([]byte)
+	if !ok {
+		return nil, errors.New("packed: missing sig")
+	}
+
+	if _, hasX5C := att.AttStmt["x5c"]; !hasX5C {
+		// Self attestation - signed by the credential key itself
+		if alg != att.AuthData.PublicKey.Alg {
+			return nil, errors.New("packed: self attestation alg does not match credential key")
+		}
+		if err := att.AuthData.PublicKey.verifySignature(signedData, sig); err != nil {
+			return nil, fmt.Errorf("packed: %v", err)
+		}
+		return &attestationResult{Format: FormatPacked, Type: AttestationTypeSelf}, nil
+	}
+
+	chain, err := parseX5C(att.AttStmt)
+	if err != nil {
+		return nil, fmt.Errorf("packed: %v", err)
+	}
+	leaf := chain[0]
+	if err := verifyWithAlgorithm(leaf.PublicKey, alg, signedData, sig); err != nil {
+		return nil, fmt.Errorf("packed: %v", err)
+	}
+
+	// §8.2.1 attestation certificate requirements
+	if leaf.Version != 3 {
+		return nil, errors.New("packed: attestation certificate must be version 3")
+	}
+	if leaf.IsCA {
+		return nil, errors.New("packed: attestation certificate must not be a CA")
+	}
+	if !containsString(leaf.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return nil, errors.New("packed: attestation certificate OU must be \"Authenticator Attestation\"")
+	}
+	if ext := findExtension(leaf, oidFIDOGenCEAAGUID); ext != nil {
+		if ext.Critical {
+			return nil, errors.New("packed: AAGUID extension must not be critical")
+		}
+		var certAAGUID []byte
+		if _, err := asn1.Unmarshal(ext.Value, &certAAGUID); err != nil {
+			return nil, fmt.Errorf("packed: invalid AAGUID extension: %v", err)
+		}
+		if !bytes.Equal(certAAGUID, att.AuthData.AAGUID) {
+			return nil, errors.New("packed: certificate AAGUID does not match authenticator data")
+		}
+	}
+
+	return &attestationResult{Format: FormatPacked, Type: AttestationTypeBasic, Chain: chain}, nil
+}
+
+// verifyFIDOU2FStatement implements §8.6 FIDO U2F attestation
+func verifyFIDOU2FStatement(att *attestation, clientDataHash []byte) (*attestationResult, error) {
+	sig, ok := att.AttStmt["sig"].([]byte)
+	if !ok {
+		return nil, errors.New("fido-u2f: missing sig")
+	}
+	chain, err := parseX5C(att.AttStmt)
+	if err != nil {
+		return nil, fmt.Errorf("fido-u2f: %v", err)
+	}
+	if len(chain) != 1 {
+		return nil, errors.New("fido-u2f: x5c must contain exactly one certificate")
+	}
+	certKey, ok := chain[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok || certKey.Curve != elliptic.P256() {
+		return nil, errors.New("fido-u2f: attestation certificate must use P-256")
+	}
+
+	key := att.AuthData.PublicKey
+	if key.Kty != coseKtyEC2 || key.Alg != COSEAlgES256 {
+		return nil, errors.New("fido-u2f: credential key must be ES256")
+	}
+
+	// verificationData = 0x00 || rpIdHash || clientDataHash || credentialId || 0x04 || x || y
+	data := []byte{0x00}
+	data = append(data, att.AuthData.RPIDHash...)
+	data = append(data, clientDataHash...)
+	data = append(data, att.AuthData.CredentialID...)
+	data = append(data, 0x04)
+	data = append(data, key.X...)
+	data = append(data, key.Y...)
+
+	if err := verifyWithAlgorithm(certKey, COSEAlgES256, data, sig); err != nil {
+		return nil, fmt.Errorf("fido-u2f: %v", err)
+	}
+
+	return &attestationResult{Format: FormatFIDOU2F, Type: AttestationTypeBasic, Chain: chain}, nil
+}
+
+// verifyTPMStatement implements §8.3 TPM attestation
+func verifyTPMStatement(att *attestation, signedData []byte) (*attestationResult, error) {
+	if ver, _ := att.AttStmt["ver"].(string); ver != "2.0" {
+		return nil, errors.New("tpm: unsupported version")
+	}
+	alg, ok := att.AttStmt["alg"].(int64)
+	if !ok {
+		return nil, errors.New("tpm: missing alg")
+	}
+	sig, ok := att.AttStmt["sig"].([]byte)
+	if !ok {
+		return nil, errors.New("tpm: missing sig")
+	}
+	rawCertInfo, ok := att.AttStmt["certInfo"].([]byte)
+	if !ok {
+		return nil, errors.New("tpm: missing certInfo")
+	}
+	rawPubArea, ok := att.AttStmt["pubArea"].([]byte)
+	if !ok {
+		return nil, errors.New("tpm: missing pubArea")
+	}
+
+	pubArea, err := parseTPMPublic(rawPubArea)
+	if err != nil {
+		return nil, err
+	}
+	if err := pubArea.matchesCOSEKey(att.AuthData.PublicKey); err != nil {
+		return nil, err
+	}
+
+	certInfo, err := parseTPMAttest(rawCertInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := hashForAlgorithm(alg)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: %v", err)
+	}
+	h := hash.New()
+	h.Write(signedData)
+	if !bytes.Equal(certInfo.ExtraData, h.Sum(nil)) {
+		return nil, errors.New("tpm: certInfo extraData does not match attToBeSigned")
+	}
+
+	name, err := pubArea.name(rawPubArea)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(certInfo.AttestedName, name) {
+		return nil, errors.New("tpm: certInfo attested name does not match pubArea")
+	}
+
+	chain, err := parseX5C(att.AttStmt)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: %v", err)
+	}
+	aik := chain[0]
+	if err := verifyWithAlgorithm(aik.PublicKey, alg, rawCertInfo, sig); err != nil {
+		return nil, fmt.Errorf("tpm: %v", err)
+	}
+
+	// §8.3.1 AIK certificate requirements
+	if aik.Version != 3 {
+		return nil, errors.New("tpm: AIK certificate must be version 3")
+	}
+	if len(aik.Subject.Names) != 0 {
+		return nil, errors.New("tpm: AIK certificate subject must be empty")
+	}
+	if aik.IsCA {
+		return nil, errors.New("tpm: AIK certificate must not be a CA")
+	}
+	if !containsOID(aik.UnknownExtKeyUsage, oidTCGKpAIKCertificate) {
+		return nil, errors.New("tpm: AIK certificate missing tcg-kp-AIKCertificate EKU")
+	}
+	if ext := findExtension(aik, oidFIDOGenCEAAGUID); ext != nil {
+		var certAAGUID []byte
+		if _, err := asn1.Unmarshal(ext.Value, &certAAGUID); err != nil || !bytes.Equal(certAAGUID, att.AuthData.AAGUID) {
+			return nil, errors.New("tpm: certificate AAGUID does not match authenticator data")
+		}
+	}
+
+	return &attestationResult{Format: FormatTPM, Type: AttestationTypeAttCA, Chain: chain}, nil
+}
+
+// androidKeyDescription mirrors the KeyDescription ASN.1 structure from the
+// Android Keystore attestation extension
+type androidKeyDescription struct {
+	AttestationVersion       int
+	AttestationSecurityLevel asn1.Enumerated
+	KeymasterVersion         int
+	KeymasterSecurityLevel   asn1.Enumerated
+	AttestationChallenge     []byte
+	UniqueID                 []byte
+	SoftwareEnforced         asn1.RawValue
+	TeeEnforced              asn1.RawValue
+}
+
+// Android Keystore authorization list tags
+const (
+	androidTagPurpose         = 1
+	androidTagAllApplications = 600
+	androidTagOrigin          = 702
+
+	androidPurposeSign     = 2
+	androidOriginGenerated = 0
+)
+
+// verifyAndroidKeyStatement implements §8.4 Android Key attestation
+func verifyAndroidKeyStatement(att *attestation, signedData, clientDataHash []byte) (*attestationResult, error) {
+	alg, ok := att.AttStmt["alg"].(int64)
+	if !ok {
+		return nil, errors.New("android-key: missing alg")
+	}
+	sig, ok := att.AttStmt["sig"].([]byte)
+	if !ok {
+		return nil, errors.New("android-key: missing sig")
+	}
+	chain, err := parseX5C(att.AttStmt)
+	if err != nil {
+		return nil, fmt.Errorf("android-key: %v", err)
+	}
+	leaf := chain[0]
+
+	if err := verifyWithAlgorithm(leaf.PublicKey, alg, signedData, sig); err != nil {
+		return nil, fmt.Errorf("android-key: %v", err)
+	}
+	if err := matchCertificateKey(leaf, att.AuthData.PublicKey); err != nil {
+		return nil, fmt.Errorf("android-key: %v", err)
+	}
+
+	ext := findExtension(leaf, oidAndroidKeyDescription)
+	if ext == nil {
+		return nil, errors.New("android-key: missing key description extension")
+	}
+	var desc androidKeyDescription
+	if _, err := asn1.Unmarshal(ext.Value, &desc); err != nil {
+		return nil, fmt.Errorf("android-key: invalid key description: %v", err)
+	}
+	if !bytes.Equal(desc.AttestationChallenge, clientDataHash) {
+		return nil, errors.New("android-key: attestationChallenge does not match clientDataHash")
+	}
+
+	software, err := parseAndroidAuthorizationList(desc.SoftwareEnforced)
+	if err != nil {
+		return nil, err
+	}
+	tee, err := parseAndroidAuthorizationList(desc.TeeEnforced)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key must be scoped to this RP, generated inside the keystore and signing-only
+	if _, found := software[androidTagAllApplications]; found {
+		return nil, errors.New("android-key: key is usable by all applications")
+	}
+	if _, found := tee[androidTagAllApplications]; found {
+		return nil, errors.New("android-key: key is usable by all applications")
+	}
+	if !androidAuthorizationHasInt(tee, androidTagOrigin, androidOriginGenerated) &&
+		!androidAuthorizationHasInt(software, androidTagOrigin, androidOriginGenerated) {
+		return nil, errors.New("android-key: key was not generated in the keystore")
+	}
+	if !androidAuthorizationHasInt(tee, androidTagPurpose, androidPurposeSign) &&
+		!androidAuthorizationHasInt(software, androidTagPurpose, androidPurposeSign) {
+		return nil, errors.New("android-key: key purpose does not include signing")
+	}
+
+	return &attestationResult{Format: FormatAndroidKey, Type: AttestationTypeBasic, Chain: chain}, nil
+}
+
+// parseAndroidAuthorizationList splits an AuthorizationList SEQUENCE into its
+// explicitly tagged entries, keyed by tag number
+func parseAndroidAuthorizationList(list asn1.RawValue) (map[int]asn1.RawValue, error) {
+	entries := make(map[int]asn1.RawValue)
+	rest := list.Bytes
+	for len(rest) > 0 {
+		var entry asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("android-key: invalid authorization list: %v", err)
+		}
+		if entry.Class == asn1.ClassContextSpecific {
+			entries[entry.Tag] = entry
+		}
+	}
+	return entries, nil
+}
+
+// androidAuthorizationHasInt reports whether the tagged entry is (or, for a
+// SET OF INTEGER, contains) want
+func androidAuthorizationHasInt(entries map[int]asn1.RawValue, tag, want int) bool {
+	entry, ok := entries[tag]
+	if !ok {
+		return false
+	}
+	var inner asn1.RawValue
+	if _, err := asn1.Unmarshal(entry.Bytes, &inner); err != nil {
+		return false
+	}
+	if inner.Tag == asn1.TagSet {
+		rest := inner.Bytes
+		for len(rest) > 0 {
+			var v int
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+				return false
+			}
+			if v == want {
+				return true
+			}
+		}
+		return false
+	}
+	var v int
+	if _, err := asn1.Unmarshal(entry.Bytes, &v); err != nil {
+		return false
+	}
+	return v == want
+}
+
+// verifyAppleStatement implements §8.8 Apple anonymous attestation
+func verifyAppleStatement(att *attestation, signedData []byte) (*attestationResult, error) {
+	chain, err := parseX5C(att.AttStmt)
+	if err != nil {
+		return nil, fmt.Errorf("apple: %v", err)
+	}
+	leaf := chain[0]
+
+	ext := findExtension(leaf, oidAppleNonce)
+	if ext == nil {
+		return nil, errors.New("apple: missing nonce extension")
+	}
+	var nonceSeq struct {
+		Nonce []byte `asn1:"explicit,tag:1"`
+	}
+	if _, err := asn1.Unmarshal(ext.Value, &nonceSeq); err != nil {
+		return nil, fmt.Errorf("apple: invalid nonce extension: %v", err)
+	}
+	nonce := sha256.Sum256(signedData)
+	if !bytes.Equal(nonceSeq.Nonce, nonce[:]) {
+		return nil, errors.New("apple: nonce does not match authenticator data")
+	}
+	if err := matchCertificateKey(leaf, att.AuthData.PublicKey); err != nil {
+		return nil, fmt.Errorf("apple: %v", err)
+	}
+
+	return &attestationResult{Format: FormatApple, Type: AttestationTypeAttCA, Chain: chain}, nil
+}
+
+// parseX5C decodes the x5c certificate array from an attestation statement
+func parseX5C(attStmt map[interface{}]interface{}) ([]*x509.Certificate, error) {
+	raw, ok := attStmt["x5c"].([]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, errors.New("missing x5c")
+	}
+	chain := make([]*x509.Certificate, 0, len(raw))
+	for i, item := range raw {
+		der, ok := item.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("x5c[%d] is not a byte string", i)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("x5c[%d]: %v", i, err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// matchCertificateKey checks that a certificate certifies the credential key
+func matchCertificateKey(cert *x509.Certificate, key *coseKey) error {
+	credentialKey, err := key.publicKey()
+	if err != nil {
+		return err
+	}
+	certKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !certKey.Equal(credentialKey) {
+		return errors.New("certificate public key does not match credential public key")
+	}
+	return nil
+}
+
+func findExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) *pkix.Extension {
+	for i := range cert.Extensions {
+		if cert.Extensions[i].Id.Equal(oid) {
+			return &cert.Extensions[i]
+		}
+	}
+	return nil
+}
+
+func hashForAlgorithm(alg int64) (crypto.Hash, error) {
+	switch alg {
+	case COSEAlgES256, COSEAlgRS256:
+		return crypto.SHA256, nil
+	}
+	return 0, fmt.Errorf("unsupported algorithm %d", alg)
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOID(values []asn1.ObjectIdentifier, want asn1.ObjectIdentifier) bool {
+	for _, v := range values {
+		if v.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"   // registers SHA-1 for TPM nameAlg
+	_ "crypto/sha512" // registers SHA-384/512 for TPM nameAlg
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// TPM 2.0 constants used by the tpm attestation format
+const (
+	tpmGeneratedValue     = 0xff544347
+	tpmSTAttestCertify    = 0x8017
+	tpmAlgRSA             = 0x0001
+	tpmAlgSHA1            = 0x0004
+	tpmAlgSHA256          = 0x000b
+	tpmAlgSHA384          = 0x000c
+	tpmAlgSHA512          = 0x000d
+	tpmAlgECC             = 0x0023
+	tpmECCNistP256        = 0x0003
+	tpmDefaultRSAExponent = 65537
+)
+
+// tpmPublic is the subset of TPMT_PUBLIC needed to compare the attested key
+// with the credential public key
+type tpmPublic struct {
+	Type     uint16
+	NameAlg  uint16
+	Exponent uint32
+	CurveID  uint16
+	Modulus  []byte
+	X        []byte
+	Y        []byte
+}
+
+// tpmAttest is the subset of TPMS_ATTEST needed for certify verification
+type tpmAttest struct {
+	ExtraData    []byte
+	AttestedName []byte
+}
+
+type tpmReader struct {
+	buf *bytes.Reader
+	err error
+}
+
+func (r *tpmReader) u16() uint16 {
+	var v uint16
+	if r.err == nil {
+		r.err = binary.Read(r.buf, binary.BigEndian, &v)
+	}
+	return v
+}
+
+func (r *tpmReader) u32() uint32 {
+	var v uint32
+	if r.err == nil {
+		r.err = binary.Read(r.buf, binary.BigEndian, &v)
+	}
+	return v
+}
+
+func (r *tpmReader) skip(n int) {
+	if r.err == nil && r.buf.Len() < n {
+		r.err = errTPMTruncated
+		return
+	}
+	if r.err == nil {
+		_, r.err = r.buf.Seek(int64(n), 1)
+	}
+}
+
+// sized reads a TPM2B structure (uint16 size followed by bytes)
+func (r *tpmReader) sized() []byte {
+	n := int(r.u16())
+	if r.err != nil {
+		return nil
+	}
+	if r.buf.Len() < n {
+		r.err = errTPMTruncated
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = r.buf.Read(b)
+	return b
+}
+
+var errTPMTruncated = errors.New("tpm: structure truncated")
+
+// parseTPMPublic decodes a TPMT_PUBLIC structure (pubArea)
+func parseTPMPublic(raw []byte) (*tpmPublic, error) {
+	r := &tpmReader{buf: bytes.NewReader(raw)}
+	pub := &tpmPublic{
+		Type:    r.u16(),
+		NameAlg: r.u16(),
+	}
+	r.u32()   // objectAttributes
+	r.sized() // authPolicy
+
+	switch pub.Type {
+	case tpmAlgRSA:
+		r.u16() // symmetric
+		r.u16() // scheme
+		r.u16() // keyBits
+		pub.Exponent = r.u32()
+		pub.Modulus = r.sized()
+	case tpmAlgECC:
+		r.u16() // symmetric
+		r.u16() // scheme
+		pub.CurveID = r.u16()
+		r.u16() // kdf
+		pub.X = r.sized()
+		pub.Y = r.sized()
+	default:
+		return nil, fmt.Errorf("tpm: unsupported pubArea type %#x", pub.Type)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("tpm: invalid pubArea: %v", r.err)
+	}
+	if r.buf.Len() != 0 {
+		return nil, errors.New("tpm: trailing data after pubArea")
+	}
+	return pub, nil
+}
+
+// matchesCOSEKey checks that pubArea describes the credential public key
+func (p *tpmPublic) matchesCOSEKey(key *coseKey) error {
+	switch p.Type {
+	case tpmAlgRSA:
+		if key.Kty != coseKtyRSA {
+			return errors.New("tpm: pubArea is RSA but credential key is not")
+		}
+		exponent := p.Exponent
+		if exponent == 0 {
+			exponent = tpmDefaultRSAExponent
+		}
+		if new(big.Int).SetBytes(key.E).Cmp(big.NewInt(int64(exponent))) != 0 ||
+			!bytes.Equal(trimLeadingZeros(p.Modulus), trimLeadingZeros(key.N)) {
+			return errors.New("tpm: pubArea RSA key does not match credential key")
+		}
+	case tpmAlgECC:
+		if key.Kty != coseKtyEC2 || p.CurveID != tpmECCNistP256 {
+			return errors.New("tpm: pubArea ECC curve does not match credential key")
+		}
+		if !bytes.Equal(trimLeadingZeros(p.X), trimLeadingZeros(key.X)) ||
+			!bytes.Equal(trimLeadingZeros(p.Y), trimLeadingZeros(key.Y)) {
+			return errors.New("tpm: pubArea ECC key does not match credential key")
+		}
+	}
+	return nil
+}
+
+// name computes the TPM object name: nameAlg || H_nameAlg(pubArea)
+func (p *tpmPublic) name(rawPubArea []byte) ([]byte, error) {
+	var hash crypto.Hash
+	switch p.NameAlg {
+	case tpmAlgSHA1:
+		hash = crypto.SHA1
+	case tpmAlgSHA256:
+		hash = crypto.SHA256
+	case tpmAlgSHA384:
+		hash = crypto.SHA384
+	case tpmAlgSHA512:
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("tpm: unsupported nameAlg %#x", p.NameAlg)
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("tpm: hash for nameAlg %#x not linked", p.NameAlg)
+	}
+	h := hash.New()
+	h.Write(rawPubArea)
+
+	name := make([]byte, 2, 2+hash.Size())
+	binary.BigEndian.PutUint16(name, p.NameAlg)
+	return append(name, h.Sum(nil)...), nil
+}
+
+// parseTPMAttest decodes a TPMS_ATTEST structure (certInfo) of type certify
+func parseTPMAttest(raw []byte) (*tpmAttest, error) {
+	r := &tpmReader{buf: bytes.NewReader(raw)}
+	magic := r.u32()
+	attestType := r.u16()
+	r.sized() // qualifiedSigner
+	extraData := r.sized()
+	r.skip(17) // clockInfo
+	r.skip(8)  // firmwareVersion
+	attestedName := r.sized()
+	r.sized() // attested qualifiedName
+
+	if r.err != nil {
+		return nil, fmt.Errorf("tpm: invalid certInfo: %v", r.err)
+	}
+	if magic != tpmGeneratedValue {
+		return nil, errors.New("tpm: certInfo magic is not TPM_GENERATED_VALUE")
+	}
+	if attestType != tpmSTAttestCertify {
+		return nil, errors.New("tpm: certInfo type is not TPM_ST_ATTEST_CERTIFY")
+	}
+
+	return &tpmAttest{ExtraData: extraData, AttestedName: attestedName}, nil
+}
+
+func trimLeadingZeros(b []byte) []byte {
+	return bytes.TrimLeft(b, "\x00")
+}
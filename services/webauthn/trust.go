@@ -0,0 +1,398 @@
+package webauthn
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"modus/config"
+)
+
+// FIDO MDS status reports that mean an authenticator must no longer be trusted
+var revokedAuthenticatorStatuses = map[string]bool{
+	"REVOKED":                      true,
+	"USER_VERIFICATION_BYPASS":     true,
+	"ATTESTATION_KEY_COMPROMISE":   true,
+	"USER_KEY_REMOTE_COMPROMISE":   true,
+	"USER_KEY_PHYSICAL_COMPROMISE": true,
+}
+
+// AuthenticatorMetadata describes an authenticator model known to the trust store
+type AuthenticatorMetadata struct {
+	AAGUID      string `json:"aaguid"`
+	Description string `json:"description"`
+	Status      string `json:"status,omitempty"` // latest MDS status report
+}
+
+// Revoked reports whether the latest MDS status marks the model as compromised
+func (m AuthenticatorMetadata) Revoked() bool {
+	return revokedAuthenticatorStatuses[m.Status]
+}
+
+// defaultTrustAnchors is used by services created with NewWebAuthnService.
+// It is filled from the configured MDS blob on first use.
+var (
+	defaultTrustAnchors = NewTrustAnchorStore()
+	trustAnchorsLoaded  bool
+	trustAnchorsMutex   sync.Mutex
+)
+
+// SetDefaultTrustAnchors replaces the trust anchors new services verify
+// against, instead of those from the configured MDS blob
+func SetDefaultTrustAnchors(store *TrustAnchorStore) {
+	trustAnchorsMutex.Lock()
+	defer trustAnchorsMutex.Unlock()
+	defaultTrustAnchors = store
+	trustAnchorsLoaded = true
+}
+
+// DefaultTrustAnchors returns the shared trust anchor store
+func DefaultTrustAnchors() *TrustAnchorStore {
+	trustAnchorsMutex.Lock()
+	defer trustAnchorsMutex.Unlock()
+	return defaultTrustAnchors
+}
+
+// loadDefaultTrustAnchors fills the shared store from the MDS blob in the
+// configuration, once. A blob that fails to load is not remembered, so the
+// error is reported on every call.
+func loadDefaultTrustAnchors(cfg config.WebAuthnConfig) (*TrustAnchorStore, error) {
+	trustAnchorsMutex.Lock()
+	defer trustAnchorsMutex.Unlock()
+
+	if trustAnchorsLoaded {
+		return defaultTrustAnchors, nil
+	}
+	if cfg.MetadataBLOB == "" {
+		log.Printf("⚠️ WebAuthn: No MDS blob configured; registrations that need attested hardware keys will fail")
+		trustAnchorsLoaded = true
+		return defaultTrustAnchors, nil
+	}
+
+	roots, err := parsePEMCertificates([]byte(cfg.MetadataRootPEM))
+	if err != nil || len(roots) == 0 {
+		return nil, fmt.Errorf("invalid MDS root certificate: %v", err)
+	}
+	store := NewTrustAnchorStore()
+	if err := store.LoadMetadataBLOB([]byte(cfg.MetadataBLOB), roots[0]); err != nil {
+		return nil, fmt.Errorf("failed to load trust anchors: %v", err)
+	}
+
+	defaultTrustAnchors = store
+	trustAnchorsLoaded = true
+	return defaultTrustAnchors, nil
+}
+
+// TrustAnchorStore holds the root certificates attestation chains are checked
+// against. Roots are looked up by AAGUID first, then by attestation
+// certificate key identifier (FIDO U2F authenticators have no AAGUID), then
+// by attestation format (e.g. the Apple WebAuthn root for "apple").
+type TrustAnchorStore struct {
+	byAAGUID map[string]*x509.CertPool
+	byKeyID  map[string]*x509.CertPool
+	byFormat map[string]*x509.CertPool
+	metadata map[string]AuthenticatorMetadata
+	mutex    sync.RWMutex
+}
+
+// NewTrustAnchorStore creates an empty trust anchor store
+func NewTrustAnchorStore() *TrustAnchorStore {
+	return &TrustAnchorStore{
+		byAAGUID: make(map[string]*x509.CertPool),
+		byKeyID:  make(map[string]*x509.CertPool),
+		byFormat: make(map[string]*x509.CertPool),
+		metadata: make(map[string]AuthenticatorMetadata),
+	}
+}
+
+// AddRootForAAGUID trusts root for attestations from the given authenticator model
+func (s *TrustAnchorStore) AddRootForAAGUID(aaguid string, root *x509.Certificate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	addToPool(s.byAAGUID, strings.ToLower(aaguid), root)
+}
+
+// AddRootForFormat trusts root for every attestation of the given format
+func (s *TrustAnchorStore) AddRootForFormat(format string, root *x509.Certificate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	addToPool(s.byFormat, format, root)
+}
+
+// AddRootsPEMForFormat parses PEM certificates and trusts them for a format
+func (s *TrustAnchorStore) AddRootsPEMForFormat(format string, pemData []byte) error {
+	roots, err := parsePEMCertificates(pemData)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		s.AddRootForFormat(format, root)
+	}
+	return nil
+}
+
+// Empty reports whether the store holds no roots at all
+func (s *TrustAnchorStore) Empty() bool {
+	if s == nil {
+		return true
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.byAAGUID) == 0 && len(s.byKeyID) == 0 && len(s.byFormat) == 0
+}
+
+// Metadata returns what the store knows about an authenticator model
+func (s *TrustAnchorStore) Metadata(aaguid string) (AuthenticatorMetadata, bool) {
+	if s == nil {
+		return AuthenticatorMetadata{}, false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	m, ok := s.metadata[strings.ToLower(aaguid)]
+	return m, ok
+}
+
+// LoadMetadataBLOBFile loads a FIDO MDS3 blob from a local file.
+// See LoadMetadataBLOB for how mdsRoot is used.
+func (s *TrustAnchorStore) LoadMetadataBLOBFile(path string, mdsRoot *x509.Certificate) error {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read MDS blob: %v", err)
+	}
+	return s.LoadMetadataBLOB(blob, mdsRoot)
+}
+
+// LoadMetadataBLOB imports attestation roots and status reports from a FIDO
+// MDS3 blob (a JWS). When mdsRoot is set the blob signature and its x5c chain
+// are verified against it; when nil the blob is trusted as provisioned, which
+// is only appropriate for files deployed by operators.
+func (s *TrustAnchorStore) LoadMetadataBLOB(blob []byte, mdsRoot *x509.Certificate) error {
+	var payload mdsPayload
+	claims := jwt.MapClaims{}
+
+	if mdsRoot != nil {
+		_, err := jwt.ParseWithClaims(strings.TrimSpace(string(blob)), claims, func(token *jwt.Token) (interface{}, error) {
+			return mdsSigningKey(token, mdsRoot)
+		}, jwt.WithValidMethods([]string{"ES256", "RS256"}))
+		if err != nil {
+			return fmt.Errorf("invalid MDS blob signature: %v", err)
+		}
+	} else {
+		if _, _, err := jwt.NewParser().ParseUnverified(strings.TrimSpace(string(blob)), claims); err != nil {
+			return fmt.Errorf("invalid MDS blob: %v", err)
+		}
+	}
+
+	// Round-trip the claims into the typed payload
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("invalid MDS payload: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, entry := range payload.Entries {
+		roots := make([]*x509.Certificate, 0, len(entry.MetadataStatement.AttestationRootCertificates))
+		for _, b64 := range entry.MetadataStatement.AttestationRootCertificates {
+			der, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				return fmt.Errorf("invalid root certificate encoding for %s: %v", entry.AAGUID, err)
+			}
+			root, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("invalid root certificate for %s: %v", entry.AAGUID, err)
+			}
+			roots = append(roots, root)
+		}
+
+		status := ""
+		if n := len(entry.StatusReports); n > 0 {
+			status = entry.StatusReports[n-1].Status
+		}
+
+		if entry.AAGUID != "" {
+			aaguid := strings.ToLower(entry.AAGUID)
+			s.metadata[aaguid] = AuthenticatorMetadata{
+				AAGUID:      aaguid,
+				Description: entry.MetadataStatement.Description,
+				Status:      status,
+			}
+			for _, root := range roots {
+				addToPool(s.byAAGUID, aaguid, root)
+			}
+		}
+		for _, keyID := range entry.AttestationCertificateKeyIdentifiers {
+			keyID = strings.ToLower(keyID)
+			s.metadata["keyid:"+keyID] = AuthenticatorMetadata{
+				Description: entry.MetadataStatement.Description,
+				Status:      status,
+			}
+			for _, root := range roots {
+				addToPool(s.byKeyID, keyID, root)
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifyChain checks an attestation certificate chain against the configured
+// roots. It returns trusted=false with no error when there is no chain or no
+// anchor is configured, and an error when a chain is presented but invalid
+// or the authenticator model has been revoked.
+func (s *TrustAnchorStore) verifyChain(result *attestationResult, aaguid string) (bool, error) {
+	if s == nil || len(result.Chain) == 0 {
+		return false, nil
+	}
+
+	leaf := result.Chain[0]
+	keyID := hex.EncodeToString(leaf.SubjectKeyId)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if m, ok := s.metadata[strings.ToLower(aaguid)]; ok && m.Revoked() {
+		return false, fmt.Errorf("authenticator %s has status %s", aaguid, m.Status)
+	}
+	if m, ok := s.metadata["keyid:"+keyID]; ok && m.Revoked() {
+		return false, fmt.Errorf("attestation key %s has status %s", keyID, m.Status)
+	}
+
+	var roots *x509.CertPool
+	if aaguid != "" && aaguid != zeroAAGUID {
+		roots = s.byAAGUID[strings.ToLower(aaguid)]
+	}
+	if roots == nil && keyID != "" {
+		roots = s.byKeyID[keyID]
+	}
+	if roots == nil {
+		roots = s.byFormat[result.Format]
+	}
+	if roots == nil {
+		return false, nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range result.Chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return false, fmt.Errorf("attestation chain is not trusted: %v", err)
+	}
+	return true, nil
+}
+
+// zeroAAGUID is reported by U2F authenticators and by platforms that
+// anonymise the authenticator model
+const zeroAAGUID = "00000000-0000-0000-0000-000000000000"
+
+// mdsPayload is the subset of the MDS3 BLOB payload used for trust decisions
+type mdsPayload struct {
+	No      int `json:"no"`
+	Entries []struct {
+		AAGUID                               string   `json:"aaguid"`
+		AttestationCertificateKeyIdentifiers []string `json:"attestationCertificateKeyIdentifiers"`
+		MetadataStatement                    struct {
+			Description                 string   `json:"description"`
+			AttestationRootCertificates []string `json:"attestationRootCertificates"`
+		} `json:"metadataStatement"`
+		StatusReports []struct {
+			Status        string `json:"status"`
+			EffectiveDate string `json:"effectiveDate"`
+		} `json:"statusReports"`
+	} `json:"entries"`
+}
+
+// mdsSigningKey validates the blob's x5c header against mdsRoot and returns
+// the signing certificate's key
+func mdsSigningKey(token *jwt.Token, mdsRoot *x509.Certificate) (interface{}, error) {
+	x5c, ok := token.Header["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return nil, errors.New("MDS blob header has no x5c")
+	}
+
+	var chain []*x509.Certificate
+	for _, item := range x5c {
+		b64, ok := item.(string)
+		if !ok {
+			return nil, errors.New("MDS blob x5c entry is not a string")
+		}
+		der, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(mdsRoot)
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("MDS signing certificate is not trusted: %v", err)
+	}
+
+	return chain[0].PublicKey, nil
+}
+
+func addToPool(pools map[string]*x509.CertPool, key string, cert *x509.Certificate) {
+	pool, ok := pools[key]
+	if !ok {
+		pool = x509.NewCertPool()
+		pools[key] = pool
+	}
+	pool.AddCert(cert)
+}
+
+func parsePEMCertificates(pemData []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PEM data")
+	}
+	return certs, nil
+}
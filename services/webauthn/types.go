@@ -39,6 +39,7 @@ type PubKeyCredParam struct {
 type AuthenticatorSelection struct {
 	AuthenticatorAttachment string `json:"authenticatorAttachment,omitempty"`
 	RequireResidentKey      bool   `json:"requireResidentKey"`
+	ResidentKey             string `json:"residentKey,omitempty"`
 	UserVerification        string `json:"userVerification"`
 }
 
@@ -50,11 +51,12 @@ type PublicKeyCredDescriptor struct {
 
 // WebAuthn Registration Types
 type RegistrationRequest struct {
-	UserID                string                     `json:"userId"`
-	Challenge             string                     `json:"challenge"`
-	ClientDataJSON        string                     `json:"clientDataJSON"`
-	AttestationObject     string                     `json:"attestationObject"`
+	UserID                string                           `json:"userId"`
+	Challenge             string                           `json:"challenge"`
+	ClientDataJSON        string                           `json:"clientDataJSON"`
+	AttestationObject     string                           `json:"attestationObject"`
 	AuthenticatorResponse AuthenticatorAttestationResponse `json:"response"`
+	Transports            []string                         `json:"transports,omitempty"` // from getTransports()
 }
 
 type AuthenticatorAttestationResponse struct {
@@ -71,12 +73,13 @@ type RegistrationResponse struct {
 
 // WebAuthn Authentication Types
 type AuthenticationRequest struct {
-	UserID                string                        `json:"userId"`
-	Challenge             string                        `json:"challenge"`
-	ClientDataJSON        string                        `json:"clientDataJSON"`
-	AuthenticatorData     string                        `json:"authenticatorData"`
-	Signature             string                        `json:"signature"`
-	UserHandle            string                        `json:"userHandle,omitempty"`
+	UserID                string                         `json:"userId"`
+	CredentialID          string                         `json:"credentialId"`
+	Challenge             string                         `json:"challenge"`
+	ClientDataJSON        string                         `json:"clientDataJSON"`
+	AuthenticatorData     string                         `json:"authenticatorData"`
+	Signature             string                         `json:"signature"`
+	UserHandle            string                         `json:"userHandle,omitempty"`
 	AuthenticatorResponse AuthenticatorAssertionResponse `json:"response"`
 }
 
@@ -88,15 +91,19 @@ type AuthenticatorAssertionResponse struct {
 }
 
 type AuthenticationResponse struct {
-	Success   bool   `json:"success"`
-	UserID    string `json:"userId"`
-	Message   string `json:"message"`
-	SessionID string `json:"sessionId,omitempty"`
+	Success      bool   `json:"success"`
+	UserID       string `json:"userId"`
+	CredentialID string `json:"credentialId,omitempty"` // credential used, for session binding
+	Message      string `json:"message"`
+	// Assurance inputs for the session: UV flag set, and key proven to be
+	// non-syncable hardware by a trusted attestation
+	UserVerified  bool `json:"userVerified"`
+	HardwareBound bool `json:"hardwareBound"`
 }
 
 // WebAuthn Assertion Challenge Types
 type AssertionChallengeRequest struct {
-	UserID string `json:"userId,omitempty"`
+	UserID string `json:"userId,omitempty"` // empty for usernameless passkey sign-in
 }
 
 type AssertionChallengeResponse struct {
@@ -116,13 +123,51 @@ type StoredChallenge struct {
 }
 
 type WebAuthnCredential struct {
-	UID          string    `json:"uid,omitempty"`
-	UserID       string    `json:"userId"`
-	CredentialID string    `json:"credentialId"`
-	PublicKey    string    `json:"publicKey"`
-	SignCount    int       `json:"signCount"`
-	Transports   []string  `json:"transports"`
-	AddedAt      time.Time `json:"addedAt"`
+	UID                string    `json:"uid,omitempty"`
+	UserID             string    `json:"userId"`
+	CredentialID       string    `json:"credentialId"`
+	PublicKey          string    `json:"publicKey"` // base64url COSE_Key
+	AAGUID             string    `json:"aaguid,omitempty"`
+	Nickname           string    `json:"nickname,omitempty"`
+	AttestationFormat  string    `json:"attestationFormat,omitempty"` // verified at registration
+	AttestationType    string    `json:"attestationType,omitempty"`
+	AttestationTrusted bool      `json:"attestationTrusted,omitempty"` // chain verified against a trust anchor
+	SignCount          int       `json:"signCount"`
+	CloneWarning       bool      `json:"cloneWarning,omitempty"` // signCount went backwards
+	Transports         []string  `json:"transports"`
+	AddedAt            time.Time `json:"addedAt"`
+}
+
+// Credential Management Types
+type CredentialInfo struct {
+	CredentialID      string     `json:"credentialId"`
+	Nickname          string     `json:"nickname,omitempty"`
+	AuthenticatorName string     `json:"authenticatorName"` // derived from the AAGUID
+	AAGUID            string     `json:"aaguid,omitempty"`
+	AttestationFormat string     `json:"attestationFormat,omitempty"`
+	Transports        []string   `json:"transports,omitempty"`
+	CloneWarning      bool       `json:"cloneWarning"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	LastUsedAt        *time.Time `json:"lastUsedAt,omitempty"` // nil if never used to sign in
+}
+
+type RenameCredentialRequest struct {
+	UserID       string `json:"userId"`
+	CredentialID string `json:"credentialId"`
+	Nickname     string `json:"nickname"`
+}
+
+type RevokeCredentialRequest struct {
+	UserID              string `json:"userId"`
+	CredentialID        string `json:"credentialId"`
+	Reason              string `json:"reason,omitempty"`
+	OtherFactorVerified bool   `json:"-"` // set by the caller after verifying OTP etc.
+}
+
+type CredentialResponse struct {
+	Success      bool   `json:"success"`
+	CredentialID string `json:"credentialId,omitempty"`
+	Message      string `json:"message"`
 }
 
 // Error Types
@@ -139,32 +184,33 @@ func (e WebAuthnError) Error() string {
 const (
 	// Challenge expiry time (5 minutes)
 	ChallengeExpiryMinutes = 5
-	
-	// Relying Party Information
-	DefaultRPID   = "do-study.hypermode.host"
-	DefaultRPName = "DO Study LMS"
-	
+
 	// Timeout (60 seconds)
 	DefaultTimeout = 60000
-	
+
 	// User Verification
 	UserVerificationRequired    = "required"
 	UserVerificationPreferred   = "preferred"
 	UserVerificationDiscouraged = "discouraged"
-	
+
+	// Resident Key (discoverable credential) requirement
+	ResidentKeyRequired    = "required"
+	ResidentKeyPreferred   = "preferred"
+	ResidentKeyDiscouraged = "discouraged"
+
 	// Attestation
 	AttestationNone   = "none"
 	AttestationDirect = "direct"
-	
+
 	// Authenticator Attachment
-	AttachmentPlatform     = "platform"
+	AttachmentPlatform      = "platform"
 	AttachmentCrossPlatform = "cross-platform"
-	
+
 	// Error Codes
-	ErrorInvalidChallenge    = "INVALID_CHALLENGE"
-	ErrorExpiredChallenge    = "EXPIRED_CHALLENGE"
-	ErrorInvalidCredential   = "INVALID_CREDENTIAL"
-	ErrorUserNotFound        = "USER_NOT_FOUND"
-	ErrorRegistrationFailed  = "REGISTRATION_FAILED"
+	ErrorInvalidChallenge     = "INVALID_CHALLENGE"
+	ErrorExpiredChallenge     = "EXPIRED_CHALLENGE"
+	ErrorInvalidCredential    = "INVALID_CREDENTIAL"
+	ErrorUserNotFound         = "USER_NOT_FOUND"
+	ErrorRegistrationFailed   = "REGISTRATION_FAILED"
 	ErrorAuthenticationFailed = "AUTHENTICATION_FAILED"
 )
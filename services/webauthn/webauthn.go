@@ -5,27 +5,53 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/hypermodeinc/modus/sdk/go/pkg/dgraph"
+
+	themislog "modus/agents/audit/ThemisLog"
+	"modus/config"
 )
 
 // WebAuthnService handles WebAuthn operations
 type WebAuthnService struct {
-	rpID   string
-	rpName string
+	rpID             string
+	rpName           string
+	allowedOrigins   []string
+	userVerification string
+
+	trustAnchors  *TrustAnchorStore
+	defaultPolicy AttestationPolicy
+	rolePolicies  map[string]AttestationPolicy
 }
 
-// NewWebAuthnService creates a new WebAuthn service instance
-func NewWebAuthnService() *WebAuthnService {
-	return &WebAuthnService{
-		rpID:   DefaultRPID,
-		rpName: DefaultRPName,
+// NewWebAuthnService creates a new WebAuthn service instance for the
+// relying party in the shared configuration
+func NewWebAuthnService() (*WebAuthnService, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
 	}
+
+	trustAnchors, err := loadDefaultTrustAnchors(cfg.WebAuthn)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WebAuthnService{
+		rpID:             cfg.WebAuthn.RPID,
+		rpName:           cfg.WebAuthn.RPName,
+		userVerification: UserVerificationPreferred,
+		trustAnchors:     trustAnchors,
+		defaultPolicy:    DefaultAttestationPolicy(),
+		rolePolicies:     defaultRolePolicies(),
+	}
+	w.SetAllowedOrigins(cfg.WebAuthn.Origins)
+	return w, nil
 }
 
 // CreateRegistrationChallenge generates a WebAuthn registration challenge
@@ -39,10 +65,20 @@ func (w *WebAuthnService) CreateRegistrationChallenge(ctx context.Context, req C
 	}
 
 	// Store challenge in database with expiry
-	if err := w.storeChallenge(challenge, req.UserID, "registration"); err != nil {
+	if err := w.storeChallenge(challenge, req.UserID, ChallengeTypeRegistration); err != nil {
 		return ChallengeResponse{}, fmt.Errorf("failed to store challenge: %v", err)
 	}
 
+	// Admins and assessors are asked for direct attestation
+	policy, err := w.attestationPolicyForUser(req.UserID)
+	if err != nil {
+		log.Printf("⚠️ Warning: Could not resolve attestation policy: %v", err)
+		policy = w.defaultPolicy
+	}
+	if err := w.checkTrustAnchorsFor(policy); err != nil {
+		return ChallengeResponse{}, err
+	}
+
 	// Get existing credentials to exclude
 	excludeCredentials, err := w.getUserCredentials(req.UserID)
 	if err != nil {
@@ -63,15 +99,18 @@ func (w *WebAuthnService) CreateRegistrationChallenge(ctx context.Context, req C
 			DisplayName: req.DisplayName,
 		},
 		PubKeyCredParams: []PubKeyCredParam{
-			{Type: "public-key", Alg: -7},  // ES256
-			{Type: "public-key", Alg: -257}, // RS256
+			{Type: "public-key", Alg: COSEAlgES256},
+			{Type: "public-key", Alg: COSEAlgEdDSA},
+			{Type: "public-key", Alg: COSEAlgRS256},
 		},
+		// Discoverable credentials let learners sign in without a username
 		AuthenticatorSelection: AuthenticatorSelection{
-			RequireResidentKey: false,
-			UserVerification:   UserVerificationPreferred,
+			RequireResidentKey: true,
+			ResidentKey:        ResidentKeyRequired,
+			UserVerification:   w.userVerification,
 		},
 		Timeout:            DefaultTimeout,
-		Attestation:        AttestationNone,
+		Attestation:        policy.Conveyance,
 		ExcludeCredentials: excludeCredentials,
 	}
 
@@ -83,8 +122,8 @@ func (w *WebAuthnService) CreateRegistrationChallenge(ctx context.Context, req C
 func (w *WebAuthnService) VerifyRegistration(ctx context.Context, req RegistrationRequest) (RegistrationResponse, error) {
 	log.Printf("🔐 WebAuthn: Verifying registration for user %s", req.UserID)
 
-	// Verify challenge
-	if err := w.verifyChallenge(req.Challenge, req.UserID, "registration"); err != nil {
+	// Consume challenge; it can't be replayed even if verification fails below
+	if err := w.consumeChallenge(req.Challenge, req.UserID, ChallengeTypeRegistration); err != nil {
 		return RegistrationResponse{
 			Success: false,
 			Message: fmt.Sprintf("Challenge verification failed: %v", err),
@@ -100,16 +139,16 @@ func (w *WebAuthnService) VerifyRegistration(ctx context.Context, req Registrati
 		}, nil
 	}
 
-	// Verify challenge matches
-	if clientData.Challenge != req.Challenge {
+	// Verify type, challenge and origin
+	if err := w.verifyClientData(clientData, ClientDataTypeCreate, req.Challenge); err != nil {
 		return RegistrationResponse{
 			Success: false,
-			Message: "Challenge mismatch",
+			Message: fmt.Sprintf("Client data verification failed: %v", err),
 		}, nil
 	}
 
-	// Parse attestation object (simplified - in production, full attestation verification needed)
-	credentialID, publicKey, err := parseAttestationObject(req.AttestationObject)
+	// Decode the CBOR attestation object and extract the attested credential
+	att, err := parseAttestationObject(req.AttestationObject)
 	if err != nil {
 		return RegistrationResponse{
 			Success: false,
@@ -117,14 +156,38 @@ func (w *WebAuthnService) VerifyRegistration(ctx context.Context, req Registrati
 		}, nil
 	}
 
+	if err := w.verifyAuthenticatorFlags(att.AuthData, w.userVerification); err != nil {
+		return RegistrationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Authenticator data verification failed: %v", err),
+		}, nil
+	}
+
+	// Verify the attestation statement and apply the user's attestation policy
+	clientDataHash := sha256.Sum256(clientData.raw)
+	attResult, err := w.checkAttestation(req.UserID, att, clientDataHash[:])
+	if err != nil {
+		log.Printf("❌ WebAuthn: Attestation rejected for user %s: %v", req.UserID, err)
+		return RegistrationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Attestation rejected: %v", err),
+		}, nil
+	}
+
+	credentialID := encodeBase64URL(att.AuthData.CredentialID)
+
 	// Store credential in database
 	credential := WebAuthnCredential{
-		UserID:       req.UserID,
-		CredentialID: credentialID,
-		PublicKey:    publicKey,
-		SignCount:    0,
-		Transports:   []string{"internal", "usb", "nfc", "ble"},
-		AddedAt:      time.Now(),
+		UserID:             req.UserID,
+		CredentialID:       credentialID,
+		PublicKey:          encodeBase64URL(att.AuthData.CredentialPublicKey),
+		AAGUID:             formatAAGUID(att.AuthData.AAGUID),
+		AttestationFormat:  attResult.Format,
+		AttestationType:    attResult.Type,
+		AttestationTrusted: attResult.Trusted,
+		SignCount:          int(att.AuthData.SignCount),
+		Transports:         knownTransports(req.Transports),
+		AddedAt:            time.Now(),
 	}
 
 	if err := w.storeCredential(credential); err != nil {
@@ -134,9 +197,6 @@ func (w *WebAuthnService) VerifyRegistration(ctx context.Context, req Registrati
 		}, nil
 	}
 
-	// Clean up challenge
-	w.deleteChallenge(req.Challenge)
-
 	log.Printf("✅ WebAuthn: Registration successful for user %s", req.UserID)
 	return RegistrationResponse{
 		Success:      true,
@@ -157,14 +217,17 @@ func (w *WebAuthnService) CreateAuthenticationChallenge(req AssertionChallengeRe
 	}
 
 	// Store challenge
-	if err := w.storeChallenge(challenge, req.UserID, "authentication"); err != nil {
+	if err := w.storeChallenge(challenge, req.UserID, ChallengeTypeAuthentication); err != nil {
 		return AssertionChallengeResponse{}, fmt.Errorf("failed to store challenge: %v", err)
 	}
 
-	// Get user's credentials
-	allowCredentials, err := w.getUserCredentials(req.UserID)
-	if err != nil {
-		return AssertionChallengeResponse{}, fmt.Errorf("failed to get user credentials: %v", err)
+	// Without a user ID the browser offers any passkey it holds for this RP
+	allowCredentials := []PublicKeyCredDescriptor{}
+	if req.UserID != "" {
+		allowCredentials, err = w.getUserCredentials(req.UserID)
+		if err != nil {
+			return AssertionChallengeResponse{}, fmt.Errorf("failed to get user credentials: %v", err)
+		}
 	}
 
 	response := AssertionChallengeResponse{
@@ -172,7 +235,7 @@ func (w *WebAuthnService) CreateAuthenticationChallenge(req AssertionChallengeRe
 		Timeout:          DefaultTimeout,
 		RelyingPartyID:   w.rpID,
 		AllowCredentials: allowCredentials,
-		UserVerification: UserVerificationPreferred,
+		UserVerification: w.assertionUserVerification(req.UserID),
 	}
 
 	log.Printf("✅ WebAuthn: Authentication challenge created for user %s", req.UserID)
@@ -183,8 +246,8 @@ func (w *WebAuthnService) CreateAuthenticationChallenge(req AssertionChallengeRe
 func (w *WebAuthnService) VerifyAuthentication(req AuthenticationRequest) (AuthenticationResponse, error) {
 	log.Printf("🔐 WebAuthn: Verifying authentication for user %s", req.UserID)
 
-	// Verify challenge
-	if err := w.verifyChallenge(req.Challenge, req.UserID, "authentication"); err != nil {
+	// Consume challenge; it can't be replayed even if verification fails below
+	if err := w.consumeChallenge(req.Challenge, req.UserID, ChallengeTypeAuthentication); err != nil {
 		return AuthenticationResponse{
 			Success: false,
 			Message: fmt.Sprintf("Challenge verification failed: %v", err),
@@ -200,26 +263,24 @@ func (w *WebAuthnService) VerifyAuthentication(req AuthenticationRequest) (Authe
 		}, nil
 	}
 
-	// Normalize base64 challenges (handle padding differences)
-	clientChallenge := strings.TrimRight(clientData.Challenge, "=")
-	requestChallenge := strings.TrimRight(req.Challenge, "=")
-	
-	// Verify challenge matches
-	log.Printf("🔍 WebAuthn: Challenge comparison - Client: '%s' (normalized: '%s'), Request: '%s' (normalized: '%s')", 
-		clientData.Challenge, clientChallenge, req.Challenge, requestChallenge)
-	if clientChallenge != requestChallenge {
-		log.Printf("❌ WebAuthn: Challenge mismatch - Client: '%s' != Request: '%s'", clientChallenge, requestChallenge)
+	// Verify type, challenge and origin
+	if err := w.verifyClientData(clientData, ClientDataTypeGet, req.Challenge); err != nil {
+		log.Printf("❌ WebAuthn: Client data verification failed: %v", err)
 		return AuthenticationResponse{
 			Success: false,
-			Message: "Challenge mismatch",
+			Message: fmt.Sprintf("Client data verification failed: %v", err),
 		}, nil
 	}
 
-	// Extract credential ID from authenticator data (simplified)
-	credentialID := extractCredentialID(req.AuthenticatorData)
-	
-	// Get stored credential (simplified - in production, verify signature)
-	credential, err := w.getCredentialByID(credentialID)
+	// Look up the credential the browser asserted with
+	if req.CredentialID == "" {
+		return AuthenticationResponse{
+			Success: false,
+			Message: "Credential ID is required",
+		}, nil
+	}
+
+	credential, err := w.getCredentialByID(req.CredentialID)
 	if err != nil {
 		return AuthenticationResponse{
 			Success: false,
@@ -227,30 +288,73 @@ func (w *WebAuthnService) VerifyAuthentication(req AuthenticationRequest) (Authe
 		}, nil
 	}
 
-	// Update sign count (simplified)
-	credential.SignCount++
-	if err := w.updateCredentialSignCount(credentialID, credential.SignCount); err != nil {
+	if req.UserID != "" && credential.UserID != req.UserID {
+		log.Printf("❌ WebAuthn: Credential %s does not belong to user %s", req.CredentialID, req.UserID)
 		return AuthenticationResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to update sign count: %v", err),
+			Message: "Credential does not belong to this user",
+		}, nil
+	}
+
+	// Usernameless sign-in resolves the user from the credential's userHandle
+	if err := verifyUserHandle(credential, req.UserHandle, req.UserID == ""); err != nil {
+		log.Printf("❌ WebAuthn: User handle verification failed for credential %s: %v", req.CredentialID, err)
+		return AuthenticationResponse{
+			Success: false,
+			Message: fmt.Sprintf("User handle verification failed: %v", err),
 		}, nil
 	}
 
-	// Create authentication session
-	sessionID, err := w.createAuthSession(req.UserID)
+	// Verify the assertion signature over authenticatorData || SHA-256(clientDataJSON)
+	authData, err := verifyAssertion(credential, req.AuthenticatorData, clientData.raw, req.Signature)
 	if err != nil {
-		log.Printf("⚠️ Warning: Could not create auth session: %v", err)
+		log.Printf("❌ WebAuthn: Assertion verification failed for credential %s: %v", req.CredentialID, err)
+		return AuthenticationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Assertion verification failed: %v", err),
+		}, nil
 	}
 
-	// Clean up challenge
-	w.deleteChallenge(req.Challenge)
+	if err := w.verifyAuthenticatorFlags(authData, w.assertionUserVerification(req.UserID)); err != nil {
+		return AuthenticationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Authenticator data verification failed: %v", err),
+		}, nil
+	}
 
-	log.Printf("✅ WebAuthn: Authentication successful for user %s", req.UserID)
+	// A counter that did not advance means the key may have been cloned
+	if credential.CloneWarning {
+		return AuthenticationResponse{
+			Success: false,
+			Message: "Credential is flagged as possibly cloned",
+		}, nil
+	}
+	if signCountRegressed(uint32(credential.SignCount), authData.SignCount) {
+		w.flagCredentialCloned(credential, authData.SignCount)
+		return AuthenticationResponse{
+			Success: false,
+			Message: "Signature counter did not increase; credential flagged as possibly cloned",
+		}, nil
+	}
+
+	// Record the authenticator's signature counter and last use
+	credential.SignCount = int(authData.SignCount)
+	if err := w.updateCredentialUsage(credential.UID, credential.SignCount); err != nil {
+		return AuthenticationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to update sign count: %v", err),
+		}, nil
+	}
+
+	// The caller issues the session through ChronosSession
+	log.Printf("✅ WebAuthn: Authentication successful for user %s", credential.UserID)
 	return AuthenticationResponse{
-		Success:   true,
-		UserID:    req.UserID,
-		Message:   "WebAuthn authentication successful",
-		SessionID: sessionID,
+		Success:       true,
+		UserID:        credential.UserID,
+		CredentialID:  credential.CredentialID,
+		Message:       "WebAuthn authentication successful",
+		UserVerified:  authData.UserVerified(),
+		HardwareBound: hardwareBound(credential, authData),
 	}, nil
 }
 
@@ -268,85 +372,45 @@ func generateChallenge() (string, error) {
 // storeChallenge stores a challenge in the database with expiry
 func (w *WebAuthnService) storeChallenge(challenge, userID, challengeType string) error {
 	expiresAt := time.Now().Add(ChallengeExpiryMinutes * time.Minute)
-	
+
 	nquads := fmt.Sprintf(`_:challenge <dgraph.type> "WebAuthnChallenge" .
 _:challenge <challenge> "%s" .
-_:challenge <userId> "%s" .
 _:challenge <type> "%s" .
 _:challenge <expiresAt> "%s" .
 _:challenge <createdAt> "%s" .`,
-		challenge, userID, challengeType, 
+		challenge, challengeType,
 		expiresAt.Format(time.RFC3339),
 		time.Now().Format(time.RFC3339))
 
+	// Usernameless (passkey) challenges are not bound to a user
+	if userID != "" {
+		nquads += fmt.Sprintf("\n_:challenge <userId> \"%s\" .", userID)
+	}
+
 	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
 	_, err := dgraph.ExecuteMutations("dgraph", mutationObj)
 	return err
 }
 
-// verifyChallenge verifies a challenge exists and is not expired
-func (w *WebAuthnService) verifyChallenge(challenge, userID, challengeType string) error {
-	log.Printf("🔍 WebAuthn: Verifying challenge - Challenge: %s, UserID: %s, Type: %s", challenge, userID, challengeType)
-	
-	query := fmt.Sprintf(`{
-		challenges(func: eq(challenge, "%s")) @filter(eq(userId, "%s") AND eq(type, "%s")) {
-			uid
-			expiresAt
-		}
-	}`, challenge, userID, challengeType)
-	
-	log.Printf("🔍 WebAuthn: Challenge query: %s", query)
-
-	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
-	if err != nil {
-		log.Printf("❌ WebAuthn: Query execution failed: %v", err)
-		return err
-	}
-	
-	log.Printf("🔍 WebAuthn: Query response: %s", resp.Json)
-
-	var result struct {
-		Challenges []struct {
-			UID       string `json:"uid"`
-			ExpiresAt string `json:"expiresAt"`
-		} `json:"challenges"`
-	}
-
-	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
-		return err
-	}
-
-	if len(result.Challenges) == 0 {
-		return fmt.Errorf("challenge not found")
-	}
-
-	// Check expiry
-	expiresAt, err := time.Parse(time.RFC3339, result.Challenges[0].ExpiresAt)
-	if err != nil {
-		return err
-	}
-
-	if time.Now().After(expiresAt) {
-		return fmt.Errorf("challenge expired")
-	}
-
-	return nil
-}
-
 // storeCredential stores a WebAuthn credential in the database
 func (w *WebAuthnService) storeCredential(cred WebAuthnCredential) error {
-	transportsJSON, _ := json.Marshal(cred.Transports)
-	
 	nquads := fmt.Sprintf(`_:credential <dgraph.type> "WebAuthnCredential" .
 _:credential <user> <%s> .
 _:credential <credentialId> "%s" .
 _:credential <publicKey> "%s" .
+_:credential <aaguid> "%s" .
+_:credential <attestationFormat> "%s" .
+_:credential <attestationType> "%s" .
+_:credential <attestationTrusted> "%t"^^<xs:boolean> .
 _:credential <signCount> "%d" .
-_:credential <transports> "%s" .
 _:credential <addedAt> "%s" .`,
-		cred.UserID, cred.CredentialID, cred.PublicKey, 
-		cred.SignCount, string(transportsJSON),
-		cred.AddedAt.Format(time.RFC3339))
+		cred.UserID, cred.CredentialID, cred.PublicKey, cred.AAGUID,
+		cred.AttestationFormat, cred.AttestationType, cred.AttestationTrusted, cred.SignCount, cred.AddedAt.Format(time.RFC3339))
+
+	// transports is a [string] predicate - one N-Quad per value
+	for _, transport := range cred.Transports {
+		nquads += fmt.Sprintf("\n_:credential <transports> %q .", transport)
+	}
 
 	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
 	_, err := dgraph.ExecuteMutations("dgraph", mutationObj)
@@ -370,8 +434,8 @@ func (w *WebAuthnService) getUserCredentials(userID string) ([]PublicKeyCredDesc
 
 	var result struct {
 		Credentials []struct {
-			CredentialID string `json:"credentialId"`
-			Transports   string `json:"transports"`
+			CredentialID string   `json:"credentialId"`
+			Transports   []string `json:"transports"`
 		} `json:"credentials"`
 	}
 
@@ -381,22 +445,19 @@ func (w *WebAuthnService) getUserCredentials(userID string) ([]PublicKeyCredDesc
 
 	var descriptors []PublicKeyCredDescriptor
 	for _, cred := range result.Credentials {
-		var transports []string
-		json.Unmarshal([]byte(cred.Transports), &transports)
-		
 		descriptors = append(descriptors, PublicKeyCredDescriptor{
 			Type:       "public-key",
 			ID:         cred.CredentialID,
-			Transports: transports,
+			Transports: cred.Transports,
 		})
 	}
 
 	return descriptors, nil
 }
 
-// Simplified parsing functions (in production, use proper WebAuthn library)
+// parseClientDataJSON decodes clientDataJSON, keeping the raw bytes for hashing
 func parseClientDataJSON(clientDataJSON string) (*ClientData, error) {
-	decoded, err := base64.URLEncoding.DecodeString(clientDataJSON)
+	decoded, err := decodeBase64URL(clientDataJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -405,63 +466,220 @@ func parseClientDataJSON(clientDataJSON string) (*ClientData, error) {
 	if err := json.Unmarshal(decoded, &clientData); err != nil {
 		return nil, err
 	}
+	clientData.raw = decoded
 
 	return &clientData, nil
 }
 
-func parseAttestationObject(attestationObject string) (credentialID, publicKey string, err error) {
-	// Simplified - in production, use proper CBOR parsing
-	decoded, err := base64.URLEncoding.DecodeString(attestationObject)
+// verifyAssertion checks an assertion signature against the stored credential
+// public key and returns the parsed authenticator data
+func verifyAssertion(cred *WebAuthnCredential, authenticatorDataB64 string, clientDataJSON []byte, signatureB64 string) (*authenticatorData, error) {
+	rawAuthData, err := decodeBase64URL(authenticatorDataB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authenticator data encoding: %v", err)
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeBase64URL(signatureB64)
 	if err != nil {
-		return "", "", err
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
 	}
 
-	// Generate mock values for demo (replace with proper parsing)
-	hash := sha256.Sum256(decoded)
-	credentialID = base64.URLEncoding.EncodeToString(hash[:16])
-	publicKey = base64.URLEncoding.EncodeToString(hash[16:])
+	rawKey, err := decodeBase64URL(cred.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored public key: %v", err)
+	}
+	key, _, err := parseCOSEKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := make([]byte, 0, len(rawAuthData)+len(clientDataHash))
+	signedData = append(signedData, rawAuthData...)
+	signedData = append(signedData, clientDataHash[:]...)
+
+	if err := key.verifySignature(signedData, signature); err != nil {
+		return nil, err
+	}
 
-	return credentialID, publicKey, nil
+	return authData, nil
 }
 
-func extractCredentialID(authenticatorData string) string {
-	// Simplified extraction (replace with proper parsing)
-	decoded, _ := base64.URLEncoding.DecodeString(authenticatorData)
-	hash := sha256.Sum256(decoded)
-	return base64.URLEncoding.EncodeToString(hash[:16])
+// checkAttestation verifies the attestation statement, checks its chain
+// against the trust anchors and applies the user's attestation policy
+func (w *WebAuthnService) checkAttestation(userID string, att *attestation, clientDataHash []byte) (*attestationResult, error) {
+	result, err := verifyAttestationStatement(att, clientDataHash)
+	if err != nil {
+		return nil, err
+	}
+
+	aaguid := formatAAGUID(att.AuthData.AAGUID)
+	trusted, err := w.trustAnchors.verifyChain(result, aaguid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail closed: without roles we cannot tell whether hardware keys are required
+	policy, err := w.attestationPolicyForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve attestation policy: %v", err)
+	}
+
+	if err := w.checkTrustAnchorsFor(policy); err != nil {
+		return nil, err
+	}
+
+	result.Trusted = trusted
+
+	decision, reason := policy.Evaluate(result, aaguid, trusted)
+	switch decision {
+	case AttestationReject:
+		return nil, WebAuthnError{Code: ErrorRegistrationFailed, Message: reason}
+	case AttestationWarn:
+		log.Printf("⚠️ WebAuthn: Accepting %s attestation from %s with warning: %s", result.Format, aaguid, reason)
+	}
+
+	log.Printf("🔍 WebAuthn: Attestation %s/%s from %s (trusted: %t)", result.Format, result.Type, aaguid, trusted)
+	return result, nil
+}
+
+// checkTrustAnchorsFor fails when a policy needs trusted attestation and no
+// trust anchors are loaded: every registration would be rejected, which is
+// a deployment problem rather than a bad authenticator
+func (w *WebAuthnService) checkTrustAnchorsFor(policy AttestationPolicy) error {
+	if policy.RequireTrusted && w.trustAnchors.Empty() {
+		log.Printf("❌ WebAuthn: Attestation policy requires trust anchors but none are loaded")
+		return fmt.Errorf("no attestation trust anchors are loaded; set WEBAUTHN_MDS_BLOB and WEBAUTHN_MDS_ROOT_CERT")
+	}
+	return nil
+}
+
+// formatAAGUID renders a 16-byte AAGUID in canonical UUID form
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	h := hex.EncodeToString(aaguid)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
 }
 
 // Additional helper functions
+
+// getCredentialByID loads a stored credential by its base64url credential ID
 func (w *WebAuthnService) getCredentialByID(credentialID string) (*WebAuthnCredential, error) {
-	// Implementation for getting credential by ID
-	return &WebAuthnCredential{CredentialID: credentialID, SignCount: 0}, nil
-}
+	// Normalise padding/alphabet so lookups match the stored encoding
+	rawID, err := decodeBase64URL(credentialID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential ID: %v", err)
+	}
+	credentialID = encodeBase64URL(rawID)
 
-func (w *WebAuthnService) updateCredentialSignCount(credentialID string, signCount int) error {
-	// Implementation for updating sign count
-	// TODO: Implement actual sign count update in database
-	_ = credentialID // Mark as used
-	_ = signCount    // Mark as used
-	return nil
+	query := fmt.Sprintf(`{
+		credentials(func: eq(credentialId, "%s")) @filter(type(WebAuthnCredential)) {
+			uid
+			credentialId
+			publicKey
+			aaguid
+			nickname
+			attestationTrusted
+			signCount
+			cloneWarning
+			transports
+			addedAt
+			user {
+				uid
+			}
+		}
+	}`, credentialID)
+
+	resp, err := dgraph.ExecuteQuery("dgraph", dgraph.NewQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Credentials []struct {
+			UID                string    `json:"uid"`
+			CredentialID       string    `json:"credentialId"`
+			PublicKey          string    `json:"publicKey"`
+			AAGUID             string    `json:"aaguid"`
+			Nickname           string    `json:"nickname"`
+			AttestationTrusted bool      `json:"attestationTrusted"`
+			SignCount          int       `json:"signCount"`
+			CloneWarning       bool      `json:"cloneWarning"`
+			Transports         []string  `json:"transports"`
+			AddedAt            time.Time `json:"addedAt"`
+			User               struct {
+				UID string `json:"uid"`
+			} `json:"user"`
+		} `json:"credentials"`
+	}
+
+	if err := json.Unmarshal([]byte(resp.Json), &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Credentials) == 0 {
+		return nil, WebAuthnError{Code: ErrorInvalidCredential, Message: "credential not found"}
+	}
+
+	cred := result.Credentials[0]
+	return &WebAuthnCredential{
+		UID:                cred.UID,
+		UserID:             cred.User.UID,
+		CredentialID:       cred.CredentialID,
+		PublicKey:          cred.PublicKey,
+		AAGUID:             cred.AAGUID,
+		Nickname:           cred.Nickname,
+		AttestationTrusted: cred.AttestationTrusted,
+		SignCount:          cred.SignCount,
+		CloneWarning:       cred.CloneWarning,
+		Transports:         cred.Transports,
+		AddedAt:            cred.AddedAt,
+	}, nil
 }
 
-func (w *WebAuthnService) createAuthSession(userID string) (string, error) {
-	// Implementation for creating auth session
-	// TODO: Implement actual session creation in database
-	sessionID := fmt.Sprintf("session_%s_%d", userID, time.Now().Unix())
-	return sessionID, nil
+// updateCredentialUsage persists the latest signature counter and last-used time
+func (w *WebAuthnService) updateCredentialUsage(credentialUID string, signCount int) error {
+	nquads := fmt.Sprintf(`<%s> <signCount> "%d" .
+<%s> <lastUsedAt> "%s" .`,
+		credentialUID, signCount, credentialUID, time.Now().Format(time.RFC3339))
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	_, err := dgraph.ExecuteMutations("dgraph", mutationObj)
+	return err
 }
 
-func (w *WebAuthnService) deleteChallenge(challenge string) error {
-	// Implementation for deleting challenge
-	// TODO: Implement actual challenge deletion from database
-	_ = challenge // Mark as used
-	return nil
+// flagCredentialCloned marks a credential whose counter went backwards and
+// records the event for security review
+func (w *WebAuthnService) flagCredentialCloned(cred *WebAuthnCredential, receivedSignCount uint32) {
+	log.Printf("🚨 WebAuthn: Possible cloned authenticator for credential %s (stored signCount %d, received %d)",
+		cred.CredentialID, cred.SignCount, receivedSignCount)
+
+	nquads := fmt.Sprintf(`<%s> <cloneWarning> "true" .
+<%s> <cloneDetectedAt> "%s" .`,
+		cred.UID, cred.UID, time.Now().Format(time.RFC3339))
+
+	mutationObj := dgraph.NewMutation().WithSetNquads(nquads)
+	if _, err := dgraph.ExecuteMutations("dgraph", mutationObj); err != nil {
+		log.Printf("⚠️ Warning: Could not flag credential %s: %v", cred.CredentialID, err)
+	}
+
+	auditor.Record("WEBAUTHN_CLONE_SUSPECTED", "WebAuthnCredential", cred.UID, cred.UserID, themislog.SeverityCritical,
+		fmt.Sprintf("Signature counter did not increase for credential %s: stored %d, received %d",
+			cred.CredentialID, cred.SignCount, receivedSignCount))
 }
 
 // ClientData represents the parsed client data JSON
 type ClientData struct {
-	Type      string `json:"type"`
-	Challenge string `json:"challenge"`
-	Origin    string `json:"origin"`
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+
+	raw []byte // exact bytes signed over by the authenticator
 }
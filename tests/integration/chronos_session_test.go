@@ -11,10 +11,9 @@ import (
 )
 
 // Test types matching main.go GraphQL types
-type SessionRequest struct {
-	UserID     string `json:"userId"`
-	ChannelDID string `json:"channelDID"`
-	Action     string `json:"action"`
+type CreateSessionRequest struct {
+	OTPCode   string `json:"otpCode"`
+	Recipient string `json:"recipient"`
 }
 
 type SessionResponse struct {
@@ -24,6 +23,8 @@ type SessionResponse struct {
 	ExpiresAt   int64  `json:"expiresAt"`
 	Message     string `json:"message"`
 	UserID      string `json:"userId"`
+	// RefreshToken is single-use; each refresh returns a new one
+	RefreshToken string `json:"refreshToken"`
 }
 
 type ValidationRequest struct {
@@ -38,13 +39,14 @@ type ValidationResponse struct {
 }
 
 type RefreshRequest struct {
-	Token string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 type RefreshResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expiresAt"`
-	Message   string `json:"message,omitempty"`
+	Token        string `json:"token"`
+	ExpiresAt    int64  `json:"expiresAt"`
+	RefreshToken string `json:"refreshToken"`
+	Message      string `json:"message,omitempty"`
 }
 
 type RevocationRequest struct {
@@ -78,16 +80,16 @@ func main() {
 	fmt.Println(strings.Repeat("=", 50))
 
 	// Test data
-	testUserID := "test-user-123"
-	testChannelDID := "test-channel-did-456"
-	testAction := "signin"
+	// Send a sign-in OTP to the recipient first; the code is consumed here
+	testRecipient := "test@example.com"
+	testOTPCode := "123456"
 
 	var sessionToken string
 	var refreshedToken string
 
 	// Test 1: Session Creation (Issue)
 	fmt.Println("\n📝 Test 1: Session Creation (Issue)")
-	sessionResp, err := testCreateSession(testUserID, testChannelDID, testAction)
+	sessionResp, err := testCreateSession(testRecipient, testOTPCode)
 	if err != nil {
 		fmt.Printf("❌ Session creation failed: %v\n", err)
 		return
@@ -123,7 +125,7 @@ func main() {
 
 	// Test 3: Session Refresh
 	fmt.Println("\n🔄 Test 3: Session Refresh")
-	refreshResp, err := testRefreshSession(sessionToken)
+	refreshResp, err := testRefreshSession(sessionResp.RefreshToken)
 	if err != nil {
 		fmt.Printf("❌ Session refresh failed: %v\n", err)
 	} else {
@@ -185,9 +187,9 @@ func main() {
 	fmt.Println("🎯 ChronosSession Integration Tests Complete")
 }
 
-func testCreateSession(userID, channelDID, action string) (*SessionResponse, error) {
+func testCreateSession(recipient, otpCode string) (*SessionResponse, error) {
 	query := `
-		mutation CreateSession($req: SessionRequest!) {
+		mutation CreateSession($req: CreateSessionRequest!) {
 			createSession(req: $req) {
 				success
 				sessionId
@@ -195,15 +197,15 @@ func testCreateSession(userID, channelDID, action string) (*SessionResponse, err
 				expiresAt
 				message
 				userId
+				refreshToken
 			}
 		}
 	`
 	
 	variables := map[string]interface{}{
-		"req": SessionRequest{
-			UserID:     userID,
-			ChannelDID: channelDID,
-			Action:     action,
+		"req": CreateSessionRequest{
+			OTPCode:   otpCode,
+			Recipient: recipient,
 		},
 	}
 
@@ -249,12 +251,13 @@ func testValidateSession(token string) (*ValidationResponse, error) {
 	return &response.ValidateSession, nil
 }
 
-func testRefreshSession(token string) (*RefreshResponse, error) {
+func testRefreshSession(refreshToken string) (*RefreshResponse, error) {
 	query := `
 		mutation RefreshSession($req: RefreshRequest!) {
 			refreshSession(req: $req) {
 				token
 				expiresAt
+				refreshToken
 				message
 			}
 		}
@@ -262,7 +265,7 @@ func testRefreshSession(token string) (*RefreshResponse, error) {
 	
 	variables := map[string]interface{}{
 		"req": RefreshRequest{
-			Token: token,
+			RefreshToken: refreshToken,
 		},
 	}
 
@@ -1,3 +1,3 @@
 package utils
 
-// Encryption utilities placeholder
+// Encryption of personal data lives in the PII vault, services/pii